@@ -7,6 +7,8 @@ function addPage() {
 
     var notification = document.getElementById("add-notif");
 
+    var allowDuplicates = document.getElementById("allow-duplicates");
+
     var form = document.querySelector(".add-form");
 
     function setError(text) {
@@ -24,6 +26,7 @@ function addPage() {
 
         ajax("/add?format=json", {
             "searchTerm": link,
+            "allowDuplicates": allowDuplicates.checked,
         }).post(function (status, obj) {
             if (status === 200) {
                 InstantClick.go("/");
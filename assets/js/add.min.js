@@ -1 +1 @@
-function addPage(){var linkInput=document.getElementById("searchTerm");linkInput.required=!1,linkInput.focus();var notification=document.getElementById("add-notif"),form;function setError(text){notification.innerText=text}document.querySelector(".add-form").addEventListener("submit",(function(evt){var link=linkInput.value;if(evt.preventDefault(),""==link.trim())return setError("Link must not be empty");ajax("/add?format=json",{searchTerm:link}).post((function(status,obj){200!==status?setError(obj.message||"Unknown error"):InstantClick.go("/")}))}));var abortForm=document.querySelector(".abort-form");abortForm&&abortForm.addEventListener("submit",(function(evt){if(evt.preventDefault(),!confirm("Are you sure you want stop this download?"))return!1;ajax("/abort?format=json",{}).post((function(status,obj){200!==status?setError(obj.message||"Unknown error"):InstantClick.go("/add")}))}))}
\ No newline at end of file
+function addPage(){var linkInput=document.getElementById("searchTerm");linkInput.required=!1,linkInput.focus();var notification=document.getElementById("add-notif"),allowDuplicates=document.getElementById("allow-duplicates"),form;function setError(text){notification.innerText=text}document.querySelector(".add-form").addEventListener("submit",(function(evt){var link=linkInput.value;if(evt.preventDefault(),""==link.trim())return setError("Link must not be empty");ajax("/add?format=json",{searchTerm:link,allowDuplicates:allowDuplicates.checked}).post((function(status,obj){200!==status?setError(obj.message||"Unknown error"):InstantClick.go("/")}))}));var abortForm=document.querySelector(".abort-form");abortForm&&abortForm.addEventListener("submit",(function(evt){if(evt.preventDefault(),!confirm("Are you sure you want stop this download?"))return!1;ajax("/abort?format=json",{}).post((function(status,obj){200!==status?setError(obj.message||"Unknown error"):InstantClick.go("/add")}))}))}
\ No newline at end of file
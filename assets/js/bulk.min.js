@@ -0,0 +1 @@
+var bulkSelecting=false;function bulkSelect(){bulkSelecting=false;var form=document.getElementById("bulk-form");if(!form){return;}form.classList.remove("hidden");document.getElementById("bulk-next").value=location.pathname+location.search;document.getElementById("bulk-toggle").addEventListener("click",function(evt){bulkSelecting=!bulkSelecting;document.body.classList.toggle("is-selecting",bulkSelecting);evt.currentTarget.innerText=bulkSelecting?"Cancel":"Select songs";if(!bulkSelecting){var selected=document.querySelectorAll(".song-link.is-selected");for(var i=0;i<selected.length;i++){setSelected(selected[i],false);}}});document.getElementById("bulk-all").addEventListener("click",function(){var links=document.querySelectorAll(".song-link");for(var i=0;i<links.length;i++){setSelected(links[i],true);}});}function setSelected(elem,selected){var form=document.getElementById("bulk-form");var id=elem.id.substr(5);elem.classList.toggle("is-selected",selected);var input=form.querySelector("input[name='id'][value='"+id+"']");if(selected&&!input){input=document.createElement("input");input.type="hidden";input.name="id";input.value=id;form.appendChild(input);}else if(!selected&&input){input.remove();}var count=form.querySelectorAll("input[name='id']").length;document.getElementById("bulk-count").innerText=count;document.getElementById("bulk-edit").disabled=count===0;}["mousedown","click"].forEach(function(type){document.addEventListener(type,function(evt){if(!bulkSelecting){return;}var elem=evt.target.closest(".song-link");if(!elem){return;}evt.preventDefault();evt.stopPropagation();if(type==="click"){setSelected(elem,!elem.classList.contains("is-selected"));}},true);});
\ No newline at end of file
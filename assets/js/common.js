@@ -25,6 +25,7 @@ InstantClick.on('change', function () {
 // Based on https://gist.github.com/duanckham/e5b690178b759603b81c
 // usage(POST): ajax(url, data).post(function(status, obj) { });
 // usage(GET): ajax(url, data).get(function(status, obj) { });
+// PUT and DELETE requests work like POST requests
 var ajax = function (url, data) {
     var wrap = function (method, cb) {
         var xhr = new XMLHttpRequest();
@@ -32,7 +33,7 @@ var ajax = function (url, data) {
         xhr.setRequestHeader("X-XHR", "true");
 
         var sendstr = null;
-        if (method === "POST" && data) {
+        if (method !== "GET" && data) {
             if ('entries' in data) {
                 sendstr = data;
             } else {
@@ -68,6 +69,12 @@ var ajax = function (url, data) {
         },
         post: function (cb) {
             return wrap("POST", cb);
+        },
+        put: function (cb) {
+            return wrap("PUT", cb);
+        },
+        delete: function (cb) {
+            return wrap("DELETE", cb);
         }
     };
 };
@@ -87,7 +94,7 @@ function trimChar(string, charToRemove) {
 
 // must this page be reloaded after
 function isListingPage() {
-    return location.pathname.startsWith("/album/") || location.pathname.startsWith("/artist/") || ["/", "/add", "/songs", "/artists", "/years", "/incomplete", "/unsynced", "/search", "/edits"].indexOf(location.pathname) !== -1;
+    return location.pathname.startsWith("/album/") || location.pathname.startsWith("/artist/") || location.pathname.startsWith("/playlist/") || location.pathname.startsWith("/smart/") || ["/", "/add", "/songs", "/artists", "/years", "/incomplete", "/unsynced", "/search", "/edits", "/trims", "/playlists", "/smart"].indexOf(location.pathname) !== -1;
 }
 
 function registerCover() {
@@ -58,6 +58,35 @@ ws.onmessage = function (evt) {
         }
     }
 
+    // songs-edit contains all songs that were changed or deleted at once
+    if (e.type === "songs-edit") {
+        var ids = e.data.deleted.slice();
+        e.data.songs.forEach(function (s) {
+            changedItems[s.id] = Math.random();
+            ids.push(s.id);
+        });
+
+        if (!isReload && (isListingPage() || ids.indexOf(trimChar(location.pathname, "/").substr(5)) !== -1)) {
+            reload();
+        }
+    }
+
+    // playlist-edit playlist-delete smart-playlist-edit smart-playlist-delete
+    if (e.type.startsWith("playlist-") || e.type.startsWith("smart-playlist-")) {
+        var smart = e.type.startsWith("smart-");
+        var listPath = smart ? "/smart" : "/playlists";
+
+        if (location.pathname === listPath) {
+            reload();
+        } else if (trimChar(location.pathname, "/") === (smart ? "smart/" : "playlist/") + e.data.id && !isReload) {
+            if (e.type.endsWith("-delete")) {
+                InstantClick.go(listPath);
+            } else {
+                reload();
+            }
+        }
+    }
+
     if (e.type.startsWith("progress-")) {
         setProgressbar(e.type, e.data)
         lastProgress = e.type;
@@ -2,15 +2,25 @@ InstantClick.on('change', function () {
     switch (location.pathname.split("/")[1]) {
         case "song":
             songPage();
+            playlistTargets();
             break;
         case "add":
             addPage();
             break;
         case "album":
             albumPage();
+            playlistTargets();
+            break;
+        case "playlist":
+            playlistPage();
+            break;
+        case "smart":
+            registerPlaylistDelete("/smart");
+            break;
         default:
             break;
     }
 
+    bulkSelect();
     initSearch();
 })
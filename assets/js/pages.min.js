@@ -1 +1 @@
-InstantClick.on("change",function(){switch(location.pathname.split("/")[1]){case"song":songPage();break;case"add":addPage();break;case"album":albumPage()}initSearch()});
\ No newline at end of file
+InstantClick.on("change",function(){switch(location.pathname.split("/")[1]){case"song":songPage(),playlistTargets();break;case"add":addPage();break;case"album":albumPage(),playlistTargets();break;case"playlist":playlistPage();break;case"smart":registerPlaylistDelete("/smart")}bulkSelect(),initSearch()});
\ No newline at end of file
@@ -0,0 +1 @@
+function playlistTargets(){var container=document.getElementById("playlist-targets");if(!container){return;}function songIDs(){var ids=[];var links=document.querySelectorAll(".album-songs .song-link");for(var i=0;i<links.length;i++){ids.push(links[i].id.substr(5));}if(ids.length===0){ids.push(document.getElementById("song-id").value);}return ids;}function addSongs(playlistID,ids){var notif=document.getElementById("playlist-notif");ajax("/api/v1/playlist/"+playlistID+"/songs",{songs:ids}).post(function(status,obj){if(status===200){notif.innerText="Added to "+obj.playlist.name;}else{notif.innerText=obj.message||"Unknown error";}});}function dragSongs(ids){return function(evt){evt.dataTransfer.setData("text/plain",ids.join(","));evt.dataTransfer.effectAllowed="copy";};}var links=document.querySelectorAll(".album-songs .song-link");for(var i=0;i<links.length;i++){links[i].addEventListener("dragstart",dragSongs([links[i].id.substr(5)]));}var cover=document.getElementById("song-cover");if(cover){cover.addEventListener("dragstart",dragSongs(songIDs()));}var targets=container.getElementsByClassName("playlist-target");for(var i=0;i<targets.length;i++){var target=targets[i];target.addEventListener("click",function(evt){addSongs(evt.currentTarget.dataset.playlist,songIDs());});target.addEventListener("dragover",function(evt){evt.preventDefault();evt.dataTransfer.dropEffect="copy";evt.currentTarget.classList.add("is-primary");});target.addEventListener("dragleave",function(evt){evt.currentTarget.classList.remove("is-primary");});target.addEventListener("drop",function(evt){evt.preventDefault();evt.currentTarget.classList.remove("is-primary");var data=evt.dataTransfer.getData("text/plain");if(data){addSongs(evt.currentTarget.dataset.playlist,data.split(","));}});}}function registerPlaylistDelete(listURL){function confirmDelete(evt){evt.preventDefault();if(!confirm("Are you sure you want to delete this playlist? The songs in it will not be deleted.")){return false;}var formData=new FormData();formData.set("delete","delete");ajax(location.pathname,formData).post(function(status,obj){if(status===200){isReload=true;InstantClick.go(listURL);}else{document.getElementById("playlist-notif").innerText=obj.message||"Unknown error";}});return false;}var button=document.getElementById("delete-playlist");if(button){button.addEventListener("click",confirmDelete);}}function playlistPage(){var playlistID=location.pathname.split("/")[2];registerPlaylistDelete("/playlists");var list=document.getElementById("playlist-songs");if(!list){return;}var dragged=null;var oldOrder="";function order(){var ids=[];var songs=list.getElementsByClassName("playlist-song");for(var i=0;i<songs.length;i++){ids.push(songs[i].dataset.song);}return ids;}function saveOrder(){var ids=order();if(ids.join(",")===oldOrder){return;}ajax("/api/v1/playlist/"+playlistID+"/songs",{songs:ids}).put(function(status,obj){if(status!==200){document.getElementById("playlist-notif").innerText=obj.message||"Unknown error";}});}list.addEventListener("dragstart",function(evt){dragged=evt.target.closest(".playlist-song");if(!dragged){return;}evt.dataTransfer.setData("text/plain",dragged.dataset.song);evt.dataTransfer.effectAllowed="move";dragged.classList.add("is-dragged");oldOrder=order().join(",");});list.addEventListener("dragover",function(evt){var over=evt.target.closest(".playlist-song");if(!dragged||!over||over===dragged){return;}evt.preventDefault();var rect=over.getBoundingClientRect();if(evt.clientY<rect.top+rect.height/2){list.insertBefore(dragged,over);}else{list.insertBefore(dragged,over.nextSibling);}});list.addEventListener("drop",function(evt){evt.preventDefault();});list.addEventListener("dragend",function(){if(!dragged){return;}dragged.classList.remove("is-dragged");dragged=null;saveOrder();});}
\ No newline at end of file
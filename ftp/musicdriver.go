@@ -4,271 +4,145 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os"
-	"path"
-	"path/filepath"
-	"sort"
-	"strings"
 	"xarantolus/sensibleHub/store"
-	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/vfs"
 
 	"goftp.io/server"
 )
 
-var (
-	errReadOnly = fmt.Errorf("write/delete not allowed")
-	errNotFound = fmt.Errorf("file/directory not found")
-)
-
-// The different depths an FTP path can have
-const (
-	rootDirectory   int = 0
-	artistDirectory int = 1
-	albumDirectory  int = 2
-	songFile        int = 3
-)
-
 type musicDriver struct {
-	Artists map[string]album
-
 	manager *store.Manager
-	cfg     config.Config
-}
 
-// album is part of the virtual file system
-type album map[string][]*fileInfo
+	// fs is the virtual file system of the logged in user
+	fs *vfs.FS
+}
 
 func (m *musicDriver) Init(c *server.Conn) {
 	log.Println("[FTP] Connected client from", c.RemoteAddr())
 }
 
+// CheckPasswd logs in a user and builds the file system they are allowed to see.
+// It is called instead of the server-wide authentication because the driver implements it
+func (m *musicDriver) CheckPasswd(name, pass string) (bool, error) {
+	user, ok := vfs.FindUser(m.manager.GetConfig(), name, pass)
+	if !ok {
+		return false, fmt.Errorf("login failure")
+	}
+
+	m.fs = vfs.New(m.manager, user)
+
+	log.Printf("[FTP] Logged in %s\n", user.Name)
+	return true, nil
+}
+
+// rootInfo pretends to be a good root directory
+type rootInfo struct {
+	*vfs.Dir
+}
+
+func (r rootInfo) Name() string {
+	return "/"
+}
+
 func (m *musicDriver) Stat(path string) (fi server.FileInfo, err error) {
-	split := splitPath(path)
+	dir, file, ok := m.fs.Find(path)
+	if !ok {
+		return nil, vfs.ErrNotFound
+	}
 
-	switch len(split) {
-	case rootDirectory:
-		// this one pretends to be a good root directory
-		return &artistAlbumInfo{
-			Album: "/",
-		}, nil
-	case artistDirectory:
-		return &artistAlbumInfo{
-			Artist:   split[0],
-			isArtist: true,
-		}, nil
-	case albumDirectory:
-		return &artistAlbumInfo{
-			Album: split[1],
-		}, nil
-	case songFile:
-		{
-			// Stat the actual mp3 files
-			a, ok := m.Artists[split[0]]
-			if ok {
-				if files, ok := a[split[1]]; ok {
-					for _, fi := range files {
-						if fi.Name() == split[2] {
-							return fi, nil
-						}
-					}
-				}
-			}
-		}
-	default:
-		err = errNotFound
+	if file != nil {
+		return file, nil
 	}
 
-	return
+	if len(vfs.Split(path)) == 0 {
+		return rootInfo{dir}, nil
+	}
+
+	return dir, nil
 }
 
 func (m *musicDriver) ChangeDir(path string) (err error) {
-	split := splitPath(path)
-
-	if len(split) == artistDirectory {
-		// Exists or is root
-		if _, ok := m.Artists[split[0]]; split[0] == "" || ok {
-			return nil
-		}
-	} else if len(split) == albumDirectory {
-		a, ok := m.Artists[split[0]]
-		if ok {
-			if _, ok := a[split[1]]; ok {
-				return nil
-			}
-		}
+	dir, _, ok := m.fs.Find(path)
+	if !ok || dir == nil {
+		return vfs.ErrNotFound
 	}
 
-	return errNotFound
+	return nil
 }
 
 // params  - path
 // returns - a string containing the file data to send to the client
 func (m *musicDriver) GetFile(path string, offset int64) (i int64, content io.ReadCloser, err error) {
-	split := splitPath(path)
-	if len(split) != songFile {
-		err = errNotFound
+	_, file, ok := m.fs.Find(path)
+	if !ok || file == nil {
+		err = vfs.ErrNotFound
 		return
 	}
 
-	art, ok := m.Artists[split[0]]
-	if !ok {
-		err = errNotFound
+	f, err := m.fs.Open(file)
+	if err != nil {
 		return
 	}
 
-	al, ok := art[split[1]]
-	if !ok {
-		err = errNotFound
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
 		return
 	}
 
-	for _, file := range al {
-		// Serve the file with the given name
-		if file.Name() == split[2] {
-			p, er := file.MP3Path(m.cfg)
-			if er != nil {
-				err = er
-				return
-			}
-
-			f, er := os.Open(p)
-			if er != nil {
-				err = er
-				return
-			}
-
-			fi, er := f.Stat()
-			if er != nil {
-				f.Close()
-				err = er
-				return
-			}
-
-			if offset > 0 {
-				_, err = f.Seek(offset, io.SeekStart)
-				if err != nil {
-					f.Close()
-					return 0, nil, err
-				}
-			}
-
-			return fi.Size() - offset, f, nil
+	if offset > 0 {
+		_, err = f.Seek(offset, io.SeekStart)
+		if err != nil {
+			f.Close()
+			return 0, nil, err
 		}
 	}
 
-	// seems like we couldn't find the file
-	err = errNotFound
-	return
+	return fi.Size() - offset, f, nil
 }
 
 func (m *musicDriver) ListDir(path string, f func(server.FileInfo) error) (err error) {
-	split := splitPath(path)
-
-	switch len(split) {
-	case artistDirectory:
-		if split[0] == "" {
-			// We want them in alphabetical order
-			var keys []string
-			for k := range m.Artists {
-				keys = append(keys, k)
-			}
-
-			sort.Strings(keys)
+	dir, _, ok := m.fs.Find(path)
+	if !ok || dir == nil {
+		return vfs.ErrNotFound
+	}
 
-			// List all artists in root directory
-			for _, artist := range keys {
-				err = f(&artistAlbumInfo{
-					Artist:   artist,
-					isArtist: true,
-				})
-				if err != nil {
-					return
-				}
-			}
-		} else {
-			var keys []string
-			for k := range m.Artists[split[0]] {
-				keys = append(keys, k)
-			}
-			sort.Strings(keys)
+	dirs, files := dir.List()
 
-			// List all albums in artist directory
-			for _, album := range keys {
-				err = f(&artistAlbumInfo{
-					Album: album,
-				})
-				if err != nil {
-					return
-				}
-			}
+	for _, d := range dirs {
+		err = f(d)
+		if err != nil {
+			return
 		}
-	case albumDirectory:
-		// List all albums in artist directory
-		for _, song := range m.Artists[split[0]][split[1]] {
-			err = f(song)
-			if err != nil {
-				return
-			}
+	}
+
+	for _, file := range files {
+		err = f(file)
+		if err != nil {
+			return
 		}
-	default:
-		err = errNotFound
 	}
 
 	return
 }
 
-func splitPath(p string) []string {
-	return strings.Split(strings.Trim(p, "/"), "/")
-}
-
 // PutFile implements putting files on the server while also importing them. That way, you can use FTP to import your music library
 func (m *musicDriver) PutFile(p string, f io.Reader, overwrite bool) (n int64, err error) {
-	dest := filepath.Join("import", store.CleanName(path.Base(strings.ReplaceAll(p, "\\", "/"))))
-
-	// Try to create the import directory, but ignore if it doesn't work.
-	_ = os.MkdirAll(filepath.Dir(dest), os.ModePerm)
-
-	d, err := os.Create(dest)
-	if err != nil {
-		return
-	}
-
-	n, err = io.Copy(d, f)
-	if err != nil {
-		d.Close()
-
-		os.Remove(d.Name())
-
-		return
-	}
-
-	err = d.Close()
-	if err != nil {
-		return
-	}
-
-	_, err = m.manager.ImportFile(d.Name(), nil)
-	if err != nil {
-		log.Println("[Import] Error while importing file from FTP:", err.Error())
-	}
-
-	return
+	return m.fs.Put(p, f)
 }
 
-// Methods that are not implemented as the server is supposed to be mostly read-only:
-
-func (m *musicDriver) DeleteDir(path string) (err error) {
-	return errReadOnly
+func (m *musicDriver) DeleteFile(p string) (err error) {
+	return m.fs.DeleteFile(p)
 }
 
-func (m *musicDriver) DeleteFile(string) (err error) {
-	return errReadOnly
+func (m *musicDriver) DeleteDir(p string) (err error) {
+	return m.fs.DeleteDir(p)
 }
 
 func (m *musicDriver) Rename(src string, dest string) (err error) {
-	return errReadOnly
+	return m.fs.Rename(src, dest)
 }
 
-func (m *musicDriver) MakeDir(path string) (err error) {
-	return errReadOnly
+func (m *musicDriver) MakeDir(p string) (err error) {
+	return m.fs.MakeDir(p)
 }
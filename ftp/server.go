@@ -1,48 +1,60 @@
 package ftp
 
 import (
-	"crypto/subtle"
 	"fmt"
 	"log"
 	"xarantolus/sensibleHub/store"
 	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/vfs"
 
 	"goftp.io/server/core"
 )
 
 // RunServer runs the FTP server until it crashes
 func RunServer(manager *store.Manager, cfg config.Config) (err error) {
+	// Make sure all layouts are valid before anyone logs in
+	err = vfs.CheckLayouts(cfg)
+	if err != nil {
+		return
+	}
+
 	opts := &core.ServerOpts{
 		Factory: &musicDriverFactory{
 			Manager: manager,
 		},
 		Port:   cfg.FTP.Port,
-		Auth:   &configAuth{cfg: cfg},
 		Logger: &core.DiscardLogger{},
+
+		PassivePorts: cfg.FTP.PassivePorts,
 	}
 
-	server := core.NewServer(opts)
+	opts.PublicIP, err = publicIP(cfg.FTP.PublicHost)
+	if err != nil {
+		return fmt.Errorf("resolving public host: %s", err.Error())
+	}
 
-	log.Printf("[FTP] Server listening on port %d\n", cfg.FTP.Port)
-	return server.ListenAndServe()
-}
+	if cfg.FTP.TLS.Mode != "" {
+		opts.TLS = true
+		opts.ExplicitFTPS = cfg.FTP.TLS.Mode == "explicit"
 
-// configAuth implements the server.Auth interface
-type configAuth struct {
-	cfg config.Config
-}
-
-// CheckPasswd will check user's password
-func (a *configAuth) CheckPasswd(name, pass string) (bool, error) {
-	for _, user := range a.cfg.FTP.Users {
-		if constantTimeEquals(name, user.Name) && constantTimeEquals(pass, user.Passwd) {
-			log.Printf("[FTP] Logged in %s\n", user.Name)
-			return true, nil
+		opts.CertFile, opts.KeyFile = cfg.FTP.TLS.CertFile, cfg.FTP.TLS.KeyFile
+		if opts.CertFile == "" {
+			opts.CertFile, opts.KeyFile, err = selfSignedCertificate(cfg.Dir, cfg.FTP.PublicHost)
+			if err != nil {
+				return fmt.Errorf("generating self-signed certificate: %s", err.Error())
+			}
 		}
 	}
-	return false, fmt.Errorf("login failure")
-}
 
-func constantTimeEquals(a, b string) bool {
-	return len(a) == len(b) && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+	server := core.NewServer(opts)
+
+	// NewServer doesn't copy this option, so we set it afterwards
+	server.ForceTLS = cfg.FTP.TLS.Force && opts.ExplicitFTPS
+
+	if opts.TLS {
+		log.Printf("[FTP] Server listening on port %d with %s TLS\n", cfg.FTP.Port, cfg.FTP.TLS.Mode)
+	} else {
+		log.Printf("[FTP] Server listening on port %d\n", cfg.FTP.Port)
+	}
+	return server.ListenAndServe()
 }
@@ -0,0 +1,148 @@
+package ftp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+	"xarantolus/sensibleHub/store/file"
+)
+
+const (
+	// Names of the generated self-signed certificate and key, they are stored next to the configuration file
+	generatedCertName = "ftp-cert.pem"
+	generatedKeyName  = "ftp-key.pem"
+
+	// Older versions stored them in the data directory, which is served by the web server
+	legacyCertFile = "data/ftp-cert.pem"
+	legacyKeyFile  = "data/ftp-key.pem"
+)
+
+// selfSignedCertificate returns the paths of a self-signed certificate and key in `dir`, generating them if they don't exist yet
+func selfSignedCertificate(dir, host string) (certFile, keyFile string, err error) {
+	certFile, keyFile = filepath.Join(dir, generatedCertName), filepath.Join(dir, generatedKeyName)
+
+	err = moveLegacyCertificate(certFile, keyFile)
+	if err != nil {
+		return
+	}
+
+	_, certErr := os.Stat(certFile)
+	_, keyErr := os.Stat(keyFile)
+	if certErr == nil && keyErr == nil {
+		return certFile, keyFile, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return
+	}
+
+	template := x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"sensibleHub"},
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+	}
+
+	if host != "" {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return
+	}
+
+	err = os.MkdirAll(dir, os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	if err != nil {
+		return
+	}
+
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+	if err != nil {
+		return
+	}
+
+	return certFile, keyFile, nil
+}
+
+// moveLegacyCertificate moves a certificate and key generated by older versions out of the data directory,
+// so clients that trust the certificate don't see a new one
+func moveLegacyCertificate(certFile, keyFile string) (err error) {
+	if _, err = os.Stat(legacyKeyFile); os.IsNotExist(err) {
+		return nil
+	}
+
+	if _, err = os.Stat(keyFile); err == nil {
+		// A new key was already generated, the old one is no longer needed
+		return os.Remove(legacyKeyFile)
+	}
+
+	err = file.Move(legacyKeyFile, keyFile)
+	if err != nil {
+		return
+	}
+	err = os.Chmod(keyFile, 0600)
+	if err != nil {
+		return
+	}
+
+	err = file.Move(legacyCertFile, certFile)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+
+	return
+}
+
+// publicIP resolves the configured public host to an IPv4 address, which is what passive mode needs
+func publicIP(host string) (ip string, err error) {
+	if host == "" || net.ParseIP(host) != nil {
+		return host, nil
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return
+	}
+
+	for _, i := range ips {
+		if v4 := i.To4(); v4 != nil {
+			return v4.String(), nil
+		}
+	}
+
+	return "", &net.DNSError{Err: "no IPv4 address found", Name: host}
+}
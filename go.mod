@@ -15,8 +15,11 @@ require (
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
 	github.com/vitali-fedulov/images4 v1.3.1
 	goftp.io/server v0.4.1
+	golang.org/x/crypto v0.36.0
 	golang.org/x/image v0.25.0
+	golang.org/x/net v0.37.0
 	golang.org/x/sync v0.12.0
+	golang.org/x/text v0.23.0
 )
 
 require (
@@ -45,13 +48,10 @@ require (
 	github.com/stretchr/objx v0.1.1 // indirect
 	github.com/stretchr/testify v1.6.1 // indirect
 	github.com/yuin/goldmark v1.4.13 // indirect
-	golang.org/x/crypto v0.36.0 // indirect
 	golang.org/x/mod v0.17.0 // indirect
-	golang.org/x/net v0.37.0 // indirect
 	golang.org/x/sys v0.31.0 // indirect
 	golang.org/x/telemetry v0.0.0-20240228155512-f48c80bd79b2 // indirect
 	golang.org/x/term v0.30.0 // indirect
-	golang.org/x/text v0.23.0 // indirect
 	golang.org/x/tools v0.21.1-0.20240508182429-e35e4ccd0d2d // indirect
 	golang.org/x/xerrors v0.0.0-20190717185122-a985d3407aa7 // indirect
 	gopkg.in/check.v1 v0.0.0-20161208181325-20d25e280405 // indirect
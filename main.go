@@ -3,12 +3,14 @@ package main
 import (
 	"embed"
 	"flag"
+	"fmt"
 	"log"
 	"os/exec"
 
 	"xarantolus/sensibleHub/ftp"
 	"xarantolus/sensibleHub/store"
 	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/upnp"
 	"xarantolus/sensibleHub/web"
 
 	// Supported image formats
@@ -31,9 +33,19 @@ func main() {
 	var (
 		flagDebug  = flag.Bool("debug", false, "Start the server in debug mode")
 		flagConfig = flag.String("config", config.DefaultConfigFile, "Path to the configuration file")
+		flagHash   = flag.String("hash-password", "", "Print a hash of the given password that can be used in the configuration file, then exit")
 	)
 	flag.Parse()
 
+	if *flagHash != "" {
+		hash, err := config.HashPassword(*flagHash)
+		if err != nil {
+			panic("while hashing password: " + err.Error())
+		}
+		fmt.Println(hash)
+		return
+	}
+
 	if *flagDebug {
 		log.Println("[Debug] Debug mode enabled")
 	}
@@ -54,6 +66,9 @@ func main() {
 	checkInstalledCommand(cfg.Alternatives.FFmpeg)
 	checkInstalledCommand(cfg.Alternatives.FFprobe)
 	checkInstalledCommand(cfg.Alternatives.YoutubeDL)
+	if cfg.Alternatives.Fpcalc != "" {
+		checkInstalledCommand(cfg.Alternatives.Fpcalc)
+	}
 
 	// Let's initialize our Manager. This is the main data structure
 	// that handles basically everything
@@ -86,6 +101,12 @@ func main() {
 	// That way they aren't all generated on the first load of the /songs page
 	go manager.GenerateCoverPreviews()
 
+	// Fingerprint songs that were added before fingerprinting was enabled, they are needed for finding duplicates
+	go manager.FingerprintMissing()
+
+	// Songs that were added before audio quality was recorded are probed in the background
+	go manager.ProbeMissing()
+
 	// Start the FTP server
 	go func() {
 		err := ftp.RunServer(manager, cfg)
@@ -94,6 +115,17 @@ func main() {
 		}
 	}()
 
+	// Make the library discoverable for TVs and network speakers
+	if cfg.UPnP.Enabled {
+		go func() {
+			// The media server can still be used by devices that know its address, so this doesn't stop the other servers
+			err := upnp.RunServer(cfg)
+			if err != nil {
+				log.Printf("[UPnP] Error while running discovery, devices cannot find the media server: %s\n", err.Error())
+			}
+		}()
+	}
+
 	// And the web server of course
 	err = web.RunServer(manager, cfg, assetFS, templateFS, *flagDebug)
 	if err != nil {
@@ -0,0 +1,295 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"xarantolus/sensibleHub/store/music"
+)
+
+// BulkEditData describes changes that are applied to several songs at once. Fields that are nil stay the same
+type BulkEditData struct {
+	Artist *string `json:"artist"`
+	Album  *string `json:"album"`
+	Genre  *string `json:"genre"`
+
+	// Setting Year or Rating to 0 clears them
+	Year   *int `json:"year"`
+	Rating *int `json:"rating"`
+
+	Sync *bool `json:"sync"`
+
+	// Replace is applied after the fields above were set
+	Replace *BulkReplace `json:"replace"`
+
+	// Delete deletes the songs, it cannot be combined with other changes
+	Delete bool `json:"delete"`
+}
+
+// BulkReplace replaces text in the title, artist or album of songs
+type BulkReplace struct {
+	Find string `json:"find"`
+	With string `json:"with"`
+
+	// Regex means Find is a regular expression, With can then contain references like $1
+	Regex bool `json:"regex"`
+
+	// Fields are "title", "artist" and "album". If none are given, all of them are used
+	Fields []string `json:"fields"`
+}
+
+// BulkChange describes how a bulk edit changes one song
+type BulkChange struct {
+	// Song is the song after the edit. For deleted songs, it is the song before the edit
+	Song music.Entry `json:"song"`
+
+	Deleted bool          `json:"deleted,omitempty"`
+	Fields  []FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange is a field of a song that is changed
+type FieldChange struct {
+	Field  string `json:"field"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+var replaceFields = map[string]func(e *music.Entry) *string{
+	"title":  func(e *music.Entry) *string { return &e.MusicData.Title },
+	"artist": func(e *music.Entry) *string { return &e.MusicData.Artist },
+	"album":  func(e *music.Entry) *string { return &e.MusicData.Album },
+}
+
+// replacer returns a function that applies the replacement to a string
+func (r BulkReplace) replacer() (f func(string) string, err error) {
+	if r.Find == "" {
+		return nil, fmt.Errorf("Need a text to find")
+	}
+
+	for _, field := range r.Fields {
+		if _, ok := replaceFields[field]; !ok {
+			return nil, fmt.Errorf("Cannot replace text in field %q, must be \"title\", \"artist\" or \"album\"", field)
+		}
+	}
+
+	if !r.Regex {
+		return func(s string) string {
+			return strings.ReplaceAll(s, r.Find, r.With)
+		}, nil
+	}
+
+	re, err := regexp.Compile(r.Find)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid regular expression: %s", err.Error())
+	}
+
+	return func(s string) string {
+		return re.ReplaceAllString(s, r.With)
+	}, nil
+}
+
+// edit returns `e` with the changes applied
+func (data BulkEditData) edit(e music.Entry, replace func(string) string) (music.Entry, error) {
+	if data.Artist != nil {
+		e.MusicData.Artist = strings.TrimSpace(*data.Artist)
+	}
+	if data.Album != nil {
+		e.MusicData.Album = strings.TrimSpace(*data.Album)
+	}
+	if data.Genre != nil {
+		e.MusicData.Genre = strings.TrimSpace(*data.Genre)
+	}
+	if data.Year != nil {
+		if *data.Year == 0 {
+			e.MusicData.Year = nil
+		} else if e.MusicData.Year == nil || *e.MusicData.Year != *data.Year {
+			year := *data.Year
+			e.MusicData.Year = &year
+		}
+	}
+	if data.Rating != nil {
+		e.MusicData.Rating = *data.Rating
+	}
+	if data.Sync != nil {
+		e.SyncSettings.Should = *data.Sync
+	}
+
+	if replace != nil {
+		fields := data.Replace.Fields
+		if len(fields) == 0 {
+			fields = []string{"title", "artist", "album"}
+		}
+
+		for _, field := range fields {
+			value := replaceFields[field](&e)
+			*value = strings.TrimSpace(replace(*value))
+		}
+	}
+
+	if e.MusicData.Title == "" {
+		return e, fmt.Errorf("Song %s would not have a title", e.ID)
+	}
+
+	return e, nil
+}
+
+// fieldChanges returns all fields that a bulk edit can change that are different in `before` and `after`
+func fieldChanges(before, after music.Entry) (changes []FieldChange) {
+	formatYear := func(y *int) string {
+		if y == nil {
+			return ""
+		}
+		return strconv.Itoa(*y)
+	}
+	formatI := func(i int) string {
+		if i == 0 {
+			return ""
+		}
+		return strconv.Itoa(i)
+	}
+
+	fields := []FieldChange{
+		{"title", before.MusicData.Title, after.MusicData.Title},
+		{"artist", before.MusicData.Artist, after.MusicData.Artist},
+		{"album", before.MusicData.Album, after.MusicData.Album},
+		{"year", formatYear(before.MusicData.Year), formatYear(after.MusicData.Year)},
+		{"genre", before.MusicData.Genre, after.MusicData.Genre},
+		{"rating", formatI(before.MusicData.Rating), formatI(after.MusicData.Rating)},
+		{"sync", strconv.FormatBool(before.SyncSettings.Should), strconv.FormatBool(after.SyncSettings.Should)},
+	}
+
+	for _, f := range fields {
+		if f.Before != f.After {
+			changes = append(changes, f)
+		}
+	}
+
+	return
+}
+
+// bulkChanges returns the changes `data` would make to the songs with the given IDs, songs that stay the same
+// are left out. If one of the songs cannot be changed, nothing is returned.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) bulkChanges(ids []string, data BulkEditData) (changes []BulkChange, err error) {
+	changesFields := data.Artist != nil || data.Album != nil || data.Genre != nil || data.Year != nil ||
+		data.Rating != nil || data.Sync != nil || data.Replace != nil
+
+	if data.Delete && changesFields {
+		return nil, fmt.Errorf("Deleting songs cannot be combined with other changes")
+	}
+	if !data.Delete && !changesFields {
+		return nil, fmt.Errorf("Nothing to change")
+	}
+	if data.Rating != nil && (*data.Rating < 0 || *data.Rating > 5) {
+		return nil, fmt.Errorf("Rating must be between 0 and 5")
+	}
+
+	var replace func(string) string
+	if data.Replace != nil {
+		replace, err = data.Replace.replacer()
+		if err != nil {
+			return
+		}
+	}
+
+	var seen = make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		entry, ok := m.Songs[id]
+		if !ok {
+			return nil, fmt.Errorf("Cannot edit entry with id %s as it doesn't exist", id)
+		}
+
+		if data.Delete {
+			changes = append(changes, BulkChange{Song: entry, Deleted: true})
+			continue
+		}
+
+		edited, err := data.edit(entry, replace)
+		if err != nil {
+			return nil, err
+		}
+
+		if fields := fieldChanges(entry, edited); len(fields) > 0 {
+			changes = append(changes, BulkChange{Song: edited, Fields: fields})
+		}
+	}
+
+	return
+}
+
+// PreviewEditEntries returns the changes EditEntries would make without applying them
+func (m *Manager) PreviewEditEntries(ids []string, data BulkEditData) (changes []BulkChange, err error) {
+	m.SongsLock.RLock()
+	defer m.SongsLock.RUnlock()
+
+	return m.bulkChanges(ids, data)
+}
+
+// EditEntries applies `data` to all songs with the given IDs. Either all songs are changed or none of them.
+// All changes are sent in one "songs-edit" event, which contains the changed songs and the IDs of deleted ones
+func (m *Manager) EditEntries(ids []string, data BulkEditData) (changes []BulkChange, err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	changes, err = m.bulkChanges(ids, data)
+	if err != nil || len(changes) == 0 {
+		return
+	}
+
+	return changes, m.applyChanges(changes)
+}
+
+// applyChanges saves the songs in `changes` and deletes the ones that are marked as deleted.
+// All changes are sent in one "songs-edit" event.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) applyChanges(changes []BulkChange) (err error) {
+	var (
+		now = time.Now()
+
+		edited  = []music.Entry{}
+		deleted = []string{}
+	)
+	for i, c := range changes {
+		if c.Deleted {
+			m.forgetEntry(c.Song.ID)
+			deleted = append(deleted, c.Song.ID)
+			continue
+		}
+
+		c.Song.LastEdit = now
+		changes[i] = c
+
+		m.Songs[c.Song.ID] = c.Song
+		m.indexEntry(c.Song)
+		edited = append(edited, c.Song)
+	}
+
+	err = m.Save(false)
+	if err != nil {
+		return
+	}
+
+	// Files are only deleted after the songs were removed from the library
+	for _, c := range changes {
+		if c.Deleted && c.Song.ID != "" {
+			if rerr := os.RemoveAll(c.Song.DirPath()); rerr != nil && err == nil {
+				err = rerr
+			}
+		}
+	}
+
+	m.event("songs-edit", map[string]interface{}{
+		"songs":   edited,
+		"deleted": deleted,
+	})
+
+	return
+}
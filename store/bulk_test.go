@@ -0,0 +1,133 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func bulkTestManager() *Manager {
+	year := 1975
+	return &Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs: map[string]music.Entry{
+			"a": {ID: "a", MusicData: music.MusicData{Title: "Bohemian Rhapsody (Remastered)", Artist: "Qeen", Album: "A Night at the Opera", Year: &year}},
+			"b": {ID: "b", MusicData: music.MusicData{Title: "Love of My Life (Remastered)", Artist: "Qeen", Album: "A Night at the Opera"}},
+			"c": {ID: "c", MusicData: music.MusicData{Title: "Innuendo", Artist: "Queen", Album: "Innuendo", Year: &year}, SyncSettings: music.SyncSettings{Should: true}},
+		},
+		Playlists: map[string]Playlist{
+			"p": {ID: "p", Name: "Queen", Songs: []string{"a", "b", "c"}},
+		},
+	}
+}
+
+func TestManager_EditEntries(t *testing.T) {
+	// Editing saves to the data directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	m := bulkTestManager()
+
+	artist, year := "Queen", 1975
+	data := BulkEditData{
+		Artist:  &artist,
+		Year:    &year,
+		Replace: &BulkReplace{Find: `\s*\(Remaster(ed)?\)$`, Regex: true, Fields: []string{"title"}},
+	}
+
+	// Previews don't change anything
+	changes, err := m.PreviewEditEntries([]string{"a", "b", "c", "a"}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected changes for a and b, got %+v", changes)
+	}
+	if m.Songs["a"].MusicData.Artist != "Qeen" {
+		t.Fatalf("preview changed song: %+v", m.Songs["a"])
+	}
+
+	a := changes[0]
+	if a.Song.ID != "a" || a.Song.MusicData.Title != "Bohemian Rhapsody" || len(a.Fields) != 2 {
+		t.Errorf("unexpected change for a: %+v", a)
+	}
+	if b := changes[1]; b.Song.MusicData.Title != "Love of My Life" || *b.Song.MusicData.Year != 1975 || len(b.Fields) != 3 {
+		t.Errorf("unexpected change for b: %+v", b)
+	}
+
+	// If one song doesn't exist, nothing is changed
+	_, err = m.EditEntries([]string{"a", "missing"}, data)
+	if err == nil {
+		t.Errorf("expected error for missing song")
+	}
+	if m.Songs["a"].MusicData.Artist != "Qeen" {
+		t.Fatalf("song was changed even though the edit failed: %+v", m.Songs["a"])
+	}
+
+	// Titles must not become empty
+	_, err = m.EditEntries([]string{"a", "c"}, BulkEditData{Replace: &BulkReplace{Find: "Innuendo"}})
+	if err == nil || m.Songs["c"].MusicData.Album != "Innuendo" {
+		t.Errorf("expected error for empty title, got %v", err)
+	}
+
+	changes, err = m.EditEntries([]string{"a", "b", "c"}, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %d", len(changes))
+	}
+	for _, id := range []string{"a", "b"} {
+		e := m.Songs[id]
+		if e.MusicData.Artist != "Queen" || e.LastEdit.IsZero() {
+			t.Errorf("song %s wasn't edited: %+v", id, e)
+		}
+	}
+	if !m.Songs["c"].LastEdit.IsZero() {
+		t.Errorf("song c was edited even though nothing changed")
+	}
+
+	_, err = m.EditEntries([]string{"a"}, BulkEditData{})
+	if err == nil {
+		t.Errorf("expected error for an edit without changes")
+	}
+
+	_, err = m.EditEntries([]string{"a"}, BulkEditData{Replace: &BulkReplace{Find: "(", Regex: true}})
+	if err == nil {
+		t.Errorf("expected error for invalid regex")
+	}
+
+	// Deleting
+	err = os.MkdirAll(filepath.Join("data", "songs", "b"), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.EditEntries([]string{"a", "b"}, BulkEditData{Delete: true, Artist: &artist})
+	if err == nil {
+		t.Errorf("expected error when deleting and editing at the same time")
+	}
+
+	changes, err = m.EditEntries([]string{"a", "b"}, BulkEditData{Delete: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 2 || !changes[0].Deleted || len(m.Songs) != 1 {
+		t.Errorf("songs weren't deleted: %+v", m.Songs)
+	}
+	if p := m.Playlists["p"]; len(p.Songs) != 1 || p.Songs[0] != "c" {
+		t.Errorf("deleted songs are still in playlist: %v", p.Songs)
+	}
+	if _, err := os.Stat(filepath.Join("data", "songs", "b")); !os.IsNotExist(err) {
+		t.Errorf("song directory wasn't deleted: %v", err)
+	}
+}
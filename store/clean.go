@@ -7,6 +7,7 @@ import (
 	"os"
 	"path/filepath"
 	"time"
+	"xarantolus/sensibleHub/store/music"
 )
 
 // CleanUp removes all unused directories in the data directory. They might not have been deleted due to errors.
@@ -84,6 +85,7 @@ func (m *Manager) runCleanJob(maxAgeDays int) {
 // DeleteGeneratedFiles deletes generates files older than maxAgeDays * 24 * Hour.
 // if maxAgeDays is less than 0, no files will be deleted
 // if maxAgeDays is 0, all generated files will be deleted
+// If `keep_generated_until_synced` is set in the config, files are kept until all devices that synced before have downloaded them
 func (m *Manager) DeleteGeneratedFiles(maxAgeDays int) (n int) {
 	// If it's less than 0, don't delete any songs
 	if maxAgeDays < 0 {
@@ -93,26 +95,49 @@ func (m *Manager) DeleteGeneratedFiles(maxAgeDays int) (n int) {
 	// Delete all generated files that were created before this date
 	maxDate := time.Now().Add(time.Duration(-maxAgeDays) * 24 * time.Hour)
 
+	var filters map[string]Filter
+	if m.cfg.KeepGeneratedUntilSynced {
+		filters = m.userFilters()
+	}
+
 	m.SongsLock.RLock()
 	defer m.SongsLock.RUnlock()
 
 	for _, song := range m.Songs {
+		// Files generated with settings that changed since are never used again, no matter how old they are
+		for _, outName := range song.OutdatedGenerated(m.cfg) {
+			if os.Remove(outName) == nil {
+				n++
+			}
+		}
+
 		if song.LastEdit.After(maxDate) {
 			continue
 		}
 
-		// this is the mp3 file
-		outName := filepath.Join("data", "songs", song.ID, "latest.mp3")
+		// These are the mp3 file and files of all other transcoding profiles
+		generated, _ := filepath.Glob(filepath.Join("data", "songs", song.ID, "latest*"))
 
-		err := os.Remove(outName)
-		if err != nil {
-			if !os.IsNotExist(err) {
-				log.Printf("Cannot clean up MP3 file for %s (%s): %s\n", song.SongName(), song.ID, err.Error())
-			}
-			continue
+		var pending map[string]bool
+		if m.cfg.KeepGeneratedUntilSynced {
+			pending = m.pendingProfiles(song, filters)
 		}
 
-		n++
+		for _, outName := range generated {
+			if pending[music.GeneratedProfile(filepath.Base(outName))] {
+				continue
+			}
+
+			err := os.Remove(outName)
+			if err != nil {
+				if !os.IsNotExist(err) {
+					log.Printf("Cannot clean up generated file %s for %s (%s): %s\n", filepath.Base(outName), song.SongName(), song.ID, err.Error())
+				}
+				continue
+			}
+
+			n++
+		}
 	}
 
 	return
@@ -1,31 +1,83 @@
 package config
 
 import (
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/muhammadmuzzammil1998/jsonc"
 )
 
 type Config struct {
+	// Dir is the directory of the configuration file. Generated files that must not be served
+	// from the data directory, like the key of the FTPS certificate, are stored next to it
+	Dir string `json:"-"`
+
 	Port int `json:"port"`
 
 	FTP struct {
 		Port int `json:"port"`
 
-		Users []struct {
-			Name   string `json:"name"`
-			Passwd string `json:"passwd"`
-		} `json:"users"`
+		TLS struct {
+			// Mode is "explicit" (clients upgrade the connection using AUTH TLS), "implicit" (TLS from the start) or empty to disable TLS
+			Mode string `json:"mode"`
+			// Force makes clients upgrade to TLS before logging in when using explicit mode
+			Force bool `json:"force"`
+
+			// CertFile and KeyFile are paths to PEM files. If they are empty, a self-signed certificate is generated
+			CertFile string `json:"cert_file"`
+			KeyFile  string `json:"key_file"`
+		} `json:"tls"`
+
+		// PassivePorts is a port range for passive connections, e.g. "30000-30009". If empty, random ports are used
+		PassivePorts string `json:"passive_ports"`
+		// PublicHost is the IP address or host name clients should connect to for passive connections
+		PublicHost string `json:"public_host"`
+
+		Users []FTPUser `json:"users"`
 	} `json:"ftp"`
 
+	// Web configures who can use the website and its API
+	Web struct {
+		// Users are accounts for the website. If there are none, no FTP user has a web role and
+		// no proxy header is set, everyone can use the website
+		Users []WebUser `json:"users"`
+
+		// ProxyHeader is the header a trusted reverse proxy sets to the name of the user it authenticated, e.g. "X-Forwarded-User"
+		ProxyHeader string `json:"proxy_header"`
+		// TrustedProxies are the IP addresses or CIDR ranges of reverse proxies that may set ProxyHeader
+		TrustedProxies []string `json:"trusted_proxies"`
+		// ProxyRole is the role of users authenticated by the proxy that don't have an account. If empty, they are rejected
+		ProxyRole string `json:"proxy_role"`
+	} `json:"web"`
+
+	// UPnP configures the optional DLNA media server for TVs and network speakers
+	UPnP struct {
+		Enabled bool `json:"enabled"`
+
+		// Name is the name devices show for this server, defaults to "sensibleHub"
+		Name string `json:"name"`
+
+		// Interface is the name of the network interface used for discovery, e.g. "eth0". If empty, all interfaces are used
+		Interface string `json:"interface"`
+
+		// Profile is the transcoding profile of the files offered in addition to the original audio files
+		Profile string `json:"profile"`
+	} `json:"upnp"`
+
 	KeepGeneratedDays int `json:"keep_generated_days"`
+	// KeepGeneratedUntilSynced keeps generated files older than KeepGeneratedDays until all devices that have synced before downloaded them
+	KeepGeneratedUntilSynced bool `json:"keep_generated_until_synced"`
 
 	Cover struct {
 		MaxSize int `json:"max_size"`
 	} `json:"cover"`
 
+	// Profiles are named output formats, e.g. "opus" or "car"
+	Profiles map[string]Profile `json:"profiles"`
+
 	AllowExternal struct {
 		Apple bool `json:"apple"`
 	} `json:"allow_external"`
@@ -34,11 +86,58 @@ type Config struct {
 		FFmpeg    string `json:"ffmpeg"`
 		FFprobe   string `json:"ffprobe"`
 		YoutubeDL string `json:"youtube-dl"`
+		// Fpcalc is Chromaprint's fingerprinting tool used for finding duplicates. If empty, songs are not fingerprinted
+		Fpcalc string `json:"fpcalc"`
 	} `json:"alternatives"`
 
 	GenerateOnStartup bool `json:"generate_on_startup"`
 }
 
+// FTPUser is an account that can log in via FTP
+type FTPUser struct {
+	Name string `json:"name"`
+	// Passwd is either a plaintext password or a bcrypt/argon2 hash of it, see CheckPassword
+	Passwd string `json:"passwd"`
+
+	// Profile is the name of the transcoding profile for files of this user. Empty means mp3
+	Profile string `json:"profile"`
+
+	// Filter restricts which songs this user can see
+	Filter SongFilter `json:"filter"`
+
+	// Delete is what happens when this user deletes a file: "unsync" disables syncing the song,
+	// "trash" moves it to the trash directory. If empty, deleting is not allowed
+	Delete string `json:"delete"`
+	// Rename allows renaming files and directories, which changes the tags of the songs in them
+	Rename bool `json:"rename"`
+	// DisableUpload disallows importing files
+	DisableUpload bool `json:"disable_upload"`
+
+	// WebRole allows this user to log in to the website with the given role, see WebUser. If empty, they cannot log in
+	WebRole string `json:"web_role"`
+
+	// Layout is the path template for files, e.g. "{artist}/{album}/{track:02} {title}.{ext}". Empty means "{artist}/{album}/{name}.{ext}"
+	Layout string `json:"layout"`
+}
+
+// SongFilter selects songs. Empty fields don't restrict anything
+type SongFilter struct {
+	// Artists and Albums are lists of names, matching is case-insensitive
+	Artists []string `json:"artists"`
+	Albums  []string `json:"albums"`
+
+	// MinYear and MaxYear define an inclusive year range. Songs without a year don't match if one of them is set
+	MinYear int `json:"min_year"`
+	MaxYear int `json:"max_year"`
+
+	// MinRating is the minimum number of stars
+	MinRating int `json:"min_rating"`
+
+	// Playlists are names of playlists or smart playlists, only songs in one of them match.
+	// Matching is case-insensitive
+	Playlists []string `json:"playlists"`
+}
+
 const (
 	DefaultConfigFile = "config.json"
 )
@@ -54,11 +153,67 @@ func Parse(path string) (c Config, err error) {
 		return
 	}
 
+	c.Dir = filepath.Dir(path)
+
+	for name, p := range c.Profiles {
+		err = p.validate(name)
+		if err != nil {
+			return
+		}
+		c.Profiles[name] = p
+	}
+
+	switch c.FTP.TLS.Mode {
+	case "", "explicit", "implicit":
+	default:
+		return c, fmt.Errorf("invalid FTP TLS mode %q, must be \"explicit\", \"implicit\" or empty", c.FTP.TLS.Mode)
+	}
+
+	if (c.FTP.TLS.CertFile == "") != (c.FTP.TLS.KeyFile == "") {
+		return c, fmt.Errorf("FTP TLS needs both a certificate and a key file")
+	}
+
+	if c.FTP.PassivePorts != "" {
+		var start, end int
+		_, err = fmt.Sscanf(c.FTP.PassivePorts, "%d-%d", &start, &end)
+		if err != nil || start <= 0 || end > 65535 || start > end {
+			return c, fmt.Errorf("invalid FTP passive port range %q, must be like \"30000-30009\"", c.FTP.PassivePorts)
+		}
+	}
+
+	for _, u := range c.FTP.Users {
+		if _, ok := c.Profile(u.Profile); !ok {
+			return c, fmt.Errorf("FTP user %q uses profile %q, but it doesn't exist", u.Name, u.Profile)
+		}
+
+		switch u.Delete {
+		case "", "unsync", "trash":
+		default:
+			return c, fmt.Errorf("FTP user %q has invalid delete setting %q, must be \"unsync\", \"trash\" or empty", u.Name, u.Delete)
+		}
+	}
+
+	err = c.validateWeb()
+	if err != nil {
+		return
+	}
+
+	if _, ok := c.Profile(c.UPnP.Profile); !ok {
+		return c, fmt.Errorf("UPnP uses profile %q, but it doesn't exist", c.UPnP.Profile)
+	}
+
+	if strings.TrimSpace(c.UPnP.Name) == "" {
+		c.UPnP.Name = "sensibleHub"
+	}
+
 	rid, ok := os.LookupEnv("RUNNING_IN_DOCKER")
 	if ok && strings.ToLower(rid) == "true" {
 		c.Alternatives.FFmpeg = "ffmpeg"
 		c.Alternatives.FFprobe = "ffprobe"
 		c.Alternatives.YoutubeDL = "yt-dlp"
+		if strings.TrimSpace(c.Alternatives.Fpcalc) != "" {
+			c.Alternatives.Fpcalc = "fpcalc"
+		}
 		log.Println("[Info] Running in Docker, using local binaries. This means that the \"alternatives\" config part is ignored!")
 	} else {
 		// Set default paths/names if none are set
@@ -74,6 +229,8 @@ func Parse(path string) (c Config, err error) {
 		if c.Alternatives.YoutubeDL == "" {
 			c.Alternatives.YoutubeDL = "youtube-dl"
 		}
+		// Fingerprinting is optional, so there is no default
+		c.Alternatives.Fpcalc = strings.TrimSpace(c.Alternatives.Fpcalc)
 	}
 
 	return
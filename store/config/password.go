@@ -0,0 +1,95 @@
+package config
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// CheckPassword returns whether `password` matches `stored`, which is either a bcrypt hash ("$2a$...", "$2b$..."),
+// an argon2 hash in PHC format ("$argon2id$v=19$m=65536,t=3,p=4$salt$hash") or a plaintext password
+func CheckPassword(stored, password string) bool {
+	switch {
+	case strings.HasPrefix(stored, "$2a$"), strings.HasPrefix(stored, "$2b$"), strings.HasPrefix(stored, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	case strings.HasPrefix(stored, "$argon2"):
+		ok, err := checkArgon2(stored, password)
+		return err == nil && ok
+	default:
+		return len(stored) == len(password) && subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	}
+}
+
+// CheckToken checks a token like the Subsonic API sends it: the hex MD5 of the password and `salt`.
+// This only works for plaintext passwords, `supported` is false for hashes
+func CheckToken(stored, token, salt string) (ok, supported bool) {
+	if strings.HasPrefix(stored, "$2") || strings.HasPrefix(stored, "$argon2") {
+		return false, false
+	}
+
+	sum := md5.Sum([]byte(stored + salt))
+	expected := hex.EncodeToString(sum[:])
+
+	token = strings.ToLower(token)
+
+	return len(expected) == len(token) && subtle.ConstantTimeCompare([]byte(expected), []byte(token)) == 1, true
+}
+
+// HashPassword returns a bcrypt hash of `password` that can be used instead of the password in the config file
+func HashPassword(password string) (string, error) {
+	h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(h), err
+}
+
+func checkArgon2(stored, password string) (ok bool, err error) {
+	// "", variant, version, parameters, salt, hash
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false, fmt.Errorf("invalid argon2 hash")
+	}
+
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil {
+		return
+	}
+	if version != argon2.Version {
+		return false, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+
+	var (
+		memory, time uint32
+		threads      uint8
+	)
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads)
+	if err != nil {
+		return
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return
+	}
+
+	var computed []byte
+	switch parts[1] {
+	case "argon2id":
+		computed = argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(hash)))
+	case "argon2i":
+		computed = argon2.Key([]byte(password), salt, time, memory, threads, uint32(len(hash)))
+	default:
+		return false, fmt.Errorf("unsupported argon2 variant %q", parts[1])
+	}
+
+	return subtle.ConstantTimeCompare(hash, computed) == 1, nil
+}
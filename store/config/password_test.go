@@ -0,0 +1,65 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+)
+
+func TestCheckPassword(t *testing.T) {
+	bcryptHash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	salt := []byte("somesaltsomesalt")
+	argonHash := fmt.Sprintf("$argon2id$v=%d$m=1024,t=1,p=1$%s$%s", argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte("secret"), salt, 1, 1024, 1, 32)))
+
+	tests := []struct {
+		stored   string
+		password string
+		want     bool
+	}{
+		{"secret", "secret", true},
+		{"secret", "Secret", false},
+		{"secret", "", false},
+		{bcryptHash, "secret", true},
+		{bcryptHash, "wrong", false},
+		{bcryptHash, bcryptHash, false},
+		{argonHash, "secret", true},
+		{argonHash, "wrong", false},
+		{"$argon2id$v=19$invalid", "secret", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.stored+"/"+tt.password, func(t *testing.T) {
+			if got := CheckPassword(tt.stored, tt.password); got != tt.want {
+				t.Errorf("CheckPassword() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckToken(t *testing.T) {
+	tests := []struct {
+		stored, token, salt string
+		ok, supported       bool
+	}{
+		// Example from the Subsonic API documentation
+		{"sesame", "26719a1196d2a940705a59634eb18eab", "c19b2d", true, true},
+		{"sesame", "26719A1196D2A940705A59634EB18EAB", "c19b2d", true, true},
+		{"sesame", "26719a1196d2a940705a59634eb18eab", "other", false, true},
+		{"$2a$10$abcdefghijklmnopqrstuv", "26719a1196d2a940705a59634eb18eab", "c19b2d", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.stored+"/"+tt.salt, func(t *testing.T) {
+			ok, supported := CheckToken(tt.stored, tt.token, tt.salt)
+			if ok != tt.ok || supported != tt.supported {
+				t.Errorf("CheckToken() = %v, %v, want %v, %v", ok, supported, tt.ok, tt.supported)
+			}
+		})
+	}
+}
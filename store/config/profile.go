@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Profile describes an output format songs can be transcoded to
+type Profile struct {
+	// Codec is one of "mp3", "aac", "opus", "flac" or "copy".
+	// "copy" keeps the original audio stream and container if possible
+	Codec string `json:"codec"`
+
+	// Bitrate is the constant bitrate in kbit/s. If it is 0, Quality or the encoder default is used
+	Bitrate int `json:"bitrate"`
+	// Quality is the VBR quality passed to the encoder, e.g. 0-9 for mp3 where 0 is best. Ignored if a bitrate is set.
+	// Opus doesn't support it
+	Quality *float64 `json:"quality"`
+
+	// SampleRate in Hz. If 0, the sample rate of the source is kept
+	SampleRate int `json:"sample_rate"`
+
+	Cover struct {
+		// MaxSize is the maximum width of embedded cover images. If 0, the global cover size is used
+		MaxSize int `json:"max_size"`
+		// Format is "jpeg", "png" or "none" to not embed any cover image
+		Format string `json:"format"`
+	} `json:"cover"`
+
+	// TagVersion is the ID3v2 version (3 or 4) for mp3 files
+	TagVersion int `json:"tag_version"`
+}
+
+var (
+	profileNameRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+	codecExtensions = map[string]string{
+		"mp3":  "mp3",
+		"aac":  "m4a",
+		"opus": "opus",
+		"flac": "flac",
+		"copy": "",
+	}
+)
+
+// Extension returns the file extension of files generated with this profile.
+// For the "copy" codec, it is the extension of the source audio `sourceExt`
+func (p Profile) Extension(sourceExt string) string {
+	if p.Codec == "copy" {
+		return strings.ToLower(strings.TrimPrefix(sourceExt, "."))
+	}
+	return codecExtensions[p.Codec]
+}
+
+// Profile returns the transcoding profile with the given name.
+// An empty name returns the default mp3 profile
+func (c *Config) Profile(name string) (p Profile, ok bool) {
+	if name == "" {
+		p.Codec = "mp3"
+		p.Cover.MaxSize = c.Cover.MaxSize
+		p.Cover.Format = "jpeg"
+		p.TagVersion = 3
+		return p, true
+	}
+
+	p, ok = c.Profiles[name]
+	return
+}
+
+func (p *Profile) validate(name string) error {
+	if !profileNameRegex.MatchString(name) {
+		return fmt.Errorf("profile name %q may only contain letters, numbers, '-' and '_'", name)
+	}
+
+	p.Codec = strings.ToLower(strings.TrimSpace(p.Codec))
+	if _, ok := codecExtensions[p.Codec]; !ok {
+		return fmt.Errorf("profile %q has unknown codec %q", name, p.Codec)
+	}
+
+	p.Cover.Format = strings.ToLower(strings.TrimSpace(p.Cover.Format))
+	switch p.Cover.Format {
+	case "", "jpg":
+		p.Cover.Format = "jpeg"
+	case "jpeg", "png", "none":
+	default:
+		return fmt.Errorf("profile %q has unknown cover format %q", name, p.Cover.Format)
+	}
+
+	switch p.TagVersion {
+	case 0:
+		p.TagVersion = 3
+	case 3, 4:
+	default:
+		return fmt.Errorf("profile %q has invalid tag version %d, must be 3 or 4", name, p.TagVersion)
+	}
+
+	if p.Bitrate < 0 || p.SampleRate < 0 {
+		return fmt.Errorf("profile %q must not have a negative bitrate or sample rate", name)
+	}
+
+	// libopus only has bitrates, a quality would be ignored
+	if p.Codec == "opus" && p.Quality != nil {
+		return fmt.Errorf("profile %q uses opus, which doesn't support quality values. Set a bitrate instead", name)
+	}
+
+	return nil
+}
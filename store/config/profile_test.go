@@ -0,0 +1,26 @@
+package config
+
+import "testing"
+
+func TestProfile_validate(t *testing.T) {
+	quality := 2.0
+
+	tests := []struct {
+		name    string
+		profile Profile
+		wantErr bool
+	}{
+		{"mp3 with quality", Profile{Codec: "mp3", Quality: &quality}, false},
+		{"opus with bitrate", Profile{Codec: "OPUS", Bitrate: 96}, false},
+		{"opus with quality", Profile{Codec: "opus", Quality: &quality}, true},
+		{"unknown codec", Profile{Codec: "wma"}, true},
+		{"negative bitrate", Profile{Codec: "aac", Bitrate: -1}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.profile.validate("test"); (err != nil) != tt.wantErr {
+				t.Errorf("Profile.validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
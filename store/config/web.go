@@ -0,0 +1,131 @@
+package config
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// Roles of website users. Listeners can only look at songs and play them, editors can also
+// add and change songs and playlists, admins can additionally delete songs
+const (
+	RoleListener = "listener"
+	RoleEditor   = "editor"
+	RoleAdmin    = "admin"
+)
+
+// WebUser is an account that can log in to the website
+type WebUser struct {
+	Name string `json:"name"`
+	// Passwd is either a plaintext password or a bcrypt/argon2 hash of it, see CheckPassword
+	Passwd string `json:"passwd"`
+
+	// Role is "admin", "editor" or "listener"
+	Role string `json:"role"`
+}
+
+func validRole(role string) bool {
+	switch role {
+	case RoleListener, RoleEditor, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// WebAuthEnabled returns whether anyone needs to log in to use the website
+func (c Config) WebAuthEnabled() bool {
+	return len(c.webUsers()) > 0 || c.Web.ProxyHeader != ""
+}
+
+// webUsers returns all website accounts, including FTP users with a web role
+func (c Config) webUsers() (users []WebUser) {
+	users = append(users, c.Web.Users...)
+
+	for _, u := range c.FTP.Users {
+		if u.WebRole != "" {
+			users = append(users, WebUser{
+				Name:   u.Name,
+				Passwd: u.Passwd,
+				Role:   u.WebRole,
+			})
+		}
+	}
+
+	return
+}
+
+// FindWebUser returns the website account with the given name. Accounts in the web section
+// take precedence over FTP users with the same name
+func (c Config) FindWebUser(name string) (u WebUser, ok bool) {
+	for _, user := range c.webUsers() {
+		if len(name) == len(user.Name) && subtle.ConstantTimeCompare([]byte(name), []byte(user.Name)) == 1 {
+			return user, true
+		}
+	}
+	return
+}
+
+// CheckWebUser returns the website account with the given credentials
+func (c Config) CheckWebUser(name, pass string) (u WebUser, ok bool) {
+	u, ok = c.FindWebUser(name)
+	if !ok || !CheckPassword(u.Passwd, pass) {
+		return WebUser{}, false
+	}
+	return u, true
+}
+
+// IsTrustedProxy returns whether `ip` belongs to one of the trusted reverse proxies
+func (c Config) IsTrustedProxy(ip net.IP) bool {
+	for _, p := range c.Web.TrustedProxies {
+		_, network, err := net.ParseCIDR(p)
+		if err == nil {
+			if network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		if trusted := net.ParseIP(p); trusted != nil && trusted.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Config) validateWeb() error {
+	for _, u := range c.Web.Users {
+		if strings.TrimSpace(u.Name) == "" {
+			return fmt.Errorf("web users need a name")
+		}
+		if !validRole(u.Role) {
+			return fmt.Errorf("web user %q has invalid role %q, must be \"admin\", \"editor\" or \"listener\"", u.Name, u.Role)
+		}
+	}
+
+	for _, u := range c.FTP.Users {
+		if u.WebRole != "" && !validRole(u.WebRole) {
+			return fmt.Errorf("FTP user %q has invalid web role %q, must be \"admin\", \"editor\", \"listener\" or empty", u.Name, u.WebRole)
+		}
+	}
+
+	c.Web.ProxyHeader = strings.TrimSpace(c.Web.ProxyHeader)
+	if c.Web.ProxyHeader == "" {
+		return nil
+	}
+
+	if len(c.Web.TrustedProxies) == 0 {
+		return fmt.Errorf("the web proxy header needs at least one trusted proxy address")
+	}
+	for _, p := range c.Web.TrustedProxies {
+		if _, _, err := net.ParseCIDR(p); err != nil && net.ParseIP(p) == nil {
+			return fmt.Errorf("invalid trusted proxy %q, must be an IP address or CIDR range", p)
+		}
+	}
+
+	if c.Web.ProxyRole != "" && !validRole(c.Web.ProxyRole) {
+		return fmt.Errorf("invalid web proxy role %q, must be \"admin\", \"editor\", \"listener\" or empty", c.Web.ProxyRole)
+	}
+
+	return nil
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"net"
+	"testing"
+)
+
+func TestConfig_CheckWebUser(t *testing.T) {
+	var c Config
+	c.Web.Users = []WebUser{
+		{Name: "admin", Passwd: "admin-password", Role: RoleAdmin},
+		{Name: "shared", Passwd: "web-password", Role: RoleListener},
+	}
+	c.FTP.Users = []FTPUser{
+		{Name: "phone", Passwd: "phone-password", WebRole: RoleEditor},
+		{Name: "car", Passwd: "car-password"},
+		{Name: "shared", Passwd: "ftp-password", WebRole: RoleAdmin},
+	}
+
+	tests := []struct {
+		name, pass string
+
+		wantOK   bool
+		wantRole string
+	}{
+		{"admin", "admin-password", true, RoleAdmin},
+		{"admin", "wrong", false, ""},
+		{"phone", "phone-password", true, RoleEditor},
+		// FTP users without a web role cannot log in
+		{"car", "car-password", false, ""},
+		// Web accounts take precedence
+		{"shared", "web-password", true, RoleListener},
+		{"shared", "ftp-password", false, ""},
+		{"unknown", "", false, ""},
+	}
+
+	for _, tt := range tests {
+		u, ok := c.CheckWebUser(tt.name, tt.pass)
+		if ok != tt.wantOK || u.Role != tt.wantRole {
+			t.Errorf("CheckWebUser(%q, %q) = %q, %v, want %q, %v", tt.name, tt.pass, u.Role, ok, tt.wantRole, tt.wantOK)
+		}
+	}
+
+	if !c.WebAuthEnabled() {
+		t.Errorf("web authentication should be enabled")
+	}
+}
+
+func TestConfig_validateWeb(t *testing.T) {
+	var c Config
+	if err := c.validateWeb(); err != nil || c.WebAuthEnabled() {
+		t.Errorf("an empty config should be valid and not require logging in: %v", err)
+	}
+
+	c.Web.Users = []WebUser{{Name: "a", Role: "owner"}}
+	if err := c.validateWeb(); err == nil {
+		t.Errorf("expected an error for an invalid role")
+	}
+
+	c.Web.Users = nil
+	c.Web.ProxyHeader = "X-Forwarded-User"
+	if err := c.validateWeb(); err == nil {
+		t.Errorf("expected an error for a proxy header without trusted proxies")
+	}
+
+	c.Web.TrustedProxies = []string{"127.0.0.1", "10.0.0.0/8", "::1"}
+	if err := c.validateWeb(); err != nil {
+		t.Fatal(err)
+	}
+
+	for ip, want := range map[string]bool{"127.0.0.1": true, "10.1.2.3": true, "::1": true, "192.168.1.1": false} {
+		if got := c.IsTrustedProxy(net.ParseIP(ip)); got != want {
+			t.Errorf("IsTrustedProxy(%s) = %v, want %v", ip, got, want)
+		}
+	}
+
+	c.Web.TrustedProxies = []string{"localhost"}
+	if err := c.validateWeb(); err == nil {
+		t.Errorf("expected an error for an invalid trusted proxy")
+	}
+}
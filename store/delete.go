@@ -1,8 +1,11 @@
 package store
 
 import (
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"time"
 	"xarantolus/sensibleHub/store/music"
 )
@@ -17,7 +20,27 @@ func (m *Manager) DeleteEntry(id string) (err error) {
 		return fmt.Errorf("Cannot edit entry with id %s as it doesn't exist", id)
 	}
 
-	delete(m.Songs, id)
+	err = m.removeEntry(entry)
+	if err != nil {
+		return
+	}
+
+	err = m.Save(false)
+	if err != nil {
+		return
+	}
+
+	m.event("song-delete", map[string]interface{}{
+		"id": id,
+	})
+
+	return nil
+}
+
+// removeEntry removes the entry from the library and deletes its files.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) removeEntry(entry music.Entry) (err error) {
+	m.forgetEntry(entry.ID)
 
 	if entry.ID != "" {
 		err = os.RemoveAll(entry.DirPath())
@@ -26,6 +49,62 @@ func (m *Manager) DeleteEntry(id string) (err error) {
 		}
 	}
 
+	return nil
+}
+
+// forgetEntry removes the entry with the given `id` from the library and everything that references it.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) forgetEntry(id string) {
+	delete(m.Songs, id)
+	m.unindexEntry(id)
+	m.removeSyncRecords(id)
+	m.removePlays(id)
+	delete(m.SourceHistory, id)
+
+	// Open playlist pages should show that the song is gone
+	for _, p := range m.removeFromPlaylists(id) {
+		m.event("playlist-edit", map[string]interface{}{
+			"id":       p.ID,
+			"playlist": p,
+		})
+	}
+}
+
+// TrashEntry removes the entry with the given ID from the library, but keeps its files in the trash directory.
+// The entry is saved next to them as entry.json, that way it can be restored manually
+func (m *Manager) TrashEntry(id string) (err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	entry, ok := m.Songs[id]
+	if !ok || entry.ID == "" {
+		return fmt.Errorf("Cannot edit entry with id %s as it doesn't exist", id)
+	}
+
+	entryData, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return
+	}
+
+	err = ioutil.WriteFile(filepath.Join(entry.DirPath(), "entry.json"), entryData, 0644)
+	if err != nil {
+		return
+	}
+
+	trashDir := fmt.Sprintf(trashDirTemplate, id)
+
+	err = os.MkdirAll(filepath.Dir(trashDir), os.ModePerm)
+	if err != nil {
+		return
+	}
+
+	err = os.Rename(entry.DirPath(), trashDir)
+	if err != nil {
+		return
+	}
+
+	m.forgetEntry(id)
+
 	err = m.Save(false)
 	if err != nil {
 		return
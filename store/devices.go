@@ -0,0 +1,200 @@
+package store
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+	"xarantolus/sensibleHub/store/music"
+)
+
+// Device is a user that downloads songs via FTP or WebDAV, e.g. a phone or a car USB stick
+type Device struct {
+	// LastSync is the last time this device downloaded a song
+	LastSync time.Time `json:"last_sync"`
+
+	// Songs maps song IDs to the version the device downloaded last
+	Songs map[string]SyncedSong `json:"songs"`
+}
+
+// SyncedSong describes which version of a song a device has
+type SyncedSong struct {
+	// LastEdit is the `LastEdit` time of the song when it was downloaded
+	LastEdit time.Time `json:"last_edit"`
+	// Profile is the transcoding profile of the downloaded file
+	Profile string `json:"profile"`
+
+	Synced time.Time `json:"synced"`
+}
+
+// syncSaveDelay is how long download records are collected before they are saved. Syncing a device downloads
+// many files in a row, that way the data file is written once instead of after every file
+const syncSaveDelay = 10 * time.Second
+
+// RecordSync records that the given device downloaded `e` with the given profile.
+// The manager is saved after syncSaveDelay and only if the device didn't have this version before, that way
+// downloading the same files over and over again doesn't write the data file every time
+func (m *Manager) RecordSync(device, profile string, e music.Entry) (err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	if m.Devices == nil {
+		m.Devices = make(map[string]*Device)
+	}
+
+	d, ok := m.Devices[device]
+	if !ok || d.Songs == nil {
+		d = &Device{
+			Songs: make(map[string]SyncedSong),
+		}
+		m.Devices[device] = d
+	}
+
+	now := time.Now()
+	d.LastSync = now
+
+	old, had := d.Songs[e.ID]
+	d.Songs[e.ID] = SyncedSong{
+		LastEdit: e.LastEdit,
+		Profile:  profile,
+		Synced:   now,
+	}
+
+	if had && old.isCurrent(e, profile) {
+		return nil
+	}
+
+	if m.syncSave == nil {
+		m.syncSave = time.AfterFunc(syncSaveDelay, m.saveSyncRecords)
+	}
+
+	return nil
+}
+
+// saveSyncRecords saves the download records collected by RecordSync
+func (m *Manager) saveSyncRecords() {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	m.syncSave = nil
+
+	err := m.Save(false)
+	if err != nil {
+		log.Printf("[Devices] Error while saving download records: %s\n", err.Error())
+	}
+}
+
+// isCurrent returns whether the synced song is the latest version of `e` in the given profile
+func (s SyncedSong) isCurrent(e music.Entry, profile string) bool {
+	return s.LastEdit.Equal(e.LastEdit) && s.Profile == profile
+}
+
+// shouldHave returns whether a user with the filter `f` can see `e` and should therefore have it on their device
+func shouldHave(e music.Entry, f Filter) bool {
+	return e.SyncSettings.Should && MatchesFilter(e, f)
+}
+
+// DeviceGroups returns groups that show which songs are outdated or were never synced on each device.
+// Devices are the FTP users from the configuration
+func (m *Manager) DeviceGroups() (groups []Group) {
+	list := m.AllEntries()
+
+	sort.SliceStable(list, func(i, j int) bool {
+		return strings.ToUpper(list[i].SongName()) < strings.ToUpper(list[j].SongName())
+	})
+
+	filters := m.userFilters()
+
+	m.SongsLock.RLock()
+	defer m.SongsLock.RUnlock()
+
+	for _, user := range m.cfg.FTP.Users {
+		var (
+			outdated, missing []music.Entry
+			upToDate          int
+		)
+
+		d := m.Devices[user.Name]
+
+		for _, e := range list {
+			if !shouldHave(e, filters[user.Name]) {
+				continue
+			}
+
+			var synced SyncedSong
+			var ok bool
+			if d != nil {
+				synced, ok = d.Songs[e.ID]
+			}
+
+			switch {
+			case !ok:
+				missing = append(missing, e)
+			case !synced.isCurrent(e, user.Profile):
+				outdated = append(outdated, e)
+			default:
+				upToDate++
+			}
+		}
+
+		if d == nil || d.LastSync.IsZero() {
+			groups = append(groups, Group{
+				Title:       user.Name,
+				Description: fmt.Sprintf("This device has never synced. It should have %d songs", len(missing)),
+			})
+			continue
+		}
+
+		groups = append(groups, Group{
+			Title:       user.Name,
+			Description: fmt.Sprintf("Last sync on %s, %d songs are up to date", d.LastSync.Format("2006-01-02 15:04"), upToDate),
+		})
+
+		if len(outdated) > 0 {
+			groups = append(groups, Group{
+				Title:       user.Name + ": outdated",
+				Description: fmt.Sprintf("%d songs were edited after this device downloaded them", len(outdated)),
+				Songs:       outdated,
+			})
+		}
+
+		if len(missing) > 0 {
+			groups = append(groups, Group{
+				Title:       user.Name + ": never synced",
+				Description: fmt.Sprintf("%d songs were never downloaded by this device", len(missing)),
+				Songs:       missing,
+			})
+		}
+	}
+
+	return
+}
+
+// pendingProfiles returns the profiles of all known devices that should have `e`, but didn't download its latest version yet.
+// `filters` are the filters of all users from userFilters. It assumes that m.SongsLock is already locked for reading
+func (m *Manager) pendingProfiles(e music.Entry, filters map[string]Filter) (profiles map[string]bool) {
+	profiles = make(map[string]bool)
+
+	for _, user := range m.cfg.FTP.Users {
+		d, ok := m.Devices[user.Name]
+		// Devices that never synced would keep all files forever
+		if !ok || !shouldHave(e, filters[user.Name]) {
+			continue
+		}
+
+		if synced, ok := d.Songs[e.ID]; !ok || !synced.isCurrent(e, user.Profile) {
+			profiles[user.Profile] = true
+		}
+	}
+
+	return
+}
+
+// removeSyncRecords removes all records of the song with the given ID.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) removeSyncRecords(id string) {
+	for _, d := range m.Devices {
+		delete(d.Songs, id)
+	}
+}
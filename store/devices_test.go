@@ -0,0 +1,131 @@
+package store
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func TestManager_syncState(t *testing.T) {
+	edit := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	song := music.Entry{
+		ID:           "song",
+		LastEdit:     edit,
+		SyncSettings: music.SyncSettings{Should: true},
+		MusicData:    music.MusicData{Artist: "Queen"},
+	}
+
+	m := Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs:     map[string]music.Entry{song.ID: song},
+		Devices: map[string]*Device{
+			"phone": {LastSync: edit, Songs: map[string]SyncedSong{
+				"song": {LastEdit: edit, Profile: "opus"},
+			}},
+			"car": {LastSync: edit, Songs: map[string]SyncedSong{
+				"song": {LastEdit: edit.Add(-time.Hour)},
+			}},
+			"stick": {LastSync: edit, Songs: map[string]SyncedSong{
+				"song": {LastEdit: edit, Profile: "opus"},
+			}},
+			"kid": {LastSync: edit, Songs: map[string]SyncedSong{}},
+		},
+	}
+	m.cfg.FTP.Users = []config.FTPUser{
+		{Name: "phone", Profile: "opus"},
+		{Name: "car"},
+		// Has the song in another profile
+		{Name: "stick", Profile: "mp3-low"},
+		// Doesn't see the song
+		{Name: "kid", Filter: config.SongFilter{Artists: []string{"ABBA"}}},
+		// Never synced
+		{Name: "new", Profile: "new"},
+	}
+
+	want := map[string]bool{
+		"":        true,
+		"mp3-low": true,
+	}
+
+	if got := m.pendingProfiles(song, m.userFilters()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Manager.pendingProfiles() = %v, want %v", got, want)
+	}
+
+	var titles []string
+	for _, g := range m.DeviceGroups() {
+		titles = append(titles, g.Title)
+	}
+
+	wantTitles := []string{"phone", "car", "car: outdated", "stick", "stick: outdated", "kid", "new"}
+	if !reflect.DeepEqual(titles, wantTitles) {
+		t.Errorf("Manager.DeviceGroups() returned groups %v, want %v", titles, wantTitles)
+	}
+}
+
+func TestManager_RecordSync(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	edit := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	song := music.Entry{ID: "song", LastEdit: edit}
+
+	m := Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs:     map[string]music.Entry{song.ID: song},
+	}
+
+	for i := 0; i < 3; i++ {
+		err = m.RecordSync("phone", "opus", song)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Records are saved together after a while
+	if _, err := os.Stat(managerDataFile); !os.IsNotExist(err) {
+		t.Errorf("expected the data file to be written later, got %v", err)
+	}
+	if m.syncSave == nil || !m.syncSave.Stop() {
+		t.Fatalf("expected a pending save")
+	}
+	m.saveSyncRecords()
+
+	if m.syncSave != nil {
+		t.Errorf("expected no pending save after saving")
+	}
+
+	content, err := os.ReadFile(managerDataFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved Manager
+	err = json.Unmarshal(content, &saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := saved.Devices["phone"].Songs["song"]; !got.isCurrent(song, "opus") {
+		t.Errorf("saved record %+v isn't current", got)
+	}
+
+	// Downloading the same version again doesn't save
+	err = m.RecordSync("phone", "opus", song)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.syncSave != nil {
+		t.Errorf("expected no save for a version the device already has")
+	}
+}
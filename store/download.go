@@ -20,11 +20,31 @@ import (
 
 const (
 	songDirTemplate = "data/songs/%s"
+	// trashDirTemplate is where songs are moved when they are trashed instead of deleted
+	trashDirTemplate = "data/trash/%s"
 )
 
-// Download downloads the song from the given URL using youtube-dl and saves it to the appropriate directory
-func (m *Manager) download(downloadURL string) (err error) {
-	log.Println("[Download] Start downloading", downloadURL)
+// Download downloads the song from the given URL using youtube-dl and saves it to the appropriate directory.
+// If fingerprinting is enabled and duplicates aren't allowed, songs that sound like an existing song are kept
+// as pending download and a *DuplicateError is returned.
+// If the request has a ReplaceID, the audio of that song is replaced instead of adding a new song.
+// If it has a PendingID, the files of that pending download are added instead of downloading the URL
+func (m *Manager) download(req downloadRequest) (err error) {
+	downloadURL := req.URL
+
+	var tmpDir string
+	if req.PendingID != "" {
+		p, ok := m.takePending(req.PendingID)
+		if !ok {
+			return fmt.Errorf("Cannot add pending download with id %s as it doesn't exist", req.PendingID)
+		}
+
+		tmpDir, downloadURL = p.dir, p.duplicate.URL
+
+		log.Println("[Download] Adding pending download", downloadURL)
+	} else {
+		log.Println("[Download] Start downloading", downloadURL)
+	}
 
 	defer func() {
 		if err != nil {
@@ -32,51 +52,32 @@ func (m *Manager) download(downloadURL string) (err error) {
 		}
 	}()
 
-	tmpDir, err := ioutil.TempDir("", "shub")
-	if err != nil {
-		return
+	if tmpDir == "" {
+		tmpDir, err = ioutil.TempDir("", "shub")
+		if err != nil {
+			return
+		}
 	}
 
-	// Delete temporary directory after downloading
+	// Delete temporary directory after downloading, except if it is kept as pending download
+	var keepTmpDir bool
 	defer func() {
+		if keepTmpDir {
+			return
+		}
+
 		derr := os.RemoveAll(tmpDir)
 		if err == nil {
 			err = derr
 		}
 	}()
 
-	cmdCtx, cancel := context.WithCancel(context.Background())
-
-	m.downloadContextLock.Lock()
-	m.downloadContext = cmdCtx
-	m.downloadCancelFunc = cancel
-	m.currentDownload = downloadURL
-	m.downloadContextLock.Unlock()
-
-	// Setup youtube-dl command and run it
-	cmd := exec.CommandContext(cmdCtx, m.cfg.Alternatives.YoutubeDL, "--write-info-json", "--write-thumbnail", "-f", "bestaudio/best", "--max-downloads", "1", "--no-playlist", "-x", "-o", "song.%(ext)s")
-	cmd.Dir = tmpDir
-
-	// when searching for a specific song, we want to reject Instrumental versions.
-	// This leads to youtube-dl selecting the second search result if the instrumental is first
-	// Don't add it when we explicitly want them though
-	if strings.HasPrefix(downloadURL, "ytsearch:") && !strings.Contains(strings.ToUpper(downloadURL), "INSTRUMENTAL") {
-		cmd.Args = append(cmd.Args, "--reject-title", "(Instrumental)")
-	}
-
-	cmd.Args = append(cmd.Args, downloadURL)
-
-	out, err := cmd.CombinedOutput()
-
-	m.downloadContextLock.Lock()
-	m.downloadContext = nil
-	m.downloadCancelFunc = nil
-	m.currentDownload = ""
-	m.downloadContextLock.Unlock()
-
-	// "exit status 101" means that the download limit has been reached (because of --max-downloads). We should just take this one song then, it's fine
-	if err != nil && err.Error() != "exit status 101" {
-		return fmt.Errorf("Error while running youtube-dl: %s\nOutput: %s", err.Error(), string(out))
+	var out []byte
+	if req.PendingID == "" {
+		out, err = m.runYoutubeDL(tmpDir, downloadURL)
+		if err != nil {
+			return
+		}
 	}
 
 	var (
@@ -173,6 +174,31 @@ func (m *Manager) download(downloadURL string) (err error) {
 		}
 	}
 
+	// Fingerprinting is optional, which is why errors are ignored
+	var fingerprint []uint32
+	if m.cfg.Alternatives.Fpcalc != "" {
+		fp, _, ferr := m.calculateFingerprint(audioPath)
+		if ferr != nil {
+			log.Println("[Download] Error while fingerprinting:", ferr.Error())
+		} else {
+			fingerprint = fp
+		}
+	}
+
+	// A replacement is supposed to sound like the song it replaces
+	if fingerprint != nil && !req.AllowDuplicates && req.ReplaceID == "" {
+		if e, similarity, ok := m.probableDuplicate(fingerprint); ok {
+			keepTmpDir = true
+
+			return m.keepPending(tmpDir, DuplicateError{
+				URL:        downloadURL,
+				Song:       e,
+				Similarity: similarity,
+				Downloaded: time.Now(),
+			})
+		}
+	}
+
 	now := time.Now()
 
 	// Check if we already downloaded the song
@@ -195,6 +221,22 @@ func (m *Manager) download(downloadURL string) (err error) {
 		return fmt.Errorf("Already downloaded exact same song %q (id %s)", e.SongName(), e.ID)
 	}
 
+	if req.ReplaceID != "" {
+		a := replacementAudio{
+			path:         audioPath,
+			size:         audioSize,
+			duration:     dur,
+			sourceURL:    sourceURL,
+			sourceFormat: minfo.Format,
+			fingerprint:  fingerprint,
+		}
+		if jsonErr == nil {
+			a.metaPath = jsonPath
+		}
+
+		return m.replaceAudio(req.ReplaceID, a)
+	}
+
 	// Technically, we would need to lock `m.generateID`, but that doesn't play
 	// nicely with `m.betterCover` below. Not great, but generating two
 	// IDs at the same time just doesn't happen with downloads as they are sequential.
@@ -231,6 +273,13 @@ func (m *Manager) download(downloadURL string) (err error) {
 		},
 	}
 
+	// The rest of the audio info is filled in by probing the file after adding it
+	if minfo.Format != "" {
+		e.AudioInfo = &music.AudioInfo{
+			SourceFormat: minfo.Format,
+		}
+	}
+
 	// Create song dir
 	songDir := fmt.Sprintf(songDirTemplate, e.ID)
 	err = os.MkdirAll(songDir, 0o644)
@@ -332,6 +381,9 @@ func (m *Manager) download(downloadURL string) (err error) {
 		}
 	}
 
+	// User-defined rules run last, so they can fix anything the steps above got wrong
+	m.rewrite(e, minfo.Uploader)
+
 	// Move all kinds of files - this may not work on all platforms as they aren't in the same directory
 	if jsonErr == nil {
 		err = file.Move(jsonPath, filepath.Join(songDir, e.MetaFile.Filename))
@@ -347,6 +399,14 @@ func (m *Manager) download(downloadURL string) (err error) {
 		return
 	}
 
+	if fingerprint != nil && writeFingerprint(filepath.Join(songDir, fingerprintFilename), fingerprint) == nil {
+		e.Fingerprint = &music.Fingerprint{
+			Filename:   fingerprintFilename,
+			Duration:   dur,
+			Calculated: now,
+		}
+	}
+
 	if e.PictureData.Filename != "" {
 		hex, _ := music.CalculateDominantColor(e.CoverPath())
 		e.PictureData.DominantColorHEX = music.Color(hex)
@@ -367,9 +427,64 @@ func (m *Manager) download(downloadURL string) (err error) {
 
 	log.Printf("[Download] Added %s\n", e.SongName())
 
+	// Music videos often start with silence or someone talking, so we look for good trim points.
+	// This runs in the background as it doesn't need to block the next download
+	go func(id string) {
+		err := m.AnalyzeTrim(id)
+		if err != nil {
+			log.Printf("[Download] Error while analyzing trim points for %s: %s\n", id, err.Error())
+		}
+	}(e.ID)
+
+	go func(id string) {
+		err := m.ProbeEntry(id)
+		if err != nil {
+			log.Printf("[Download] Error while probing audio quality of %s: %s\n", id, err.Error())
+		}
+	}(e.ID)
+
 	return nil
 }
 
+// runYoutubeDL downloads the song at `downloadURL` into `dir`. The download can be cancelled using AbortDownload
+func (m *Manager) runYoutubeDL(dir, downloadURL string) (out []byte, err error) {
+	cmdCtx, cancel := context.WithCancel(context.Background())
+
+	m.downloadContextLock.Lock()
+	m.downloadContext = cmdCtx
+	m.downloadCancelFunc = cancel
+	m.currentDownload = downloadURL
+	m.downloadContextLock.Unlock()
+
+	// Setup youtube-dl command and run it
+	cmd := exec.CommandContext(cmdCtx, m.cfg.Alternatives.YoutubeDL, "--write-info-json", "--write-thumbnail", "-f", "bestaudio/best", "--max-downloads", "1", "--no-playlist", "-x", "-o", "song.%(ext)s")
+	cmd.Dir = dir
+
+	// when searching for a specific song, we want to reject Instrumental versions.
+	// This leads to youtube-dl selecting the second search result if the instrumental is first
+	// Don't add it when we explicitly want them though
+	if strings.HasPrefix(downloadURL, "ytsearch:") && !strings.Contains(strings.ToUpper(downloadURL), "INSTRUMENTAL") {
+		cmd.Args = append(cmd.Args, "--reject-title", "(Instrumental)")
+	}
+
+	cmd.Args = append(cmd.Args, downloadURL)
+
+	out, err = cmd.CombinedOutput()
+
+	m.downloadContextLock.Lock()
+	m.downloadContext = nil
+	m.downloadCancelFunc = nil
+	m.currentDownload = ""
+	m.downloadContextLock.Unlock()
+
+	// "exit status 101" means that the download limit has been reached (because of --max-downloads). We should just take this one song then, it's fine
+	if err != nil && err.Error() != "exit status 101" {
+		return out, fmt.Errorf("Error while running youtube-dl: %s\nOutput: %s", err.Error(), string(out))
+	}
+
+	return out, nil
+}
+
 // AbortDownload cancels the currently running download, returning an error if no download is running
 func (m *Manager) AbortDownload() (err error) {
 	m.downloadContextLock.Lock()
@@ -412,6 +527,9 @@ type info struct {
 	UploadDate  string `json:"upload_date"`  // Take year from here...
 	ReleaseDate string `json:"release_date"` // ...or from here
 
+	// Format describes the format that was selected for downloading, e.g. "251 - audio only (medium)"
+	Format string `json:"format"`
+
 	// WebpageURL is used to "clean" the URL, e.g. to remove playlist parameters as they aren't used here
 	WebpageURL string `json:"webpage_url"` // This usually shouldn't be empty
 }
@@ -28,13 +28,77 @@ type EditEntryData struct {
 	Artist string
 	Album  string
 	Year   string
+	Genre  string
+	Track  string
+	Disc   string
+	Rating string
 
 	Start string
 	End   string
 
+	FadeIn  string
+	FadeOut string
+	Gain    string
+	Mono    string
+	Speed   string
+	Pitch   string
+
 	Sync string
 }
 
+// EditDataFromEntry returns the data that EditEntry needs to keep `e` as it is.
+// Changing some of its fields only edits these fields. Like on the song page,
+// unset start and end times are set to the start and end of the audio
+func EditDataFromEntry(e music.Entry) (data EditEntryData) {
+	formatI := func(i int) string {
+		if i == 0 {
+			return ""
+		}
+		return strconv.Itoa(i)
+	}
+	formatF := func(f float64) string {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	formatB := func(b bool) string {
+		if b {
+			return "on"
+		}
+		return ""
+	}
+
+	data = EditEntryData{
+		Title:  e.MusicData.Title,
+		Artist: e.MusicData.Artist,
+		Album:  e.MusicData.Album,
+		Genre:  e.MusicData.Genre,
+		Track:  formatI(e.MusicData.Track),
+		Disc:   formatI(e.MusicData.Disc),
+		Rating: formatI(e.MusicData.Rating),
+
+		Start:   formatF(e.AudioSettings.Start),
+		End:     formatF(e.AudioSettings.End),
+		FadeIn:  formatF(e.AudioSettings.FadeIn),
+		FadeOut: formatF(e.AudioSettings.FadeOut),
+		Gain:    formatF(e.AudioSettings.Gain),
+		Mono:    formatB(e.AudioSettings.Mono),
+		Speed:   formatF(e.AudioSettings.Speed),
+		Pitch:   formatF(e.AudioSettings.Pitch),
+
+		Sync: formatB(e.SyncSettings.Should),
+	}
+	if e.MusicData.Year != nil {
+		data.Year = strconv.Itoa(*e.MusicData.Year)
+	}
+	if e.AudioSettings.Start == -1 {
+		data.Start = "0"
+	}
+	if e.AudioSettings.End == -1 {
+		data.End = formatF(e.MusicData.Duration)
+	}
+
+	return
+}
+
 // EditEntry edits the entry with the given `id`.
 // All fields in `data` may be empty, only those that have values will be updated.
 // As a special case, CoverImage will only be read if CoverFilename is also set.
@@ -59,12 +123,24 @@ func (m *Manager) EditEntry(id string, data EditEntryData) (err error) {
 
 	year, err := strconv.Atoi(data.Year)
 	if err == nil {
-		entry.MusicData.Year = &year
+		// Keep the old pointer if the year didn't change, otherwise the entry would always count as edited
+		if entry.MusicData.Year == nil || *entry.MusicData.Year != year {
+			entry.MusicData.Year = &year
+		}
 	} else {
 		// allow clearing year value after it has been set
 		entry.MusicData.Year = nil
 	}
 
+	entry.MusicData.Genre = strings.TrimSpace(data.Genre)
+
+	// Track and disc numbers are cleared like the year
+	setValidI(&entry.MusicData.Track, data.Track, 1, 9999)
+	setValidI(&entry.MusicData.Disc, data.Disc, 1, 999)
+
+	// Invalid ratings also clear the value
+	setValidI(&entry.MusicData.Rating, data.Rating, 0, 5)
+
 	// these floats must have a valid value that is between 0 and the length of the audio
 	setValidF(&entry.AudioSettings.Start, data.Start, 0, entry.MusicData.Duration)
 	setValidF(&entry.AudioSettings.End, data.End, 0, entry.MusicData.Duration)
@@ -78,6 +154,16 @@ func (m *Manager) EditEntry(id string, data EditEntryData) (err error) {
 		return ErrAudioSameStartEnd
 	}
 
+	// Fades can be at most as long as the song itself
+	setValidF(&entry.AudioSettings.FadeIn, data.FadeIn, 0, entry.MusicData.Duration)
+	setValidF(&entry.AudioSettings.FadeOut, data.FadeOut, 0, entry.MusicData.Duration)
+
+	setValidF(&entry.AudioSettings.Gain, data.Gain, -30, 30)
+	setValidF(&entry.AudioSettings.Speed, data.Speed, 0.5, 2)
+	setValidF(&entry.AudioSettings.Pitch, data.Pitch, -12, 12)
+
+	setValidB(&entry.AudioSettings.Mono, data.Mono)
+
 	// Sync must be a valid bool
 	setValidB(&entry.SyncSettings.Should, data.Sync)
 
@@ -125,6 +211,74 @@ func (m *Manager) EditEntry(id string, data EditEntryData) (err error) {
 
 	entry.LastEdit = time.Now()
 
+	m.Songs[id] = entry
+	m.indexEntry(entry)
+
+	err = m.Save(false)
+	if err != nil {
+		return
+	}
+
+	m.event("song-edit", map[string]interface{}{
+		"id":   id,
+		"song": entry,
+	})
+
+	return nil
+}
+
+// RetagEntry sets the music data of the entry with the given `id` to the values
+// extracted from a path using Layout.Values. Values that are not given stay the same
+func (m *Manager) RetagEntry(id string, values map[string]string) (err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	entry, ok := m.Songs[id]
+	if !ok {
+		return fmt.Errorf("Cannot edit entry with id %s as it doesn't exist", id)
+	}
+
+	entryBefore := entry
+
+	applyLayoutValues(&entry.MusicData, values)
+
+	if entry == entryBefore {
+		return nil
+	}
+
+	entry.LastEdit = time.Now()
+	m.Songs[id] = entry
+	m.indexEntry(entry)
+
+	err = m.Save(false)
+	if err != nil {
+		return
+	}
+
+	m.event("song-edit", map[string]interface{}{
+		"id":   id,
+		"song": entry,
+	})
+
+	return nil
+}
+
+// SetSync sets whether the entry with the given `id` should be synced
+func (m *Manager) SetSync(id string, should bool) (err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	entry, ok := m.Songs[id]
+	if !ok {
+		return fmt.Errorf("Cannot edit entry with id %s as it doesn't exist", id)
+	}
+
+	if entry.SyncSettings.Should == should {
+		return nil
+	}
+
+	entry.SyncSettings.Should = should
+	entry.LastEdit = time.Now()
 	m.Songs[id] = entry
 
 	err = m.Save(false)
@@ -221,6 +375,51 @@ func (m *Manager) EditAlbumCover(artist, album string, coverName string, coverIm
 	return m.Save(false)
 }
 
+// RenameAlbum sets artist and album name of all songs in the album identified by `artist` and `album`.
+// It returns the number of changed songs
+func (m *Manager) RenameAlbum(artist, album, newArtist, newAlbum string) (n int, err error) {
+	artist, album = CleanName(artist), CleanName(album)
+	newArtist, newAlbum = strings.TrimSpace(newArtist), strings.TrimSpace(newAlbum)
+	if newArtist == "" || newAlbum == "" {
+		return 0, fmt.Errorf("An album needs an artist and a name")
+	}
+
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	now := time.Now()
+	for sid, e := range m.Songs {
+		if !strings.EqualFold(CleanName(e.Artist()), artist) || !strings.EqualFold(CleanName(e.AlbumName()), album) {
+			continue
+		}
+
+		if e.MusicData.Artist == newArtist && e.MusicData.Album == newAlbum {
+			continue
+		}
+
+		e.MusicData.Artist = newArtist
+		e.MusicData.Album = newAlbum
+		e.LastEdit = now
+
+		m.Songs[sid] = e
+		m.indexEntry(e)
+		n++
+
+		defer func(id string, s music.Entry) {
+			m.event("song-edit", map[string]interface{}{
+				"id":   id,
+				"song": s,
+			})
+		}(sid, e)
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+
+	return n, m.Save(false)
+}
+
 func setValidS(target *string, value string) {
 	value = strings.TrimSpace(value)
 	if value != "" {
@@ -235,6 +434,16 @@ func setValidF(target *float64, value string, min, max float64) {
 	}
 }
 
+// setValidI sets `target` to `value` if it is a number in the given range, otherwise it is reset to 0
+func setValidI(target *int, value string, min, max int) {
+	i, err := strconv.Atoi(strings.TrimSpace(value))
+	if err == nil && i >= min && i <= max {
+		*target = i
+	} else {
+		*target = 0
+	}
+}
+
 func setValidB(target *bool, value string) {
 	value = strings.ToUpper(value)
 
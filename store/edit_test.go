@@ -0,0 +1,138 @@
+package store
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func TestEditDataFromEntry(t *testing.T) {
+	// Editing saves to the data directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	year := 1975
+	entry := music.Entry{
+		ID:            "a",
+		SyncSettings:  music.SyncSettings{Should: true},
+		AudioSettings: music.AudioSettings{Start: 1.5, End: 350, FadeIn: 2, Gain: -3, Mono: true, Speed: 1.25, Pitch: -2},
+		MusicData:     music.MusicData{Title: "Bohemian Rhapsody", Artist: "Queen", Album: "A Night at the Opera", Year: &year, Genre: "Rock", Track: 11, Disc: 1, Rating: 5, Duration: 354},
+	}
+	m := Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs:     map[string]music.Entry{"a": entry},
+	}
+
+	// Without changes, nothing is edited
+	err = m.EditEntry("a", EditDataFromEntry(entry))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := m.Songs["a"]; !e.LastEdit.IsZero() {
+		t.Errorf("entry was changed: %+v", e)
+	}
+
+	data := EditDataFromEntry(entry)
+	data.Album = "Live Killers"
+	data.Sync = ""
+	err = m.EditEntry("a", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := m.Songs["a"]
+	if e.MusicData.Album != "Live Killers" || e.SyncSettings.Should {
+		t.Errorf("changes weren't applied: %+v", e)
+	}
+	e.MusicData.Album, e.SyncSettings.Should, e.LastEdit = entry.MusicData.Album, true, entry.LastEdit
+	if e != entry {
+		t.Errorf("other fields were changed:\n%+v\nwant\n%+v", e, entry)
+	}
+}
+
+func TestManager_RenameAlbum(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	m := Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs: map[string]music.Entry{
+			"a": {ID: "a", MusicData: music.MusicData{Title: "Bohemian Rhapsody", Artist: "Queen", Album: "A NIGHT AT THE OPERA"}},
+			"b": {ID: "b", MusicData: music.MusicData{Title: "Love of My Life", Artist: "queen", Album: "A night at the opera"}},
+			"c": {ID: "c", MusicData: music.MusicData{Title: "We Will Rock You", Artist: "Queen", Album: "News of the World"}},
+		},
+	}
+
+	if _, err := m.RenameAlbum("Queen", "A Night at the Opera", "Queen", " "); err == nil {
+		t.Errorf("expected an error for an empty album name")
+	}
+
+	n, err := m.RenameAlbum("queen", "a night at the opera", "Queen", "A Night at the Opera")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("renamed %d songs, want 2", n)
+	}
+
+	for _, id := range []string{"a", "b"} {
+		if md := m.Songs[id].MusicData; md.Artist != "Queen" || md.Album != "A Night at the Opera" {
+			t.Errorf("song %s wasn't renamed: %+v", id, md)
+		}
+	}
+	if m.Songs["c"].MusicData.Album != "News of the World" {
+		t.Errorf("song of another album was renamed")
+	}
+}
+
+func TestManager_RetagEntry(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	year := 1975
+	m := Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs: map[string]music.Entry{
+			"a": {ID: "a", MusicData: music.MusicData{Title: "Bohemian Rhapsody", Artist: "Queen", Year: &year}},
+		},
+	}
+
+	// Renaming a file to the same values shouldn't count as an edit
+	err = m.RetagEntry("a", map[string]string{"artist": "Queen", "year": "1975"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !m.Songs["a"].LastEdit.IsZero() {
+		t.Errorf("unchanged song was marked as edited")
+	}
+
+	err = m.RetagEntry("a", map[string]string{"year": "1976"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := m.Songs["a"]; e.LastEdit.IsZero() || e.MusicData.Year == nil || *e.MusicData.Year != 1976 {
+		t.Errorf("year wasn't changed: %+v", e)
+	}
+}
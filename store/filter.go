@@ -0,0 +1,107 @@
+package store
+
+import (
+	"strings"
+	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/store/music"
+)
+
+// Filter is a SongFilter with the songs of its playlists looked up, see NewFilter
+type Filter struct {
+	config.SongFilter
+
+	// playlistSongs contains the IDs of all songs in the playlists of the filter
+	playlistSongs map[string]bool
+}
+
+// NewFilter looks up the songs of the playlists in `f`. Since playlists change, the filter should not be kept
+// around for long. It must not be called while m.SongsLock is locked
+func (m *Manager) NewFilter(f config.SongFilter) Filter {
+	filter := Filter{SongFilter: f}
+	if len(f.Playlists) == 0 {
+		return filter
+	}
+
+	filter.playlistSongs = make(map[string]bool)
+	add := func(entries []music.Entry) {
+		for _, e := range entries {
+			filter.playlistSongs[e.ID] = true
+		}
+	}
+
+	for _, p := range m.AllPlaylists() {
+		if containsFold(f.Playlists, p.Name) {
+			add(m.PlaylistEntries(p))
+		}
+	}
+	for _, p := range m.AllSmartPlaylists() {
+		if containsFold(f.Playlists, p.Name) {
+			add(m.SmartEntries(p))
+		}
+	}
+
+	return filter
+}
+
+// userFilters returns the filters of all FTP users by their name.
+// It must not be called while m.SongsLock is locked
+func (m *Manager) userFilters() map[string]Filter {
+	filters := make(map[string]Filter)
+	for _, u := range m.cfg.FTP.Users {
+		filters[u.Name] = m.NewFilter(u.Filter)
+	}
+	return filters
+}
+
+// MatchesFilter returns whether `e` is selected by the filter `f`
+func MatchesFilter(e music.Entry, f Filter) bool {
+	if len(f.Artists) > 0 && !matchesArtist(f.Artists, e.MusicData.Artist) {
+		return false
+	}
+
+	if len(f.Playlists) > 0 && !f.playlistSongs[e.ID] {
+		return false
+	}
+
+	if len(f.Albums) > 0 && !containsFold(f.Albums, e.MusicData.Album) {
+		return false
+	}
+
+	if f.MinYear != 0 || f.MaxYear != 0 {
+		if e.MusicData.Year == nil {
+			return false
+		}
+
+		year := *e.MusicData.Year
+		if (f.MinYear != 0 && year < f.MinYear) || (f.MaxYear != 0 && year > f.MaxYear) {
+			return false
+		}
+	}
+
+	return e.MusicData.Rating >= f.MinRating
+}
+
+// containsFold returns whether `list` contains `s`, ignoring case and surrounding whitespace
+func containsFold(list []string, s string) bool {
+	s = strings.TrimSpace(s)
+	for _, l := range list {
+		if strings.EqualFold(strings.TrimSpace(l), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesArtist returns whether `list` contains `artist` or one of the artists in a list like "Queen, David Bowie"
+func matchesArtist(list []string, artist string) bool {
+	if containsFold(list, artist) {
+		return true
+	}
+
+	for _, a := range strings.Split(artist, ",") {
+		if containsFold(list, a) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package store
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func TestMatchesFilter(t *testing.T) {
+	year := func(y int) *int {
+		return &y
+	}
+
+	queen := music.Entry{
+		MusicData: music.MusicData{
+			Artist: "Queen",
+			Album:  "A Night at the Opera",
+			Year:   year(1975),
+			Rating: 4,
+		},
+	}
+	collab := music.Entry{
+		MusicData: music.MusicData{
+			Artist: "Queen, David Bowie",
+		},
+	}
+	noYear := music.Entry{
+		MusicData: music.MusicData{
+			Artist: "Unknown Band",
+		},
+	}
+
+	tests := []struct {
+		name   string
+		entry  music.Entry
+		filter config.SongFilter
+		want   bool
+	}{
+		{"empty filter", queen, config.SongFilter{}, true},
+		{"empty filter without data", noYear, config.SongFilter{}, true},
+		{"artist ignores case", queen, config.SongFilter{Artists: []string{"David Bowie", "queen "}}, true},
+		{"other artist", queen, config.SongFilter{Artists: []string{"David Bowie"}}, false},
+		{"one of multiple artists", collab, config.SongFilter{Artists: []string{"david bowie"}}, true},
+		{"all of multiple artists", collab, config.SongFilter{Artists: []string{"Queen, David Bowie"}}, true},
+		{"none of multiple artists", collab, config.SongFilter{Artists: []string{"ABBA"}}, false},
+		{"album", queen, config.SongFilter{Albums: []string{"A Night at the Opera"}}, true},
+		{"other album", queen, config.SongFilter{Albums: []string{"Jazz"}}, false},
+		{"year in range", queen, config.SongFilter{MinYear: 1970, MaxYear: 1979}, true},
+		{"year only max", queen, config.SongFilter{MaxYear: 1975}, true},
+		{"year too old", queen, config.SongFilter{MinYear: 1980}, false},
+		{"no year with year filter", noYear, config.SongFilter{MaxYear: 2000}, false},
+		{"rating", queen, config.SongFilter{MinRating: 4}, true},
+		{"rating too low", queen, config.SongFilter{MinRating: 5}, false},
+		{"unrated", noYear, config.SongFilter{MinRating: 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := MatchesFilter(tt.entry, Filter{SongFilter: tt.filter}); got != tt.want {
+				t.Errorf("MatchesFilter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestManager_NewFilter(t *testing.T) {
+	m := &Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs: map[string]music.Entry{
+			"a": {ID: "a", MusicData: music.MusicData{Title: "A", Artist: "Queen", Genre: "Rock"}},
+			"b": {ID: "b", MusicData: music.MusicData{Title: "B", Artist: "Queen"}},
+			"c": {ID: "c", MusicData: music.MusicData{Title: "C", Artist: "ABBA", Genre: "Pop"}},
+		},
+		Playlists: map[string]Playlist{
+			"p": {ID: "p", Name: "Favorites", Songs: []string{"b", "missing"}},
+		},
+		SmartPlaylists: map[string]SmartPlaylist{
+			"s": {ID: "s", Name: "Pop", Rules: []Rule{{"genre", "is", "pop"}}},
+		},
+	}
+
+	tests := []struct {
+		filter config.SongFilter
+		want   []string
+	}{
+		{config.SongFilter{}, []string{"a", "b", "c"}},
+		{config.SongFilter{Playlists: []string{"favorites "}}, []string{"b"}},
+		{config.SongFilter{Playlists: []string{"Favorites", "Pop"}}, []string{"b", "c"}},
+		{config.SongFilter{Playlists: []string{"Pop"}, Artists: []string{"Queen"}}, nil},
+		{config.SongFilter{Playlists: []string{"Unknown"}}, nil},
+	}
+	for _, tt := range tests {
+		f := m.NewFilter(tt.filter)
+
+		var got []string
+		for _, id := range []string{"a", "b", "c"} {
+			if MatchesFilter(m.Songs[id], f) {
+				got = append(got, id)
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("filter %+v matched %v, want %v", tt.filter, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,538 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/bits"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"xarantolus/sensibleHub/store/music"
+)
+
+const (
+	fingerprintFilename = "fingerprint.bin"
+
+	// fingerprintLength is how many seconds at the start of a song are fingerprinted
+	fingerprintLength = 120
+
+	// duplicateSimilarity is the share of equal bits two aligned fingerprints need to be considered duplicates
+	duplicateSimilarity = 0.85
+	// duplicateMinShared is how many exactly equal values two fingerprints need to be compared at all
+	duplicateMinShared = 10
+	// duplicateMinOverlap is the number of values that must overlap after aligning, fpcalc returns about 8 per second
+	duplicateMinOverlap = 80
+)
+
+// calculateFingerprint runs fpcalc on the audio file at `path`
+func (m *Manager) calculateFingerprint(path string) (fp []uint32, duration float64, err error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(m.cfg.Alternatives.Fpcalc, "-raw", "-length", strconv.Itoa(fingerprintLength), path)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, 0, fmt.Errorf("Error while running fpcalc: %s\nOutput: %s", err.Error(), stderr.String())
+	}
+
+	return parseFpcalc(out)
+}
+
+// parseFpcalc parses the output of `fpcalc -raw`, which contains lines like "DURATION=213" and "FINGERPRINT=1,2,3"
+func parseFpcalc(out []byte) (fp []uint32, duration float64, err error) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 1<<20)
+
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+
+		switch key {
+		case "DURATION":
+			duration, err = strconv.ParseFloat(value, 64)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid duration in fpcalc output: %w", err)
+			}
+		case "FINGERPRINT":
+			for _, s := range strings.Split(value, ",") {
+				// Newer versions of fpcalc print signed numbers
+				n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+				if err != nil {
+					return nil, 0, fmt.Errorf("invalid fingerprint in fpcalc output: %w", err)
+				}
+				fp = append(fp, uint32(n))
+			}
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	if len(fp) == 0 {
+		return nil, 0, fmt.Errorf("fpcalc didn't output a fingerprint")
+	}
+
+	return fp, duration, nil
+}
+
+// writeFingerprint writes `fp` to `path` as little-endian binary data
+func writeFingerprint(path string, fp []uint32) error {
+	buf := make([]byte, 4*len(fp))
+	for i, v := range fp {
+		binary.LittleEndian.PutUint32(buf[4*i:], v)
+	}
+
+	return ioutil.WriteFile(path, buf, 0644)
+}
+
+// readFingerprint reads a fingerprint that was written by writeFingerprint
+func readFingerprint(path string) (fp []uint32, err error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	fp = make([]uint32, len(buf)/4)
+	for i := range fp {
+		fp[i] = binary.LittleEndian.Uint32(buf[4*i:])
+	}
+
+	return
+}
+
+// FingerprintEntry calculates the fingerprint of the song with the given `id` and stores it next to the audio file
+func (m *Manager) FingerprintEntry(id string) (err error) {
+	if m.cfg.Alternatives.Fpcalc == "" {
+		return fmt.Errorf("Fingerprinting is disabled, please set the path to fpcalc in the configuration file")
+	}
+
+	e, ok := m.GetEntry(id)
+	if !ok {
+		return fmt.Errorf("Cannot fingerprint entry with id %s as it doesn't exist", id)
+	}
+
+	// Like with trim analysis, we don't hold any lock while running fpcalc
+	fp, duration, err := m.calculateFingerprint(e.AudioPath())
+	if err != nil {
+		return
+	}
+
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	// The entry might have been edited or deleted in the meantime
+	e, ok = m.Songs[id]
+	if !ok {
+		return fmt.Errorf("Entry with id %s was deleted while fingerprinting", id)
+	}
+
+	err = writeFingerprint(filepath.Join(e.DirPath(), fingerprintFilename), fp)
+	if err != nil {
+		return
+	}
+
+	e.Fingerprint = &music.Fingerprint{
+		Filename:   fingerprintFilename,
+		Duration:   duration,
+		Calculated: time.Now(),
+	}
+	m.Songs[id] = e
+
+	return m.Save(false)
+}
+
+// FingerprintMissing fingerprints all songs that don't have a fingerprint yet.
+// It does nothing if fingerprinting is disabled. Since it might take a long time, it should run in the background
+func (m *Manager) FingerprintMissing() {
+	if m.cfg.Alternatives.Fpcalc == "" {
+		return
+	}
+
+	var ids []string
+	for _, e := range m.AllEntries() {
+		if e.Fingerprint == nil {
+			ids = append(ids, e.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	log.Printf("[Fingerprint] Fingerprinting %d songs\n", len(ids))
+
+	var done int
+	for _, id := range ids {
+		err := m.FingerprintEntry(id)
+		if err != nil {
+			log.Printf("[Fingerprint] Error while fingerprinting %s: %s\n", id, err.Error())
+			continue
+		}
+		done++
+	}
+
+	log.Printf("[Fingerprint] Finished fingerprinting %d songs\n", done)
+}
+
+// fingerprintPositions maps every value of `fp` to the positions it appears at
+func fingerprintPositions(fp []uint32) map[uint32][]int {
+	positions := make(map[uint32][]int, len(fp))
+	for i, v := range fp {
+		positions[v] = append(positions[v], i)
+	}
+	return positions
+}
+
+// fingerprintSimilarity returns the share of equal bits of `a` and `b` at their best alignment,
+// which is found by looking at the offsets between exactly equal values. `positionsB` must be
+// the result of fingerprintPositions(b). If the fingerprints can't be aligned, it returns 0
+func fingerprintSimilarity(a []uint32, b []uint32, positionsB map[uint32][]int) float64 {
+	votes := make(map[int]int)
+	for i, v := range a {
+		for _, j := range positionsB[v] {
+			votes[j-i]++
+		}
+	}
+
+	var offset, shared int
+	for o, n := range votes {
+		if n > shared || (n == shared && o < offset) {
+			offset, shared = o, n
+		}
+	}
+	if shared < duplicateMinShared {
+		return 0
+	}
+
+	var diff, overlap int
+	for i, v := range a {
+		j := i + offset
+		if j < 0 || j >= len(b) {
+			continue
+		}
+		diff += bits.OnesCount32(v ^ b[j])
+		overlap++
+	}
+	if overlap < duplicateMinOverlap {
+		return 0
+	}
+
+	return 1 - float64(diff)/float64(32*overlap)
+}
+
+// DuplicateGroup is a group of songs that probably contain the same audio
+type DuplicateGroup struct {
+	// Songs are the songs in this group, the one with the best audio quality first
+	Songs []music.Entry
+
+	// Similarity is the lowest similarity between two songs that put them into this group, from 0 to 1
+	Similarity float64
+}
+
+// FindDuplicates groups all fingerprinted songs that probably contain the same audio.
+// Songs without a fingerprint are never reported as duplicates
+func (m *Manager) FindDuplicates() (groups []DuplicateGroup) {
+	var (
+		entries []music.Entry
+		fps     [][]uint32
+	)
+	for _, e := range m.AllEntries() {
+		if e.Fingerprint == nil {
+			continue
+		}
+
+		fp, err := readFingerprint(e.FingerprintPath())
+		if err != nil || len(fp) == 0 {
+			continue
+		}
+
+		entries = append(entries, e)
+		fps = append(fps, fp)
+	}
+
+	// Only songs that share some values are compared, which keeps this from comparing every pair of songs
+	songsWithValue := make(map[uint32][]int)
+	for i, fp := range fps {
+		seen := make(map[uint32]bool, len(fp))
+		for _, v := range fp {
+			if !seen[v] {
+				seen[v] = true
+				songsWithValue[v] = append(songsWithValue[v], i)
+			}
+		}
+	}
+
+	// Values that appear in many songs, e.g. silence, don't say anything
+	maxSongs := len(fps) / 10
+	if maxSongs < 10 {
+		maxSongs = 10
+	}
+
+	shared := make(map[[2]int]int)
+	for _, songs := range songsWithValue {
+		if len(songs) < 2 || len(songs) > maxSongs {
+			continue
+		}
+		for x := 0; x < len(songs); x++ {
+			for y := x + 1; y < len(songs); y++ {
+				shared[[2]int{songs[x], songs[y]}]++
+			}
+		}
+	}
+
+	// parent is a union-find structure of song indices
+	parent := make([]int, len(entries))
+	for i := range parent {
+		parent[i] = i
+	}
+	var root func(i int) int
+	root = func(i int) int {
+		if parent[i] != i {
+			parent[i] = root(parent[i])
+		}
+		return parent[i]
+	}
+
+	similarity := make(map[int]float64)
+	positions := make(map[int]map[uint32][]int)
+	for pair, n := range shared {
+		if n < duplicateMinShared {
+			continue
+		}
+
+		a, b := pair[0], pair[1]
+		if positions[b] == nil {
+			positions[b] = fingerprintPositions(fps[b])
+		}
+
+		sim := fingerprintSimilarity(fps[a], fps[b], positions[b])
+		if sim < duplicateSimilarity {
+			continue
+		}
+
+		ra, rb := root(a), root(b)
+		lowest := sim
+		for _, r := range []int{ra, rb} {
+			if s, ok := similarity[r]; ok && s < lowest {
+				lowest = s
+			}
+		}
+		delete(similarity, ra)
+		delete(similarity, rb)
+
+		parent[ra] = rb
+		similarity[rb] = lowest
+	}
+
+	byRoot := make(map[int][]music.Entry)
+	for i, e := range entries {
+		r := root(i)
+		byRoot[r] = append(byRoot[r], e)
+	}
+
+	for r, songs := range byRoot {
+		if len(songs) < 2 {
+			continue
+		}
+
+		sortByQuality(songs)
+
+		groups = append(groups, DuplicateGroup{
+			Songs:      songs,
+			Similarity: similarity[r],
+		})
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Songs[0].Added.After(groups[j].Songs[0].Added)
+	})
+
+	return
+}
+
+// sortByQuality sorts songs by bitrate, the highest first. Songs with the same bitrate are sorted by the date they were added
+func sortByQuality(songs []music.Entry) {
+	sort.Slice(songs, func(i, j int) bool {
+		bi, bj := songs[i].Bitrate(), songs[j].Bitrate()
+		if bi != bj {
+			return bi > bj
+		}
+		return songs[i].Added.Before(songs[j].Added)
+	})
+}
+
+// probableDuplicate returns the song that is most similar to the fingerprint `fp`, if any song is similar enough
+func (m *Manager) probableDuplicate(fp []uint32) (e music.Entry, similarity float64, ok bool) {
+	positions := fingerprintPositions(fp)
+
+	for _, other := range m.AllEntries() {
+		if other.Fingerprint == nil {
+			continue
+		}
+
+		ofp, err := readFingerprint(other.FingerprintPath())
+		if err != nil {
+			continue
+		}
+
+		sim := fingerprintSimilarity(ofp, fp, positions)
+		if sim >= duplicateSimilarity && sim > similarity {
+			e, similarity, ok = other, sim, true
+		}
+	}
+
+	return
+}
+
+// MergeDuplicates merges the songs with the given `otherIDs` into the song with `keepID`. The audio of the kept song
+// stays, metadata that it doesn't have is taken from the other songs. The other songs are deleted afterwards,
+// but their place in playlists and play history goes to the kept song
+func (m *Manager) MergeDuplicates(keepID string, otherIDs ...string) (err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	keep, ok := m.Songs[keepID]
+	if !ok {
+		return fmt.Errorf("Cannot merge into entry with id %s as it doesn't exist", keepID)
+	}
+
+	var others []music.Entry
+	for _, id := range otherIDs {
+		if id == keepID {
+			continue
+		}
+
+		o, ok := m.Songs[id]
+		if !ok {
+			return fmt.Errorf("Cannot merge entry with id %s as it doesn't exist", id)
+		}
+		others = append(others, o)
+	}
+	if len(others) == 0 {
+		return fmt.Errorf("Need at least one other song to merge into %s", keep.SongName())
+	}
+
+	for _, o := range others {
+		mergeMusicData(&keep.MusicData, o.MusicData)
+
+		if o.PictureData.Filename != "" && o.PictureData.Size > keep.PictureData.Size {
+			err = m.takeCover(&keep, o)
+			if err != nil {
+				return
+			}
+		}
+
+		keep.SyncSettings.Should = keep.SyncSettings.Should || o.SyncSettings.Should
+		if o.Added.Before(keep.Added) {
+			keep.Added = o.Added
+		}
+
+		m.replaceInPlaylists(o.ID, keep.ID)
+		for i, p := range m.PlayHistory {
+			if p.ID == o.ID {
+				m.PlayHistory[i].ID = keep.ID
+			}
+		}
+	}
+
+	keep.LastEdit = time.Now()
+	m.Songs[keepID] = keep
+	m.indexEntry(keep)
+
+	for _, o := range others {
+		err = m.removeEntry(o)
+		if err != nil {
+			return
+		}
+	}
+
+	err = m.Save(false)
+	if err != nil {
+		return
+	}
+
+	m.event("song-edit", map[string]interface{}{
+		"id":   keepID,
+		"song": keep,
+	})
+	for _, o := range others {
+		m.event("song-delete", map[string]interface{}{
+			"id": o.ID,
+		})
+	}
+
+	return nil
+}
+
+// mergeMusicData sets all fields of `dst` that are empty to the values of `src`. The higher rating is kept
+func mergeMusicData(dst *music.MusicData, src music.MusicData) {
+	for _, f := range []struct{ dst, src *string }{
+		{&dst.Title, &src.Title},
+		{&dst.Artist, &src.Artist},
+		{&dst.Album, &src.Album},
+		{&dst.Genre, &src.Genre},
+	} {
+		if strings.TrimSpace(*f.dst) == "" {
+			*f.dst = *f.src
+		}
+	}
+
+	if dst.Year == nil && src.Year != nil {
+		y := *src.Year
+		dst.Year = &y
+	}
+	if dst.Track == 0 {
+		dst.Track = src.Track
+	}
+	if dst.Disc == 0 {
+		dst.Disc = src.Disc
+	}
+	if src.Rating > dst.Rating {
+		dst.Rating = src.Rating
+	}
+}
+
+// takeCover copies the cover of `src` to the song directory of `dst`, replacing its current cover
+func (m *Manager) takeCover(dst *music.Entry, src music.Entry) (err error) {
+	oldCover, oldCoverPath := dst.PictureData.Filename, dst.CoverPath()
+
+	coverFN := "cover" + strings.ToLower(filepath.Ext(src.PictureData.Filename))
+
+	err = copyOverwrite(src.CoverPath(), filepath.Join(dst.DirPath(), coverFN))
+	if err != nil {
+		return
+	}
+
+	if oldCover != coverFN && oldCover != "" {
+		err = os.Remove(oldCoverPath)
+		if err != nil && !os.IsNotExist(err) {
+			return
+		}
+	}
+
+	dst.PictureData = src.PictureData
+	dst.PictureData.Filename = coverFN
+
+	return nil
+}
+
+// FingerprintStatus returns whether fingerprinting is enabled and how many songs don't have a fingerprint yet
+func (m *Manager) FingerprintStatus() (enabled bool, missing int) {
+	for _, e := range m.AllEntries() {
+		if e.Fingerprint == nil {
+			missing++
+		}
+	}
+
+	return m.cfg.Alternatives.Fpcalc != "", missing
+}
@@ -0,0 +1,224 @@
+package store
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func TestParseFpcalc(t *testing.T) {
+	fp, duration, err := parseFpcalc([]byte("FILE=song.mp3\nDURATION=213\nFINGERPRINT=1,4294967295,-1,42\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if duration != 213 {
+		t.Errorf("duration = %v, want 213", duration)
+	}
+	if want := []uint32{1, 4294967295, 4294967295, 42}; !reflect.DeepEqual(fp, want) {
+		t.Errorf("fingerprint = %v, want %v", fp, want)
+	}
+
+	if _, _, err := parseFpcalc([]byte("DURATION=213\n")); err == nil {
+		t.Errorf("expected an error for output without fingerprint")
+	}
+	if _, _, err := parseFpcalc([]byte("FINGERPRINT=1,x,3\n")); err == nil {
+		t.Errorf("expected an error for an invalid fingerprint")
+	}
+}
+
+// randomFingerprint returns a fingerprint of `n` random values
+func randomFingerprint(r *rand.Rand, n int) []uint32 {
+	fp := make([]uint32, n)
+	for i := range fp {
+		fp[i] = r.Uint32()
+	}
+	return fp
+}
+
+// noisyFingerprint returns a copy of `fp` that starts `shift` values later and has `flips` random bits flipped per value
+// in every fourth value, like the same audio in a different encoding
+func noisyFingerprint(r *rand.Rand, fp []uint32, shift, flips int) []uint32 {
+	out := append([]uint32(nil), fp[shift:]...)
+	for i := 0; i < len(out); i += 4 {
+		for f := 0; f < flips; f++ {
+			out[i] ^= 1 << r.Intn(32)
+		}
+	}
+	return out
+}
+
+func TestFingerprintSimilarity(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+
+	original := randomFingerprint(r, 900)
+	reencoded := noisyFingerprint(r, original, 16, 3)
+	other := randomFingerprint(r, 900)
+
+	if sim := fingerprintSimilarity(original, original, fingerprintPositions(original)); sim != 1 {
+		t.Errorf("similarity of a fingerprint with itself = %v, want 1", sim)
+	}
+
+	sim := fingerprintSimilarity(original, reencoded, fingerprintPositions(reencoded))
+	if sim < duplicateSimilarity {
+		t.Errorf("similarity of shifted, noisy copy = %v, want at least %v", sim, duplicateSimilarity)
+	}
+	if back := fingerprintSimilarity(reencoded, original, fingerprintPositions(original)); back != sim {
+		t.Errorf("similarity is not symmetric: %v != %v", back, sim)
+	}
+
+	if sim := fingerprintSimilarity(original, other, fingerprintPositions(other)); sim != 0 {
+		t.Errorf("similarity of unrelated fingerprints = %v, want 0", sim)
+	}
+
+	// Short overlaps are not enough, e.g. when a song is only a part of another one
+	if sim := fingerprintSimilarity(original[:50], original, fingerprintPositions(original)); sim != 0 {
+		t.Errorf("similarity of a short part = %v, want 0", sim)
+	}
+}
+
+func TestManager_duplicates(t *testing.T) {
+	// Fingerprints are read from and covers copied in the data directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	year := 1975
+	added := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	m := Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs: map[string]music.Entry{
+			// "a" is the best audio, but "b" has more metadata and a better cover
+			"a": {ID: "a", Added: added.Add(time.Hour), FileData: music.FileData{Filename: "original.flac", Size: 30 << 20},
+				MusicData: music.MusicData{Title: "Bohemian Rhapsody", Artist: "Queen", Duration: 354}},
+			"b": {ID: "b", Added: added, FileData: music.FileData{Filename: "original.m4a", Size: 5 << 20},
+				SyncSettings: music.SyncSettings{Should: true},
+				MusicData:    music.MusicData{Title: "Bohemian Rhapsody (Official Video)", Artist: "Queen Official", Album: "A Night at the Opera", Year: &year, Rating: 4, Duration: 360},
+				PictureData:  music.PictureData{Filename: "cover.jpg", Size: 600}},
+			"c": {ID: "c", Added: added, FileData: music.FileData{Filename: "original.mp3", Size: 8 << 20},
+				MusicData: music.MusicData{Title: "Bohemian Rhapsody", Artist: "Queen", Duration: 355}},
+			"d": {ID: "d", Added: added, FileData: music.FileData{Filename: "original.mp3", Size: 8 << 20},
+				MusicData: music.MusicData{Title: "Heroes", Artist: "David Bowie", Duration: 371}},
+			// "e" has no fingerprint
+			"e": {ID: "e", MusicData: music.MusicData{Title: "Bohemian Rhapsody", Artist: "Queen"}},
+		},
+		Playlists: map[string]Playlist{
+			"p": {ID: "p", Name: "Queen", Songs: []string{"b", "d", "c"}},
+			"q": {ID: "q", Name: "Everything", Songs: []string{"a", "b"}},
+		},
+		PlayHistory: []Play{{ID: "b", Time: added}, {ID: "d", Time: added}},
+	}
+
+	r := rand.New(rand.NewSource(1))
+	rhapsody := randomFingerprint(r, 1000)
+	fingerprints := map[string][]uint32{
+		"a": rhapsody,
+		"b": noisyFingerprint(r, rhapsody, 40, 2),
+		"c": noisyFingerprint(r, rhapsody, 3, 3),
+		"d": randomFingerprint(r, 1000),
+	}
+
+	for id, fp := range fingerprints {
+		e := m.Songs[id]
+		err = os.MkdirAll(e.DirPath(), 0o755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = writeFingerprint(filepath.Join(e.DirPath(), fingerprintFilename), fp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.Fingerprint = &music.Fingerprint{Filename: fingerprintFilename}
+		m.Songs[id] = e
+	}
+	b := m.Songs["b"]
+	err = os.WriteFile(b.CoverPath(), []byte("cover"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	groups := m.FindDuplicates()
+	if len(groups) != 1 {
+		t.Fatalf("expected one group of duplicates, got %d", len(groups))
+	}
+
+	var ids []string
+	for _, e := range groups[0].Songs {
+		ids = append(ids, e.ID)
+	}
+	// The highest bitrate comes first
+	if want := []string{"a", "c", "b"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("duplicates are %v, want %v", ids, want)
+	}
+	if groups[0].Similarity < duplicateSimilarity || groups[0].Similarity > 1 {
+		t.Errorf("similarity of group is %v", groups[0].Similarity)
+	}
+
+	if _, sim, ok := m.probableDuplicate(noisyFingerprint(r, rhapsody, 10, 1)); !ok || sim < duplicateSimilarity {
+		t.Errorf("probableDuplicate() didn't find a similar song")
+	}
+	if _, _, ok := m.probableDuplicate(randomFingerprint(r, 1000)); ok {
+		t.Errorf("probableDuplicate() found a duplicate of unrelated audio")
+	}
+
+	if err := m.MergeDuplicates("a", "unknown"); err == nil {
+		t.Errorf("expected an error when merging an unknown song")
+	}
+	if err := m.MergeDuplicates("a", "a"); err == nil {
+		t.Errorf("expected an error when merging a song with itself only")
+	}
+
+	err = m.MergeDuplicates("a", "b", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := m.Songs["b"]; ok {
+		t.Errorf("merged song b still exists")
+	}
+	if _, err := os.Stat(filepath.Join("data", "songs", "c")); !os.IsNotExist(err) {
+		t.Errorf("directory of merged song c still exists")
+	}
+
+	a := m.Songs["a"]
+	if a.MusicData.Title != "Bohemian Rhapsody" || a.MusicData.Album != "A Night at the Opera" || a.MusicData.Year == nil || *a.MusicData.Year != 1975 || a.MusicData.Rating != 4 {
+		t.Errorf("metadata wasn't merged correctly: %+v", a.MusicData)
+	}
+	if a.FileData.Filename != "original.flac" || a.MusicData.Duration != 354 {
+		t.Errorf("audio of the kept song was changed: %+v", a.FileData)
+	}
+	if a.PictureData.Size != 600 {
+		t.Errorf("cover of song b wasn't taken")
+	}
+	if _, err := os.Stat(a.CoverPath()); err != nil {
+		t.Errorf("cover wasn't copied: %s", err.Error())
+	}
+	if !a.SyncSettings.Should || !a.Added.Equal(added) {
+		t.Errorf("sync settings or add date weren't merged: %+v, %s", a.SyncSettings, a.Added)
+	}
+
+	if got, want := m.Playlists["p"].Songs, []string{"a", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("playlist p contains %v, want %v", got, want)
+	}
+	if got, want := m.Playlists["q"].Songs, []string{"a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("playlist q contains %v, want %v", got, want)
+	}
+	if m.PlayHistory[0].ID != "a" || m.PlayHistory[1].ID != "d" {
+		t.Errorf("play history wasn't updated: %v", m.PlayHistory)
+	}
+
+	if groups := m.FindDuplicates(); len(groups) != 0 {
+		t.Errorf("expected no duplicates after merging, got %d groups", len(groups))
+	}
+}
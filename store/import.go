@@ -3,9 +3,11 @@ package store
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -41,6 +43,42 @@ func (m *Manager) ImportFiles(directory string) (err error) {
 	return
 }
 
+// ImportReader imports the file `name` with the content read from `f`. It is copied to the import directory first,
+// which means that files that cannot be imported are tried again on the next start. `n` is the number of bytes read
+func (m *Manager) ImportReader(name string, f io.Reader) (e *music.Entry, n int64, err error) {
+	base := path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if !musicExtensions[strings.TrimPrefix(strings.ToLower(path.Ext(base)), ".")] {
+		return nil, 0, fmt.Errorf("%q doesn't seem to be an audio file", base)
+	}
+
+	dest := filepath.Join("import", CleanName(base))
+
+	// Try to create the import directory, but ignore if it doesn't work.
+	_ = os.MkdirAll(filepath.Dir(dest), os.ModePerm)
+
+	d, err := os.Create(dest)
+	if err != nil {
+		return
+	}
+
+	n, err = io.Copy(d, f)
+	if err != nil {
+		d.Close()
+
+		os.Remove(d.Name())
+
+		return
+	}
+
+	err = d.Close()
+	if err != nil {
+		return
+	}
+
+	e, err = m.ImportFile(d.Name(), nil)
+	return
+}
+
 // ImportFile imports a file from the given path. `info` is optional
 func (m *Manager) ImportFile(musicFile string, info os.FileInfo) (e *music.Entry, err error) {
 	if info == nil {
@@ -195,5 +233,21 @@ func (m *Manager) ImportFile(musicFile string, info os.FileInfo) (e *music.Entry
 
 	log.Printf("[Import] Added %s\n", e.SongName())
 
+	go func(id string) {
+		err := m.ProbeEntry(id)
+		if err != nil {
+			log.Printf("[Import] Error while probing audio quality of %s: %s\n", id, err.Error())
+		}
+	}(e.ID)
+
+	if m.cfg.Alternatives.Fpcalc != "" {
+		go func(id string) {
+			err := m.FingerprintEntry(id)
+			if err != nil {
+				log.Printf("[Import] Error while fingerprinting %s: %s\n", id, err.Error())
+			}
+		}(e.ID)
+	}
+
 	return e, nil
 }
@@ -0,0 +1,358 @@
+package store
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"xarantolus/sensibleHub/store/music"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// searchIndex is an inverted index over the words of title, artist and album of all songs.
+// All text in it is folded, see fold
+type searchIndex struct {
+	lock sync.RWMutex
+
+	songs map[string]indexedSong
+
+	// words maps words to the IDs of songs that contain them
+	words map[string]map[string]bool
+	// grams maps trigrams to the words that contain them, they are used for substring and fuzzy lookups
+	grams map[string]map[string]bool
+}
+
+// indexedSong contains the folded fields of a song, so they don't have to be processed for every search
+type indexedSong struct {
+	title, artist, album indexedField
+}
+
+type indexedField struct {
+	text string
+	// words are the space-separated words used for scoring
+	words []string
+}
+
+func (f indexedField) tokens() []string {
+	return tokenizeFolded(f.text)
+}
+
+func newSearchIndex() *searchIndex {
+	return &searchIndex{
+		songs: make(map[string]indexedSong),
+		words: make(map[string]map[string]bool),
+		grams: make(map[string]map[string]bool),
+	}
+}
+
+// searchIndex returns the search index of the manager, it is built on first use
+func (m *Manager) searchIndex() *searchIndex {
+	m.indexOnce.Do(func() {
+		ix := newSearchIndex()
+
+		m.SongsLock.RLock()
+		for _, e := range m.Songs {
+			ix.update(e)
+		}
+		m.index = ix
+		m.SongsLock.RUnlock()
+	})
+
+	return m.index
+}
+
+// indexEntry updates the search index after the title, artist or album of `e` might have changed.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) indexEntry(e music.Entry) {
+	if m.index != nil {
+		m.index.update(e)
+	}
+}
+
+// unindexEntry removes the song with the given `id` from the search index.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) unindexEntry(id string) {
+	if m.index != nil {
+		m.index.remove(id)
+	}
+}
+
+var foldTransformer = sync.Pool{
+	New: func() interface{} {
+		return transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	},
+}
+
+// foldReplacer replaces letters that have no decomposition
+var foldReplacer = strings.NewReplacer("ß", "SS", "Ø", "O", "Æ", "AE", "Œ", "OE", "Ł", "L", "Đ", "D", "Þ", "TH")
+
+// fold converts `s` to upper case and removes diacritics, "Beyoncé" becomes "BEYONCE"
+func fold(s string) string {
+	t := foldTransformer.Get().(transform.Transformer)
+	res, _, err := transform.String(t, s)
+	foldTransformer.Put(t)
+	if err != nil {
+		res = s
+	}
+
+	return foldReplacer.Replace(strings.ToUpper(res))
+}
+
+// tokenizeFolded splits folded text into the words that are put in the index
+func tokenizeFolded(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// trigrams returns all substrings of `word` that are three runes long
+func trigrams(word string) (grams []string) {
+	r := []rune(word)
+	for i := 0; i+3 <= len(r); i++ {
+		grams = append(grams, string(r[i:i+3]))
+	}
+	return
+}
+
+func newIndexedField(s string) indexedField {
+	f := fold(s)
+	return indexedField{
+		text:  f,
+		words: splitString(f),
+	}
+}
+
+func newIndexedSong(e music.Entry) indexedSong {
+	return indexedSong{
+		title:  newIndexedField(e.MusicData.Title),
+		artist: newIndexedField(e.MusicData.Artist),
+		album:  newIndexedField(e.MusicData.Album),
+	}
+}
+
+// update adds `e` to the index or replaces the previous version of it
+func (ix *searchIndex) update(e music.Entry) {
+	song := newIndexedSong(e)
+
+	ix.lock.Lock()
+	defer ix.lock.Unlock()
+
+	if old, ok := ix.songs[e.ID]; ok {
+		if old.title.text == song.title.text && old.artist.text == song.artist.text && old.album.text == song.album.text {
+			return
+		}
+		ix.removeLocked(e.ID)
+	}
+
+	ix.songs[e.ID] = song
+	for _, w := range song.tokens() {
+		ids, ok := ix.words[w]
+		if !ok {
+			ids = make(map[string]bool)
+			ix.words[w] = ids
+
+			for _, g := range trigrams(w) {
+				if ix.grams[g] == nil {
+					ix.grams[g] = make(map[string]bool)
+				}
+				ix.grams[g][w] = true
+			}
+		}
+		ids[e.ID] = true
+	}
+}
+
+// remove removes the song with the given `id` from the index
+func (ix *searchIndex) remove(id string) {
+	ix.lock.Lock()
+	ix.removeLocked(id)
+	ix.lock.Unlock()
+}
+
+func (ix *searchIndex) removeLocked(id string) {
+	song, ok := ix.songs[id]
+	if !ok {
+		return
+	}
+	delete(ix.songs, id)
+
+	for _, w := range song.tokens() {
+		ids := ix.words[w]
+		delete(ids, id)
+		if len(ids) > 0 {
+			continue
+		}
+
+		// No song contains this word anymore
+		delete(ix.words, w)
+		for _, g := range trigrams(w) {
+			delete(ix.grams[g], w)
+			if len(ix.grams[g]) == 0 {
+				delete(ix.grams, g)
+			}
+		}
+	}
+}
+
+// tokens returns the distinct words of all indexed fields
+func (s indexedSong) tokens() (words []string) {
+	seen := make(map[string]bool)
+	for _, f := range []indexedField{s.title, s.artist, s.album} {
+		for _, w := range f.tokens() {
+			if !seen[w] {
+				seen[w] = true
+				words = append(words, w)
+			}
+		}
+	}
+	return
+}
+
+// get returns the indexed fields of the song with the given `id`
+func (ix *searchIndex) get(id string) (s indexedSong, ok bool) {
+	ix.lock.RLock()
+	s, ok = ix.songs[id]
+	ix.lock.RUnlock()
+	return
+}
+
+// lookup returns the IDs of all songs that contain every word of the folded `term`. Words that aren't
+// found are replaced by similar words from the index, e.g. "BEYONSE" by "BEYONCE". `corrected` is
+// the term with these replacements
+func (ix *searchIndex) lookup(term string) (ids map[string]bool, corrected string) {
+	ix.lock.RLock()
+	defer ix.lock.RUnlock()
+
+	corrected = term
+	for _, tok := range tokenizeFolded(term) {
+		matches := ix.containing(tok)
+		if len(matches) == 0 {
+			var best string
+			best, matches = ix.similar(tok)
+			if best != "" {
+				corrected = strings.Replace(corrected, tok, best, 1)
+			}
+		}
+
+		found := make(map[string]bool)
+		for _, w := range matches {
+			for id := range ix.words[w] {
+				if ids == nil || ids[id] {
+					found[id] = true
+				}
+			}
+		}
+
+		ids = found
+		if len(ids) == 0 {
+			break
+		}
+	}
+
+	return
+}
+
+// containing returns all words in the index that contain `tok`. Tokens shorter than three letters
+// must be at the start of words
+func (ix *searchIndex) containing(tok string) (words []string) {
+	grams := trigrams(tok)
+	if len(grams) == 0 {
+		// Too short for trigrams and it would be contained in almost every word, so only prefixes are matched
+		for w := range ix.words {
+			if strings.HasPrefix(w, tok) {
+				words = append(words, w)
+			}
+		}
+		return
+	}
+
+	// Only words from the smallest trigram list can contain all trigrams
+	smallest := ix.grams[grams[0]]
+	for _, g := range grams[1:] {
+		if len(ix.grams[g]) < len(smallest) {
+			smallest = ix.grams[g]
+		}
+	}
+
+	for w := range smallest {
+		if strings.Contains(w, tok) {
+			words = append(words, w)
+		}
+	}
+	return
+}
+
+// similar returns the words in the index that have the smallest edit distance to `tok` and the one
+// that's used in most songs. Short words are not corrected as almost everything would be similar
+func (ix *searchIndex) similar(tok string) (best string, words []string) {
+	length := len([]rune(tok))
+	if length < 4 {
+		return
+	}
+
+	maxDist := 1
+	if length > 6 {
+		maxDist = 2
+	}
+
+	candidates := make(map[string]bool)
+	for _, g := range trigrams(tok) {
+		for w := range ix.grams[g] {
+			candidates[w] = true
+		}
+	}
+
+	bestDist := maxDist + 1
+	for w := range candidates {
+		diff := len([]rune(w)) - length
+		if diff > maxDist || -diff > maxDist {
+			continue
+		}
+
+		d := editDistance(tok, w)
+		switch {
+		case d > bestDist:
+			continue
+		case d < bestDist:
+			bestDist, words = d, nil
+		}
+		words = append(words, w)
+	}
+
+	sort.Strings(words)
+	for _, w := range words {
+		if best == "" || len(ix.words[w]) > len(ix.words[best]) {
+			best = w
+		}
+	}
+
+	return
+}
+
+// editDistance returns the Levenshtein distance between `a` and `b`
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+
+	return prev[len(rb)]
+}
@@ -0,0 +1,156 @@
+package store
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func TestFold(t *testing.T) {
+	tests := map[string]string{
+		"Beyoncé":          "BEYONCE",
+		"Björk":            "BJORK",
+		"Sigur Rós":        "SIGUR ROS",
+		"Mötley Crüe":      "MOTLEY CRUE",
+		"Die Ärzte - Weiß": "DIE ARZTE - WEISS",
+		"Ørjan Nilsen":     "ORJAN NILSEN",
+	}
+	for in, want := range tests {
+		if got := fold(in); got != want {
+			t.Errorf("fold(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestManager_searchIndex(t *testing.T) {
+	m := Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs: map[string]music.Entry{
+			"a": {ID: "a", MusicData: music.MusicData{Title: "Halo", Artist: "Beyoncé", Album: "I Am... Sasha Fierce"}},
+			"b": {ID: "b", MusicData: music.MusicData{Title: "Army of Me", Artist: "Björk", Album: "Post"}},
+			"c": {ID: "c", MusicData: music.MusicData{Title: "Hoppípolla", Artist: "Sigur Rós", Album: "Takk..."}},
+			"d": {ID: "d", MusicData: music.MusicData{Title: "Bohemian Rhapsody", Artist: "Queen", Album: "A Night at the Opera"}},
+		},
+	}
+
+	ids := func(entries []music.Entry) (res []string) {
+		for _, e := range entries {
+			res = append(res, e.ID)
+		}
+		sort.Strings(res)
+		return
+	}
+
+	search := func(t *testing.T, query string, want ...string) {
+		t.Helper()
+		if got := ids(m.Search(query)); !reflect.DeepEqual(got, want) {
+			t.Errorf("Search(%q) = %v, want %v", query, got, want)
+		}
+	}
+
+	// Diacritics are ignored in both directions
+	search(t, "bjork", "b")
+	search(t, "Hoppipolla", "c")
+	search(t, "sigur rós", "c")
+
+	// Prefixes and substrings
+	search(t, "bohem", "d")
+	search(t, "rhaps", "d")
+
+	// Typos
+	search(t, "beyonse", "a")
+	search(t, "rhapsodie", "d")
+	search(t, "qeen", "d")
+	search(t, "xyzzy")
+
+	// The index follows edits and deletions
+	e := m.Songs["d"]
+	e.MusicData.Artist = "Freddie Mercury"
+	m.Songs["d"] = e
+	m.indexEntry(e)
+	search(t, "queen")
+	search(t, "mercury", "d")
+
+	delete(m.Songs, "b")
+	m.unindexEntry("b")
+	search(t, "bjork")
+
+	if _, ok := m.index.words["BJORK"]; ok {
+		t.Errorf("words of deleted songs should be removed from the index")
+	}
+}
+
+// syntheticManager returns a manager with `n` songs made from random words
+func syntheticManager(n int) *Manager {
+	r := rand.New(rand.NewSource(1))
+
+	syllables := []string{"ka", "lo", "mi", "ra", "te", "su", "ne", "vo", "dé", "bri", "an", "el", "on", "yu", "ß", "ö"}
+	vocabulary := make([]string, 5000)
+	for i := range vocabulary {
+		for j := 0; j < 2+r.Intn(3); j++ {
+			vocabulary[i] += syllables[r.Intn(len(syllables))]
+		}
+	}
+
+	words := func(k int) (s string) {
+		for i := 0; i < k; i++ {
+			if i > 0 {
+				s += " "
+			}
+			s += vocabulary[r.Intn(len(vocabulary))]
+		}
+		return
+	}
+
+	artists := make([]string, n/20)
+	for i := range artists {
+		artists[i] = words(1 + r.Intn(2))
+	}
+
+	m := &Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs:     make(map[string]music.Entry, n),
+	}
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("song%d", i)
+		m.Songs[id] = music.Entry{ID: id, MusicData: music.MusicData{
+			Title:  words(1 + r.Intn(4)),
+			Artist: artists[r.Intn(len(artists))],
+			Album:  words(1 + r.Intn(3)),
+		}}
+	}
+
+	return m
+}
+
+func BenchmarkManager_Search(b *testing.B) {
+	m := syntheticManager(50000)
+	m.searchIndex()
+
+	for _, query := range []string{"ka", "kalomi", "kalomy", "mira tesu", `"dé ra"`, "artist:kalo -mi"} {
+		b.Run(query, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				m.Search(query)
+			}
+		})
+	}
+}
+
+func BenchmarkSearchIndex_update(b *testing.B) {
+	m := syntheticManager(50000)
+	ix := m.searchIndex()
+
+	entries := m.AllEntries()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		e := entries[i%len(entries)]
+		e.MusicData.Title += " (Live)"
+		ix.update(e)
+		ix.update(entries[i%len(entries)])
+	}
+}
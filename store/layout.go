@@ -0,0 +1,376 @@
+package store
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"xarantolus/sensibleHub/store/music"
+)
+
+// DefaultLayout is the directory layout used if none is configured, e.g. "Artist/Album/Artist - Title.mp3"
+const DefaultLayout = "{artist}/{album}/{name}.{ext}"
+
+const (
+	// unknownValue is used for missing titles, artists, albums and genres, like music.Entry.Artist does
+	unknownValue = "Unknown"
+
+	// otherComponent is used for path components that would be empty, e.g. because the name only contains characters
+	// that can't be used in file names. Artist and album directories use the same names as before layouts existed
+	otherComponent = "Other"
+	otherArtist    = "Other Artist"
+	otherAlbum     = "Other Album"
+)
+
+// layoutSeparators are the characters that are removed from literals next to placeholders without a value
+const layoutSeparators = " -_"
+
+// unknownPlaceholders are the placeholders that are unknownValue if the song doesn't have them
+var unknownPlaceholders = map[string]bool{
+	"title":       true,
+	"artist":      true,
+	"albumartist": true,
+	"album":       true,
+	"genre":       true,
+}
+
+// Layout is a parsed path template like "{artist}/{year} - {album}/{track:02} {title}.{ext}".
+// Each part between slashes is a directory, the last one is the file name
+type Layout struct {
+	components []layoutComponent
+}
+
+// layoutComponent is one directory or the file name of a layout
+type layoutComponent struct {
+	tokens []layoutToken
+
+	// expr matches names created from this component, the submatches are the values of `names`
+	expr  *regexp.Regexp
+	names []string
+
+	// other is used if the name would be empty
+	other string
+}
+
+// layoutToken is either a literal string or a placeholder
+type layoutToken struct {
+	literal string
+
+	placeholder string
+	// width is the minimum width of numbers, they are padded with zeros
+	width int
+}
+
+// layoutPlaceholders are all placeholders that can be used in a layout. The value is true for numbers
+var layoutPlaceholders = map[string]bool{
+	"title":       false,
+	"artist":      false,
+	"albumartist": false,
+	"album":       false,
+	"name":        false,
+	"genre":       false,
+	"ext":         false,
+	"id":          false,
+	"year":        true,
+	"track":       true,
+	"disc":        true,
+	"rating":      true,
+}
+
+// ParseLayout parses a path template. An empty template returns the default layout
+func ParseLayout(template string) (l Layout, err error) {
+	template = strings.TrimSpace(template)
+	if template == "" {
+		template = DefaultLayout
+	}
+
+	for _, component := range strings.Split(strings.Trim(template, "/"), "/") {
+		var tokens []layoutToken
+
+		rest := component
+		for rest != "" {
+			start := strings.IndexRune(rest, '{')
+			if start == -1 {
+				tokens = append(tokens, layoutToken{literal: rest})
+				break
+			}
+			if start > 0 {
+				tokens = append(tokens, layoutToken{literal: rest[:start]})
+			}
+
+			end := strings.IndexRune(rest[start:], '}')
+			if end == -1 {
+				return l, fmt.Errorf("layout %q has an unclosed placeholder", template)
+			}
+			end += start
+
+			token, err := parsePlaceholder(rest[start+1 : end])
+			if err != nil {
+				return l, fmt.Errorf("layout %q: %s", template, err.Error())
+			}
+			tokens = append(tokens, token)
+
+			rest = rest[end+1:]
+		}
+
+		if len(tokens) == 0 {
+			return l, fmt.Errorf("layout %q contains an empty directory name", template)
+		}
+
+		l.components = append(l.components, newLayoutComponent(tokens))
+	}
+
+	return
+}
+
+// newLayoutComponent compiles the expression that is used to extract values from names created using `tokens`
+func newLayoutComponent(tokens []layoutToken) (c layoutComponent) {
+	c.tokens = tokens
+	c.other = otherComponent
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for _, t := range tokens {
+		if t.placeholder == "" {
+			expr.WriteString(regexp.QuoteMeta(t.literal))
+			continue
+		}
+
+		expr.WriteString("(.+?)")
+		c.names = append(c.names, t.placeholder)
+
+		switch {
+		case t.placeholder == "artist" || t.placeholder == "albumartist":
+			c.other = otherArtist
+		case t.placeholder == "album" && c.other == otherComponent:
+			c.other = otherAlbum
+		}
+	}
+	expr.WriteString("$")
+
+	c.expr = regexp.MustCompile(expr.String())
+
+	return
+}
+
+// parsePlaceholder parses the inside of a placeholder, e.g. "track:02"
+func parsePlaceholder(s string) (t layoutToken, err error) {
+	name, width, hasWidth := strings.Cut(s, ":")
+	t.placeholder = strings.ToLower(strings.TrimSpace(name))
+
+	isNumber, ok := layoutPlaceholders[t.placeholder]
+	if !ok {
+		return t, fmt.Errorf("unknown placeholder {%s}", s)
+	}
+
+	if hasWidth {
+		if !isNumber {
+			return t, fmt.Errorf("placeholder {%s} is not a number and cannot have a width", s)
+		}
+
+		t.width, err = strconv.Atoi(width)
+		if err != nil || t.width < 0 {
+			return t, fmt.Errorf("invalid width in placeholder {%s}", s)
+		}
+	}
+
+	return t, nil
+}
+
+// Path returns the path components for a song file with the extension `ext`.
+// `track` is used if the song doesn't have a track number, e.g. its position in the album
+func (l Layout) Path(e music.Entry, ext string, track int) (components []string) {
+	values := layoutValues(e, ext, track)
+
+	for _, lc := range l.components {
+		components = append(components, lc.name(values))
+	}
+
+	return
+}
+
+// name returns the name of this component for a song with the given placeholder values
+func (lc layoutComponent) name(values map[string]string) string {
+	parts := make([]string, len(lc.tokens))
+	for i, t := range lc.tokens {
+		if t.placeholder == "" {
+			parts[i] = CleanName(t.literal)
+			continue
+		}
+
+		v := CleanName(values[t.placeholder])
+		if v != "" && len(v) < t.width {
+			v = strings.Repeat("0", t.width-len(v)) + v
+		}
+		parts[i] = v
+	}
+
+	// Separators next to empty values, e.g. the " - " in "{year} - {album}" without a year, are removed.
+	// Values are never trimmed, an artist like "-M-" keeps its dashes
+	for i, t := range lc.tokens {
+		if t.placeholder == "" {
+			continue
+		}
+		if parts[i] != "" {
+			continue
+		}
+
+		if i > 0 && lc.tokens[i-1].placeholder == "" {
+			parts[i-1] = strings.TrimRight(parts[i-1], layoutSeparators)
+		}
+		if i+1 < len(lc.tokens) && lc.tokens[i+1].placeholder == "" {
+			parts[i+1] = strings.TrimLeft(parts[i+1], layoutSeparators)
+		}
+	}
+
+	c := strings.TrimSpace(strings.Join(parts, ""))
+	if c == "" || c == "." || c == ".." {
+		c = lc.other
+	}
+
+	return c
+}
+
+func layoutValues(e music.Entry, ext string, track int) map[string]string {
+	title := e.MusicData.Title
+	if title == "" {
+		title = unknownValue
+	}
+
+	if e.MusicData.Track > 0 {
+		track = e.MusicData.Track
+	}
+
+	disc := e.MusicData.Disc
+	if disc <= 0 {
+		disc = 1
+	}
+
+	genre := e.MusicData.Genre
+	if genre == "" {
+		genre = unknownValue
+	}
+
+	var year string
+	if e.MusicData.Year != nil {
+		year = strconv.Itoa(*e.MusicData.Year)
+	}
+
+	var trackNumber string
+	if track > 0 {
+		trackNumber = strconv.Itoa(track)
+	}
+
+	return map[string]string{
+		"title":  title,
+		"artist": e.Artist(),
+		// We don't store album artists separately
+		"albumartist": e.Artist(),
+		"album":       e.AlbumName(),
+		"name":        strings.TrimSuffix(e.SongName(), "."),
+		"genre":       genre,
+		"ext":         ext,
+		"id":          e.ID,
+		"year":        year,
+		"track":       trackNumber,
+		"disc":        strconv.Itoa(disc),
+		"rating":      strconv.Itoa(e.MusicData.Rating),
+	}
+}
+
+// Depth returns the number of path components of paths created using this layout
+func (l Layout) Depth() int {
+	return len(l.components)
+}
+
+// Values extracts placeholder values from path components that were created using this layout.
+// A path with fewer components, e.g. a directory, is matched against the beginning of the layout.
+// Components that don't match are ignored, as are placeholders that cannot be written back
+func (l Layout) Values(components []string) map[string]string {
+	values := make(map[string]string)
+
+	for i, c := range components {
+		l.componentValues(i, c, values)
+	}
+
+	return values
+}
+
+// ChangedValues is like Values, but only extracts values from components of `to` that are different in `from`.
+// It is used when a path is renamed, that way tags in unchanged components are kept as they are
+func (l Layout) ChangedValues(from, to []string) map[string]string {
+	values := make(map[string]string)
+
+	for i, c := range to {
+		if i < len(from) && from[i] == c {
+			continue
+		}
+
+		l.componentValues(i, c, values)
+	}
+
+	return values
+}
+
+// componentValues matches `c` against the `i`th component of the layout and puts all values in `values`.
+// Values that Path uses for missing data are skipped, otherwise songs would be tagged with them
+func (l Layout) componentValues(i int, c string, values map[string]string) {
+	if i >= len(l.components) {
+		return
+	}
+
+	lc := l.components[i]
+	if strings.EqualFold(c, lc.other) {
+		return
+	}
+
+	match := lc.expr.FindStringSubmatch(c)
+	if match == nil {
+		return
+	}
+
+	for j, name := range lc.names {
+		switch name {
+		case "name", "ext", "id":
+			// These cannot be mapped back to song data
+			continue
+		}
+
+		v := strings.TrimSpace(match[j+1])
+		if unknownPlaceholders[name] && strings.EqualFold(v, unknownValue) {
+			continue
+		}
+		if name == "albumartist" {
+			name = "artist"
+		}
+
+		values[name] = v
+	}
+}
+
+// applyLayoutValues sets the fields of `md` to the values extracted by Layout.Values
+func applyLayoutValues(md *music.MusicData, values map[string]string) {
+	for name, v := range values {
+		switch name {
+		case "title":
+			setValidS(&md.Title, v)
+		case "artist":
+			md.Artist = v
+		case "album":
+			md.Album = v
+		case "genre":
+			md.Genre = v
+		case "year":
+			// A new pointer would make an unchanged entry look edited
+			if y, err := strconv.Atoi(v); err == nil && (md.Year == nil || *md.Year != y) {
+				md.Year = &y
+			}
+		case "track":
+			setValidI(&md.Track, v, 1, 9999)
+		case "disc":
+			setValidI(&md.Disc, v, 1, 999)
+		case "rating":
+			setValidI(&md.Rating, v, 0, 5)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func TestLayout_Path(t *testing.T) {
+	year := 1975
+
+	queen := music.Entry{
+		ID: "abcd",
+		MusicData: music.MusicData{
+			Title:  "Bohemian Rhapsody",
+			Artist: "Queen",
+			Album:  "A Night at the Opera",
+			Year:   &year,
+			Track:  11,
+		},
+	}
+	unknown := music.Entry{
+		ID: "efgh",
+		MusicData: music.MusicData{
+			Title: "Untitled",
+		},
+	}
+	dashes := music.Entry{
+		ID: "ijkl",
+		MusicData: music.MusicData{
+			Title:  "Je dis aime",
+			Artist: "-M-",
+			Album:  "Je dis aime -",
+		},
+	}
+	unnamed := music.Entry{
+		ID: "mnop",
+		MusicData: music.MusicData{
+			Title:  "東京",
+			Artist: "東京事変",
+			Album:  "教育",
+		},
+	}
+
+	tests := []struct {
+		layout string
+		entry  music.Entry
+		track  int
+		want   []string
+	}{
+		{"", queen, 0, []string{"Queen", "A Night at the Opera", "Queen - Bohemian Rhapsody.mp3"}},
+		{"", unknown, 0, []string{"Unknown", "Unknown", "Untitled.mp3"}},
+		{"{albumartist}/{year} - {album}/{disc}-{track:02} {title}.{ext}", queen, 3, []string{"Queen", "1975 - A Night at the Opera", "1-11 Bohemian Rhapsody.mp3"}},
+		{"{artist}/{year} - {album}/{track:02} {title}.{ext}", unknown, 3, []string{"Unknown", "Unknown", "03 Untitled.mp3"}},
+		{"{name}.{ext}", queen, 0, []string{"Queen - Bohemian Rhapsody.mp3"}},
+		{"/{genre}/{artist}/{title}.{ext}/", queen, 0, []string{"Unknown", "Queen", "Bohemian Rhapsody.mp3"}},
+		{"{artist}/{title}: {id}.{ext}", queen, 0, []string{"Queen", "Bohemian Rhapsody abcd.mp3"}},
+		{"{artist}/{year} - {album}/{title}.{ext}", dashes, 0, []string{"-M-", "Je dis aime -", "Je dis aime.mp3"}},
+		{"{artist}/{album}/{title} - {id}.{ext}", unnamed, 0, []string{"Other Artist", "Other Album", "mnop.mp3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.layout, func(t *testing.T) {
+			l, err := ParseLayout(tt.layout)
+			if err != nil {
+				t.Fatalf("ParseLayout() error = %v", err)
+			}
+
+			if got := l.Path(tt.entry, "mp3", tt.track); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Layout.Path() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLayout_Invalid(t *testing.T) {
+	for _, layout := range []string{
+		"{artist}/{unknown}.{ext}",
+		"{artist}/{title.{ext}",
+		"{artist}//{title}.{ext}",
+		"{title:02}.{ext}",
+		"{track:x}.{ext}",
+	} {
+		if _, err := ParseLayout(layout); err == nil {
+			t.Errorf("ParseLayout(%q) should return an error", layout)
+		}
+	}
+}
+
+func TestLayout_Values(t *testing.T) {
+	l, err := ParseLayout("{albumartist}/{year} - {album}/{track:02} {title}.{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		from, to []string
+		want     map[string]string
+	}{
+		{
+			name: "directory",
+			to:   []string{"Queen", "1975 - A Night at the Opera"},
+			want: map[string]string{"artist": "Queen", "year": "1975", "album": "A Night at the Opera"},
+		},
+		{
+			name: "file",
+			to:   []string{"Queen", "1975 - A Night at the Opera", "11 Bohemian Rhapsody.mp3"},
+			want: map[string]string{"artist": "Queen", "year": "1975", "album": "A Night at the Opera", "track": "11", "title": "Bohemian Rhapsody"},
+		},
+		{
+			name: "not matching",
+			to:   []string{"Queen", "A Night at the Opera"},
+			want: map[string]string{"artist": "Queen"},
+		},
+		{
+			name: "placeholders for missing data",
+			to:   []string{"Unknown", "Other Album", "11 Unknown.mp3"},
+			want: map[string]string{"track": "11"},
+		},
+		{
+			name: "unknown album",
+			to:   []string{"Queen", "1975 - unknown"},
+			want: map[string]string{"artist": "Queen", "year": "1975"},
+		},
+		{
+			name: "only changed components",
+			from: []string{"Queen", "1975 - A Night at the Opera", "11 Bohemian Rhapsody.mp3"},
+			to:   []string{"Queen", "1975 - Greatest Hits", "11 Bohemian Rhapsody.mp3"},
+			want: map[string]string{"year": "1975", "album": "Greatest Hits"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := l.ChangedValues(tt.from, tt.to)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Layout.ChangedValues() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"xarantolus/sensibleHub/store/config"
 	"xarantolus/sensibleHub/store/music"
@@ -29,9 +30,36 @@ type Manager struct {
 	Songs     map[string]music.Entry `json:"songs"`
 	SongsLock *sync.RWMutex          `json:"-"`
 
+	// Devices maps FTP/WebDAV user names to the songs they downloaded. It is also protected by SongsLock
+	Devices map[string]*Device `json:"devices,omitempty"`
+
+	// Playlists maps playlist IDs to the playlists created by the user. They are also protected by SongsLock
+	Playlists map[string]Playlist `json:"playlists,omitempty"`
+
+	// SmartPlaylists maps IDs to the rule-based playlists created by the user. They are also protected by SongsLock
+	SmartPlaylists map[string]SmartPlaylist `json:"smart_playlists,omitempty"`
+
+	// PlayHistory contains the last songs that were played by clients, oldest first. It is also protected by SongsLock
+	PlayHistory []Play `json:"play_history,omitempty"`
+
+	// SourceHistory maps song IDs to the audio sources they had before their audio was replaced, oldest first.
+	// It is also protected by SongsLock
+	SourceHistory map[string][]ReplacedSource `json:"source_history,omitempty"`
+
+	// APITokens maps token IDs to the API tokens of website users. They are also protected by SongsLock
+	APITokens map[string]APIToken `json:"api_tokens,omitempty"`
+
+	// NameAliases maps the keys of old artist and album spellings to the names they were merged into.
+	// They are also protected by SongsLock
+	NameAliases map[string]NameAlias `json:"name_aliases,omitempty"`
+
+	// Rewrites are the rules that change the metadata of new downloads, they are applied in order.
+	// They are also protected by SongsLock
+	Rewrites []RewriteRule `json:"rewrite_rules,omitempty"`
+
 	// enqueuedURLs is a queue where all urls that should be downloaded are put in.
 	// They will be processed sequentially
-	enqueuedURLs chan string
+	enqueuedURLs chan downloadRequest
 
 	// evtFunc is called whenever a websocket event should be written to all sockets
 	// It should be set before using the manager / starting the server
@@ -45,6 +73,10 @@ type Manager struct {
 	// lastErr is the last error encountered while running youtube-dl, might be nil
 	lastErr error
 
+	// pending contains downloads that are probably duplicates, they are only added once the user confirms it
+	pending     map[string]pendingDownload
+	pendingLock sync.Mutex
+
 	downloadContextLock sync.Mutex
 	// currentDownload contains the url that is currently processed by youtube-dl
 	currentDownload string
@@ -52,6 +84,16 @@ type Manager struct {
 	// downloadCancelFunc can be called to cancel the currently running download process
 	downloadCancelFunc context.CancelFunc
 
+	// index is used for searching, it is built on first use by searchIndex
+	index     *searchIndex
+	indexOnce sync.Once
+
+	// syncSave is the pending save of download records, see RecordSync. It is protected by SongsLock
+	syncSave *time.Timer
+
+	// generation is increased whenever the manager is saved, see Generation
+	generation atomic.Uint64
+
 	// cfg is the configuration
 	cfg config.Config
 }
@@ -62,7 +104,7 @@ func NewManager(cfg config.Config) (m *Manager, err error) {
 	m = &Manager{
 		Songs:        make(map[string]music.Entry),
 		SongsLock:    new(sync.RWMutex),
-		enqueuedURLs: make(chan string, 25), // Allow up to 25 items to be queued
+		enqueuedURLs: make(chan downloadRequest, 25), // Allow up to 25 items to be queued
 		cfg:          cfg,
 	}
 
@@ -86,6 +128,9 @@ func NewManager(cfg config.Config) (m *Manager, err error) {
 		return m, err
 	}
 
+	// Build the index now, so the first search isn't slow
+	m.searchIndex()
+
 	return
 }
 
@@ -101,6 +146,9 @@ func (m *Manager) Save(lock ...bool) (err error) {
 		defer m.SongsLock.Unlock()
 	}
 
+	// Everything that changes the library is saved, even if writing the file fails the data in memory changed
+	m.generation.Add(1)
+
 	err = os.MkdirAll(filepath.Dir(managerDataFile), 0o755) // https://stackoverflow.com/a/31151508
 	if err != nil {
 		return
@@ -132,14 +180,23 @@ func (m *Manager) Save(lock ...bool) (err error) {
 	return os.Rename(tmpFile, managerDataFile)
 }
 
-// Add adds an entry to the manager and saves it.
+// Generation returns a number that changes whenever songs, playlists or devices were changed.
+// It can be used to find out whether data derived from the library is outdated
+func (m *Manager) Generation() uint64 {
+	return m.generation.Load()
+}
+
+// Add adds an entry to the manager and saves it. Artist and album names that were merged into other names are renamed.
 // It assumes that m.SongsLock is already locked
 func (m *Manager) Add(e *music.Entry) (err error) {
 	if _, ok := m.Songs[e.ID]; ok {
 		return fmt.Errorf("ID %s already taken", e.ID)
 	}
 
+	m.applyAliases(e)
+
 	m.Songs[e.ID] = *e
+	m.indexEntry(*e)
 
 	err = m.Save(false)
 	if err != nil {
@@ -163,8 +220,23 @@ func (m *Manager) GetEntry(id string) (e music.Entry, ok bool) {
 	return
 }
 
-// Enqueue adds a new url to the queue of songs that should be downloaded
-func (m *Manager) Enqueue(u string) (err error) {
+// downloadRequest is an item in the download queue
+type downloadRequest struct {
+	URL string
+
+	// AllowDuplicates disables the check for songs that sound the same as the downloaded one
+	AllowDuplicates bool
+
+	// ReplaceID is the ID of the song whose audio should be replaced by the download. If empty, a new song is added
+	ReplaceID string
+
+	// PendingID is the ID of a pending download whose files should be added instead of downloading URL
+	PendingID string
+}
+
+// Enqueue adds a new url to the queue of songs that should be downloaded.
+// Unless `allowDuplicates` is set, the download fails if a song with the same audio already exists
+func (m *Manager) Enqueue(u string, allowDuplicates bool) (err error) {
 	parsed, err := url.ParseRequestURI(u)
 	if err == nil {
 		if e, ok := m.hasLink(parsed); ok {
@@ -176,8 +248,12 @@ func (m *Manager) Enqueue(u string) (err error) {
 		u = fmt.Sprintf("ytsearch:%s %q", strings.TrimSpace(u), "auto generated")
 	}
 
+	return m.enqueue(downloadRequest{URL: u, AllowDuplicates: allowDuplicates})
+}
+
+func (m *Manager) enqueue(req downloadRequest) error {
 	select {
-	case m.enqueuedURLs <- u:
+	case m.enqueuedURLs <- req:
 		return nil
 	default:
 		return fmt.Errorf("Cannot enqueue more songs at this time")
@@ -268,10 +344,10 @@ func randSeq(n int) string {
 }
 
 func (m *Manager) serve() {
-	for newURL := range m.enqueuedURLs {
+	for req := range m.enqueuedURLs {
 		m.setIsWorking(true)
 
-		err := m.download(newURL)
+		err := m.download(req)
 		m.lastErr = err
 
 		if err != nil {
@@ -298,6 +374,11 @@ func (m *Manager) setIsWorking(state bool) {
 	}
 }
 
+// QueueLength returns the number of downloads that are waiting for the current one to finish
+func (m *Manager) QueueLength() int {
+	return len(m.enqueuedURLs)
+}
+
 // LastError returns the last error encountered while downloading
 func (m *Manager) LastError() error {
 	return m.lastErr
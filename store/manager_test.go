@@ -10,7 +10,7 @@ import (
 func TestManager_hasLink(t *testing.T) {
 	m := Manager{
 		SongsLock:    new(sync.RWMutex),
-		enqueuedURLs: make(chan string, 25),
+		enqueuedURLs: make(chan downloadRequest, 25),
 
 		Songs: map[string]music.Entry{
 			"id": {
@@ -0,0 +1,116 @@
+package music
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// processingSampleRate is the sample rate audio is resampled to before changing its pitch.
+// We need to know the sample rate for that, and this way we don't have to probe the file first
+const processingSampleRate = 44100
+
+// SpeedFactor returns the playback speed, where 1 is the original speed
+func (a AudioSettings) SpeedFactor() float64 {
+	// Entries from before speed could be set have a speed of 0
+	if a.Speed <= 0 {
+		return 1
+	}
+	return a.Speed
+}
+
+// HasProcessing returns whether the audio needs to be processed by filters.
+// If it doesn't, only the start and end times must be applied
+func (a AudioSettings) HasProcessing() bool {
+	return a.FadeIn > 0 || a.FadeOut > 0 || a.Gain != 0 || a.Mono ||
+		a.SpeedFactor() != 1 || a.Pitch != 0
+}
+
+// Filters returns an ffmpeg audio filter graph that applies all audio settings, including start and end times.
+// `duration` is the duration of the original audio in seconds.
+// If no processing is necessary, an empty string is returned and start and end times should be set as usual
+func (a AudioSettings) Filters(duration float64) string {
+	if !a.HasProcessing() {
+		return ""
+	}
+
+	var filters []string
+
+	start, end := 0.0, duration
+	if a.Start > 0 {
+		start = a.Start
+	}
+	if a.End > 0 && a.End < duration {
+		end = a.End
+	}
+
+	// Trimming is done here instead of using -ss/-to, that way all following filters see the trimmed audio
+	if start > 0 || end < duration {
+		trim := "atrim=start=" + formatSeconds(start)
+		if end < duration {
+			trim += ":end=" + formatSeconds(end)
+		}
+		filters = append(filters, trim, "asetpts=PTS-STARTPTS")
+	}
+
+	length := end - start
+
+	if a.FadeIn > 0 {
+		filters = append(filters, "afade=t=in:st=0:d="+formatSeconds(math.Min(a.FadeIn, length)))
+	}
+	if a.FadeOut > 0 {
+		fadeOut := math.Min(a.FadeOut, length)
+		filters = append(filters, fmt.Sprintf("afade=t=out:st=%s:d=%s", formatSeconds(length-fadeOut), formatSeconds(fadeOut)))
+	}
+
+	if a.Gain != 0 {
+		filters = append(filters, "volume="+strconv.FormatFloat(a.Gain, 'f', -1, 64)+"dB")
+	}
+
+	if a.Mono {
+		filters = append(filters, "aformat=channel_layouts=mono")
+	}
+
+	tempo := a.SpeedFactor()
+
+	if a.Pitch != 0 {
+		// Changing the sample rate changes pitch and tempo, so we have to correct the tempo afterwards
+		ratio := math.Pow(2, a.Pitch/12)
+		filters = append(filters,
+			"aresample="+strconv.Itoa(processingSampleRate),
+			"asetrate="+strconv.FormatFloat(processingSampleRate*ratio, 'f', 0, 64),
+			"aresample="+strconv.Itoa(processingSampleRate),
+		)
+		tempo /= ratio
+	}
+
+	filters = append(filters, atempo(tempo)...)
+
+	return strings.Join(filters, ",")
+}
+
+// atempo returns atempo filters that change the tempo by `factor`.
+// Older versions of ffmpeg only accept factors between 0.5 and 2, so we chain multiple filters if necessary
+func atempo(factor float64) (filters []string) {
+	const epsilon = 0.0001
+
+	for factor > 2 {
+		filters = append(filters, "atempo=2")
+		factor /= 2
+	}
+	for factor < 0.5 {
+		filters = append(filters, "atempo=0.5")
+		factor /= 0.5
+	}
+
+	if math.Abs(factor-1) > epsilon {
+		filters = append(filters, "atempo="+strconv.FormatFloat(factor, 'f', 6, 64))
+	}
+
+	return
+}
+
+func formatSeconds(s float64) string {
+	return strconv.FormatFloat(s, 'f', 3, 64)
+}
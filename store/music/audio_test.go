@@ -0,0 +1,68 @@
+package music
+
+import "testing"
+
+func TestAudioSettings_Filters(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings AudioSettings
+		duration float64
+		want     string
+	}{
+		{
+			name:     "only trimming",
+			settings: AudioSettings{Start: 5, End: 100},
+			duration: 120,
+			want:     "",
+		},
+		{
+			name:     "old entry without speed",
+			settings: AudioSettings{Start: -1, End: -1, Speed: 0},
+			duration: 120,
+			want:     "",
+		},
+		{
+			name:     "fades with trimming",
+			settings: AudioSettings{Start: 10, End: 70, FadeIn: 2, FadeOut: 5},
+			duration: 120,
+			want:     "atrim=start=10.000:end=70.000,asetpts=PTS-STARTPTS,afade=t=in:st=0:d=2.000,afade=t=out:st=55.000:d=5.000",
+		},
+		{
+			name:     "fade longer than song",
+			settings: AudioSettings{Start: -1, End: -1, FadeOut: 30},
+			duration: 20,
+			want:     "afade=t=out:st=0.000:d=20.000",
+		},
+		{
+			name:     "gain and mono",
+			settings: AudioSettings{Start: -1, End: -1, Gain: -3.5, Mono: true},
+			duration: 120,
+			want:     "volume=-3.5dB,aformat=channel_layouts=mono",
+		},
+		{
+			name:     "speed",
+			settings: AudioSettings{Start: -1, End: -1, Speed: 1.25},
+			duration: 120,
+			want:     "atempo=1.250000",
+		},
+		{
+			name:     "pitch an octave up keeps the speed",
+			settings: AudioSettings{Start: -1, End: -1, Pitch: 12},
+			duration: 120,
+			want:     "aresample=44100,asetrate=88200,aresample=44100,atempo=0.500000",
+		},
+		{
+			name:     "pitch up and slower needs chained atempo",
+			settings: AudioSettings{Start: -1, End: -1, Pitch: 12, Speed: 0.5},
+			duration: 120,
+			want:     "aresample=44100,asetrate=88200,aresample=44100,atempo=0.5,atempo=0.500000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.settings.Filters(tt.duration); got != tt.want {
+				t.Errorf("AudioSettings.Filters() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -32,6 +32,17 @@ type Entry struct {
 	// AudioSettings stores settings such as audio start and end time
 	AudioSettings AudioSettings `json:"audio_settings"`
 
+	// TrimSuggestion contains start and end times that were detected by analyzing the audio.
+	// They are kept separate from AudioSettings until they are applied. Might be nil
+	TrimSuggestion *TrimSuggestion `json:"trim_suggestion,omitempty"`
+
+	// Fingerprint describes the acoustic fingerprint of the audio, which is used for finding duplicates.
+	// It is nil if no fingerprint was calculated
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+
+	// AudioInfo describes the codec and quality of the original file. It is nil if the file wasn't probed yet
+	AudioInfo *AudioInfo `json:"audio_info,omitempty"`
+
 	// MusicData describes music metadata that is typically embedded into music files
 	MusicData MusicData `json:"music_data"`
 
@@ -127,6 +138,122 @@ type AudioSettings struct {
 	Start float64 `json:"start"`
 	// Start is the end time of the song in the given audio. If not set, it is < 0
 	End float64 `json:"end"`
+
+	// FadeIn and FadeOut are the durations of fades at the start and end of the song, in seconds
+	FadeIn  float64 `json:"fade_in,omitempty"`
+	FadeOut float64 `json:"fade_out,omitempty"`
+
+	// Gain is a volume adjustment in dB
+	Gain float64 `json:"gain,omitempty"`
+
+	// Mono defines whether the audio should be downmixed to a single channel
+	Mono bool `json:"mono,omitempty"`
+
+	// Speed is the playback speed factor, 1 is the original speed. Use SpeedFactor to read it
+	Speed float64 `json:"speed,omitempty"`
+	// Pitch shifts the audio by this many semitones without changing its speed
+	Pitch float64 `json:"pitch,omitempty"`
+}
+
+// TrimSuggestion describes trim points that were proposed by the silence analysis
+type TrimSuggestion struct {
+	// Start is the suggested start time. If nothing should be cut at the start, it is < 0
+	Start float64 `json:"start"`
+	// End is the suggested end time. If nothing should be cut at the end, it is < 0
+	End float64 `json:"end"`
+
+	// Reason describes why these points were suggested, e.g. "Leading silence"
+	Reason string `json:"reason"`
+
+	Analyzed time.Time `json:"analyzed"`
+}
+
+// Fingerprint describes a file in the song directory that contains the acoustic fingerprint calculated by Chromaprint's fpcalc
+type Fingerprint struct {
+	Filename string `json:"filename"`
+
+	// Duration is the length of the audio in seconds as reported by fpcalc
+	Duration float64 `json:"duration"`
+
+	Calculated time.Time `json:"calculated"`
+}
+
+// FingerprintPath returns the path of the fingerprint file or an empty string if there is none
+func (e *Entry) FingerprintPath() string {
+	if e.Fingerprint == nil {
+		return ""
+	}
+
+	return filepath.Join("data", "songs", e.ID, e.Fingerprint.Filename)
+}
+
+// AudioInfo describes the audio stream of the original file as reported by ffprobe
+type AudioInfo struct {
+	Codec string `json:"codec"`
+	// Bitrate is in kbit/s, it is 0 if ffprobe didn't report it
+	Bitrate    int `json:"bitrate"`
+	SampleRate int `json:"sample_rate"`
+	Channels   int `json:"channels"`
+
+	// Lossless is true for codecs like FLAC or ALAC that don't remove anything from the audio
+	Lossless bool `json:"lossless,omitempty"`
+
+	// Cutoff is the frequency in Hz above which the audio is (almost) silent. Lossy encoders cut off high frequencies,
+	// the lower the bitrate the lower the cutoff. It is 0 if the audio contains all frequencies that were checked
+	Cutoff int `json:"cutoff,omitempty"`
+	// Transcoded is set if the cutoff is lower than expected for the bitrate,
+	// which means the file was probably converted from a source with a lower bitrate
+	Transcoded bool `json:"transcoded,omitempty"`
+
+	// SourceFormat is the format youtube-dl selected for downloading, e.g. "251 - audio only (medium)". Empty for imported songs
+	SourceFormat string `json:"source_format,omitempty"`
+
+	Probed time.Time `json:"probed"`
+}
+
+// String returns a short human-readable description like "opus, 160 kbit/s, 48 kHz, stereo"
+func (a *AudioInfo) String() string {
+	var parts []string
+	if a.Codec != "" {
+		parts = append(parts, a.Codec)
+	}
+	if a.Bitrate > 0 {
+		parts = append(parts, fmt.Sprintf("%d kbit/s", a.Bitrate))
+	}
+	if a.SampleRate > 0 {
+		parts = append(parts, strings.TrimSuffix(fmt.Sprintf("%.1f", float64(a.SampleRate)/1000), ".0")+" kHz")
+	}
+	switch a.Channels {
+	case 0:
+	case 1:
+		parts = append(parts, "mono")
+	case 2:
+		parts = append(parts, "stereo")
+	default:
+		parts = append(parts, fmt.Sprintf("%d channels", a.Channels))
+	}
+	if a.Cutoff > 0 {
+		parts = append(parts, fmt.Sprintf("cutoff at %d kHz", a.Cutoff/1000))
+	}
+	if a.Transcoded {
+		parts = append(parts, "probably transcoded from a lower bitrate")
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+// Bitrate returns the bitrate of the original audio file in kbit/s. If the file wasn't probed,
+// the average bitrate is calculated from its size and duration
+func (e *Entry) Bitrate() int {
+	if e.AudioInfo != nil && e.AudioInfo.Bitrate > 0 {
+		return e.AudioInfo.Bitrate
+	}
+
+	if e.MusicData.Duration <= 0 {
+		return 0
+	}
+
+	return int(float64(e.FileData.Size) * 8 / e.MusicData.Duration / 1000)
 }
 
 // TimeRange returns the playback range for HTML media elements
@@ -152,12 +279,37 @@ func (e *Entry) PlaybackRange() string {
 	return fmt.Sprintf("#t=%f,%f", e.AudioSettings.Start, e.AudioSettings.End)
 }
 
+// PlayDuration returns the duration of the song after trimming and speed adjustments in seconds
+func (e *Entry) PlayDuration() float64 {
+	start, end := e.AudioSettings.Start, e.AudioSettings.End
+	if start < 0 {
+		start = 0
+	}
+	if end <= 0 || end > e.MusicData.Duration {
+		end = e.MusicData.Duration
+	}
+	if end < start {
+		return 0
+	}
+
+	return (end - start) / e.AudioSettings.SpeedFactor()
+}
+
 type MusicData struct {
 	Title  string `json:"title"`
 	Artist string `json:"artist"`
 	Album  string `json:"album"`
 	Year   *int   `json:"year,omitempty"` // optional, may be nil
 
+	Genre string `json:"genre,omitempty"`
+
+	// Track and Disc are the position of the song in its album, 0 means unknown
+	Track int `json:"track,omitempty"`
+	Disc  int `json:"disc,omitempty"`
+
+	// Rating is the users' rating from 1 to 5 stars, 0 means unrated
+	Rating int `json:"rating,omitempty"`
+
 	// Duration is the duration of the original file in seconds
 	Duration float64 `json:"duration"`
 }
@@ -0,0 +1,450 @@
+package music
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"log"
+	"mime"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/store/file"
+
+	"github.com/nfnt/resize"
+	"golang.org/x/sync/singleflight"
+)
+
+var transcodeGroup singleflight.Group
+
+// MP3Path returns the path for an mp3 file for this song. This might take some time
+func (e *Entry) MP3Path(cfg config.Config) (p string, err error) {
+	return e.ProfilePath(cfg, "")
+}
+
+// ProfilePath returns the path of this song transcoded with the profile `profileName` from the config.
+// The empty name is the default mp3 profile. The file is cached until the song or the profile settings are edited.
+// This might take some time
+func (e *Entry) ProfilePath(cfg config.Config, profileName string) (p string, err error) {
+	profile, ok := cfg.Profile(profileName)
+	if !ok {
+		return "", fmt.Errorf("Cannot transcode song with profile %q as it doesn't exist", profileName)
+	}
+
+	ap, err := filepath.Abs(e.AudioPath())
+	if err != nil {
+		return
+	}
+
+	ext := profile.Extension(filepath.Ext(ap))
+	outName, _ := e.GeneratedPath(cfg, profileName)
+
+	// Re-create this file if it doesn't exist or doesn't have the latest details
+	if _, ok := e.generatedInfo(outName); ok {
+		return outName, nil
+	}
+
+	_, err, _ = transcodeGroup.Do(e.ID+"/"+profileName, func() (res interface{}, err error) {
+		defer runtime.GC()
+		defer transcodeGroup.Forget(e.ID + "/" + profileName)
+
+		td, err := ioutil.TempDir("", "sh-transcode")
+		if err != nil {
+			return
+		}
+		defer os.RemoveAll(td)
+
+		tempAudio := filepath.Join(td, "temp."+ext)
+
+		var outbuf bytes.Buffer
+
+		cmd := exec.Command(cfg.Alternatives.FFmpeg, "-y", "-i", ap)
+		cmd.Args = append(cmd.Args, "-map", "0:a")
+
+		// If we have a cover image, we add it
+		if mode := coverMode(ext); e.PictureData.Filename != "" && profile.Cover.Format != "none" && mode != "" {
+			cover, w, h, err := e.encodeCover(cfg, profile)
+			if err != nil {
+				log.Println("error while encoding cover image:", err.Error())
+			}
+
+			switch {
+			case len(cover) == 0:
+				// Just don't add a cover
+			case mode == "stream":
+				// Use the buffer as cover input (stdin) and tell ffmpeg what kind of image stream it is
+				cmd.Args = append(cmd.Args, "-probesize", "64M", "-f", profile.Cover.Format+"_pipe", "-i", "-")
+				cmd.Stdin = bytes.NewReader(cover)
+
+				cmd.Args = append(cmd.Args, "-map", "1", "-c:v", "copy", "-disposition:v", "attached_pic")
+
+				// Make sure the image stream is recognized as cover image
+				cmd.Args = append(cmd.Args,
+					"-metadata:s:v", "title=Front cover",
+					"-metadata:s:v", "comment=Cover (front)")
+
+				cmd.Args = append(cmd.Args, "-flush_packets", "0")
+			case mode == "tag":
+				// Ogg files store covers as a base64-encoded FLAC picture block in a tag.
+				// It's too long for a command-line argument, so we pass it as metadata file
+				metaPath := filepath.Join(td, "meta.txt")
+				err = ioutil.WriteFile(metaPath, []byte(ffmetadata(map[string]string{
+					"METADATA_BLOCK_PICTURE": base64.StdEncoding.EncodeToString(pictureBlock(cover, "image/"+profile.Cover.Format, w, h)),
+				})), 0644)
+				if err != nil {
+					return nil, err
+				}
+
+				cmd.Args = append(cmd.Args, "-f", "ffmetadata", "-i", metaPath, "-map_metadata", "1")
+			}
+		}
+
+		filters := e.AudioSettings.Filters(e.MusicData.Duration)
+		if filters != "" {
+			// The filters also take care of start and end times. Since the audio is
+			// processed, the stream can never be copied
+			cmd.Args = append(cmd.Args, "-af", filters)
+		} else {
+			// set start and end time depending on audio settings
+			if e.AudioSettings.Start != -1 {
+				cmd.Args = append(cmd.Args, "-ss", strconv.FormatFloat(e.AudioSettings.Start, 'f', 3, 64))
+			}
+			if e.AudioSettings.End != -1 {
+				cmd.Args = append(cmd.Args, "-to", strconv.FormatFloat(e.AudioSettings.End, 'f', 3, 64))
+			}
+		}
+
+		cmd.Args = append(cmd.Args, codecArgs(profile, filepath.Ext(ap), filters != "")...)
+
+		// now set all kinds of metadata, e.g. like key="something"
+		if have(&e.MusicData.Title) {
+			cmd.Args = append(cmd.Args, "-metadata", "title="+e.MusicData.Title)
+		}
+		if have(&e.MusicData.Album) {
+			cmd.Args = append(cmd.Args, "-metadata", "album="+e.MusicData.Album)
+		}
+		if have(&e.MusicData.Artist) {
+			cmd.Args = append(cmd.Args, "-metadata", "artist="+e.MusicData.Artist)
+		}
+		if e.MusicData.Year != nil {
+			cmd.Args = append(cmd.Args, "-metadata", "date="+strconv.Itoa(*e.MusicData.Year))
+		}
+		if have(&e.MusicData.Genre) {
+			cmd.Args = append(cmd.Args, "-metadata", "genre="+e.MusicData.Genre)
+		}
+		if e.MusicData.Track > 0 {
+			cmd.Args = append(cmd.Args, "-metadata", "track="+strconv.Itoa(e.MusicData.Track))
+		}
+		if e.MusicData.Disc > 0 {
+			cmd.Args = append(cmd.Args, "-metadata", "disc="+strconv.Itoa(e.MusicData.Disc))
+		}
+
+		cmd.Args = append(cmd.Args,
+			"-hide_banner", // don't show the ffmpeg banner, it's unnecessary noise for potential error output
+
+			// Write everything to tempAudio
+			tempAudio)
+
+		cmd.Stderr = os.Stderr // &outbuf // Collect error output in case we need it for error logging
+
+		// Generate / Convert audio file
+		err = cmd.Run()
+		if err != nil {
+			err = fmt.Errorf("%s\n\nStderr:%s", err.Error(), outbuf.String())
+			log.Println("Error while running ffmpeg for audio generation:", err.Error())
+			return
+		}
+		// if everything goes right, we can now move it to its destination
+		err = file.Move(tempAudio, outName)
+		if err != nil {
+			return
+		}
+
+		// Files generated with earlier settings are never served again
+		for _, p := range e.OutdatedGenerated(cfg) {
+			_ = os.Remove(p)
+		}
+
+		return outName, nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return outName, nil
+}
+
+// GeneratedSize returns the size of the file generated with the profile `profileName`.
+// `ok` is false if it wasn't generated yet or is outdated
+func (e *Entry) GeneratedSize(cfg config.Config, profileName string) (size int64, ok bool) {
+	p, ok := e.GeneratedPath(cfg, profileName)
+	if !ok {
+		return 0, false
+	}
+
+	fi, ok := e.generatedInfo(p)
+	if !ok {
+		return 0, false
+	}
+
+	return fi.Size(), true
+}
+
+// GeneratedPath returns the path of the file generated with the profile `profileName`, it might not exist yet.
+// The name contains a hash of the profile settings, that way files are generated again when the settings are changed.
+// `ok` is false if the profile doesn't exist
+func (e *Entry) GeneratedPath(cfg config.Config, profileName string) (p string, ok bool) {
+	profile, ok := cfg.Profile(profileName)
+	if !ok {
+		return "", false
+	}
+
+	ext := profile.Extension(filepath.Ext(e.FileData.Filename))
+	return filepath.Join("data", "songs", e.ID, generatedName(profileName, settingsHash(cfg, profile), ext)), true
+}
+
+// OutdatedGenerated returns the paths of files generated for this song with profile settings that changed since
+// or with profiles that no longer exist
+func (e *Entry) OutdatedGenerated(cfg config.Config) (paths []string) {
+	generated, _ := filepath.Glob(filepath.Join(e.DirPath(), "latest*"))
+
+	for _, p := range generated {
+		current, ok := e.GeneratedPath(cfg, GeneratedProfile(filepath.Base(p)))
+		if !ok || current != p {
+			paths = append(paths, p)
+		}
+	}
+
+	return
+}
+
+// settingsHash returns a short hash of all settings that change the files generated with `profile`
+func settingsHash(cfg config.Config, profile config.Profile) string {
+	if profile.Cover.MaxSize <= 0 {
+		profile.Cover.MaxSize = cfg.Cover.MaxSize
+	}
+
+	b, _ := json.Marshal(profile)
+	sum := sha256.Sum256(b)
+
+	return hex.EncodeToString(sum[:settingsHashLen/2])
+}
+
+// settingsHashLen is the length of the hash returned by settingsHash
+const settingsHashLen = 8
+
+// generatedInfo returns information about the generated file at `p`. `ok` is false if it doesn't exist
+// or was generated before the song was last edited
+func (e *Entry) generatedInfo(p string) (fi os.FileInfo, ok bool) {
+	fi, err := os.Stat(p)
+	if err != nil || !fi.ModTime().After(e.LastEdit) {
+		return nil, false
+	}
+
+	return fi, true
+}
+
+// generatedName returns the filename of a file generated with the given profile and settings hash,
+// e.g. "latest.phone.0a1b2c3d.opus" or "latest.0a1b2c3d.mp3" for the default profile
+func generatedName(profileName, hash, ext string) string {
+	if profileName == "" {
+		return "latest." + hash + "." + ext
+	}
+	return "latest." + profileName + "." + hash + "." + ext
+}
+
+// GeneratedProfile returns the name of the profile a file named by generatedName was generated with.
+// It also understands names from before they contained a hash, like "latest.mp3" and "latest.phone.opus"
+func GeneratedProfile(filename string) string {
+	name := strings.TrimSuffix(filename, filepath.Ext(filename))
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "latest"), ".")
+
+	if profile, _, ok := strings.Cut(name, "."); ok {
+		return profile
+	}
+
+	if isSettingsHash(name) {
+		return ""
+	}
+	return name
+}
+
+func isSettingsHash(s string) bool {
+	if len(s) != settingsHashLen {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// audioContentTypes contains content types for audio files that are not always known to the mime package
+var audioContentTypes = map[string]string{
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".opus": "audio/ogg",
+	".ogg":  "audio/ogg",
+	".flac": "audio/flac",
+}
+
+// ContentType returns the content type for an audio file with the extension `ext`, e.g. ".mp3".
+// If it is not known, an empty string is returned
+func ContentType(ext string) string {
+	contentType, ok := audioContentTypes[strings.ToLower(ext)]
+	if !ok {
+		contentType = mime.TypeByExtension(ext)
+	}
+	return contentType
+}
+
+// codecArgs returns the ffmpeg output arguments for encoding audio with profile `p`.
+// `sourceExt` is the extension of the original audio file, `processed` whether audio filters are applied
+func codecArgs(p config.Profile, sourceExt string, processed bool) (args []string) {
+	var encoder string
+	switch p.Codec {
+	case "copy":
+		// Processed audio must be encoded, ffmpeg chooses the default encoder for the container then
+		if !processed {
+			return []string{"-c:a", "copy"}
+		}
+	case "mp3":
+		// An mp3 stream can be kept as long as we don't want to change anything about it
+		if !processed && strings.EqualFold(sourceExt, ".mp3") && p.Bitrate == 0 && p.Quality == nil && p.SampleRate == 0 {
+			args = append(args, "-c:a", "copy")
+		} else {
+			encoder = "libmp3lame"
+		}
+	case "aac":
+		encoder = "aac"
+	case "opus":
+		encoder = "libopus"
+	case "flac":
+		encoder = "flac"
+	}
+
+	if encoder != "" {
+		args = append(args, "-c:a", encoder)
+
+		// FLAC is lossless, bitrates don't make sense
+		if p.Codec != "flac" {
+			if p.Bitrate > 0 {
+				args = append(args, "-b:a", strconv.Itoa(p.Bitrate)+"k")
+			} else if p.Quality != nil {
+				// Profiles are validated, so this is never an opus profile
+				args = append(args, "-q:a", strconv.FormatFloat(*p.Quality, 'f', -1, 64))
+			}
+		}
+
+		if p.SampleRate > 0 {
+			args = append(args, "-ar", strconv.Itoa(p.SampleRate))
+		}
+	}
+
+	if p.Extension(sourceExt) == "mp3" {
+		args = append(args, "-id3v2_version", strconv.Itoa(p.TagVersion), "-write_id3v1", "1",
+			// Don't write a duration header to the output file.
+			// This causes media players to display the correct duration.
+			// https://superuser.com/questions/607703/wrong-audio-duration-with-ffmpeg
+			"-write_xing", "0")
+	}
+
+	return
+}
+
+// coverMode returns how a cover image is embedded in files with the given extension:
+// "stream" for an attached picture stream, "tag" for a picture tag or "" if we cannot add covers
+func coverMode(ext string) string {
+	switch ext {
+	case "mp3", "m4a", "mp4", "flac":
+		return "stream"
+	case "opus", "ogg":
+		return "tag"
+	default:
+		return ""
+	}
+}
+
+// encodeCover returns the cover image of this song in the format of the profile, resized to its max size
+func (e *Entry) encodeCover(cfg config.Config, p config.Profile) (cover []byte, width, height int, err error) {
+	b, err := ioutil.ReadFile(e.CoverPath())
+	if err != nil {
+		return
+	}
+
+	coverImg, _, err := image.Decode(bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+
+	maxImageSize := p.Cover.MaxSize
+	if maxImageSize <= 0 {
+		maxImageSize = cfg.Cover.MaxSize
+	}
+	if maxImageSize > 0 && coverImg.Bounds().Dx() > maxImageSize {
+		coverImg = resize.Resize(uint(maxImageSize), 0, coverImg, resize.MitchellNetravali)
+	}
+
+	var coverBuf bytes.Buffer
+	if p.Cover.Format == "png" {
+		err = png.Encode(&coverBuf, coverImg)
+	} else {
+		// There are *some* audio players that support *only* JPEG, which is why it's the default
+		err = jpeg.Encode(&coverBuf, coverImg, &jpeg.Options{Quality: 100})
+	}
+	if err != nil {
+		return
+	}
+
+	return coverBuf.Bytes(), coverImg.Bounds().Dx(), coverImg.Bounds().Dy(), nil
+}
+
+// pictureBlock returns a FLAC picture metadata block for a front cover image.
+// See https://xiph.org/flac/format.html#metadata_block_picture
+func pictureBlock(img []byte, mime string, width, height int) []byte {
+	var b bytes.Buffer
+
+	write := func(v int) {
+		_ = binary.Write(&b, binary.BigEndian, uint32(v))
+	}
+
+	write(3) // Picture type: front cover
+	write(len(mime))
+	b.WriteString(mime)
+	write(0) // No description
+	write(width)
+	write(height)
+	write(24) // Color depth
+	write(0)  // Not an indexed image
+	write(len(img))
+	b.Write(img)
+
+	return b.Bytes()
+}
+
+// ffmetadata returns an ffmpeg metadata file containing the given tags
+func ffmetadata(tags map[string]string) string {
+	escape := strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n")
+
+	var sb strings.Builder
+	sb.WriteString(";FFMETADATA1\n")
+	for k, v := range tags {
+		sb.WriteString(escape.Replace(k) + "=" + escape.Replace(v) + "\n")
+	}
+
+	return sb.String()
+}
+
+func have(s *string) bool {
+	return strings.TrimSpace(*s) != ""
+}
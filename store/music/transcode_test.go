@@ -0,0 +1,200 @@
+package music
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+	"xarantolus/sensibleHub/store/config"
+)
+
+func Test_codecArgs(t *testing.T) {
+	quality := 2.0
+
+	tests := []struct {
+		name      string
+		profile   config.Profile
+		sourceExt string
+		processed bool
+		want      []string
+	}{
+		{
+			name:      "default mp3 keeps mp3 stream",
+			profile:   config.Profile{Codec: "mp3", TagVersion: 3},
+			sourceExt: ".MP3",
+			want:      []string{"-c:a", "copy", "-id3v2_version", "3", "-write_id3v1", "1", "-write_xing", "0"},
+		},
+		{
+			name:      "processed mp3 is encoded",
+			profile:   config.Profile{Codec: "mp3", TagVersion: 4},
+			sourceExt: ".mp3",
+			processed: true,
+			want:      []string{"-c:a", "libmp3lame", "-id3v2_version", "4", "-write_id3v1", "1", "-write_xing", "0"},
+		},
+		{
+			name:      "mp3 with constant bitrate",
+			profile:   config.Profile{Codec: "mp3", Bitrate: 192, SampleRate: 44100, TagVersion: 3},
+			sourceExt: ".m4a",
+			want:      []string{"-c:a", "libmp3lame", "-b:a", "192k", "-ar", "44100", "-id3v2_version", "3", "-write_id3v1", "1", "-write_xing", "0"},
+		},
+		{
+			name:      "aac with quality",
+			profile:   config.Profile{Codec: "aac", Quality: &quality},
+			sourceExt: ".webm",
+			want:      []string{"-c:a", "aac", "-q:a", "2"},
+		},
+		{
+			name:      "opus",
+			profile:   config.Profile{Codec: "opus", Bitrate: 96},
+			sourceExt: ".webm",
+			want:      []string{"-c:a", "libopus", "-b:a", "96k"},
+		},
+		{
+			name:      "flac ignores bitrate",
+			profile:   config.Profile{Codec: "flac", Bitrate: 320},
+			sourceExt: ".wav",
+			want:      []string{"-c:a", "flac"},
+		},
+		{
+			name:      "copy",
+			profile:   config.Profile{Codec: "copy"},
+			sourceExt: ".webm",
+			want:      []string{"-c:a", "copy"},
+		},
+		{
+			name:      "copy with processing uses default encoder",
+			profile:   config.Profile{Codec: "copy"},
+			sourceExt: ".webm",
+			processed: true,
+			want:      nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := codecArgs(tt.profile, tt.sourceExt, tt.processed); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("codecArgs() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratedProfile(t *testing.T) {
+	for _, profile := range []string{"", "phone", "car"} {
+		for _, ext := range []string{"mp3", "opus", "m4a"} {
+			name := generatedName(profile, "0a1b2c3d", ext)
+			if got := GeneratedProfile(name); got != profile {
+				t.Errorf("GeneratedProfile(%q) = %q, want %q", name, got, profile)
+			}
+		}
+	}
+
+	// Files generated before names contained a hash
+	for name, profile := range map[string]string{"latest.mp3": "", "latest.phone.opus": "phone"} {
+		if got := GeneratedProfile(name); got != profile {
+			t.Errorf("GeneratedProfile(%q) = %q, want %q", name, got, profile)
+		}
+	}
+}
+
+func TestEntry_GeneratedPath(t *testing.T) {
+	var cfg config.Config
+	cfg.Profiles = map[string]config.Profile{"phone": {Codec: "opus", Bitrate: 96}}
+
+	e := Entry{ID: "a", FileData: FileData{Filename: "a.m4a"}}
+
+	before, _ := e.GeneratedPath(cfg, "phone")
+
+	cfg.Profiles["phone"] = config.Profile{Codec: "opus", Bitrate: 128}
+	after, ok := e.GeneratedPath(cfg, "phone")
+	if !ok || after == before || filepath.Ext(after) != ".opus" {
+		t.Errorf("changing the bitrate should change the path, got %q and %q", before, after)
+	}
+
+	if _, ok := e.GeneratedPath(cfg, "missing"); ok {
+		t.Errorf("expected no path for a profile that doesn't exist")
+	}
+}
+
+func TestEntry_GeneratedSize(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	var cfg config.Config
+	e := Entry{ID: "a", FileData: FileData{Filename: "a.m4a", Size: 1000}, LastEdit: time.Now().Add(-time.Hour)}
+
+	if _, ok := e.GeneratedSize(cfg, ""); ok {
+		t.Errorf("expected no size before the file was generated")
+	}
+
+	err = os.MkdirAll(e.DirPath(), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := e.GeneratedPath(cfg, "")
+	err = os.WriteFile(p, []byte("audio"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if size, ok := e.GeneratedSize(cfg, ""); !ok || size != 5 {
+		t.Errorf("GeneratedSize() = %d, %v, want 5, true", size, ok)
+	}
+
+	// Files generated before the last edit are outdated
+	e.LastEdit = time.Now().Add(time.Hour)
+	if _, ok := e.GeneratedSize(cfg, ""); ok {
+		t.Errorf("expected no size for an outdated file")
+	}
+}
+
+func TestEntry_OutdatedGenerated(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	var cfg config.Config
+	cfg.Profiles = map[string]config.Profile{"phone": {Codec: "opus", Bitrate: 96}}
+
+	e := Entry{ID: "a", FileData: FileData{Filename: "a.m4a"}}
+	err = os.MkdirAll(e.DirPath(), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ := e.GeneratedPath(cfg, "phone")
+	mp3, _ := e.GeneratedPath(cfg, "")
+	old := filepath.Join(e.DirPath(), "latest.phone.0a1b2c3d.opus")
+	legacy := filepath.Join(e.DirPath(), "latest.phone.opus")
+	removed := filepath.Join(e.DirPath(), "latest.car.0a1b2c3d.mp3")
+
+	for _, p := range []string{current, mp3, old, legacy, removed, e.AudioPath()} {
+		err = os.WriteFile(p, []byte("audio"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got := e.OutdatedGenerated(cfg)
+	sort.Strings(got)
+	want := []string{removed, old, legacy}
+	sort.Strings(want)
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("OutdatedGenerated() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,426 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"xarantolus/sensibleHub/store/music"
+)
+
+// minNameSimilarity is the similarity two different name keys must at least have to be listed as near-duplicates
+const minNameSimilarity = 0.8
+
+// NameAlias maps a spelling of an artist or album to the name it was merged into.
+// Songs with that spelling that are added later get the new name
+type NameAlias struct {
+	// Key identifies the alias, it is built from the normalized old spelling
+	Key string `json:"key"`
+
+	// Artist and Album are the old spelling, artist aliases don't have an album
+	Artist string `json:"artist"`
+	Album  string `json:"album,omitempty"`
+
+	NewArtist string `json:"new_artist"`
+	NewAlbum  string `json:"new_album,omitempty"`
+
+	Created time.Time `json:"created"`
+}
+
+// AlbumName identifies an album by its artist and title
+type AlbumName struct {
+	Artist string `json:"artist"`
+	Album  string `json:"album"`
+}
+
+// NameCount is a spelling of an artist or album and the number of songs that use it
+type NameCount struct {
+	Artist string `json:"artist"`
+	Album  string `json:"album,omitempty"`
+
+	Songs int `json:"songs"`
+}
+
+// NameGroup contains spellings of an artist or album that are probably the same.
+// Similarity is 1 if the names only differ in case, spaces or punctuation
+type NameGroup struct {
+	Similarity float64     `json:"similarity"`
+	Names      []NameCount `json:"names"`
+}
+
+// nameKey normalizes an artist or album name, "The Beatles", "Beatles" and "BEATLES!" have the same key
+func nameKey(name string) string {
+	key := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, fold(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "THE ")))
+
+	return strings.ToLower(key)
+}
+
+func artistAliasKey(artist string) string {
+	return "artist:" + nameKey(artist)
+}
+
+func albumAliasKey(artist, album string) string {
+	return "album:" + nameKey(artist) + ":" + nameKey(album)
+}
+
+// nameSimilarity returns how similar two name keys are, 1 means they are equal
+func nameSimilarity(a, b string) float64 {
+	if a == b {
+		return 1
+	}
+
+	la, lb := len([]rune(a)), len([]rune(b))
+	// Short names like "Muse" and "Mase" are often different artists
+	if min(la, lb) < 4 {
+		return 0
+	}
+
+	// The edit distance is at least the difference in length, this skips most comparisons
+	if 1-float64(max(la, lb)-min(la, lb))/float64(max(la, lb)) < minNameSimilarity {
+		return 0
+	}
+
+	return 1 - float64(editDistance(a, b))/float64(max(la, lb))
+}
+
+// groupNames groups the spellings in `names` by their keys `key(name)`, keys that are similar enough are in the same group.
+// Only groups with at least two spellings are returned
+func groupNames(names []NameCount, key func(NameCount) string) (groups []NameGroup) {
+	var (
+		keys    []string
+		byKey   = make(map[string][]NameCount)
+		parents = make(map[string]string)
+		minSim  = make(map[string]float64)
+	)
+	for _, n := range names {
+		k := key(n)
+		if _, ok := byKey[k]; !ok {
+			keys = append(keys, k)
+			parents[k] = k
+			minSim[k] = 1
+		}
+		byKey[k] = append(byKey[k], n)
+	}
+
+	var find func(k string) string
+	find = func(k string) string {
+		if parents[k] != k {
+			parents[k] = find(parents[k])
+		}
+		return parents[k]
+	}
+
+	for i := range keys {
+		for j := i + 1; j < len(keys); j++ {
+			sim := nameSimilarity(keys[i], keys[j])
+			if sim < minNameSimilarity {
+				continue
+			}
+
+			ri, rj := find(keys[i]), find(keys[j])
+			if ri != rj {
+				parents[rj] = ri
+				minSim[ri] = min(minSim[ri], minSim[rj])
+			}
+			minSim[ri] = min(minSim[ri], sim)
+		}
+	}
+
+	var grouped = make(map[string]*NameGroup)
+	for _, k := range keys {
+		root := find(k)
+		g, ok := grouped[root]
+		if !ok {
+			g = &NameGroup{Similarity: 1}
+			grouped[root] = g
+		}
+		g.Names = append(g.Names, byKey[k]...)
+	}
+	for root, g := range grouped {
+		if len(g.Names) < 2 {
+			continue
+		}
+		g.Similarity = minSim[root]
+
+		// The most used spelling is first, it is usually the one that should be kept
+		sort.SliceStable(g.Names, func(i, j int) bool {
+			if g.Names[i].Songs != g.Names[j].Songs {
+				return g.Names[i].Songs > g.Names[j].Songs
+			}
+			return g.Names[i].Artist+g.Names[i].Album < g.Names[j].Artist+g.Names[j].Album
+		})
+		groups = append(groups, *g)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := groups[i].Names[0], groups[j].Names[0]
+		if !strings.EqualFold(a.Artist, b.Artist) {
+			return strings.ToUpper(a.Artist) < strings.ToUpper(b.Artist)
+		}
+		return strings.ToUpper(a.Album) < strings.ToUpper(b.Album)
+	})
+
+	return
+}
+
+// SimilarNames returns spellings of artists and albums that probably mean the same artist or album.
+// Albums are only compared to other albums of artists with the same key
+func (m *Manager) SimilarNames() (artists, albums []NameGroup) {
+	artistCounts := make(map[string]int)
+	albumCounts := make(map[AlbumName]int)
+	for _, e := range m.AllEntries() {
+		artist, album := strings.TrimSpace(e.MusicData.Artist), strings.TrimSpace(e.MusicData.Album)
+		if artist != "" {
+			artistCounts[artist]++
+		}
+		if album != "" {
+			albumCounts[AlbumName{artist, album}]++
+		}
+	}
+
+	var names []NameCount
+	for artist, n := range artistCounts {
+		names = append(names, NameCount{Artist: artist, Songs: n})
+	}
+	artists = groupNames(names, func(n NameCount) string { return nameKey(n.Artist) })
+
+	// Albums of different artists may have the same name, e.g. "Greatest Hits"
+	byArtist := make(map[string][]NameCount)
+	for a, n := range albumCounts {
+		k := nameKey(a.Artist)
+		byArtist[k] = append(byArtist[k], NameCount{Artist: a.Artist, Album: a.Album, Songs: n})
+	}
+	for _, names := range byArtist {
+		albums = append(albums, groupNames(names, func(n NameCount) string { return nameKey(n.Album) })...)
+	}
+	sort.Slice(albums, func(i, j int) bool {
+		a, b := albums[i].Names[0], albums[j].Names[0]
+		if !strings.EqualFold(a.Artist, b.Artist) {
+			return strings.ToUpper(a.Artist) < strings.ToUpper(b.Artist)
+		}
+		return strings.ToUpper(a.Album) < strings.ToUpper(b.Album)
+	})
+
+	return
+}
+
+func (a NameAlias) oldKey() string {
+	if a.Album == "" {
+		return artistAliasKey(a.Artist)
+	}
+	return albumAliasKey(a.Artist, a.Album)
+}
+
+func (a NameAlias) newKey() string {
+	if a.Album == "" {
+		return artistAliasKey(a.NewArtist)
+	}
+	return albumAliasKey(a.NewArtist, a.NewAlbum)
+}
+
+// addAlias remembers that `alias` should be renamed to its new name.
+// Aliases that pointed to the old name are changed to the new one, an alias for the new name is removed.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) addAlias(alias NameAlias) {
+	if m.NameAliases == nil {
+		m.NameAliases = make(map[string]NameAlias)
+	}
+
+	alias.Key = alias.oldKey()
+	newKey := alias.newKey()
+
+	for k, a := range m.NameAliases {
+		if k == newKey && (a.NewArtist != alias.NewArtist || a.NewAlbum != alias.NewAlbum) {
+			delete(m.NameAliases, k)
+			continue
+		}
+
+		// Chains like "Qeen" -> "Queen " -> "Queen" are shortened
+		if a.newKey() == alias.Key {
+			a.NewArtist, a.NewAlbum = alias.NewArtist, alias.NewAlbum
+			m.NameAliases[k] = a
+		}
+	}
+
+	m.NameAliases[alias.Key] = alias
+}
+
+// applyAliases renames the artist and album of `e` if they have an alias.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) applyAliases(e *music.Entry) {
+	if len(m.NameAliases) == 0 {
+		return
+	}
+
+	applyAlbum := func() {
+		if e.MusicData.Album == "" {
+			return
+		}
+		if a, ok := m.NameAliases[albumAliasKey(e.MusicData.Artist, e.MusicData.Album)]; ok {
+			e.MusicData.Artist, e.MusicData.Album = a.NewArtist, a.NewAlbum
+		}
+	}
+
+	applyAlbum()
+
+	if a, ok := m.NameAliases[artistAliasKey(e.MusicData.Artist)]; ok && e.MusicData.Artist != "" {
+		e.MusicData.Artist = a.NewArtist
+
+		// The album might have been merged after the artist was renamed
+		applyAlbum()
+	}
+}
+
+// Aliases returns all remembered spellings of artists and albums, sorted by their old names
+func (m *Manager) Aliases() (aliases []NameAlias) {
+	m.SongsLock.RLock()
+	defer m.SongsLock.RUnlock()
+
+	aliases = make([]NameAlias, 0, len(m.NameAliases))
+	for _, a := range m.NameAliases {
+		aliases = append(aliases, a)
+	}
+
+	sort.Slice(aliases, func(i, j int) bool {
+		if !strings.EqualFold(aliases[i].Artist, aliases[j].Artist) {
+			return strings.ToUpper(aliases[i].Artist) < strings.ToUpper(aliases[j].Artist)
+		}
+		return strings.ToUpper(aliases[i].Album) < strings.ToUpper(aliases[j].Album)
+	})
+
+	return
+}
+
+// RemoveAlias forgets the alias with the given key, songs that were already renamed keep their name
+func (m *Manager) RemoveAlias(key string) (err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	if _, ok := m.NameAliases[key]; !ok {
+		return fmt.Errorf("Cannot remove alias %s as it doesn't exist", key)
+	}
+
+	delete(m.NameAliases, key)
+
+	return m.Save(false)
+}
+
+// renameSongs sets the artist and album of all songs for which `match` returns true. For every spelling of a matched song,
+// an alias is added. The changed songs are sent in a "songs-edit" event.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) renameSongs(match func(e music.Entry) bool, rename func(e *music.Entry), alias func(e music.Entry) NameAlias) (edited []music.Entry, err error) {
+	var (
+		now     = time.Now()
+		aliases = make(map[NameAlias]bool)
+	)
+
+	for id, e := range m.Songs {
+		if !match(e) {
+			continue
+		}
+
+		if a := alias(e); a.Artist != a.NewArtist || a.Album != a.NewAlbum {
+			aliases[a] = true
+		}
+
+		before := e
+		rename(&e)
+		if e == before {
+			continue
+		}
+
+		e.LastEdit = now
+		m.Songs[id] = e
+		m.indexEntry(e)
+		edited = append(edited, e)
+	}
+
+	if len(edited) == 0 && len(aliases) == 0 {
+		return nil, nil
+	}
+
+	for a := range aliases {
+		a.Created = now
+		m.addAlias(a)
+	}
+
+	err = m.Save(false)
+	if err != nil {
+		return
+	}
+
+	if len(edited) > 0 {
+		m.event("songs-edit", map[string]interface{}{
+			"songs":   edited,
+			"deleted": []string{},
+		})
+	}
+
+	return
+}
+
+// MergeArtists renames all songs with an artist that has the same key as one of `names` to `newName`.
+// The old spellings are remembered, songs that are added later with them get the new name
+func (m *Manager) MergeArtists(names []string, newName string) (edited []music.Entry, err error) {
+	newName = strings.TrimSpace(newName)
+	if newName == "" {
+		return nil, fmt.Errorf("An artist needs a name")
+	}
+
+	keys := make(map[string]bool, len(names))
+	for _, n := range names {
+		if k := nameKey(n); k != "" {
+			keys[k] = true
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Need at least one artist to rename")
+	}
+
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	return m.renameSongs(func(e music.Entry) bool {
+		return keys[nameKey(e.MusicData.Artist)]
+	}, func(e *music.Entry) {
+		e.MusicData.Artist = newName
+	}, func(e music.Entry) NameAlias {
+		return NameAlias{Artist: e.MusicData.Artist, NewArtist: newName}
+	})
+}
+
+// MergeAlbums sets the artist and album name of all songs in `albums` to `newArtist` and `newAlbum`.
+// The old spellings are remembered, songs that are added later with them get the new names
+func (m *Manager) MergeAlbums(albums []AlbumName, newArtist, newAlbum string) (edited []music.Entry, err error) {
+	newArtist, newAlbum = strings.TrimSpace(newArtist), strings.TrimSpace(newAlbum)
+	if newArtist == "" || newAlbum == "" {
+		return nil, fmt.Errorf("An album needs an artist and a name")
+	}
+
+	keys := make(map[string]bool, len(albums))
+	for _, a := range albums {
+		if nameKey(a.Album) != "" {
+			keys[albumAliasKey(a.Artist, a.Album)] = true
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("Need at least one album to rename")
+	}
+
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	return m.renameSongs(func(e music.Entry) bool {
+		return e.MusicData.Album != "" && keys[albumAliasKey(e.MusicData.Artist, e.MusicData.Album)]
+	}, func(e *music.Entry) {
+		e.MusicData.Artist, e.MusicData.Album = newArtist, newAlbum
+	}, func(e music.Entry) NameAlias {
+		return NameAlias{Artist: e.MusicData.Artist, Album: e.MusicData.Album, NewArtist: newArtist, NewAlbum: newAlbum}
+	})
+}
@@ -0,0 +1,158 @@
+package store
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func Test_nameKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"Queen", "Queen ", true},
+		{"Queen", "QUEEN", true},
+		{"AC/DC", "ACDC", true},
+		{"The Beatles", "Beatles", true},
+		{"Beyoncé", "Beyonce", true},
+		{"Queen", "Qeen", false},
+	}
+	for _, tt := range tests {
+		if got := nameKey(tt.a) == nameKey(tt.b); got != tt.same {
+			t.Errorf("nameKey(%q) == nameKey(%q) is %v, want %v", tt.a, tt.b, got, tt.same)
+		}
+	}
+}
+
+func TestManager_SimilarNames(t *testing.T) {
+	m := &Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs: map[string]music.Entry{
+			"a": {ID: "a", MusicData: music.MusicData{Title: "A", Artist: "Queen", Album: "A Night at the Opera"}},
+			"b": {ID: "b", MusicData: music.MusicData{Title: "B", Artist: "Queen", Album: "A Night at the Opera"}},
+			"c": {ID: "c", MusicData: music.MusicData{Title: "C", Artist: "QUEEN", Album: "A Night At The Opera"}},
+			"d": {ID: "d", MusicData: music.MusicData{Title: "D", Artist: "Qeen"}},
+			"e": {ID: "e", MusicData: music.MusicData{Title: "E", Artist: "AC/DC", Album: "Greatest Hits"}},
+			"f": {ID: "f", MusicData: music.MusicData{Title: "F", Artist: "Muse", Album: "Greatest Hits"}},
+			"g": {ID: "g", MusicData: music.MusicData{Title: "G", Artist: "Mase"}},
+		},
+	}
+
+	artists, albums := m.SimilarNames()
+	if len(artists) != 1 || len(artists[0].Names) != 3 {
+		t.Fatalf("expected one group with Queen, QUEEN and Qeen, got %+v", artists)
+	}
+	if n := artists[0].Names[0]; n.Artist != "Queen" || n.Songs != 2 {
+		t.Errorf("expected most used spelling first, got %+v", n)
+	}
+	if artists[0].Similarity != 0.8 {
+		t.Errorf("expected similarity 0.8, got %v", artists[0].Similarity)
+	}
+
+	// Albums of different artists are not compared
+	if len(albums) != 1 || len(albums[0].Names) != 2 || albums[0].Similarity != 1 {
+		t.Errorf("expected one album group, got %+v", albums)
+	}
+}
+
+func TestManager_MergeArtists(t *testing.T) {
+	// Merging saves to the data directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	m := &Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs: map[string]music.Entry{
+			"a": {ID: "a", MusicData: music.MusicData{Title: "A", Artist: "Queen", Album: "A Night at the Opera"}},
+			"b": {ID: "b", MusicData: music.MusicData{Title: "B", Artist: "Qeen", Album: "ANATO"}},
+			"c": {ID: "c", MusicData: music.MusicData{Title: "C", Artist: "QUEEN "}},
+			"d": {ID: "d", MusicData: music.MusicData{Title: "D", Artist: "David Bowie"}},
+		},
+	}
+
+	edited, err := m.MergeArtists([]string{"Qeen", "Queen"}, "Queen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(edited) != 2 || m.Songs["b"].MusicData.Artist != "Queen" || m.Songs["c"].MusicData.Artist != "Queen" {
+		t.Errorf("artists weren't merged: %+v", m.Songs)
+	}
+	if !m.Songs["a"].LastEdit.IsZero() || m.Songs["d"].MusicData.Artist != "David Bowie" {
+		t.Errorf("unrelated songs were edited: %+v", m.Songs)
+	}
+
+	_, err = m.MergeAlbums([]AlbumName{{"Queen", "ANATO"}}, "Queen", "A Night at the Opera")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Songs["b"].MusicData.Album != "A Night at the Opera" {
+		t.Errorf("album wasn't renamed: %+v", m.Songs["b"])
+	}
+
+	// New songs with old spellings get the new names
+	m.SongsLock.Lock()
+	for _, e := range []music.Entry{
+		{ID: "e", MusicData: music.MusicData{Title: "E", Artist: "Qeen", Album: "ANATO"}},
+		{ID: "f", MusicData: music.MusicData{Title: "F", Artist: "queen"}},
+		{ID: "g", MusicData: music.MusicData{Title: "G", Artist: "Qeen", Album: "Innuendo"}},
+	} {
+		err = m.Add(&e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.SongsLock.Unlock()
+
+	if e := m.Songs["e"]; e.MusicData.Artist != "Queen" || e.MusicData.Album != "A Night at the Opera" {
+		t.Errorf("aliases weren't applied: %+v", e.MusicData)
+	}
+	if e := m.Songs["f"]; e.MusicData.Artist != "Queen" {
+		t.Errorf("alias wasn't applied: %+v", e.MusicData)
+	}
+	if e := m.Songs["g"]; e.MusicData.Artist != "Queen" || e.MusicData.Album != "Innuendo" {
+		t.Errorf("alias wasn't applied: %+v", e.MusicData)
+	}
+
+	// Renaming the artist again updates the aliases that pointed to the old name
+	_, err = m.MergeArtists([]string{"Queen"}, "Queen + Adam Lambert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := m.NameAliases[artistAliasKey("Qeen")]; a.NewArtist != "Queen + Adam Lambert" {
+		t.Errorf("alias chain wasn't shortened: %+v", m.NameAliases)
+	}
+
+	// Renaming it back removes the alias for the name
+	_, err = m.MergeArtists([]string{"Queen + Adam Lambert"}, "Queen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := m.NameAliases[artistAliasKey("Qeen")]; a.NewArtist != "Queen" {
+		t.Errorf("alias doesn't point to the new name: %+v", a)
+	}
+	if _, ok := m.NameAliases[artistAliasKey("Queen")]; ok {
+		t.Errorf("alias for the current name wasn't removed: %+v", m.NameAliases)
+	}
+
+	err = m.RemoveAlias(artistAliasKey("Qeen"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.RemoveAlias(artistAliasKey("Qeen")) == nil {
+		t.Errorf("expected error when removing an alias that doesn't exist")
+	}
+
+	_, err = m.MergeArtists([]string{"Queen"}, " ")
+	if err == nil {
+		t.Errorf("expected error for an empty name")
+	}
+}
@@ -0,0 +1,111 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"time"
+	"xarantolus/sensibleHub/store/music"
+)
+
+// DuplicateError is returned by downloads that sound like a song that is already in the library.
+// The downloaded files are kept until the download is added using AddPending or discarded using DiscardPending
+type DuplicateError struct {
+	// PendingID identifies the kept download
+	PendingID string `json:"pending_id"`
+	// URL is the link or search that was downloaded
+	URL string `json:"url"`
+
+	// Song is the existing song that sounds like the download
+	Song       music.Entry `json:"song"`
+	Similarity float64     `json:"similarity"`
+
+	Downloaded time.Time `json:"downloaded"`
+}
+
+func (d *DuplicateError) Error() string {
+	return fmt.Sprintf("This is probably a duplicate of %q (id %s, %.0f%% similar)", d.Song.SongName(), d.Song.ID, d.Similarity*100)
+}
+
+// pendingDownload is a download that is waiting for the user to decide whether it should be added
+type pendingDownload struct {
+	// dir is the temporary directory youtube-dl downloaded the files to
+	dir string
+
+	duplicate DuplicateError
+}
+
+// keepPending remembers the downloaded files in `dir` and returns the error that describes them
+func (m *Manager) keepPending(dir string, d DuplicateError) *DuplicateError {
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+
+	if m.pending == nil {
+		m.pending = make(map[string]pendingDownload)
+	}
+
+	for {
+		d.PendingID = randSeq(8)
+		if _, ok := m.pending[d.PendingID]; !ok {
+			break
+		}
+	}
+
+	m.pending[d.PendingID] = pendingDownload{
+		dir:       dir,
+		duplicate: d,
+	}
+
+	return &d
+}
+
+// takePending removes the pending download with the given `id` and returns it
+func (m *Manager) takePending(id string) (p pendingDownload, ok bool) {
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+
+	p, ok = m.pending[id]
+	delete(m.pending, id)
+
+	return
+}
+
+// PendingDownloads returns the downloads that were kept because they are probably duplicates, the newest first
+func (m *Manager) PendingDownloads() (list []DuplicateError) {
+	m.pendingLock.Lock()
+	defer m.pendingLock.Unlock()
+
+	for _, p := range m.pending {
+		list = append(list, p.duplicate)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Downloaded.After(list[j].Downloaded)
+	})
+
+	return
+}
+
+// AddPending adds the pending download with the given `id` to the library even though it is probably a duplicate.
+// It is added to the download queue, but the files that were already downloaded are used
+func (m *Manager) AddPending(id string) (err error) {
+	m.pendingLock.Lock()
+	_, ok := m.pending[id]
+	m.pendingLock.Unlock()
+
+	if !ok {
+		return fmt.Errorf("Cannot add pending download with id %s as it doesn't exist", id)
+	}
+
+	return m.enqueue(downloadRequest{PendingID: id, AllowDuplicates: true})
+}
+
+// DiscardPending deletes the files of the pending download with the given `id`
+func (m *Manager) DiscardPending(id string) (err error) {
+	p, ok := m.takePending(id)
+	if !ok {
+		return fmt.Errorf("Cannot discard pending download with id %s as it doesn't exist", id)
+	}
+
+	return os.RemoveAll(p.dir)
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func TestManager_pending(t *testing.T) {
+	m := Manager{
+		SongsLock:    new(sync.RWMutex),
+		enqueuedURLs: make(chan downloadRequest, 25),
+	}
+
+	song := music.Entry{ID: "a", MusicData: music.MusicData{Title: "Bohemian Rhapsody", Artist: "Queen"}}
+
+	newDir := func() string {
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "song.opus"), []byte("audio"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	now := time.Now()
+	oldDir, newerDir := newDir(), newDir()
+
+	var err error = m.keepPending(oldDir, DuplicateError{URL: "https://youtu.be/old", Song: song, Similarity: 0.93, Downloaded: now.Add(-time.Hour)})
+
+	var dup *DuplicateError
+	if !errors.As(err, &dup) {
+		t.Fatalf("keepPending returned %T, want *DuplicateError", err)
+	}
+	if dup.PendingID == "" || dup.Song.ID != "a" {
+		t.Errorf("keepPending returned %+v, want a pending ID and song a", dup)
+	}
+
+	newer := m.keepPending(newerDir, DuplicateError{URL: "https://youtu.be/new", Song: song, Similarity: 0.97, Downloaded: now})
+
+	list := m.PendingDownloads()
+	if len(list) != 2 || list[0].PendingID != newer.PendingID || list[1].PendingID != dup.PendingID {
+		t.Fatalf("PendingDownloads() = %+v, want the newer download first", list)
+	}
+
+	// Adding queues the download with the files that were kept
+	if err := m.AddPending(dup.PendingID); err != nil {
+		t.Fatal(err)
+	}
+	req := <-m.enqueuedURLs
+	if req.PendingID != dup.PendingID || !req.AllowDuplicates {
+		t.Errorf("AddPending queued %+v, want pending ID %s that allows duplicates", req, dup.PendingID)
+	}
+
+	p, ok := m.takePending(req.PendingID)
+	if !ok || p.dir != oldDir {
+		t.Errorf("takePending(%s) = %+v, %v, want dir %s", req.PendingID, p, ok, oldDir)
+	}
+	if _, ok := m.takePending(req.PendingID); ok {
+		t.Errorf("takePending(%s) returned a download that was already taken", req.PendingID)
+	}
+
+	// Downloading a pending download that doesn't exist anymore must fail instead of running youtube-dl
+	if err := m.download(downloadRequest{PendingID: req.PendingID}); err == nil {
+		t.Errorf("download of taken pending download %s succeeded", req.PendingID)
+	}
+
+	if err := m.DiscardPending(newer.PendingID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(newerDir); !os.IsNotExist(err) {
+		t.Errorf("DiscardPending didn't delete %s: %v", newerDir, err)
+	}
+	if len(m.PendingDownloads()) != 0 {
+		t.Errorf("PendingDownloads() = %+v after discarding, want none", m.PendingDownloads())
+	}
+
+	if err := m.AddPending(newer.PendingID); err == nil {
+		t.Errorf("AddPending of discarded download %s succeeded", newer.PendingID)
+	}
+	if err := m.DiscardPending(newer.PendingID); err == nil {
+		t.Errorf("DiscardPending of discarded download %s succeeded", newer.PendingID)
+	}
+}
@@ -0,0 +1,355 @@
+package store
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"xarantolus/sensibleHub/store/music"
+)
+
+// Playlist is an ordered list of songs created by the user
+type Playlist struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+
+	// Songs are the IDs of the songs in this playlist, in order. Every song is only contained once
+	Songs []string `json:"songs"`
+
+	// SyncSettings decide whether the playlist is shown as directory to FTP/WebDAV users
+	SyncSettings music.SyncSettings `json:"sync_settings"`
+
+	Created  time.Time `json:"created"`
+	LastEdit time.Time `json:"last_edit"`
+}
+
+// index returns the index of the song with the given ID in the playlist or -1
+func (p *Playlist) index(songID string) int {
+	for i, id := range p.Songs {
+		if id == songID {
+			return i
+		}
+	}
+	return -1
+}
+
+// AllPlaylists returns all playlists, sorted by their name
+func (m *Manager) AllPlaylists() (list []Playlist) {
+	m.SongsLock.RLock()
+	defer m.SongsLock.RUnlock()
+
+	for _, p := range m.Playlists {
+		list = append(list, p)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToUpper(list[i].Name) < strings.ToUpper(list[j].Name)
+	})
+
+	return
+}
+
+// GetPlaylist returns the playlist with the given ID
+func (m *Manager) GetPlaylist(id string) (p Playlist, ok bool) {
+	m.SongsLock.RLock()
+	p, ok = m.Playlists[id]
+	m.SongsLock.RUnlock()
+
+	return
+}
+
+// PlaylistEntries returns the songs of `p` in order
+func (m *Manager) PlaylistEntries(p Playlist) (list []music.Entry) {
+	m.SongsLock.RLock()
+	defer m.SongsLock.RUnlock()
+
+	for _, id := range p.Songs {
+		if e, ok := m.Songs[id]; ok {
+			list = append(list, e)
+		}
+	}
+
+	return
+}
+
+// CreatePlaylist creates a new, empty playlist with the given name
+func (m *Manager) CreatePlaylist(name string) (p Playlist, err error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return p, fmt.Errorf("A playlist needs a name")
+	}
+
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	if m.Playlists == nil {
+		m.Playlists = make(map[string]Playlist)
+	}
+
+	now := time.Now()
+	p = Playlist{
+		ID:       m.generatePlaylistID(),
+		Name:     name,
+		Songs:    []string{},
+		Created:  now,
+		LastEdit: now,
+	}
+
+	return p, m.savePlaylist(p)
+}
+
+// generatePlaylistID generates a new ID that is not used by any playlist or smart playlist.
+// It assumes that m.SongsLock is already locked for reading
+func (m *Manager) generatePlaylistID() (id string) {
+	for counter := 0; counter < 10000; counter++ {
+		id = randSeq(4)
+
+		_, used := m.Playlists[id]
+		_, usedSmart := m.SmartPlaylists[id]
+		if !used && !usedSmart {
+			return
+		}
+	}
+
+	panic("couldn't obtain a randomly-generated unique playlist ID after 10.000 tries")
+}
+
+// editPlaylist runs `edit` on the playlist with the given ID and saves it afterwards
+func (m *Manager) editPlaylist(id string, edit func(p *Playlist) error) (err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	p, ok := m.Playlists[id]
+	if !ok {
+		return fmt.Errorf("Cannot edit playlist with id %s as it doesn't exist", id)
+	}
+
+	err = edit(&p)
+	if err != nil {
+		return
+	}
+
+	p.LastEdit = time.Now()
+
+	return m.savePlaylist(p)
+}
+
+// savePlaylist stores `p` and saves the manager.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) savePlaylist(p Playlist) (err error) {
+	m.Playlists[p.ID] = p
+
+	err = m.Save(false)
+	if err != nil {
+		return
+	}
+
+	m.event("playlist-edit", map[string]interface{}{
+		"id":       p.ID,
+		"playlist": p,
+	})
+
+	return nil
+}
+
+// RenamePlaylist changes the name of a playlist
+func (m *Manager) RenamePlaylist(id, name string) (err error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return fmt.Errorf("A playlist needs a name")
+	}
+
+	return m.editPlaylist(id, func(p *Playlist) error {
+		p.Name = name
+		return nil
+	})
+}
+
+// SetPlaylistSync sets whether the playlist should be shown to FTP/WebDAV users
+func (m *Manager) SetPlaylistSync(id string, should bool) (err error) {
+	return m.editPlaylist(id, func(p *Playlist) error {
+		p.SyncSettings.Should = should
+		return nil
+	})
+}
+
+// AddToPlaylist appends the given songs to a playlist. Songs that are already in the playlist are not added again
+func (m *Manager) AddToPlaylist(id string, songIDs ...string) (err error) {
+	return m.editPlaylist(id, func(p *Playlist) error {
+		for _, songID := range songIDs {
+			if _, ok := m.Songs[songID]; !ok {
+				return fmt.Errorf("Cannot add song with id %s to playlist as it doesn't exist", songID)
+			}
+
+			if p.index(songID) < 0 {
+				p.Songs = append(p.Songs, songID)
+			}
+		}
+		return nil
+	})
+}
+
+// RemoveFromPlaylist removes a song from a playlist
+func (m *Manager) RemoveFromPlaylist(id, songID string) (err error) {
+	return m.editPlaylist(id, func(p *Playlist) error {
+		i := p.index(songID)
+		if i < 0 {
+			return fmt.Errorf("Song with id %s is not in this playlist", songID)
+		}
+
+		p.Songs = append(append([]string{}, p.Songs[:i]...), p.Songs[i+1:]...)
+		return nil
+	})
+}
+
+// ReorderPlaylist changes the order of songs in a playlist. `songIDs` must contain exactly the songs of the playlist
+func (m *Manager) ReorderPlaylist(id string, songIDs []string) (err error) {
+	return m.editPlaylist(id, func(p *Playlist) error {
+		if len(songIDs) != len(p.Songs) {
+			return fmt.Errorf("The new order must contain all %d songs of the playlist", len(p.Songs))
+		}
+
+		seen := make(map[string]bool)
+		for _, songID := range songIDs {
+			if seen[songID] || p.index(songID) < 0 {
+				return fmt.Errorf("The new order must contain all songs of the playlist exactly once")
+			}
+			seen[songID] = true
+		}
+
+		p.Songs = append([]string{}, songIDs...)
+		return nil
+	})
+}
+
+// DeletePlaylist deletes a playlist. The songs in it are not changed
+func (m *Manager) DeletePlaylist(id string) (err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	if _, ok := m.Playlists[id]; !ok {
+		return fmt.Errorf("Cannot delete playlist with id %s as it doesn't exist", id)
+	}
+
+	delete(m.Playlists, id)
+
+	err = m.Save(false)
+	if err != nil {
+		return
+	}
+
+	m.event("playlist-delete", map[string]interface{}{
+		"id": id,
+	})
+
+	return nil
+}
+
+// removeFromPlaylists removes the song with the given ID from all playlists and returns the ones that changed.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) removeFromPlaylists(songID string) (changed []Playlist) {
+	for id, p := range m.Playlists {
+		i := p.index(songID)
+		if i < 0 {
+			continue
+		}
+
+		p.Songs = append(append([]string{}, p.Songs[:i]...), p.Songs[i+1:]...)
+		p.LastEdit = time.Now()
+		m.Playlists[id] = p
+
+		changed = append(changed, p)
+	}
+
+	return
+}
+
+// replaceInPlaylists replaces the song with ID `oldID` with `newID` in all playlists.
+// If a playlist already contains `newID`, `oldID` is just removed from it.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) replaceInPlaylists(oldID, newID string) {
+	for id, p := range m.Playlists {
+		i := p.index(oldID)
+		if i < 0 {
+			continue
+		}
+
+		if p.index(newID) < 0 {
+			p.Songs = append([]string{}, p.Songs...)
+			p.Songs[i] = newID
+		} else {
+			p.Songs = append(append([]string{}, p.Songs[:i]...), p.Songs[i+1:]...)
+		}
+		p.LastEdit = time.Now()
+		m.Playlists[id] = p
+	}
+}
+
+// WriteM3U8 writes the songs of a playlist as extended M3U playlist to `w`. `location` returns the URL or path of a song
+func WriteM3U8(w io.Writer, p Playlist, entries []music.Entry, location func(e music.Entry) string) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "#EXTM3U\n#PLAYLIST:%s\n", oneLine(p.Name))
+	for _, e := range entries {
+		fmt.Fprintf(bw, "#EXTINF:%d,%s\n%s\n", int(e.PlayDuration()), oneLine(e.SongName()), location(e))
+	}
+
+	return bw.Flush()
+}
+
+// oneLine replaces line breaks, they would break M3U files
+func oneLine(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+type xspfPlaylist struct {
+	XMLName xml.Name `xml:"http://xspf.org/ns/0/ playlist"`
+	Version int      `xml:"version,attr"`
+
+	Title  string      `xml:"title"`
+	Tracks []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Location string `xml:"location"`
+	Title    string `xml:"title"`
+	Creator  string `xml:"creator,omitempty"`
+	Album    string `xml:"album,omitempty"`
+	TrackNum int    `xml:"trackNum,omitempty"`
+	Duration int64  `xml:"duration,omitempty"`
+	Image    string `xml:"image,omitempty"`
+}
+
+// WriteXSPF writes the songs of a playlist as XSPF playlist to `w`.
+// `location` returns the URL of a song, `image` the URL of its cover or an empty string
+func WriteXSPF(w io.Writer, p Playlist, entries []music.Entry, location, image func(e music.Entry) string) (err error) {
+	doc := xspfPlaylist{
+		Version: 1,
+		Title:   p.Name,
+	}
+
+	for _, e := range entries {
+		doc.Tracks = append(doc.Tracks, xspfTrack{
+			Location: location(e),
+			Title:    e.MusicData.Title,
+			Creator:  e.MusicData.Artist,
+			Album:    e.MusicData.Album,
+			TrackNum: e.MusicData.Track,
+			Duration: int64(e.PlayDuration() * 1000),
+			Image:    image(e),
+		})
+	}
+
+	_, err = io.WriteString(w, xml.Header)
+	if err != nil {
+		return
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "\t")
+
+	return enc.Encode(doc)
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func TestManager_playlists(t *testing.T) {
+	// Saving writes to the data directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	m := Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs: map[string]music.Entry{
+			"a": {ID: "a", MusicData: music.MusicData{Title: "Bohemian Rhapsody", Artist: "Queen", Duration: 354}},
+			"b": {ID: "b", MusicData: music.MusicData{Title: "Heroes", Artist: "David Bowie", Duration: 371}},
+			"c": {ID: "c", MusicData: music.MusicData{Title: "SOS", Artist: "ABBA", Duration: 200}},
+		},
+	}
+
+	if _, err := m.CreatePlaylist("  "); err == nil {
+		t.Errorf("expected an error for a playlist without a name")
+	}
+
+	p, err := m.CreatePlaylist("Road trip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	songs := func() []string {
+		p, _ := m.GetPlaylist(p.ID)
+		return p.Songs
+	}
+
+	err = m.AddToPlaylist(p.ID, "a", "b", "a", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := songs(), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("songs after adding are %v, want %v", got, want)
+	}
+
+	if err := m.AddToPlaylist(p.ID, "unknown"); err == nil {
+		t.Errorf("expected an error when adding an unknown song")
+	}
+
+	for _, order := range [][]string{{"c", "b"}, {"c", "c", "a"}, {"c", "b", "d"}} {
+		if err := m.ReorderPlaylist(p.ID, order); err == nil {
+			t.Errorf("expected an error when reordering to %v", order)
+		}
+	}
+
+	err = m.ReorderPlaylist(p.ID, []string{"c", "a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.RemoveFromPlaylist(p.ID, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := songs(), []string{"c", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("songs after reordering and removing are %v, want %v", got, want)
+	}
+
+	err = m.DeleteEntry("c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := songs(), []string{"b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("songs after deleting a song are %v, want %v", got, want)
+	}
+
+	p, _ = m.GetPlaylist(p.ID)
+
+	var buf bytes.Buffer
+	err = WriteM3U8(&buf, p, m.PlaylistEntries(p), func(e music.Entry) string {
+		return "/song/" + e.ID + "/audio"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "#EXTM3U\n#PLAYLIST:Road trip\n#EXTINF:371,David Bowie - Heroes\n/song/b/audio\n"
+	if buf.String() != want {
+		t.Errorf("WriteM3U8() = %q, want %q", buf.String(), want)
+	}
+
+	err = m.DeletePlaylist(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.GetPlaylist(p.ID); ok {
+		t.Errorf("playlist still exists after deleting it")
+	}
+}
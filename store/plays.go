@@ -0,0 +1,47 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// maxPlayHistory is the number of plays that are kept, older ones are removed
+const maxPlayHistory = 2500
+
+// Play records that a song was played, e.g. by a Subsonic client
+type Play struct {
+	ID   string    `json:"id"`
+	Time time.Time `json:"time"`
+}
+
+// RecordPlay adds a play of the song with the given `id` to the play history
+func (m *Manager) RecordPlay(id string, t time.Time) (err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	if _, ok := m.Songs[id]; !ok {
+		return fmt.Errorf("Cannot record play of song with id %s as it doesn't exist", id)
+	}
+
+	m.PlayHistory = append(m.PlayHistory, Play{
+		ID:   id,
+		Time: t,
+	})
+	if len(m.PlayHistory) > maxPlayHistory {
+		m.PlayHistory = append([]Play(nil), m.PlayHistory[len(m.PlayHistory)-maxPlayHistory:]...)
+	}
+
+	return m.Save(false)
+}
+
+// removePlays removes the song with the given `id` from the play history.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) removePlays(id string) {
+	plays := m.PlayHistory[:0]
+	for _, p := range m.PlayHistory {
+		if p.ID != id {
+			plays = append(plays, p)
+		}
+	}
+	m.PlayHistory = plays
+}
@@ -0,0 +1,340 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"xarantolus/sensibleHub/store/music"
+)
+
+var (
+	// cutoffFrequencies are checked for the spectral cutoff, in ascending order. The values are in Hz
+	cutoffFrequencies = []int{11000, 13000, 15000, 16000, 17000, 18000, 19000, 20000}
+
+	// losslessCodecs are the ffprobe codec names of lossless formats. PCM codecs are recognized by their prefix
+	losslessCodecs = map[string]bool{
+		"flac":    true,
+		"alac":    true,
+		"wavpack": true,
+		"ape":     true,
+		"tta":     true,
+	}
+)
+
+const (
+	// cutoffAnalyzed is how many seconds at the start of a song are analyzed for the spectral cutoff
+	cutoffAnalyzed = 90
+	// cutoffSilence is how many dB quieter than the whole audio the frequencies above the cutoff must be
+	cutoffSilence = 60
+)
+
+// expectedCutoff returns the lowest cutoff frequency that is normal for audio with the given quality.
+// Encoders cut off less at higher bitrates, lossless formats shouldn't cut off anything audible
+func expectedCutoff(info music.AudioInfo) int {
+	switch {
+	case info.Lossless:
+		return 19000
+	case info.Bitrate >= 256:
+		return 18000
+	case info.Bitrate >= 192:
+		return 17000
+	case info.Bitrate >= 128:
+		return 15000
+	default:
+		// Low bitrates are expected to sound bad
+		return 0
+	}
+}
+
+type probeInfo struct {
+	Streams []struct {
+		CodecName  string `json:"codec_name"`
+		SampleRate string `json:"sample_rate"`
+		Channels   int    `json:"channels"`
+		BitRate    string `json:"bit_rate"`
+	} `json:"streams"`
+	Format struct {
+		BitRate string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+// probeAudio returns information about the first audio stream of the file at `inputPath`
+func (m *Manager) probeAudio(inputPath string) (info music.AudioInfo, err error) {
+	cmd := exec.Command(m.cfg.Alternatives.FFprobe, "-i", inputPath, "-select_streams", "a:0",
+		"-show_entries", "stream=codec_name,sample_rate,channels,bit_rate:format=bit_rate", "-print_format", "json", "-v", "quiet")
+
+	out, err := cmd.Output()
+	if err != nil {
+		return info, fmt.Errorf("Error while running ffprobe: %w", err)
+	}
+
+	return parseProbe(out)
+}
+
+func parseProbe(out []byte) (info music.AudioInfo, err error) {
+	var probe probeInfo
+	err = json.Unmarshal(out, &probe)
+	if err != nil {
+		return
+	}
+
+	if len(probe.Streams) == 0 {
+		return info, fmt.Errorf("file doesn't contain an audio stream")
+	}
+	stream := probe.Streams[0]
+
+	info.Codec = stream.CodecName
+	info.Channels = stream.Channels
+	info.SampleRate, _ = strconv.Atoi(stream.SampleRate)
+	info.Lossless = losslessCodecs[stream.CodecName] || strings.HasPrefix(stream.CodecName, "pcm_")
+
+	// Some containers, e.g. WebM, only have the bitrate of the whole file
+	bitrate, berr := strconv.Atoi(stream.BitRate)
+	if berr != nil {
+		bitrate, _ = strconv.Atoi(probe.Format.BitRate)
+	}
+	info.Bitrate = bitrate / 1000
+
+	return info, nil
+}
+
+// volumeRegex matches the mean volume printed by ffmpeg's volumedetect filter, the first group is the index of the filter
+var volumeRegex = regexp.MustCompile(`\[Parsed_volumedetect_(\d+) @ [^\]]+\] mean_volume: (-?[\d.]+|-inf) dB`)
+
+// spectralCutoff returns the lowest frequency of cutoffFrequencies above which the audio is silent, or 0 if there is none.
+// It compares the volume of the whole audio with the volume of everything above each frequency
+func (m *Manager) spectralCutoff(inputPath string) (cutoff int, err error) {
+	var (
+		graph   strings.Builder
+		outputs []string
+	)
+
+	fmt.Fprintf(&graph, "[0:a:0]asplit=%d", len(cutoffFrequencies)+1)
+	for i := 0; i <= len(cutoffFrequencies); i++ {
+		fmt.Fprintf(&graph, "[s%d]", i)
+	}
+	graph.WriteString(";[s0]volumedetect[o0]")
+	outputs = append(outputs, "-map", "[o0]", "-f", "null", "-")
+
+	for i, f := range cutoffFrequencies {
+		// Remove all frequencies below f, this is sharper than a high-pass filter
+		keep := fmt.Sprintf("gte(b*sr/(2*nb),%d)", f)
+		fmt.Fprintf(&graph, ";[s%d]afftfilt=real='re*%s':imag='im*%s',volumedetect[o%d]", i+1, keep, keep, i+1)
+		outputs = append(outputs, "-map", fmt.Sprintf("[o%d]", i+1), "-f", "null", "-")
+	}
+
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(m.cfg.Alternatives.FFmpeg, append([]string{"-hide_banner", "-nostats", "-t", strconv.Itoa(cutoffAnalyzed), "-i", inputPath,
+		"-filter_complex", graph.String()}, outputs...)...)
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return 0, fmt.Errorf("Error while running ffmpeg: %s\nOutput: %s", err.Error(), stderr.String())
+	}
+
+	return parseCutoff(stderr.String())
+}
+
+// parseCutoff reads the volumes from the output of the filter graph used by spectralCutoff
+func parseCutoff(output string) (cutoff int, err error) {
+	type volume struct {
+		filter int
+		mean   float64
+	}
+	var volumes []volume
+
+	for _, match := range volumeRegex.FindAllStringSubmatch(output, -1) {
+		filter, _ := strconv.Atoi(match[1])
+
+		mean := -200.0 // -inf, digital silence
+		if match[2] != "-inf" {
+			mean, err = strconv.ParseFloat(match[2], 64)
+			if err != nil {
+				return
+			}
+		}
+
+		volumes = append(volumes, volume{filter, mean})
+	}
+
+	if len(volumes) != len(cutoffFrequencies)+1 {
+		return 0, fmt.Errorf("expected %d volumes in ffmpeg output, but got %d", len(cutoffFrequencies)+1, len(volumes))
+	}
+
+	// The filters are numbered in the order they appear in the graph, the first one measures the whole audio
+	sort.Slice(volumes, func(i, j int) bool {
+		return volumes[i].filter < volumes[j].filter
+	})
+
+	full := volumes[0].mean
+	if full <= -cutoffSilence {
+		// The song is too quiet to tell anything
+		return 0, nil
+	}
+
+	for i, f := range cutoffFrequencies {
+		if volumes[i+1].mean < full-cutoffSilence {
+			return f, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// ProbeEntry records codec, bitrate, sample rate, channels and the spectral cutoff of the song with the given `id`
+func (m *Manager) ProbeEntry(id string) (err error) {
+	e, ok := m.GetEntry(id)
+	if !ok {
+		return fmt.Errorf("Cannot probe entry with id %s as it doesn't exist", id)
+	}
+
+	// Like with trim analysis, we don't hold any lock while running ffmpeg
+	info, err := m.probeAudio(e.AudioPath())
+	if err != nil {
+		return
+	}
+
+	info.Cutoff, err = m.spectralCutoff(e.AudioPath())
+	if err != nil {
+		return
+	}
+
+	if expected := expectedCutoff(info); info.Cutoff > 0 && info.Cutoff <= expected {
+		info.Transcoded = true
+	}
+	info.Probed = time.Now()
+
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	// The entry might have been edited or deleted in the meantime
+	e, ok = m.Songs[id]
+	if !ok {
+		return fmt.Errorf("Entry with id %s was deleted while probing", id)
+	}
+
+	// The download format is only known when downloading, so it must not get lost
+	if e.AudioInfo != nil {
+		info.SourceFormat = e.AudioInfo.SourceFormat
+	}
+
+	e.AudioInfo = &info
+	m.Songs[id] = e
+
+	err = m.Save(false)
+	if err != nil {
+		return
+	}
+
+	m.event("song-edit", map[string]interface{}{
+		"id":   id,
+		"song": e,
+	})
+
+	return nil
+}
+
+// ProbeMissing probes all songs that weren't probed yet. Since it might take a long time, it should run in the background
+func (m *Manager) ProbeMissing() {
+	var ids []string
+	for _, e := range m.AllEntries() {
+		if e.AudioInfo == nil || e.AudioInfo.Probed.IsZero() {
+			ids = append(ids, e.ID)
+		}
+	}
+	if len(ids) == 0 {
+		return
+	}
+
+	log.Printf("[Probe] Probing audio quality of %d songs\n", len(ids))
+
+	var done int
+	for _, id := range ids {
+		err := m.ProbeEntry(id)
+		if err != nil {
+			log.Printf("[Probe] Error while probing %s: %s\n", id, err.Error())
+			continue
+		}
+		done++
+	}
+
+	log.Printf("[Probe] Finished probing %d songs\n", done)
+}
+
+// bitrateGroups are the groups of the quality listing, a song belongs to the first group whose minimum bitrate it reaches
+var bitrateGroups = []struct {
+	title   string
+	minimum int
+}{
+	{"320 kbit/s and more", 320},
+	{"256 to 320 kbit/s", 256},
+	{"192 to 256 kbit/s", 192},
+	{"128 to 192 kbit/s", 128},
+	{"Below 128 kbit/s", 0},
+}
+
+// QualityGroups groups songs by their audio quality. Songs that were probably transcoded from a lower bitrate
+// come first, then lossy songs by bitrate and lossless songs last. Within groups, songs are sorted by bitrate, the lowest first
+func (m *Manager) QualityGroups() (groups []Group) {
+	var (
+		transcoded, lossless, unknown []music.Entry
+
+		byBitrate = make([][]music.Entry, len(bitrateGroups))
+	)
+
+	for _, e := range m.AllEntries() {
+		switch {
+		case e.AudioInfo == nil || e.AudioInfo.Probed.IsZero():
+			unknown = append(unknown, e)
+		case e.AudioInfo.Transcoded:
+			transcoded = append(transcoded, e)
+		case e.AudioInfo.Lossless:
+			lossless = append(lossless, e)
+		default:
+			for i, g := range bitrateGroups {
+				if e.Bitrate() >= g.minimum {
+					byBitrate[i] = append(byBitrate[i], e)
+					break
+				}
+			}
+		}
+	}
+
+	add := func(title, description string, songs []music.Entry) {
+		if len(songs) == 0 {
+			return
+		}
+
+		sort.SliceStable(songs, func(i, j int) bool {
+			return songs[i].Bitrate() < songs[j].Bitrate()
+		})
+
+		if description == "" {
+			description = songLenDescription(len(songs))
+		}
+
+		groups = append(groups, Group{
+			Title:       title,
+			Description: description,
+			Songs:       songs,
+		})
+	}
+
+	add("Probably transcoded", "High frequencies are missing, these files were probably converted from a lower bitrate", transcoded)
+	for i := len(bitrateGroups) - 1; i >= 0; i-- {
+		add(bitrateGroups[i].title, "", byBitrate[i])
+	}
+	add("Lossless", "", lossless)
+	add("Not analyzed yet", "", unknown)
+
+	return
+}
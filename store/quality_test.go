@@ -0,0 +1,139 @@
+package store
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func TestParseProbe(t *testing.T) {
+	tests := []struct {
+		output string
+		want   music.AudioInfo
+	}{
+		{
+			`{"streams": [{"codec_name": "mp3", "sample_rate": "44100", "channels": 2, "bit_rate": "320000"}], "format": {"bit_rate": "321000"}}`,
+			music.AudioInfo{Codec: "mp3", Bitrate: 320, SampleRate: 44100, Channels: 2},
+		},
+		{
+			// WebM files don't have a stream bitrate
+			`{"streams": [{"codec_name": "opus", "sample_rate": "48000", "channels": 2}], "format": {"bit_rate": "135000"}}`,
+			music.AudioInfo{Codec: "opus", Bitrate: 135, SampleRate: 48000, Channels: 2},
+		},
+		{
+			`{"streams": [{"codec_name": "flac", "sample_rate": "96000", "channels": 1}], "format": {"bit_rate": "2500000"}}`,
+			music.AudioInfo{Codec: "flac", Bitrate: 2500, SampleRate: 96000, Channels: 1, Lossless: true},
+		},
+		{
+			`{"streams": [{"codec_name": "pcm_s16le", "sample_rate": "44100", "channels": 2, "bit_rate": "1411200"}], "format": {}}`,
+			music.AudioInfo{Codec: "pcm_s16le", Bitrate: 1411, SampleRate: 44100, Channels: 2, Lossless: true},
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := parseProbe([]byte(tt.output))
+		if err != nil {
+			t.Errorf("parseProbe(%s): %s", tt.output, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseProbe(%s) = %+v, want %+v", tt.output, got, tt.want)
+		}
+	}
+
+	if _, err := parseProbe([]byte(`{"streams": [], "format": {}}`)); err == nil {
+		t.Errorf("expected an error for a file without audio")
+	}
+}
+
+// volumedetectOutput returns ffmpeg output with the given mean volumes, the first one is the whole audio.
+// The order of the lines is shuffled like ffmpeg might do
+func volumedetectOutput(volumes ...string) string {
+	var lines []string
+	for i := len(volumes) - 1; i >= 0; i-- {
+		// asplit is filter 0, the first volumedetect is filter 1, then there are afftfilt/volumedetect pairs
+		filter := 1 + 2*i
+		lines = append(lines,
+			fmt.Sprintf("[Parsed_volumedetect_%d @ 0x5581] n_samples: 7938000", filter),
+			fmt.Sprintf("[Parsed_volumedetect_%d @ 0x5581] mean_volume: %s dB", filter, volumes[i]),
+			fmt.Sprintf("[Parsed_volumedetect_%d @ 0x5581] max_volume: 0.0 dB", filter),
+		)
+	}
+	return strings.Join(lines, "\n")
+}
+
+func TestParseCutoff(t *testing.T) {
+	tests := []struct {
+		name    string
+		volumes []string
+		want    int
+	}{
+		{"full range", []string{"-14.2", "-30.1", "-35.0", "-40.3", "-44.8", "-49.0", "-55.2", "-62.5", "-70.1"}, 0},
+		{"128 kbit/s MP3", []string{"-14.2", "-30.1", "-35.0", "-40.3", "-52.9", "-91.0", "-91.0", "-inf", "-inf"}, 17000},
+		{"low bitrate", []string{"-12.0", "-91.0", "-inf", "-inf", "-inf", "-inf", "-inf", "-inf", "-inf"}, 11000},
+		{"silence", []string{"-inf", "-inf", "-inf", "-inf", "-inf", "-inf", "-inf", "-inf", "-inf"}, 0},
+	}
+
+	for _, tt := range tests {
+		got, err := parseCutoff(volumedetectOutput(tt.volumes...))
+		if err != nil {
+			t.Errorf("%s: %s", tt.name, err.Error())
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: cutoff = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := parseCutoff(volumedetectOutput("-14.2", "-30.1")); err == nil {
+		t.Errorf("expected an error for missing volumes")
+	}
+}
+
+func TestManager_QualityGroups(t *testing.T) {
+	probed := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	m := Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs: map[string]music.Entry{
+			"a": {ID: "a", MusicData: music.MusicData{Title: "A"}, AudioInfo: &music.AudioInfo{Codec: "mp3", Bitrate: 320, Probed: probed}},
+			"b": {ID: "b", MusicData: music.MusicData{Title: "B"}, AudioInfo: &music.AudioInfo{Codec: "opus", Bitrate: 135, Probed: probed}},
+			"c": {ID: "c", MusicData: music.MusicData{Title: "C"}, AudioInfo: &music.AudioInfo{Codec: "mp3", Bitrate: 128, Probed: probed}},
+			"d": {ID: "d", MusicData: music.MusicData{Title: "D"}, AudioInfo: &music.AudioInfo{Codec: "flac", Bitrate: 900, Lossless: true, Cutoff: 16000, Transcoded: true, Probed: probed}},
+			"e": {ID: "e", MusicData: music.MusicData{Title: "E"}, AudioInfo: &music.AudioInfo{Codec: "flac", Bitrate: 1000, Lossless: true, Probed: probed}},
+			"f": {ID: "f", MusicData: music.MusicData{Title: "F"}, AudioInfo: &music.AudioInfo{Codec: "aac", Bitrate: 96, Probed: probed}},
+			// Downloaded, but not probed yet
+			"g": {ID: "g", MusicData: music.MusicData{Title: "G"}, AudioInfo: &music.AudioInfo{SourceFormat: "251 - audio only"}},
+			"h": {ID: "h", MusicData: music.MusicData{Title: "H"}},
+		},
+	}
+
+	type group struct {
+		Title string
+		IDs   []string
+	}
+	var got []group
+	for _, g := range m.QualityGroups() {
+		var ids []string
+		for _, e := range g.Songs {
+			ids = append(ids, e.ID)
+		}
+		got = append(got, group{g.Title, ids})
+	}
+
+	want := []group{
+		{"Probably transcoded", []string{"d"}},
+		{"Below 128 kbit/s", []string{"f"}},
+		{"128 to 192 kbit/s", []string{"c", "b"}},
+		{"320 kbit/s and more", []string{"a"}},
+		{"Lossless", []string{"e"}},
+		{"Not analyzed yet", []string{"g", "h"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("QualityGroups() = %v, want %v", got, want)
+	}
+}
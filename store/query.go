@@ -0,0 +1,297 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Query is a parsed search query like `artist:queen year:1975..1980 -live`
+type Query struct {
+	// Groups are alternatives separated by OR. A song matches a group if it matches all of its terms
+	Groups [][]Term
+
+	// Sort is one of SmartSortFields. If it is empty, results are sorted by their score
+	Sort       string
+	Descending bool
+}
+
+// Term is a single condition of a query
+type Term struct {
+	// Field is empty for bare words, they are scored against title, artist and album
+	Field  string
+	Value  string
+	Negate bool
+
+	// rules are the conditions for field terms, all of them must match
+	rules []Rule
+	// desc describes the term for showing the interpreted query
+	desc string
+}
+
+// bound is used for ranges with only one end, e.g. "year:1990.."
+const bound = "1e15"
+
+// ParseQuery parses a search query. It supports
+//   - bare words and quoted phrases that are matched against title, artist and album: queen "night at the"
+//   - field qualifiers for all fields smart playlists support: artist:queen album:"greatest hits"
+//   - ranges for numbers and dates: year:1975..1980 year:>1990 rating:>=4 added:30d added:2020-01-01..2020-06-30
+//   - negation using a minus: -live -artist:queen
+//   - alternatives using OR or |: artist:queen OR artist:abba
+//   - sort directives, descending with a minus: sort:year sort:-added
+//
+// Words with an unknown field like "re:zero" are treated as bare words
+func ParseQuery(query string) (q Query, err error) {
+	tokens, err := tokenize(query)
+	if err != nil {
+		return
+	}
+
+	var group []Term
+	for i, tok := range tokens {
+		if tok == "OR" || tok == "|" {
+			if len(group) == 0 || i == len(tokens)-1 {
+				return q, fmt.Errorf("OR needs a condition on both sides")
+			}
+			q.Groups = append(q.Groups, group)
+			group = nil
+			continue
+		}
+
+		var t Term
+		if len(tok) > 1 && tok[0] == '-' {
+			t.Negate = true
+			tok = tok[1:]
+		}
+
+		colon := strings.IndexByte(tok, ':')
+		quote := strings.IndexFunc(tok, isQuote)
+		if colon > 0 && (quote < 0 || quote > colon) {
+			field := strings.ToLower(tok[:colon])
+			value := unquote(tok[colon+1:])
+
+			if field == "sort" {
+				err = q.parseSort(value, t.Negate)
+				if err != nil {
+					return
+				}
+				continue
+			}
+
+			if _, ok := ruleFields[field]; ok {
+				t.Field, t.Value = field, value
+
+				err = t.parseField()
+				if err != nil {
+					return
+				}
+
+				group = append(group, t)
+				continue
+			}
+		}
+
+		t.Value = unquote(tok)
+		if t.Value == "" {
+			continue
+		}
+		group = append(group, t)
+	}
+
+	if len(group) > 0 {
+		q.Groups = append(q.Groups, group)
+	}
+
+	return
+}
+
+// tokenize splits the query at spaces that are not in quotes
+func tokenize(query string) (tokens []string, err error) {
+	var (
+		current strings.Builder
+		quote   rune
+	)
+
+	for _, r := range query {
+		switch {
+		case quote != 0:
+			if isQuote(r) {
+				quote = 0
+			}
+			current.WriteRune(r)
+		case isQuote(r):
+			quote = r
+			current.WriteRune(r)
+		case unicode.IsSpace(r):
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("Missing closing quote in %q", current.String())
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+
+	return
+}
+
+func isQuote(r rune) bool {
+	return unicode.In(r, unicode.Quotation_Mark)
+}
+
+func unquote(s string) string {
+	return strings.TrimSpace(strings.TrimFunc(s, isQuote))
+}
+
+func (q *Query) parseSort(value string, descending bool) error {
+	if strings.HasPrefix(value, "-") {
+		descending = true
+		value = value[1:]
+	}
+	value = strings.ToLower(value)
+
+	if !containsFold(SmartSortFields, value) {
+		return fmt.Errorf("Cannot sort by %q, use one of %s", value, strings.Join(SmartSortFields, ", "))
+	}
+
+	q.Sort, q.Descending = value, descending
+	return nil
+}
+
+// parseField converts the value of a field term to rules
+func (t *Term) parseField() (err error) {
+	f, v := t.Field, t.Value
+	if v == "" {
+		return fmt.Errorf("Field %s needs a value", f)
+	}
+
+	switch ruleFields[f] {
+	case textField:
+		t.rules = []Rule{{f, "contains", v}}
+		t.desc = fmt.Sprintf("%s contains %q", f, v)
+	case boolField:
+		t.rules = []Rule{{f, "is", v}}
+		t.desc = f + " is " + v
+	case numberField:
+		t.rules, t.desc = numberRules(f, v)
+	case dateField:
+		t.rules, t.desc = dateRules(f, v)
+	}
+
+	for _, r := range t.rules {
+		if _, err = r.compile(); err != nil {
+			return fmt.Errorf("Invalid value %q for %s", v, f)
+		}
+	}
+
+	return nil
+}
+
+func numberRules(f, v string) ([]Rule, string) {
+	switch {
+	case strings.Contains(v, ".."):
+		bounds := strings.SplitN(v, "..", 2)
+		switch {
+		case bounds[0] == "":
+			return []Rule{{f, "between", "-" + bound + ".." + bounds[1]}}, f + " at most " + bounds[1]
+		case bounds[1] == "":
+			return []Rule{{f, "between", bounds[0] + ".." + bound}}, f + " at least " + bounds[0]
+		}
+		return []Rule{{f, "between", v}}, f + " between " + bounds[0] + " and " + bounds[1]
+	case strings.HasPrefix(v, ">="):
+		return numberRules(f, v[2:]+"..")
+	case strings.HasPrefix(v, "<="):
+		return numberRules(f, ".."+v[2:])
+	case strings.HasPrefix(v, ">"):
+		return []Rule{{f, "greater", v[1:]}}, f + " greater than " + v[1:]
+	case strings.HasPrefix(v, "<"):
+		return []Rule{{f, "less", v[1:]}}, f + " less than " + v[1:]
+	}
+	return []Rule{{f, "is", v}}, f + " is " + v
+}
+
+func dateRules(f, v string) ([]Rule, string) {
+	switch {
+	case strings.HasSuffix(v, "d"):
+		return []Rule{{f, "in-last", strings.TrimSuffix(v, "d")}}, f + " in the last " + strings.TrimSuffix(v, "d") + " days"
+	case strings.HasPrefix(v, ">"):
+		return []Rule{{f, "after", v[1:]}}, f + " after " + v[1:]
+	case strings.HasPrefix(v, "<"):
+		return []Rule{{f, "before", v[1:]}}, f + " before " + v[1:]
+	}
+
+	from, to := v, v
+	if bounds := strings.SplitN(v, "..", 2); len(bounds) == 2 {
+		from, to = bounds[0], bounds[1]
+	}
+
+	// Ranges include both days, but "after" and "before" don't
+	var rules []Rule
+	var desc []string
+	if from != "" {
+		rules = append(rules, Rule{f, "after", addDays(from, -1)})
+		desc = append(desc, "from "+from)
+	}
+	if to != "" {
+		rules = append(rules, Rule{f, "before", addDays(to, 1)})
+		desc = append(desc, "until "+to)
+	}
+	return rules, f + " " + strings.Join(desc, " ")
+}
+
+// addDays adds days to a date like 2020-12-31. Invalid dates are returned unchanged so compiling the rule reports them
+func addDays(date string, days int) string {
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return date
+	}
+	return t.AddDate(0, 0, days).Format("2006-01-02")
+}
+
+// String returns a description of the term
+func (t Term) String() string {
+	desc := t.desc
+	if t.Field == "" {
+		desc = fmt.Sprintf("%q", t.Value)
+	}
+
+	if t.Negate {
+		return "NOT " + desc
+	}
+	return desc
+}
+
+// String describes how the query was interpreted
+func (q Query) String() string {
+	var groups []string
+	for _, g := range q.Groups {
+		var terms []string
+		for _, t := range g {
+			terms = append(terms, t.String())
+		}
+
+		s := strings.Join(terms, " AND ")
+		if len(q.Groups) > 1 && len(g) > 1 {
+			s = "(" + s + ")"
+		}
+		groups = append(groups, s)
+	}
+
+	s := strings.Join(groups, " OR ")
+	if q.Sort != "" {
+		s += ", sorted by " + q.Sort
+		if q.Descending {
+			s += " (descending)"
+		}
+	}
+
+	return strings.TrimPrefix(s, ", ")
+}
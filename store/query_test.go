@@ -0,0 +1,90 @@
+package store
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{`queen`, `"queen"`},
+		{`artist:queen year:1975..1980 -live`, `artist contains "queen" AND year between 1975 and 1980 AND NOT "live"`},
+		{`album:"greatest hits" "a night"`, `album contains "greatest hits" AND "a night"`},
+		{`artist:queen OR artist:abba year:>1975`, `artist contains "queen" OR (artist contains "abba" AND year greater than 1975)`},
+		{`rating:>=4 -year:..1990 sort:-added`, `rating at least 4 AND NOT year at most 1990, sorted by added (descending)`},
+		{`added:30d edited:2020-01-01..`, `added in the last 30 days AND edited from 2020-01-01`},
+		{`re:zero`, `"re:zero"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			q, err := ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := q.String(); got != tt.want {
+				t.Errorf("ParseQuery(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+
+	for _, invalid := range []string{`"unclosed`, `OR queen`, `queen OR`, `year:soon`, `year:1990..x`, `added:yesterday`, `artist:`, `sort:color`} {
+		if _, err := ParseQuery(invalid); err == nil {
+			t.Errorf("expected an error for query %q", invalid)
+		}
+	}
+}
+
+func TestManager_SearchQuery(t *testing.T) {
+	year := func(y int) *int {
+		return &y
+	}
+	now := time.Now()
+
+	m := Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs: map[string]music.Entry{
+			"a": {ID: "a", Added: now.AddDate(0, 0, -3), MusicData: music.MusicData{Title: "Bohemian Rhapsody", Artist: "Queen", Album: "A Night at the Opera", Year: year(1975)}},
+			"b": {ID: "b", Added: now.AddDate(0, -2, 0), MusicData: music.MusicData{Title: "Bohemian Rhapsody (Live)", Artist: "Queen", Album: "Live Killers", Year: year(1979)}},
+			"c": {ID: "c", Added: now.AddDate(0, 0, -1), MusicData: music.MusicData{Title: "Another One Bites the Dust", Artist: "Queen", Album: "Greatest Hits", Year: year(1981)}},
+			"d": {ID: "d", Added: now.AddDate(-1, 0, 0), MusicData: music.MusicData{Title: "Waterloo", Artist: "ABBA", Album: "Greatest Hits", Year: year(1974)}},
+		},
+	}
+
+	ids := func(entries []music.Entry) (res []string) {
+		for _, e := range entries {
+			res = append(res, e.ID)
+		}
+		return
+	}
+
+	tests := []struct {
+		query   string
+		want    []string
+		wantErr bool
+	}{
+		{`artist:queen year:1975..1980 -live`, []string{"a"}, false},
+		{`album:"greatest hits"`, []string{"c", "d"}, false},
+		{`artist:abba OR year:>1980`, []string{"c", "d"}, false},
+		{`rhapsody sort:-year`, []string{"b", "a"}, false},
+		{`-artist:queen`, []string{"d"}, false},
+		{`added:7d sort:added`, []string{"a", "c"}, false},
+		{`waterloo "unclosed`, []string{"d"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, _, err := m.SearchQuery(tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SearchQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(ids(got), tt.want) {
+				t.Errorf("SearchQuery() = %v, want %v", ids(got), tt.want)
+			}
+		})
+	}
+}
@@ -3,95 +3,231 @@ package store
 import (
 	"sort"
 	"strings"
+	"time"
 	"xarantolus/sensibleHub/store/music"
 )
 
-// This doesn't really work
+// Weights of the signals used for recommendations
+const (
+	relatedOtherVersion = 50 // same title, e.g. a live or remixed version
+	relatedSameArtist   = 40
+	relatedSameAlbum    = 30 // in addition to relatedSameArtist
+	relatedFeatured     = 25 // one artist is featured in the title of the other song
+	relatedPlaylist     = 20 // per playlist both songs are in
+	relatedGenre        = 15
+	relatedPlayedNear   = 5  // per time both songs were played within playedNearWindow
+	relatedMaxYear      = 10 // for the same year, one less per year in between
+	relatedMaxPlays     = 10 // popularity, one per play of the song
+
+	relatedMaxPlaylists  = 3
+	relatedMaxPlayedNear = 4
+
+	playedNearWindow = time.Hour
+)
+
+// relatedSong contains the values of a song that are compared for recommendations
+type relatedSong struct {
+	music.Entry
+
+	title, artist, album, featured, genre string
+}
+
+func newRelatedSong(e music.Entry) relatedSong {
+	rs := relatedSong{
+		Entry:  e,
+		artist: fold(strings.TrimSpace(e.MusicData.Artist)),
+		album:  fold(CleanName(e.MusicData.Album)),
+		genre:  fold(strings.TrimSpace(e.MusicData.Genre)),
+		title:  fold(strings.TrimSpace(e.MusicData.Title)),
+	}
+
+	// Split "Title (feat. Artist)" into its parts
+	if first, last := strings.IndexByte(rs.title, '('), strings.LastIndexByte(rs.title, ')'); first != -1 && last > first {
+		rs.featured = rs.title[first:last]
+		rs.title = strings.TrimSpace(rs.title[:first])
+	}
 
-// GetRelatedSongs returns some related songs for a song
-func (m *Manager) GetRelatedSongs(e music.Entry) (out []music.Entry) {
-	if e.MusicData.Artist == "" {
-		return
+	return rs
+}
+
+// relatedContext contains information about all songs that is needed for recommendations
+type relatedContext struct {
+	songs []relatedSong
+
+	// playlists maps song IDs to the IDs of the playlists they are in
+	playlists map[string][]string
+	// plays maps song IDs to the times they were played
+	plays map[string][]time.Time
+}
+
+func (m *Manager) relatedContext() *relatedContext {
+	c := &relatedContext{
+		playlists: make(map[string][]string),
+		plays:     make(map[string][]time.Time),
 	}
 
-	e.MusicData.Artist = strings.ToUpper(CleanName(e.MusicData.Artist))
+	for _, e := range m.AllEntries() {
+		c.songs = append(c.songs, newRelatedSong(e))
+	}
 
 	m.SongsLock.RLock()
 	defer m.SongsLock.RUnlock()
 
-	type suggestion struct {
-		score int
-		s     music.Entry
+	for pid, p := range m.Playlists {
+		for _, id := range p.Songs {
+			c.playlists[id] = append(c.playlists[id], pid)
+		}
+	}
+	for _, p := range m.PlayHistory {
+		c.plays[p.ID] = append(c.plays[p.ID], p.Time)
 	}
-	var suggestions []suggestion
 
-	for _, s := range m.Songs {
-		if s.ID == e.ID {
-			continue
-		}
-		var sc, mult int = 0, 2
+	return c
+}
 
-		// Is there a song with the same title? definitely show it
-		if strings.EqualFold(CleanName(s.MusicData.Title), CleanName(e.MusicData.Title)) {
-			sc += 10000
-		}
+// score returns how related `s` is to `seed`. Songs without any relation have a score of 0
+func (c *relatedContext) score(seed, s relatedSong) (sc int) {
+	if seed.ID == s.ID {
+		return 0
+	}
 
-		if !strings.EqualFold(strings.ToUpper(CleanName(s.MusicData.Artist)), e.MusicData.Artist) {
-			ti := strings.ToUpper(CleanName(s.MusicData.Title))
+	if seed.title != "" && seed.title == s.title {
+		sc += relatedOtherVersion
+	}
 
-			firstBracket, lastBracket := strings.IndexByte(ti, '('), strings.LastIndexByte(ti, ')')
+	if seed.artist != "" {
+		if seed.artist == s.artist {
+			sc += relatedSameArtist
 
-			// find the "feat." part in brackets:
-			if firstBracket != -1 && lastBracket != -1 {
-				// Both are uppercase, check if we can find the artist in there
-				if strings.Contains(ti[firstBracket:lastBracket], e.MusicData.Artist) {
-					sc += 25
-					mult = 3
-				}
+			if seed.album != "" && seed.album == s.album {
+				sc += relatedSameAlbum
 			}
+		} else if strings.Contains(s.featured, seed.artist) || (s.artist != "" && strings.Contains(seed.featured, s.artist)) {
+			sc += relatedFeatured
+		}
+	}
 
-			cleanedET := CleanName(e.MusicData.Title)
-			if b := strings.IndexByte(cleanedET, '('); b != -1 {
-				cleanedET = strings.TrimSpace(cleanedET[:b])
-			}
-			cleanedST := CleanName(s.MusicData.Title)
-			if b := strings.IndexByte(cleanedST, '('); b != -1 {
-				cleanedST = strings.TrimSpace(cleanedST[:b])
+	var shared int
+	for _, a := range c.playlists[seed.ID] {
+		for _, b := range c.playlists[s.ID] {
+			if a == b && shared < relatedMaxPlaylists {
+				shared++
 			}
+		}
+	}
+	sc += shared * relatedPlaylist
+
+	if seed.genre != "" && seed.genre == s.genre {
+		sc += relatedGenre
+	}
 
-			// It's also the same title, but one of them has an (feat. abcd) somewhere in there
-			if strings.EqualFold(cleanedST, cleanedET) {
-				sc += 10000
+	var near int
+	for _, a := range c.plays[seed.ID] {
+		for _, b := range c.plays[s.ID] {
+			if d := a.Sub(b); d < playedNearWindow && d > -playedNearWindow && near < relatedMaxPlayedNear {
+				near++
 			}
 		}
+	}
+	sc += near * relatedPlayedNear
 
-		sc += score(strings.Fields(strings.ToUpper(s.MusicData.Title)), e.MusicData.Title, 2)
-		sc += score(strings.Fields(strings.ToUpper(s.MusicData.Album)), e.MusicData.Album, 1)
-		sc += score(strings.Fields(strings.ToUpper(s.MusicData.Artist)), e.MusicData.Artist, 2)
+	// Year and popularity only decide between songs that are related in some other way
+	if sc == 0 {
+		return 0
+	}
 
-		// If add songs with the same artist as songs with a score of 0. Artists not featured anywhere will also get some similar songs then
-		if sc > 0 {
-			suggestions = append(suggestions, suggestion{sc * mult, s})
+	if seed.MusicData.Year != nil && s.MusicData.Year != nil {
+		d := *seed.MusicData.Year - *s.MusicData.Year
+		if d < 0 {
+			d = -d
+		}
+		if d < relatedMaxYear {
+			sc += relatedMaxYear - d
 		}
 	}
 
-	if len(suggestions) == 0 {
-		return
+	sc += min(len(c.plays[s.ID]), relatedMaxPlays)
+
+	return sc
+}
+
+// GetRelatedSongs returns up to `count` songs that are related to `e`, the most related first.
+// Songs are related if they are other versions of the same song, have the same artist, album or genre,
+// feature each other, are in the same playlists or were played around the same time.
+// Songs from a similar year and songs that are played more often are preferred
+func (m *Manager) GetRelatedSongs(e music.Entry, count int) (out []music.Entry) {
+	c := m.relatedContext()
+	seed := newRelatedSong(e)
+
+	type suggestion struct {
+		score int
+		s     music.Entry
+	}
+	var suggestions []suggestion
+
+	// The songs are sorted by title, so songs with the same score stay in that order
+	for _, s := range c.songs {
+		if sc := c.score(seed, s); sc > 0 {
+			suggestions = append(suggestions, suggestion{sc, s.Entry})
+		}
 	}
 
-	sort.Slice(suggestions, func(i, j int) bool {
+	sort.SliceStable(suggestions, func(i, j int) bool {
 		return suggestions[i].score > suggestions[j].score
 	})
 
-	// Return one
-	if len(suggestions) < 2 {
-		return []music.Entry{suggestions[0].s}
+	for i := 0; i < len(suggestions) && i < count; i++ {
+		out = append(out, suggestions[i].s)
 	}
 
-	if len(suggestions) < 3 {
-		return []music.Entry{suggestions[0].s, suggestions[1].s}
+	return
+}
+
+// Radio returns a queue of up to `size` songs that starts with `e`. Every following song is related to the
+// previous one and to `e`, so the queue slowly drifts away from the first song. The same artist is not
+// played more than twice in a row. The queue is shorter if there are no more related songs
+func (m *Manager) Radio(e music.Entry, size int) (queue []music.Entry) {
+	if size <= 0 {
+		return nil
+	}
+
+	c := m.relatedContext()
+	seed := newRelatedSong(e)
+
+	queue = append(queue, e)
+	used := map[string]bool{e.ID: true}
+
+	last := []relatedSong{seed}
+	for len(queue) < size {
+		prev := last[len(last)-1]
+
+		var (
+			best      relatedSong
+			bestScore int
+		)
+		for _, s := range c.songs {
+			if used[s.ID] {
+				continue
+			}
+
+			sc := c.score(prev, s) + c.score(seed, s)/2
+			if len(last) >= 2 && s.artist != "" && s.artist == prev.artist && s.artist == last[len(last)-2].artist {
+				sc /= 4
+			}
+
+			if sc > bestScore {
+				best, bestScore = s, sc
+			}
+		}
+
+		if bestScore == 0 {
+			break
+		}
+
+		queue = append(queue, best.Entry)
+		used[best.ID] = true
+		last = append(last, best)
 	}
 
-	// Or two items
-	return []music.Entry{suggestions[0].s, suggestions[1].s, suggestions[2].s}
+	return
 }
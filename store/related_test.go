@@ -0,0 +1,62 @@
+package store
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func TestManager_GetRelatedSongs(t *testing.T) {
+	year := func(y int) *int {
+		return &y
+	}
+	played := time.Date(2020, 5, 1, 20, 0, 0, 0, time.UTC)
+
+	m := Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs: map[string]music.Entry{
+			"a": {ID: "a", MusicData: music.MusicData{Title: "Bohemian Rhapsody", Artist: "Queen", Album: "A Night at the Opera", Year: year(1975), Genre: "Rock"}},
+			"b": {ID: "b", MusicData: music.MusicData{Title: "Love of My Life", Artist: "Queen", Album: "A Night at the Opera", Year: year(1975)}},
+			"c": {ID: "c", MusicData: music.MusicData{Title: "Bohemian Rhapsody (Live)", Artist: "Queen", Album: "Live Killers", Year: year(1979)}},
+			"d": {ID: "d", MusicData: music.MusicData{Title: "Under Pressure (feat. Queen)", Artist: "David Bowie", Year: year(1981)}},
+			"e": {ID: "e", MusicData: music.MusicData{Title: "Heroes", Artist: "David Bowie", Year: year(1977), Genre: "Rock"}},
+			"f": {ID: "f", MusicData: music.MusicData{Title: "Dancing Queen", Artist: "ABBA", Year: year(1976)}},
+			"g": {ID: "g", MusicData: music.MusicData{Title: "Wonderwall", Artist: "Oasis", Year: year(1995), Genre: "rock"}},
+		},
+		Playlists: map[string]Playlist{
+			"p": {ID: "p", Name: "Evening", Songs: []string{"a", "g"}},
+		},
+		PlayHistory: []Play{
+			{ID: "a", Time: played},
+			{ID: "g", Time: played.Add(10 * time.Minute)},
+		},
+	}
+
+	ids := func(entries []music.Entry) (res []string) {
+		for _, e := range entries {
+			res = append(res, e.ID)
+		}
+		return
+	}
+
+	// Other version > same album > playlist, genre and plays > featured > genre
+	if got, want := ids(m.GetRelatedSongs(m.Songs["a"], 10)), []string{"c", "b", "g", "d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetRelatedSongs() = %v, want %v", got, want)
+	}
+	if got, want := ids(m.GetRelatedSongs(m.Songs["a"], 2)), []string{"c", "b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetRelatedSongs() with count 2 = %v, want %v", got, want)
+	}
+	if got := m.GetRelatedSongs(m.Songs["f"], 10); len(got) != 0 {
+		t.Errorf("GetRelatedSongs() for an unrelated song = %v, want nothing", ids(got))
+	}
+
+	// Queen is not played three times in a row, so "b" has to wait
+	if got, want := ids(m.Radio(m.Songs["a"], 10)), []string{"a", "c", "d", "b", "g", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Radio() = %v, want %v", got, want)
+	}
+	if got, want := ids(m.Radio(m.Songs["a"], 3)), []string{"a", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Radio() with size 3 = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,265 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"xarantolus/sensibleHub/store/file"
+	"xarantolus/sensibleHub/store/music"
+)
+
+// replaceMaxDurationChange is the number of seconds the duration of a new audio file may differ from
+// the old one before start and end times are reset, as they likely don't fit the new audio
+const replaceMaxDurationChange = 5
+
+// ReplacedSource describes the audio file a song had before it was replaced
+type ReplacedSource struct {
+	SourceURL string `json:"source_url"`
+	// Filename is the name of the old audio file, which is kept in the song directory
+	Filename string  `json:"filename"`
+	Size     int64   `json:"size"`
+	Duration float64 `json:"duration"`
+	// Bitrate is in kbit/s
+	Bitrate int `json:"bitrate"`
+
+	Replaced time.Time `json:"replaced"`
+}
+
+// replacementAudio is a new audio file for an existing song
+type replacementAudio struct {
+	path     string
+	size     int64
+	duration float64
+
+	sourceURL string
+	// metaPath is the path of the youtube-dl info file. It is empty for uploads
+	metaPath string
+	// sourceFormat is the format selected by youtube-dl
+	sourceFormat string
+
+	// fingerprint might be nil if fingerprinting is disabled
+	fingerprint []uint32
+}
+
+// EnqueueReplacement adds a link to the download queue. After downloading, its audio replaces
+// the audio of the song with the given `id`, while metadata, cover and ID stay the same
+func (m *Manager) EnqueueReplacement(id, u string) (err error) {
+	if _, ok := m.GetEntry(id); !ok {
+		return fmt.Errorf("Cannot replace audio of entry with id %s as it doesn't exist", id)
+	}
+
+	_, err = url.ParseRequestURI(strings.TrimSpace(u))
+	if err != nil {
+		return fmt.Errorf("Need a link to the new audio, search terms are not supported")
+	}
+
+	return m.enqueue(downloadRequest{URL: strings.TrimSpace(u), ReplaceID: id})
+}
+
+// ReplaceAudioFile replaces the audio of the song with the given `id` with an uploaded file.
+// `filename` is the original name of the file, it is used for determining its format
+func (m *Manager) ReplaceAudioFile(id, filename string, audio io.Reader) (err error) {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if !musicExtensions[strings.TrimPrefix(ext, ".")] {
+		return fmt.Errorf("%q doesn't seem to be an audio file", filename)
+	}
+
+	if _, ok := m.GetEntry(id); !ok {
+		return fmt.Errorf("Cannot replace audio of entry with id %s as it doesn't exist", id)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "shub")
+	if err != nil {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	audioPath := filepath.Join(tmpDir, "upload"+ext)
+
+	f, err := os.Create(audioPath)
+	if err != nil {
+		return
+	}
+
+	size, err := io.Copy(f, audio)
+	if err != nil {
+		_ = f.Close()
+		return
+	}
+
+	err = f.Close()
+	if err != nil {
+		return
+	}
+
+	dur, err := m.getAudioDuration(audioPath)
+	if err != nil {
+		return fmt.Errorf("cannot get audio duration: %w", err)
+	}
+	if dur < 1 {
+		return fmt.Errorf("invalid audio (%s): duration too short", filename)
+	}
+
+	a := replacementAudio{
+		path:      audioPath,
+		size:      size,
+		duration:  dur,
+		sourceURL: "Import",
+	}
+
+	if m.cfg.Alternatives.Fpcalc != "" {
+		a.fingerprint, _, err = m.calculateFingerprint(audioPath)
+		if err != nil {
+			log.Printf("[Replace] Error while fingerprinting %s: %s\n", filename, err.Error())
+		}
+	}
+
+	return m.replaceAudio(id, a)
+}
+
+// replaceAudio moves the new audio file into the directory of the song with the given `id` and records the old source.
+// Start and end times are reset if the duration changed a lot
+func (m *Manager) replaceAudio(id string, a replacementAudio) (err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	e, ok := m.Songs[id]
+	if !ok {
+		return fmt.Errorf("Entry with id %s was deleted before its audio could be replaced", id)
+	}
+	old := e
+
+	now := time.Now()
+
+	// The old file is kept under a new name, that way the new one can have the same name
+	// and the old one can be restored manually if the replacement turns out to be worse
+	replacedFilename := fmt.Sprintf("replaced-%d%s", now.UnixNano(), filepath.Ext(old.FileData.Filename))
+	replacedPath := filepath.Join(e.DirPath(), replacedFilename)
+
+	err = os.Rename(old.AudioPath(), replacedPath)
+	if err != nil {
+		return
+	}
+
+	newFilename := "original" + strings.ToLower(filepath.Ext(a.path))
+
+	err = file.Move(a.path, filepath.Join(e.DirPath(), newFilename))
+	if err != nil {
+		if rerr := os.Rename(replacedPath, old.AudioPath()); rerr != nil {
+			log.Printf("[Replace] Error while restoring the old audio of %s: %s\n", id, rerr.Error())
+		}
+		return
+	}
+
+	// The audio has already been replaced at this point, so a missing info file is not a reason to stop
+	if a.metaPath != "" {
+		if merr := file.Move(a.metaPath, filepath.Join(e.DirPath(), "info.json")); merr != nil {
+			log.Printf("[Replace] Error while moving info file of %s: %s\n", id, merr.Error())
+		} else {
+			e.MetaFile.Filename = "info.json"
+		}
+	}
+
+	if m.SourceHistory == nil {
+		m.SourceHistory = make(map[string][]ReplacedSource)
+	}
+	m.SourceHistory[id] = append(m.SourceHistory[id], ReplacedSource{
+		SourceURL: old.SourceURL,
+		Filename:  replacedFilename,
+		Size:      old.FileData.Size,
+		Duration:  old.MusicData.Duration,
+		Bitrate:   old.Bitrate(),
+		Replaced:  now,
+	})
+
+	e.SourceURL = a.sourceURL
+	e.FileData = music.FileData{
+		Filename: newFilename,
+		Size:     a.size,
+	}
+
+	resetTrim := math.Abs(a.duration-old.MusicData.Duration) > replaceMaxDurationChange
+	if resetTrim {
+		e.AudioSettings.Start = -1
+		e.AudioSettings.End = -1
+	} else if e.AudioSettings.End > a.duration {
+		e.AudioSettings.End = -1
+	}
+	if e.AudioSettings.Start >= a.duration {
+		e.AudioSettings.Start = -1
+	}
+	e.TrimSuggestion = nil
+	e.MusicData.Duration = a.duration
+
+	// Quality and fingerprint belong to the old file
+	e.AudioInfo = nil
+	if a.sourceFormat != "" {
+		e.AudioInfo = &music.AudioInfo{
+			SourceFormat: a.sourceFormat,
+		}
+	}
+
+	if e.Fingerprint != nil {
+		_ = os.Remove(old.FingerprintPath())
+		e.Fingerprint = nil
+	}
+	if a.fingerprint != nil && writeFingerprint(filepath.Join(e.DirPath(), fingerprintFilename), a.fingerprint) == nil {
+		e.Fingerprint = &music.Fingerprint{
+			Filename:   fingerprintFilename,
+			Duration:   a.duration,
+			Calculated: now,
+		}
+	}
+
+	e.LastEdit = now
+	m.Songs[id] = e
+
+	err = m.Save(false)
+	if err != nil {
+		return
+	}
+
+	m.event("song-edit", map[string]interface{}{
+		"id":   id,
+		"song": e,
+	})
+
+	log.Printf("[Replace] Replaced audio of %s\n", e.SongName())
+
+	// The new audio needs to be analyzed again, which happens in the background
+	go func() {
+		err := m.AnalyzeTrim(id)
+		if err != nil {
+			log.Printf("[Replace] Error while analyzing trim points for %s: %s\n", id, err.Error())
+		}
+
+		err = m.ProbeEntry(id)
+		if err != nil {
+			log.Printf("[Replace] Error while probing audio quality of %s: %s\n", id, err.Error())
+		}
+
+		if a.fingerprint == nil && m.cfg.Alternatives.Fpcalc != "" {
+			err = m.FingerprintEntry(id)
+			if err != nil {
+				log.Printf("[Replace] Error while fingerprinting %s: %s\n", id, err.Error())
+			}
+		}
+	}()
+
+	return nil
+}
+
+// GetSourceHistory returns the sources the song with the given `id` had before its audio was replaced, oldest first
+func (m *Manager) GetSourceHistory(id string) []ReplacedSource {
+	m.SongsLock.RLock()
+	defer m.SongsLock.RUnlock()
+
+	return append([]ReplacedSource(nil), m.SourceHistory[id]...)
+}
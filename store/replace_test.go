@@ -0,0 +1,155 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func TestManager_replaceAudio(t *testing.T) {
+	// The audio files are moved into the data directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	year := 1975
+	m := Manager{
+		SongsLock:    new(sync.RWMutex),
+		enqueuedURLs: make(chan downloadRequest, 25),
+		Songs: map[string]music.Entry{
+			"a": {
+				ID:            "a",
+				SourceURL:     "https://www.youtube.com/watch?v=old",
+				FileData:      music.FileData{Filename: "original.m4a", Size: 3 << 20},
+				AudioSettings: music.AudioSettings{Start: 12.5, End: 190, FadeIn: 2},
+				AudioInfo:     &music.AudioInfo{Codec: "aac", Bitrate: 128},
+				MusicData:     music.MusicData{Title: "Bohemian Rhapsody", Artist: "Queen", Year: &year, Duration: 200},
+				PictureData:   music.PictureData{Filename: "cover.jpg", Size: 600},
+			},
+		},
+		Playlists: map[string]Playlist{
+			"p": {ID: "p", Name: "Queen", Songs: []string{"a"}},
+		},
+	}
+
+	e := m.Songs["a"]
+	err = os.MkdirAll(e.DirPath(), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(e.AudioPath(), []byte("old audio"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newAudio := func(name string) string {
+		p := filepath.Join(t.TempDir(), name)
+		if err := os.WriteFile(p, []byte("new audio"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	// A slightly longer upload keeps the trim points
+	err = m.replaceAudio("a", replacementAudio{path: newAudio("upload.flac"), size: 30 << 20, duration: 202, sourceURL: "Import"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e = m.Songs["a"]
+	if e.FileData.Filename != "original.flac" || e.FileData.Size != 30<<20 || e.MusicData.Duration != 202 || e.SourceURL != "Import" {
+		t.Errorf("audio wasn't replaced: %+v, %+v, %s", e.FileData, e.MusicData, e.SourceURL)
+	}
+	if _, err := os.Stat(filepath.Join(e.DirPath(), "original.m4a")); !os.IsNotExist(err) {
+		t.Errorf("old audio file still exists under its old name")
+	}
+	if data, err := os.ReadFile(e.AudioPath()); err != nil || string(data) != "new audio" {
+		t.Errorf("new audio file wasn't moved: %v", err)
+	}
+	if e.AudioSettings.Start != 12.5 || e.AudioSettings.End != 190 || e.AudioSettings.FadeIn != 2 {
+		t.Errorf("audio settings should be kept for a similar duration, got %+v", e.AudioSettings)
+	}
+	if e.AudioInfo != nil {
+		t.Errorf("audio info of the old file should be removed")
+	}
+	if e.MusicData.Title != "Bohemian Rhapsody" || e.MusicData.Year == nil || e.PictureData.Filename != "cover.jpg" || m.Playlists["p"].Songs[0] != "a" {
+		t.Errorf("metadata, cover or playlists were changed")
+	}
+
+	// A download that is a lot shorter resets them
+	err = m.replaceAudio("a", replacementAudio{path: newAudio("song.opus"), size: 4 << 20, duration: 185, sourceURL: "https://www.youtube.com/watch?v=new", sourceFormat: "251 - audio only (medium)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e = m.Songs["a"]
+	if e.AudioSettings.Start != -1 || e.AudioSettings.End != -1 {
+		t.Errorf("start and end should be reset after a large duration change, got %+v", e.AudioSettings)
+	}
+	if e.AudioInfo == nil || e.AudioInfo.SourceFormat != "251 - audio only (medium)" {
+		t.Errorf("download format wasn't recorded: %+v", e.AudioInfo)
+	}
+
+	history := m.GetSourceHistory("a")
+	if len(history) != 2 {
+		t.Fatalf("expected two previous sources, got %d", len(history))
+	}
+	if h := history[0]; h.SourceURL != "https://www.youtube.com/watch?v=old" || !strings.HasSuffix(h.Filename, ".m4a") || h.Duration != 200 || h.Bitrate != 128 {
+		t.Errorf("first previous source is %+v", h)
+	}
+	if h := history[1]; h.SourceURL != "Import" || !strings.HasSuffix(h.Filename, ".flac") {
+		t.Errorf("second previous source is %+v", h)
+	}
+	// Old files are kept next to the new one
+	for i, h := range history {
+		if data, err := os.ReadFile(filepath.Join(e.DirPath(), h.Filename)); err != nil || !strings.HasPrefix(h.Filename, "replaced-") {
+			t.Errorf("file of previous source %d wasn't kept: %v", i, err)
+		} else if want := []string{"old audio", "new audio"}[i]; string(data) != want {
+			t.Errorf("file of previous source %d contains %q, want %q", i, data, want)
+		}
+	}
+
+	// Replacing a file with one of the same name keeps the old one if moving the new one fails
+	err = m.replaceAudio("a", replacementAudio{path: filepath.Join(t.TempDir(), "missing.opus"), duration: 185})
+	if err == nil {
+		t.Fatalf("expected an error for a missing file")
+	}
+	e = m.Songs["a"]
+	if data, err := os.ReadFile(e.AudioPath()); err != nil || string(data) != "new audio" {
+		t.Errorf("audio file was lost after a failed replacement: %v", err)
+	}
+	if len(m.GetSourceHistory("a")) != 2 {
+		t.Errorf("failed replacement was recorded")
+	}
+
+	if err := m.replaceAudio("unknown", replacementAudio{path: newAudio("x.mp3"), duration: 100}); err == nil {
+		t.Errorf("expected an error when replacing the audio of an unknown song")
+	}
+
+	if err := m.EnqueueReplacement("unknown", "https://example.com/song"); err == nil {
+		t.Errorf("expected an error when enqueueing a replacement for an unknown song")
+	}
+	if err := m.EnqueueReplacement("a", "queen bohemian rhapsody"); err == nil {
+		t.Errorf("expected an error for a search term")
+	}
+	if err := m.EnqueueReplacement("a", "https://example.com/song"); err != nil {
+		t.Fatal(err)
+	}
+	if req := <-m.enqueuedURLs; req.URL != "https://example.com/song" || req.ReplaceID != "a" {
+		t.Errorf("enqueued request is %+v", req)
+	}
+
+	m.forgetEntry("a")
+	if len(m.GetSourceHistory("a")) != 0 {
+		t.Errorf("history of deleted songs should be removed")
+	}
+}
@@ -0,0 +1,325 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"xarantolus/sensibleHub/store/music"
+)
+
+// RewriteRule changes the metadata of downloaded songs, e.g. "title replace \s*\(Official Video\)" removes
+// that text from titles. Rules run after the metadata from youtube-dl and iTunes was set
+type RewriteRule struct {
+	// Field is the field the pattern is matched against, one of rewriteFields
+	Field string `json:"field"`
+
+	// Action is "replace", which replaces all matches in Field with Value, or "set-" or "clear-" followed by the
+	// field that is set to Value or cleared if the pattern matches. "set" and "clear" change Field itself
+	Action string `json:"action"`
+
+	// Pattern is a case-insensitive regular expression
+	Pattern string `json:"pattern"`
+
+	// Value can contain groups of the match like $1 and the fields of the song like {artist}
+	Value string `json:"value,omitempty"`
+}
+
+// rewriteValues contains the fields rules can use. Only the entry fields can be changed
+type rewriteValues struct {
+	e *music.Entry
+
+	// uploader is the name of the channel the song was downloaded from
+	uploader string
+}
+
+// rewriteFields maps the fields rewrite rules can match to the values, targets is the subset that can be changed
+var (
+	rewriteFields = map[string]func(v rewriteValues) *string{
+		"title":    func(v rewriteValues) *string { return &v.e.MusicData.Title },
+		"artist":   func(v rewriteValues) *string { return &v.e.MusicData.Artist },
+		"album":    func(v rewriteValues) *string { return &v.e.MusicData.Album },
+		"genre":    func(v rewriteValues) *string { return &v.e.MusicData.Genre },
+		"uploader": func(v rewriteValues) *string { return &v.uploader },
+		"source":   func(v rewriteValues) *string { return &v.e.SourceURL },
+	}
+	rewriteTargets = map[string]bool{
+		"title":  true,
+		"artist": true,
+		"album":  true,
+		"genre":  true,
+	}
+)
+
+// rewriteLineRegex splits a rule into field, action and the pattern with its value
+var rewriteLineRegex = regexp.MustCompile(`^(\S+)\s+(\S+)\s+(.+)$`)
+
+type rewriter func(v rewriteValues)
+
+func (r RewriteRule) String() string {
+	s := r.Field + " " + r.Action + " " + r.Pattern
+	if r.Value != "" {
+		s += " => " + r.Value
+	}
+	return s
+}
+
+// target returns the field that is changed by the rule and the action without the field
+func (r RewriteRule) target() (action, field string) {
+	action, field, ok := strings.Cut(r.Action, "-")
+	if !ok {
+		field = r.Field
+	}
+	return action, field
+}
+
+// compile checks the rule and returns a function that applies it
+func (r RewriteRule) compile() (rewriter, error) {
+	if _, ok := rewriteFields[r.Field]; !ok {
+		return nil, fmt.Errorf("Unknown field %q in rule %q", r.Field, r.String())
+	}
+
+	action, target := r.target()
+	if !rewriteTargets[target] {
+		return nil, fmt.Errorf("Rule %q cannot change %q, only title, artist, album and genre can be changed", r.String(), target)
+	}
+
+	switch action {
+	case "replace":
+		if target != r.Field || strings.Contains(r.Action, "-") {
+			return nil, fmt.Errorf("Rule %q can only replace text in the field it matches", r.String())
+		}
+	case "set":
+		if r.Value == "" {
+			return nil, fmt.Errorf("Rule %q needs a value after \"=>\", use clear to remove a value", r.String())
+		}
+	case "clear":
+		if r.Value != "" {
+			return nil, fmt.Errorf("Rule %q clears a field and cannot have a value", r.String())
+		}
+	default:
+		return nil, fmt.Errorf("Unknown action %q in rule %q, must be replace, set or clear", r.Action, r.String())
+	}
+
+	if r.Pattern == "" {
+		return nil, fmt.Errorf("Rule %q needs a pattern", r.String())
+	}
+	re, err := regexp.Compile("(?i)" + r.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid regular expression in rule %q: %s", r.String(), err.Error())
+	}
+
+	// expand returns the value for a match, replacing groups and fields
+	expand := func(v rewriteValues, src string, match []int) string {
+		value := string(re.ExpandString(nil, r.Value, src, match))
+		if !strings.Contains(value, "{") {
+			return value
+		}
+
+		var oldnew []string
+		for name, f := range rewriteFields {
+			oldnew = append(oldnew, "{"+name+"}", *f(v))
+		}
+		return strings.NewReplacer(oldnew...).Replace(value)
+	}
+
+	field, changed := rewriteFields[r.Field], rewriteFields[target]
+
+	return func(v rewriteValues) {
+		src := *field(v)
+
+		if action == "replace" {
+			matches := re.FindAllStringSubmatchIndex(src, -1)
+			if matches == nil {
+				return
+			}
+
+			var (
+				sb   strings.Builder
+				last int
+			)
+			for _, match := range matches {
+				sb.WriteString(src[last:match[0]])
+				sb.WriteString(expand(v, src, match))
+				last = match[1]
+			}
+			sb.WriteString(src[last:])
+
+			*changed(v) = strings.TrimSpace(sb.String())
+			return
+		}
+
+		match := re.FindStringSubmatchIndex(src)
+		if match == nil {
+			return
+		}
+
+		if action == "set" {
+			*changed(v) = strings.TrimSpace(expand(v, src, match))
+		} else {
+			*changed(v) = ""
+		}
+	}, nil
+}
+
+// ParseRewriteRules parses one rule per line, each in the form "field action pattern => value", e.g.
+// "uploader set-artist ^(.+)VEVO$ => $1". The value is optional. Empty lines are ignored
+func ParseRewriteRules(text string) (rules []RewriteRule, err error) {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		words := rewriteLineRegex.FindStringSubmatch(line)
+		if words == nil {
+			return nil, fmt.Errorf("Rule %q needs a field, an action and a pattern", line)
+		}
+
+		r := RewriteRule{
+			Field:   strings.ToLower(words[1]),
+			Action:  strings.ToLower(words[2]),
+			Pattern: words[3],
+		}
+		if pattern, value, ok := strings.Cut(" "+r.Pattern, " =>"); ok {
+			r.Pattern, r.Value = strings.TrimSpace(pattern), strings.TrimSpace(value)
+		}
+
+		_, err = r.compile()
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, r)
+	}
+
+	return
+}
+
+// RewriteRulesText returns the rules, one per line
+func RewriteRulesText(rules []RewriteRule) string {
+	var lines []string
+	for _, r := range rules {
+		lines = append(lines, r.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// compileRewriteRules returns a function that applies all rules in order. A song always keeps a title,
+// if the rules remove it the title from before is kept
+func compileRewriteRules(rules []RewriteRule) (f func(e *music.Entry, uploader string), usesUploader bool, err error) {
+	var rewriters []rewriter
+	for _, r := range rules {
+		rw, err := r.compile()
+		if err != nil {
+			return nil, false, err
+		}
+		rewriters = append(rewriters, rw)
+
+		usesUploader = usesUploader || r.Field == "uploader" || strings.Contains(r.Value, "{uploader}")
+	}
+
+	return func(e *music.Entry, uploader string) {
+		title := e.MusicData.Title
+
+		v := rewriteValues{e: e, uploader: uploader}
+		for _, rw := range rewriters {
+			rw(v)
+		}
+
+		if e.MusicData.Title == "" {
+			e.MusicData.Title = title
+		}
+	}, usesUploader, nil
+}
+
+// RewriteRules returns the rules that are applied to new downloads
+func (m *Manager) RewriteRules() []RewriteRule {
+	m.SongsLock.RLock()
+	defer m.SongsLock.RUnlock()
+
+	return append([]RewriteRule{}, m.Rewrites...)
+}
+
+// SetRewriteRules replaces the rules that are applied to new downloads. Songs that were already added stay the same
+func (m *Manager) SetRewriteRules(rules []RewriteRule) (err error) {
+	_, _, err = compileRewriteRules(rules)
+	if err != nil {
+		return
+	}
+
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	m.Rewrites = rules
+
+	return m.Save(false)
+}
+
+// rewrite applies the rewrite rules to a new song. The rules were checked when they were set
+func (m *Manager) rewrite(e *music.Entry, uploader string) {
+	f, _, err := compileRewriteRules(m.RewriteRules())
+	if err == nil {
+		f(e, uploader)
+	}
+}
+
+// rewriteChanges returns the changes `rules` would make to existing songs.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) rewriteChanges(rules []RewriteRule) (changes []BulkChange, err error) {
+	f, usesUploader, err := compileRewriteRules(rules)
+	if err != nil {
+		return
+	}
+
+	var songs []music.Entry
+	for _, e := range m.Songs {
+		songs = append(songs, e)
+	}
+	sort.Slice(songs, func(i, j int) bool {
+		return strings.ToUpper(songs[i].MusicData.Title) < strings.ToUpper(songs[j].MusicData.Title)
+	})
+
+	for _, e := range songs {
+		// The uploader is only stored in the info file from youtube-dl
+		var uploader string
+		if usesUploader && e.MetaFile.Filename != "" {
+			minfo, err := readInfoFile(filepath.Join(e.DirPath(), e.MetaFile.Filename))
+			if err == nil {
+				uploader = minfo.Uploader
+			}
+		}
+
+		edited := e
+		f(&edited, uploader)
+
+		if fields := fieldChanges(e, edited); len(fields) > 0 {
+			changes = append(changes, BulkChange{Song: edited, Fields: fields})
+		}
+	}
+
+	return
+}
+
+// PreviewRewriteRules returns the changes ApplyRewriteRules would make without applying them
+func (m *Manager) PreviewRewriteRules(rules []RewriteRule) (changes []BulkChange, err error) {
+	m.SongsLock.RLock()
+	defer m.SongsLock.RUnlock()
+
+	return m.rewriteChanges(rules)
+}
+
+// ApplyRewriteRules applies `rules` to all songs that were already added.
+// All changes are sent in one "songs-edit" event
+func (m *Manager) ApplyRewriteRules(rules []RewriteRule) (changes []BulkChange, err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	changes, err = m.rewriteChanges(rules)
+	if err != nil || len(changes) == 0 {
+		return
+	}
+
+	return changes, m.applyChanges(changes)
+}
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func TestParseRewriteRules(t *testing.T) {
+	rules, err := ParseRewriteRules(`
+title replace \s*[\(\[](Official (Music )?Video|HD|Lyrics)[\)\]]
+uploader  set-artist   ^(.+?)\s*VEVO$ => $1
+title set-artist \(feat\. ([^)]+)\) => {artist}, $1
+album clear (?i)auto generated
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("expected 4 rules, got %+v", rules)
+	}
+	if r := rules[1]; r.Field != "uploader" || r.Action != "set-artist" || r.Pattern != `^(.+?)\s*VEVO$` || r.Value != "$1" {
+		t.Errorf("unexpected rule %+v", r)
+	}
+
+	// Rules can be parsed again from their text
+	again, err := ParseRewriteRules(RewriteRulesText(rules))
+	if err != nil || len(again) != len(rules) || again[2] != rules[2] {
+		t.Errorf("rules changed after formatting them: %+v, %v", again, err)
+	}
+
+	for _, invalid := range []string{
+		"title replace",
+		"year replace 1",
+		"title remove x",
+		"title set-uploader x => y",
+		"title replace-artist x => y",
+		"title set x",
+		"title clear x => y",
+		"title set => x",
+		"title replace (",
+	} {
+		if _, err := ParseRewriteRules(invalid); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func Test_compileRewriteRules(t *testing.T) {
+	rules, err := ParseRewriteRules(`
+title replace \s*[\(\[](Official (Music )?Video|HD|Lyrics)[\)\]]
+uploader set-artist ^(.+?)\s*VEVO$ => $1
+title set-artist \(feat\. ([^)]+)\) => {artist}, $1
+title replace \s*\(feat\. [^)]+\)
+title replace ^Intro$
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, usesUploader, err := compileRewriteRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !usesUploader {
+		t.Errorf("rules use the uploader")
+	}
+
+	tests := []struct {
+		title, artist, uploader string
+		wantTitle, wantArtist   string
+	}{
+		{"Song (Official Video) [HD]", "Artist", "", "Song", "Artist"},
+		{"Song (official music video)", "Artist", "", "Song", "Artist"},
+		{"Song", "", "QueenVEVO", "Song", "Queen"},
+		{"Song (feat. Someone) (Lyrics)", "Artist", "", "Song", "Artist, Someone"},
+		{"Song (Video)", "Artist", "Artist - Topic", "Song (Video)", "Artist"},
+		// Songs keep their title
+		{"Intro", "Artist", "", "Intro", "Artist"},
+	}
+	for _, tt := range tests {
+		e := music.Entry{MusicData: music.MusicData{Title: tt.title, Artist: tt.artist}}
+		f(&e, tt.uploader)
+
+		if e.MusicData.Title != tt.wantTitle || e.MusicData.Artist != tt.wantArtist {
+			t.Errorf("rewriting %q by %q (%q) gave %q by %q, want %q by %q", tt.title, tt.artist, tt.uploader,
+				e.MusicData.Title, e.MusicData.Artist, tt.wantTitle, tt.wantArtist)
+		}
+	}
+}
+
+func TestManager_ApplyRewriteRules(t *testing.T) {
+	// Applying saves to the data directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	m := &Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs: map[string]music.Entry{
+			"a": {ID: "a", MetaFile: music.MetaFile{Filename: "info.json"}, MusicData: music.MusicData{Title: "Bohemian Rhapsody (Official Video)", Artist: "Queen Official"}},
+			"b": {ID: "b", MusicData: music.MusicData{Title: "Innuendo", Artist: "Queen"}},
+		},
+	}
+
+	err = os.MkdirAll(filepath.Join("data", "songs", "a"), os.ModePerm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join("data", "songs", "a", "info.json"), []byte(`{"uploader": "QueenVEVO"}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rules, err := ParseRewriteRules("title replace \\s*\\(Official Video\\)\nuploader set-artist ^(.+)VEVO$ => $1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := m.PreviewRewriteRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].Song.MusicData.Title != "Bohemian Rhapsody" || changes[0].Song.MusicData.Artist != "Queen" {
+		t.Fatalf("unexpected preview %+v", changes)
+	}
+	if m.Songs["a"].MusicData.Title != "Bohemian Rhapsody (Official Video)" {
+		t.Fatalf("preview changed song: %+v", m.Songs["a"])
+	}
+
+	_, err = m.ApplyRewriteRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := m.Songs["a"]; e.MusicData.Title != "Bohemian Rhapsody" || e.MusicData.Artist != "Queen" || e.LastEdit.IsZero() {
+		t.Errorf("rules weren't applied: %+v", e)
+	}
+	if !m.Songs["b"].LastEdit.IsZero() {
+		t.Errorf("song without changes was edited")
+	}
+
+	err = m.SetRewriteRules(rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := music.Entry{MusicData: music.MusicData{Title: "Innuendo (Official Video)", Artist: "Queen"}}
+	m.rewrite(&e, "")
+	if e.MusicData.Title != "Innuendo" {
+		t.Errorf("saved rules weren't applied to new song: %+v", e.MusicData)
+	}
+
+	if m.SetRewriteRules([]RewriteRule{{Field: "title", Action: "set", Pattern: "("}}) == nil {
+		t.Errorf("expected error for invalid rule")
+	}
+}
@@ -8,65 +8,247 @@ import (
 	"xarantolus/sensibleHub/store/music"
 )
 
-// Search offers search functionality
+// Search offers search functionality, see ParseQuery for the supported syntax.
+// Invalid queries are searched as plain words
 func (m *Manager) Search(query string) (list []music.Entry) {
+	list, _, _ = m.SearchQuery(query)
+	return
+}
+
+// SearchQuery searches for `query` and returns how it was interpreted. If the query cannot be parsed,
+// err describes the problem and the query is searched as plain words
+func (m *Manager) SearchQuery(query string) (list []music.Entry, q Query, err error) {
 	query = strings.TrimSpace(query)
 
 	// If searching for an url (even without prefix), we check if we already have it
-	q := query
-	if strings.ContainsAny(q, "./?=") && !strings.HasPrefix(q, "http://") && !strings.HasPrefix(q, "https://") {
-		q = "https://" + q
+	uq := query
+	if strings.ContainsAny(uq, "./?=") && !strings.HasPrefix(uq, "http://") && !strings.HasPrefix(uq, "https://") {
+		uq = "https://" + uq
 	}
 
-	if u, err := url.ParseRequestURI(q); err == nil {
+	// Field filters like "artist:queen" are also valid URIs, but they don't have a host
+	if u, perr := url.ParseRequestURI(uq); perr == nil && u.Host != "" {
 		if e, ok := m.hasLink(u); ok {
 			list = []music.Entry{e}
 			return
 		}
 	}
 
-	qs := splitString(strings.ToUpper(query))
-	e := m.AllEntries()
+	q, err = ParseQuery(query)
+	if err != nil {
+		var words []Term
+		for _, w := range splitString(query) {
+			words = append(words, Term{Value: w})
+		}
+		q = Query{}
+		if len(words) > 0 {
+			q.Groups = [][]Term{words}
+		}
+	}
+
+	list = m.queryEntries(q)
+	return
+}
+
+// searchGroup is a group of query terms prepared for matching
+type searchGroup struct {
+	// words are folded and scored, at least one of them must match
+	words []string
+	// excluded words must not be contained in title, artist or album
+	excluded []string
+
+	// candidates are the IDs of all songs that contain one of the words. It is nil if there are no words
+	candidates map[string]bool
+
+	matchers []matcher
+	negated  []bool
+}
+
+func (m *Manager) queryEntries(q Query) (list []music.Entry) {
+	ix := m.searchIndex()
+
+	var groups []searchGroup
+	for _, g := range q.Groups {
+		var sg searchGroup
+		for _, t := range g {
+			if t.Field == "" {
+				if t.Negate {
+					sg.excluded = append(sg.excluded, fold(t.Value))
+					continue
+				}
+
+				ids, corrected := ix.lookup(fold(t.Value))
+				sg.words = append(sg.words, corrected)
+				if sg.candidates == nil {
+					sg.candidates = make(map[string]bool)
+				}
+				for id := range ids {
+					sg.candidates[id] = true
+				}
+				continue
+			}
+
+			// A term matches if all of its rules match
+			var ms []matcher
+			for _, r := range t.rules {
+				match, err := r.compile()
+				if err != nil {
+					return nil
+				}
+				ms = append(ms, match)
+			}
+			sg.matchers = append(sg.matchers, func(e music.Entry, c *ruleContext) bool {
+				for _, match := range ms {
+					if !match(e, c) {
+						return false
+					}
+				}
+				return true
+			})
+			sg.negated = append(sg.negated, t.Negate)
+		}
+		groups = append(groups, sg)
+	}
+
+	if len(groups) == 0 {
+		if q.Sort == "" {
+			return nil
+		}
+		// Only sorting lists all songs
+		groups = append(groups, searchGroup{})
+	}
+
+	c := m.ruleContext()
 
 	type result struct {
 		score int
-		item  music.Entry
+		title string
+		id    string
 	}
 
-	var res []result
+	m.SongsLock.RLock()
+	defer m.SongsLock.RUnlock()
+	ix.lock.RLock()
+	defer ix.lock.RUnlock()
 
-	for _, item := range e {
-		var sc int
-		sc += score(qs, item.MusicData.Title, 5)
-		sc += score(qs, item.MusicData.Artist, 4)
+	ids := m.searchCandidates(groups)
+	res := make([]result, 0, len(ids))
 
-		// Only use album if it's not the same as other fields
-		if doublePrefix(item.MusicData.Album, item.MusicData.Title) ||
-			doublePrefix(item.MusicData.Album, item.MusicData.Artist) {
-			sc += score(qs, item.MusicData.Album, 1)
-		} else {
-			sc += score(qs, item.MusicData.Album, 3)
+	for _, id := range ids {
+		item := m.Songs[id]
+		song, ok := ix.songs[id]
+		if !ok {
+			song = newIndexedSong(item)
+		}
+
+		best, matched := 0, false
+		for _, g := range groups {
+			sc, ok := g.score(item, song, c)
+			if ok && (!matched || sc > best) {
+				best, matched = sc, true
+			}
 		}
 
-		if sc > 0 {
-			res = append(res, result{sc, item})
+		if matched {
+			res = append(res, result{best, song.title.text, id})
 		}
 	}
 
 	sort.Slice(res, func(i, j int) bool {
-		return res[i].score > res[j].score
+		if res[i].score != res[j].score {
+			return res[i].score > res[j].score
+		}
+		if res[i].title != res[j].title {
+			return res[i].title < res[j].title
+		}
+		return res[i].id < res[j].id
 	})
 
-	for _, r := range res {
-		list = append(list, r.item)
+	list = make([]music.Entry, len(res))
+	for i, r := range res {
+		list[i] = m.Songs[r.id]
+	}
+
+	if q.Sort != "" {
+		sortEntries(list, q.Sort, q.Descending)
 	}
 
 	return
 }
 
-func score(query []string, title string, multiplier int) (out int) {
-	tu := strings.ToUpper(title)
-	tf := splitString(tu)
+// searchCandidates returns the IDs of songs that might match one of the groups. If all groups contain words,
+// only songs from the index that contain them are returned, otherwise all songs need to be checked.
+// It assumes that m.SongsLock is already locked
+func (m *Manager) searchCandidates(groups []searchGroup) (ids []string) {
+	if len(groups) == 1 && groups[0].candidates != nil {
+		for id := range groups[0].candidates {
+			if _, ok := m.Songs[id]; ok {
+				ids = append(ids, id)
+			}
+		}
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, g := range groups {
+		if g.candidates == nil {
+			ids = make([]string, 0, len(m.Songs))
+			for id := range m.Songs {
+				ids = append(ids, id)
+			}
+			return
+		}
+
+		for id := range g.candidates {
+			if _, ok := m.Songs[id]; ok && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	return
+}
+
+// score returns the score of `item` and whether it matches all conditions of the group.
+// `song` contains the indexed fields of the item
+func (g searchGroup) score(item music.Entry, song indexedSong, c *ruleContext) (sc int, ok bool) {
+	if g.candidates != nil && !g.candidates[item.ID] {
+		return 0, false
+	}
+
+	for i, match := range g.matchers {
+		if match(item, c) == g.negated[i] {
+			return 0, false
+		}
+	}
+
+	for _, ex := range g.excluded {
+		if strings.Contains(song.title.text, ex) || strings.Contains(song.artist.text, ex) || strings.Contains(song.album.text, ex) {
+			return 0, false
+		}
+	}
+
+	if len(g.words) == 0 {
+		return 0, true
+	}
+
+	sc += score(g.words, song.title, 5)
+	sc += score(g.words, song.artist, 4)
+
+	// Only use album if it's not the same as other fields
+	if doublePrefix(song.album.text, song.title.text) ||
+		doublePrefix(song.album.text, song.artist.text) {
+		sc += score(g.words, song.album, 1)
+	} else {
+		sc += score(g.words, song.album, 3)
+	}
+
+	return sc, sc > 0
+}
+
+func score(query []string, field indexedField, multiplier int) (out int) {
+	tu, tf := field.text, field.words
 	if len(tf) == 0 {
 		return
 	}
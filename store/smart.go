@@ -0,0 +1,619 @@
+package store
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"xarantolus/sensibleHub/store/music"
+)
+
+// SmartPlaylist is a playlist whose songs are selected by rules instead of being added by hand
+type SmartPlaylist struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+
+	// Any decides whether songs must match any of the rules instead of all of them
+	Any   bool   `json:"any"`
+	Rules []Rule `json:"rules"`
+
+	// Sort is one of SmartSortFields, songs are sorted by title if it is empty
+	Sort       string `json:"sort,omitempty"`
+	Descending bool   `json:"descending,omitempty"`
+
+	// Limit is the maximum number of songs in the playlist, 0 means no limit
+	Limit int `json:"limit,omitempty"`
+
+	// SyncSettings decide whether the playlist is shown as directory to FTP/WebDAV users
+	SyncSettings music.SyncSettings `json:"sync_settings"`
+
+	// Builtin is set for the lists that are defined in code, they cannot be changed
+	Builtin bool `json:"builtin,omitempty"`
+
+	Created  time.Time `json:"created"`
+	LastEdit time.Time `json:"last_edit"`
+}
+
+// Rule is a condition for songs in a smart playlist, e.g. "year between 1990..1999"
+type Rule struct {
+	Field string `json:"field"`
+	Op    string `json:"op"`
+	Value string `json:"value,omitempty"`
+}
+
+type fieldKind int
+
+const (
+	textField fieldKind = iota
+	numberField
+	dateField
+	boolField
+)
+
+// ruleFields maps the fields rules can use to their type
+var ruleFields = map[string]fieldKind{
+	"title":  textField,
+	"artist": textField,
+	"album":  textField,
+	"genre":  textField,
+	"source": textField,
+	// featured is the part of the title in brackets, e.g. "(feat. Artist)"
+	"featured": textField,
+
+	"year":     numberField,
+	"track":    numberField,
+	"rating":   numberField,
+	"duration": numberField,
+	// cover is the size of the cover image in pixels, it is empty for songs without cover
+	"cover": numberField,
+	// devices is the number of FTP/WebDAV devices that downloaded the song
+	"devices": numberField,
+
+	"added":  dateField,
+	"edited": dateField,
+
+	"sync": boolField,
+}
+
+// ruleOps are the operators for each field type. Operators that don't need a value are mapped to false
+var ruleOps = map[fieldKind]map[string]bool{
+	textField: {
+		"is": true, "is-not": true, "contains": true, "not-contains": true, "starts-with": true,
+		"empty": false, "not-empty": false, "is-filename": false, "not-filename": false,
+	},
+	numberField: {
+		"is": true, "is-not": true, "less": true, "greater": true, "between": true,
+		"empty": false, "not-empty": false,
+	},
+	dateField: {
+		"in-last": true, "not-in-last": true, "before": true, "after": true,
+	},
+	boolField: {
+		"is": true,
+	},
+}
+
+// SmartSortFields are the fields smart playlists can be sorted by
+var SmartSortFields = []string{"title", "artist", "album", "year", "rating", "duration", "added", "edited"}
+
+// builtinSmartPlaylists are the categories of the "Incomplete" listing. Their rules exclude songs matched
+// by the lists before them, that way songs cascade through these categories
+var builtinSmartPlaylists = []SmartPlaylist{
+	{
+		ID: "weird-title", Name: "Weird Title", Description: "Weird titles that should probably be changed",
+		Rules: []Rule{{"title", "is-filename", ""}},
+	},
+	{
+		ID: "no-artist", Name: "No Artist", Description: "We don't even know who made these songs",
+		Rules: []Rule{{"title", "not-filename", ""}, {"artist", "empty", ""}},
+	},
+	{
+		ID: "no-cover", Name: "No Cover", Description: "There's no cover image for these songs",
+		Rules: []Rule{{"title", "not-filename", ""}, {"artist", "not-empty", ""}, {"cover", "empty", ""}},
+	},
+	{
+		ID: "no-album", Name: "No Album", Description: "Missing album information",
+		Rules: []Rule{{"title", "not-filename", ""}, {"artist", "not-empty", ""}, {"cover", "not-empty", ""}, {"album", "empty", ""}},
+	},
+	{
+		ID: "no-year", Name: "No Year", Description: "The year tag is missing",
+		Rules: []Rule{{"title", "not-filename", ""}, {"artist", "not-empty", ""}, {"cover", "greater", "749"}, {"album", "not-empty", ""}, {"year", "empty", ""}},
+	},
+	{
+		ID: "small-cover", Name: "Small Cover", Description: "Songs with a cover image less than 750 pixels in size",
+		Rules: []Rule{{"title", "not-filename", ""}, {"artist", "not-empty", ""}, {"cover", "less", "750"}, {"album", "not-empty", ""}},
+	},
+}
+
+func init() {
+	for i := range builtinSmartPlaylists {
+		builtinSmartPlaylists[i].Builtin = true
+		builtinSmartPlaylists[i].Sort = "added"
+		builtinSmartPlaylists[i].Descending = true
+	}
+}
+
+// String returns the rule in the format understood by ParseRules
+func (r Rule) String() string {
+	return strings.TrimSpace(r.Field + " " + r.Op + " " + r.Value)
+}
+
+// ParseRules parses one rule per line, each in the form "field operator value", e.g. "year between 1990..1999".
+// Empty lines are ignored
+func ParseRules(text string) (rules []Rule, err error) {
+	for _, line := range strings.Split(text, "\n") {
+		words := strings.Fields(line)
+		if len(words) == 0 {
+			continue
+		}
+		if len(words) < 2 {
+			return nil, fmt.Errorf("Rule %q needs a field and an operator", strings.TrimSpace(line))
+		}
+
+		r := Rule{
+			Field: strings.ToLower(words[0]),
+			Op:    strings.ToLower(words[1]),
+			Value: strings.Join(words[2:], " "),
+		}
+
+		_, err = r.compile()
+		if err != nil {
+			return nil, err
+		}
+
+		rules = append(rules, r)
+	}
+
+	return
+}
+
+// RulesText returns the rules of the playlist, one per line
+func (p SmartPlaylist) RulesText() string {
+	var lines []string
+	for _, r := range p.Rules {
+		lines = append(lines, r.String())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ruleContext contains information about songs that is not stored in the entry itself
+type ruleContext struct {
+	now time.Time
+
+	// devices maps song IDs to the number of devices that downloaded them
+	devices map[string]int
+}
+
+type matcher func(e music.Entry, c *ruleContext) bool
+
+// compile checks the rule and returns a function that tests whether an entry matches it
+func (r Rule) compile() (matcher, error) {
+	kind, ok := ruleFields[r.Field]
+	if !ok {
+		return nil, fmt.Errorf("Unknown field %q in rule %q", r.Field, r.String())
+	}
+
+	needsValue, ok := ruleOps[kind][r.Op]
+	if !ok {
+		return nil, fmt.Errorf("Operator %q cannot be used with field %q", r.Op, r.Field)
+	}
+	if needsValue && strings.TrimSpace(r.Value) == "" {
+		return nil, fmt.Errorf("Rule %q needs a value", r.String())
+	}
+
+	switch kind {
+	case textField:
+		return r.compileText(), nil
+	case numberField:
+		return r.compileNumber()
+	case dateField:
+		return r.compileDate()
+	default:
+		want, err := strconv.ParseBool(r.Value)
+		if err != nil {
+			return nil, fmt.Errorf("Rule %q needs true or false as value", r.String())
+		}
+		return func(e music.Entry, c *ruleContext) bool {
+			return e.SyncSettings.Should == want
+		}, nil
+	}
+}
+
+func (r Rule) compileText() matcher {
+	value := strings.ToUpper(strings.TrimSpace(r.Value))
+
+	get := func(e music.Entry) string {
+		var s string
+		switch r.Field {
+		case "title":
+			s = e.MusicData.Title
+		case "artist":
+			s = e.MusicData.Artist
+		case "album":
+			s = e.MusicData.Album
+		case "genre":
+			s = e.MusicData.Genre
+		case "source":
+			s = e.SourceURL
+		case "featured":
+			first, last := strings.IndexByte(e.MusicData.Title, '('), strings.LastIndexByte(e.MusicData.Title, ')')
+			if first != -1 && last > first {
+				s = e.MusicData.Title[first : last+1]
+			}
+		}
+		return strings.ToUpper(strings.TrimSpace(s))
+	}
+
+	return func(e music.Entry, c *ruleContext) bool {
+		s := get(e)
+
+		switch r.Op {
+		case "is":
+			return s == value
+		case "is-not":
+			return s != value
+		case "contains":
+			return strings.Contains(s, value)
+		case "not-contains":
+			return !strings.Contains(s, value)
+		case "starts-with":
+			return strings.HasPrefix(s, value)
+		case "empty":
+			return s == ""
+		case "not-empty":
+			return s != ""
+		case "is-filename":
+			return isFilename(s)
+		default: // not-filename
+			return !isFilename(s)
+		}
+	}
+}
+
+// isFilename returns whether `s` ends with the extension of a music file, which happens when an import didn't find a title
+func isFilename(s string) bool {
+	return musicExtensions[strings.ToLower(strings.TrimPrefix(filepath.Ext(s), "."))]
+}
+
+func (r Rule) compileNumber() (matcher, error) {
+	var min, max float64
+	var err error
+
+	switch r.Op {
+	case "between":
+		bounds := strings.SplitN(r.Value, "..", 2)
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("Rule %q needs a range like 1990..1999 as value", r.String())
+		}
+
+		min, err = strconv.ParseFloat(strings.TrimSpace(bounds[0]), 64)
+		if err == nil {
+			max, err = strconv.ParseFloat(strings.TrimSpace(bounds[1]), 64)
+		}
+	case "empty", "not-empty":
+	default:
+		min, err = strconv.ParseFloat(strings.TrimSpace(r.Value), 64)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Rule %q needs a number as value", r.String())
+	}
+
+	// get returns the value of the field and false if it is not set
+	get := func(e music.Entry, c *ruleContext) (float64, bool) {
+		switch r.Field {
+		case "year":
+			if e.MusicData.Year == nil || *e.MusicData.Year == 0 {
+				return 0, false
+			}
+			return float64(*e.MusicData.Year), true
+		case "track":
+			return float64(e.MusicData.Track), e.MusicData.Track != 0
+		case "rating":
+			return float64(e.MusicData.Rating), e.MusicData.Rating != 0
+		case "duration":
+			return e.PlayDuration(), true
+		case "cover":
+			return float64(e.PictureData.Size), strings.TrimSpace(e.PictureData.Filename) != ""
+		default: // devices
+			return float64(c.devices[e.ID]), true
+		}
+	}
+
+	return func(e music.Entry, c *ruleContext) bool {
+		n, ok := get(e, c)
+
+		switch r.Op {
+		case "empty":
+			return !ok
+		case "not-empty":
+			return ok
+		case "is-not":
+			return !ok || n != min
+		}
+
+		if !ok {
+			return false
+		}
+
+		switch r.Op {
+		case "is":
+			return n == min
+		case "less":
+			return n < min
+		case "greater":
+			return n > min
+		default: // between
+			return n >= min && n <= max
+		}
+	}, nil
+}
+
+func (r Rule) compileDate() (matcher, error) {
+	get := func(e music.Entry) time.Time {
+		if r.Field == "edited" {
+			return e.LastEdit
+		}
+		return e.Added
+	}
+
+	switch r.Op {
+	case "in-last", "not-in-last":
+		days, err := strconv.Atoi(strings.TrimSpace(r.Value))
+		if err != nil || days < 0 {
+			return nil, fmt.Errorf("Rule %q needs a number of days as value", r.String())
+		}
+
+		within := r.Op == "in-last"
+		return func(e music.Entry, c *ruleContext) bool {
+			return get(e).After(c.now.AddDate(0, 0, -days)) == within
+		}, nil
+	default:
+		t, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(r.Value), time.Local)
+		if err != nil {
+			return nil, fmt.Errorf("Rule %q needs a date like 2020-12-31 as value", r.String())
+		}
+
+		before := r.Op == "before"
+		return func(e music.Entry, c *ruleContext) bool {
+			if before {
+				return get(e).Before(t)
+			}
+			// "after 2020-12-31" should not include songs from that day
+			return !get(e).Before(t.AddDate(0, 0, 1))
+		}, nil
+	}
+}
+
+// validate checks the name, rules and options of the playlist
+func (p *SmartPlaylist) validate() error {
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		return fmt.Errorf("A playlist needs a name")
+	}
+
+	if len(p.Rules) == 0 {
+		return fmt.Errorf("A smart playlist needs at least one rule")
+	}
+	for _, r := range p.Rules {
+		if _, err := r.compile(); err != nil {
+			return err
+		}
+	}
+
+	if p.Sort != "" && !containsFold(SmartSortFields, p.Sort) {
+		return fmt.Errorf("Cannot sort by %q", p.Sort)
+	}
+
+	if p.Limit < 0 {
+		return fmt.Errorf("The limit must not be negative")
+	}
+
+	return nil
+}
+
+// SmartEntries returns the songs selected by the rules of `p`, sorted and limited as configured
+func (m *Manager) SmartEntries(p SmartPlaylist) (list []music.Entry) {
+	var matchers []matcher
+	for _, r := range p.Rules {
+		match, err := r.compile()
+		if err != nil {
+			// Rules are validated when saving, so this only happens if someone edited the data file
+			return nil
+		}
+		matchers = append(matchers, match)
+	}
+
+	c := m.ruleContext()
+
+	for _, e := range m.AllEntries() {
+		matched := !p.Any
+		for _, match := range matchers {
+			if match(e, c) == p.Any {
+				matched = p.Any
+				break
+			}
+		}
+
+		if matched {
+			list = append(list, e)
+		}
+	}
+
+	sortEntries(list, p.Sort, p.Descending)
+
+	if p.Limit > 0 && len(list) > p.Limit {
+		list = list[:p.Limit]
+	}
+
+	return
+}
+
+// ruleContext collects the information rules need besides the entries
+func (m *Manager) ruleContext() *ruleContext {
+	c := &ruleContext{
+		now:     time.Now(),
+		devices: make(map[string]int),
+	}
+
+	m.SongsLock.RLock()
+	for _, d := range m.Devices {
+		for id := range d.Songs {
+			c.devices[id]++
+		}
+	}
+	m.SongsLock.RUnlock()
+
+	return c
+}
+
+// sortEntries sorts `list` by one of SmartSortFields. Entries with equal values keep their order
+func sortEntries(list []music.Entry, field string, descending bool) {
+	less := func(a, b music.Entry) bool {
+		switch strings.ToLower(field) {
+		case "artist":
+			return strings.ToUpper(a.MusicData.Artist) < strings.ToUpper(b.MusicData.Artist)
+		case "album":
+			return strings.ToUpper(a.MusicData.Album) < strings.ToUpper(b.MusicData.Album)
+		case "year":
+			var ay, by int
+			if a.MusicData.Year != nil {
+				ay = *a.MusicData.Year
+			}
+			if b.MusicData.Year != nil {
+				by = *b.MusicData.Year
+			}
+			return ay < by
+		case "rating":
+			return a.MusicData.Rating < b.MusicData.Rating
+		case "duration":
+			return a.PlayDuration() < b.PlayDuration()
+		case "added":
+			return a.Added.Before(b.Added)
+		case "edited":
+			return a.LastEdit.Before(b.LastEdit)
+		default:
+			return strings.ToUpper(a.MusicData.Title) < strings.ToUpper(b.MusicData.Title)
+		}
+	}
+
+	sort.SliceStable(list, func(i, j int) bool {
+		if descending {
+			return less(list[j], list[i])
+		}
+		return less(list[i], list[j])
+	})
+}
+
+// AllSmartPlaylists returns the built-in smart playlists followed by the ones created by the user, sorted by name
+func (m *Manager) AllSmartPlaylists() (list []SmartPlaylist) {
+	m.SongsLock.RLock()
+	for _, p := range m.SmartPlaylists {
+		list = append(list, p)
+	}
+	m.SongsLock.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool {
+		return strings.ToUpper(list[i].Name) < strings.ToUpper(list[j].Name)
+	})
+
+	return append(append([]SmartPlaylist{}, builtinSmartPlaylists...), list...)
+}
+
+// GetSmartPlaylist returns the smart playlist with the given ID, which can also be a built-in one
+func (m *Manager) GetSmartPlaylist(id string) (p SmartPlaylist, ok bool) {
+	for _, b := range builtinSmartPlaylists {
+		if b.ID == id {
+			return b, true
+		}
+	}
+
+	m.SongsLock.RLock()
+	p, ok = m.SmartPlaylists[id]
+	m.SongsLock.RUnlock()
+
+	return
+}
+
+// SaveSmartPlaylist creates a smart playlist if p.ID is empty or replaces the one with the same ID
+func (m *Manager) SaveSmartPlaylist(p SmartPlaylist) (saved SmartPlaylist, err error) {
+	err = p.validate()
+	if err != nil {
+		return
+	}
+
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	if m.SmartPlaylists == nil {
+		m.SmartPlaylists = make(map[string]SmartPlaylist)
+	}
+
+	now := time.Now()
+	if p.ID == "" {
+		p.ID = m.generatePlaylistID()
+		p.Created = now
+	} else {
+		old, ok := m.SmartPlaylists[p.ID]
+		if !ok {
+			return saved, fmt.Errorf("Cannot edit smart playlist with id %s as it doesn't exist", p.ID)
+		}
+		p.Created = old.Created
+	}
+	p.Builtin = false
+	p.LastEdit = now
+
+	m.SmartPlaylists[p.ID] = p
+
+	err = m.Save(false)
+	if err != nil {
+		return
+	}
+
+	m.event("smart-playlist-edit", map[string]interface{}{
+		"id":       p.ID,
+		"playlist": p,
+	})
+
+	return p, nil
+}
+
+// DeleteSmartPlaylist deletes a smart playlist created by the user
+func (m *Manager) DeleteSmartPlaylist(id string) (err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	if _, ok := m.SmartPlaylists[id]; !ok {
+		return fmt.Errorf("Cannot delete smart playlist with id %s as it doesn't exist", id)
+	}
+
+	delete(m.SmartPlaylists, id)
+
+	err = m.Save(false)
+	if err != nil {
+		return
+	}
+
+	m.event("smart-playlist-delete", map[string]interface{}{
+		"id": id,
+	})
+
+	return nil
+}
+
+// SmartGroup returns the songs of `p` as group for listings
+func (m *Manager) SmartGroup(p SmartPlaylist) (groups []Group) {
+	songs := m.SmartEntries(p)
+	if len(songs) == 0 {
+		return nil
+	}
+
+	return []Group{{
+		Title:       p.Name,
+		Description: p.Description,
+		Link:        "/smart/" + p.ID,
+		Songs:       songs,
+	}}
+}
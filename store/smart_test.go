@@ -0,0 +1,92 @@
+package store
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules("year between 1990..1999\n\n  Artist IS Daft Punk  \n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Rule{{"year", "between", "1990..1999"}, {"artist", "is", "Daft Punk"}}
+	if !reflect.DeepEqual(rules, want) {
+		t.Errorf("ParseRules returned %v, want %v", rules, want)
+	}
+
+	for _, invalid := range []string{"year", "color is red", "year contains 19", "year between 1990", "added in-last soon", "album is", "sync is maybe"} {
+		if _, err := ParseRules(invalid); err == nil {
+			t.Errorf("expected an error for rule %q", invalid)
+		}
+	}
+}
+
+func TestManager_SmartEntries(t *testing.T) {
+	year := func(y int) *int {
+		return &y
+	}
+	now := time.Now()
+
+	m := Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs: map[string]music.Entry{
+			"a": {ID: "a", Added: now.AddDate(0, 0, -3), MusicData: music.MusicData{Title: "One More Time", Artist: "Daft Punk", Year: year(2000)},
+				PictureData: music.PictureData{Filename: "cover.jpg", Size: 500}},
+			"b": {ID: "b", Added: now.AddDate(0, -2, 0), MusicData: music.MusicData{Title: "Around the World", Artist: "Daft Punk", Album: "Homework", Year: year(1997)},
+				PictureData: music.PictureData{Filename: "cover.jpg", Size: 1000}},
+			"c": {ID: "c", Added: now.AddDate(0, 0, -1), MusicData: music.MusicData{Title: "Get Lucky (feat. Pharrell Williams)", Artist: "Daft Punk", Album: "Random Access Memories", Year: year(2013)}},
+			"d": {ID: "d", Added: now.AddDate(-1, 0, 0), MusicData: music.MusicData{Title: "Happy", Artist: "Pharrell Williams", Year: year(2013)},
+				PictureData: music.PictureData{Filename: "cover.jpg", Size: 1000}},
+			"e": {ID: "e", Added: now, MusicData: music.MusicData{Title: "track01.mp3"}},
+		},
+		Devices: map[string]*Device{
+			"phone": {Songs: map[string]SyncedSong{"a": {}, "b": {}}},
+		},
+	}
+
+	ids := func(entries []music.Entry) (res []string) {
+		for _, e := range entries {
+			res = append(res, e.ID)
+		}
+		return
+	}
+
+	tests := []struct {
+		name string
+		p    SmartPlaylist
+		want []string
+	}{
+		{"added recently", SmartPlaylist{Rules: []Rule{{"added", "in-last", "30"}}}, []string{"c", "a", "e"}},
+		{"nineties without album", SmartPlaylist{Rules: []Rule{{"year", "between", "1990..2000"}, {"album", "empty", ""}}}, []string{"a"}},
+		{"artist or featured", SmartPlaylist{Any: true, Rules: []Rule{{"artist", "is", "pharrell williams"}, {"featured", "contains", "Pharrell"}}}, []string{"c", "d"}},
+		{"small cover", SmartPlaylist{Rules: []Rule{{"cover", "less", "750"}}}, []string{"a"}},
+		{"never synced", SmartPlaylist{Rules: []Rule{{"devices", "is", "0"}}, Sort: "year", Descending: true}, []string{"c", "d", "e"}},
+		{"limit", SmartPlaylist{Rules: []Rule{{"artist", "is", "Daft Punk"}}, Sort: "added", Descending: true, Limit: 2}, []string{"c", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(m.SmartEntries(tt.p)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SmartEntries() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Songs cascade through the categories of the "Incomplete" listing, so they appear only once
+	got := make(map[string][]string)
+	for _, g := range m.Incomplete() {
+		got[g.Title] = ids(g.Songs)
+	}
+	want := map[string][]string{
+		"Weird Title": {"e"},
+		"No Cover":    {"c"},
+		"No Album":    {"a", "d"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Incomplete() returned %v, want %v", got, want)
+	}
+}
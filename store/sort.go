@@ -3,7 +3,6 @@ package store
 import (
 	"fmt"
 	"math/rand"
-	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
@@ -177,6 +176,39 @@ func (m *Manager) GroupByYear() (groups []Group) {
 	return
 }
 
+// GroupByGenre groups songs by their genre. Songs without genre are not included
+func (m *Manager) GroupByGenre() (groups []Group) {
+	m.SongsLock.RLock()
+	defer m.SongsLock.RUnlock()
+
+	gMap := map[string][]music.Entry{}
+
+	for _, song := range m.AllEntries() {
+		genre := strings.ToUpper(strings.TrimSpace(song.MusicData.Genre))
+		if genre == "" {
+			continue
+		}
+
+		gMap[genre] = append(gMap[genre], song)
+	}
+
+	for _, songs := range gMap {
+		// since every len(songs) > 0
+		groups = append(groups, Group{
+			Title:       strings.TrimSpace(songs[0].MusicData.Genre),
+			Description: songLenDescription(len(songs)),
+			Songs:       songs,
+		})
+	}
+
+	// Sort genres alphabetically
+	sort.Slice(groups, func(i, j int) bool {
+		return strings.ToUpper(groups[i].Title) < strings.ToUpper(groups[j].Title)
+	})
+
+	return
+}
+
 func isLetter(r rune) bool {
 	if r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' {
 		return true
@@ -279,58 +311,10 @@ var musicExtensions = map[string]bool{
 	"cda":  true,
 }
 
-// Incomplete returns all entries with incomplete data
+// Incomplete returns all entries with incomplete data. The groups are the built-in smart playlists
 func (m *Manager) Incomplete() (groups []Group) {
-	noArtist := Group{Title: "No Artist", Description: "We don't even know who made these songs"}
-	noAlbum := Group{Title: "No Album", Description: "Missing album information"}
-	noImage := Group{Title: "No Cover", Description: "There's no cover image for these songs"}
-	noYear := Group{Title: "No Year", Description: "The year tag is missing"}
-	weirdTitle := Group{Title: "Weird Title", Description: "Weird titles that should probably be changed"}
-	smallCover := Group{Title: "Small Cover", Description: "Songs with a cover image less than 750 pixels in size"}
-
-	// The conditions inside this loop must have the same order as the
-	// lists in the for below it. That way, songs can cascade through these categories
-	for _, e := range m.AllEntries() {
-		// Maybe an import happened here and nothing has been done to the title yet
-		if musicExtensions[strings.ToLower(strings.TrimPrefix(filepath.Ext(e.MusicData.Title), "."))] {
-			weirdTitle.Songs = append(weirdTitle.Songs, e)
-			continue
-		}
-
-		if strings.TrimSpace(e.MusicData.Artist) == "" {
-			noArtist.Songs = append(noArtist.Songs, e)
-			continue
-		}
-
-		if strings.TrimSpace(e.PictureData.Filename) == "" {
-			noImage.Songs = append(noImage.Songs, e)
-			continue
-		}
-
-		if strings.TrimSpace(e.MusicData.Album) == "" {
-			noAlbum.Songs = append(noAlbum.Songs, e)
-			continue
-		}
-
-		if e.PictureData.Size < 750 {
-			smallCover.Songs = append(smallCover.Songs, e)
-			continue
-		}
-
-		if e.MusicData.Year == nil || *e.MusicData.Year == 0 {
-			noYear.Songs = append(noYear.Songs, e)
-			continue
-		}
-	}
-
-	for _, g := range []Group{weirdTitle, noArtist, noImage, noAlbum, noYear, smallCover} {
-		if len(g.Songs) == 0 {
-			continue
-		}
-		sort.Slice(g.Songs, func(i, j int) bool {
-			return g.Songs[i].Added.After(g.Songs[j].Added)
-		})
-		groups = append(groups, g)
+	for _, p := range builtinSmartPlaylists {
+		groups = append(groups, m.SmartGroup(p)...)
 	}
 
 	return
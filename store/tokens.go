@@ -0,0 +1,134 @@
+package store
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// apiTokenPrefix is at the start of every token, which makes them easy to recognize e.g. in scripts
+const apiTokenPrefix = "shub_"
+
+// APIToken allows scripts to use the API in the name of a website user. They have the same role as that user
+type APIToken struct {
+	ID   string `json:"id"`
+	User string `json:"user"`
+	Name string `json:"name"`
+
+	// Hash is the hex SHA-256 hash of the token. The token itself is only shown when it is created
+	Hash string `json:"hash"`
+	// Hint is the start of the token, it helps recognizing it
+	Hint string `json:"hint"`
+
+	Created time.Time `json:"created"`
+	// LastUsed is only updated in memory, it is saved with the next change
+	LastUsed time.Time `json:"last_used"`
+}
+
+func hashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateAPIToken creates a new token for `user`. The returned `token` cannot be retrieved later
+func (m *Manager) CreateAPIToken(user, name string) (token string, t APIToken, err error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", t, fmt.Errorf("A token needs a name")
+	}
+	if user == "" {
+		return "", t, fmt.Errorf("Tokens can only be created for users")
+	}
+
+	b := make([]byte, 24)
+	_, err = rand.Read(b)
+	if err != nil {
+		return
+	}
+	token = apiTokenPrefix + hex.EncodeToString(b)
+
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	if m.APITokens == nil {
+		m.APITokens = make(map[string]APIToken)
+	}
+
+	id := randSeq(4)
+	for _, used := m.APITokens[id]; used; _, used = m.APITokens[id] {
+		id = randSeq(4)
+	}
+
+	t = APIToken{
+		ID:      id,
+		User:    user,
+		Name:    name,
+		Hash:    hashAPIToken(token),
+		Hint:    token[:len(apiTokenPrefix)+4],
+		Created: time.Now(),
+	}
+	m.APITokens[id] = t
+
+	return token, t, m.Save(false)
+}
+
+// UserAPITokens returns all tokens of `user`, the oldest first
+func (m *Manager) UserAPITokens(user string) (tokens []APIToken) {
+	m.SongsLock.RLock()
+	defer m.SongsLock.RUnlock()
+
+	for _, t := range m.APITokens {
+		if t.User == user {
+			tokens = append(tokens, t)
+		}
+	}
+
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].Created.Before(tokens[j].Created)
+	})
+
+	return
+}
+
+// RevokeAPIToken deletes the token with the given `id` if it belongs to `user`
+func (m *Manager) RevokeAPIToken(user, id string) (err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	t, ok := m.APITokens[id]
+	if !ok || t.User != user {
+		return fmt.Errorf("Cannot revoke token with id %s as it doesn't exist", id)
+	}
+
+	delete(m.APITokens, id)
+
+	return m.Save(false)
+}
+
+// CheckAPIToken returns the token info for `token` if it is valid
+func (m *Manager) CheckAPIToken(token string) (t APIToken, ok bool) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return
+	}
+
+	hash := []byte(hashAPIToken(token))
+
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	for id, stored := range m.APITokens {
+		if subtle.ConstantTimeCompare(hash, []byte(stored.Hash)) == 1 {
+			stored.LastUsed = time.Now()
+			m.APITokens[id] = stored
+
+			return stored, true
+		}
+	}
+
+	return
+}
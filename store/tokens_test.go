@@ -0,0 +1,68 @@
+package store
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestManager_APITokens(t *testing.T) {
+	// Creating tokens saves to the data directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	m := Manager{SongsLock: new(sync.RWMutex)}
+
+	if _, _, err := m.CreateAPIToken("admin", " "); err == nil {
+		t.Errorf("expected an error for a token without a name")
+	}
+
+	token, created, err := m.CreateAPIToken("admin", "Home automation")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(token, apiTokenPrefix) || !strings.HasPrefix(token, created.Hint) || strings.Contains(created.Hash, token) {
+		t.Errorf("unexpected token %q for %+v", token, created)
+	}
+
+	other, _, err := m.CreateAPIToken("listener", "Phone")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := m.CheckAPIToken(token)
+	if !ok || got.User != "admin" || got.ID != created.ID || got.LastUsed.IsZero() {
+		t.Errorf("CheckAPIToken() = %+v, %v", got, ok)
+	}
+	for _, invalid := range []string{"", "shub_", token + "0", strings.TrimPrefix(token, apiTokenPrefix)} {
+		if _, ok := m.CheckAPIToken(invalid); ok {
+			t.Errorf("CheckAPIToken(%q) should fail", invalid)
+		}
+	}
+
+	if tokens := m.UserAPITokens("admin"); len(tokens) != 1 || tokens[0].ID != created.ID {
+		t.Errorf("UserAPITokens() = %+v", tokens)
+	}
+
+	// Users can only revoke their own tokens
+	if err := m.RevokeAPIToken("listener", created.ID); err == nil {
+		t.Errorf("expected an error when revoking the token of another user")
+	}
+	if err := m.RevokeAPIToken("admin", created.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.CheckAPIToken(token); ok {
+		t.Errorf("revoked token is still valid")
+	}
+	if _, ok := m.CheckAPIToken(other); !ok {
+		t.Errorf("token of another user was revoked")
+	}
+}
@@ -0,0 +1,410 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"xarantolus/sensibleHub/store/music"
+)
+
+const (
+	// Everything below this volume for at least silenceMinDuration seconds is treated as silence
+	silenceNoise       = "-50dB"
+	silenceMinDuration = 0.5
+
+	// Pauses are short quiet parts. Speech contains a lot of them, music usually doesn't
+	pauseNoise       = "-30dB"
+	pauseMinDuration = 0.2
+
+	// musicMinStretch is the minimum number of seconds without any pause that we consider to be music
+	musicMinStretch = 20
+	// introMinLength and introMaxLength define how long a spoken intro/outro may be
+	introMinLength = 5
+	introMaxLength = 90
+	// introMinParts is how many separate sounds an intro must consist of before we assume it's speech
+	introMinParts = 3
+
+	// Suggestions that are closer than this to the current settings are not worth showing
+	trimTolerance = 0.25
+)
+
+// interval is a time range in an audio file, in seconds
+type interval struct {
+	Start, End float64
+}
+
+func (i interval) length() float64 {
+	return i.End - i.Start
+}
+
+// AnalyzeTrim runs a silence analysis on the audio of the song with the given ID and stores suggested trim points.
+// If nothing should be trimmed, an existing suggestion is removed
+func (m *Manager) AnalyzeTrim(id string) (err error) {
+	e, ok := m.GetEntry(id)
+	if !ok {
+		return fmt.Errorf("Cannot analyze entry with id %s as it doesn't exist", id)
+	}
+
+	// This takes some time, which is why we don't hold any lock while running ffmpeg
+	silences, err := m.detectSilence(e.AudioPath(), silenceNoise, silenceMinDuration, e.MusicData.Duration)
+	if err != nil {
+		return
+	}
+
+	var pauses []interval
+	// Only downloaded songs might be music videos that start with someone talking
+	if !e.IsImported() {
+		pauses, err = m.detectSilence(e.AudioPath(), pauseNoise, pauseMinDuration, e.MusicData.Duration)
+		if err != nil {
+			return
+		}
+	}
+
+	suggestion := suggestTrim(e.MusicData.Duration, silences, pauses)
+	if suggestion != nil {
+		suggestion.Analyzed = time.Now()
+	}
+
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	// The entry might have been edited or deleted in the meantime
+	e, ok = m.Songs[id]
+	if !ok {
+		return fmt.Errorf("Entry with id %s was deleted while analyzing", id)
+	}
+
+	if suggestion != nil && !differentTrim(e.AudioSettings, *suggestion) {
+		suggestion = nil
+	}
+
+	if suggestion == nil && e.TrimSuggestion == nil {
+		return nil
+	}
+
+	e.TrimSuggestion = suggestion
+	m.Songs[id] = e
+
+	err = m.Save(false)
+	if err != nil {
+		return
+	}
+
+	m.event("song-edit", map[string]interface{}{
+		"id":   id,
+		"song": e,
+	})
+
+	return nil
+}
+
+// ApplyTrimSuggestions sets the audio start/end times of all given songs to their trim suggestion.
+// Songs without a suggestion are ignored. If a suggestion ends before the start time, start and end are swapped like
+// EditEntry does. All songs are checked before any is changed. It returns how many songs were changed
+func (m *Manager) ApplyTrimSuggestions(ids ...string) (n int, err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	var (
+		edited []music.Entry
+		seen   = make(map[string]bool)
+	)
+	for _, id := range ids {
+		e, ok := m.Songs[id]
+		if !ok {
+			return 0, fmt.Errorf("Cannot edit entry with id %s as it doesn't exist", id)
+		}
+
+		if e.TrimSuggestion == nil || seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		if e.TrimSuggestion.Start >= 0 {
+			e.AudioSettings.Start = e.TrimSuggestion.Start
+		}
+		if e.TrimSuggestion.End >= 0 {
+			e.AudioSettings.End = e.TrimSuggestion.End
+		}
+
+		// An end time of -1 means the song is played until the end
+		if e.AudioSettings.Start >= 0 && e.AudioSettings.End >= 0 {
+			if e.AudioSettings.Start > e.AudioSettings.End {
+				e.AudioSettings.Start, e.AudioSettings.End = e.AudioSettings.End, e.AudioSettings.Start
+			}
+
+			if e.AudioSettings.Start == e.AudioSettings.End {
+				return 0, fmt.Errorf("Cannot apply trim suggestion for %s: %w", e.SongName(), ErrAudioSameStartEnd)
+			}
+		}
+
+		e.TrimSuggestion = nil
+		e.LastEdit = time.Now()
+
+		edited = append(edited, e)
+	}
+
+	if len(edited) == 0 {
+		return
+	}
+
+	for _, e := range edited {
+		m.Songs[e.ID] = e
+	}
+
+	err = m.Save(false)
+	if err != nil {
+		return
+	}
+
+	for _, e := range edited {
+		m.event("song-edit", map[string]interface{}{
+			"id":   e.ID,
+			"song": e,
+		})
+	}
+
+	return len(edited), nil
+}
+
+// DismissTrimSuggestion removes the trim suggestion of the given song without applying it
+func (m *Manager) DismissTrimSuggestion(id string) (err error) {
+	m.SongsLock.Lock()
+	defer m.SongsLock.Unlock()
+
+	e, ok := m.Songs[id]
+	if !ok {
+		return fmt.Errorf("Cannot edit entry with id %s as it doesn't exist", id)
+	}
+
+	if e.TrimSuggestion == nil {
+		return nil
+	}
+
+	e.TrimSuggestion = nil
+	m.Songs[id] = e
+
+	err = m.Save(false)
+	if err != nil {
+		return
+	}
+
+	m.event("song-edit", map[string]interface{}{
+		"id":   id,
+		"song": e,
+	})
+
+	return nil
+}
+
+// TrimSuggestions returns all songs that have a trim suggestion, grouped by the reason for it
+func (m *Manager) TrimSuggestions() (groups []Group) {
+	byReason := make(map[string][]music.Entry)
+
+	for _, e := range m.AllEntries() {
+		if e.TrimSuggestion == nil {
+			continue
+		}
+
+		byReason[e.TrimSuggestion.Reason] = append(byReason[e.TrimSuggestion.Reason], e)
+	}
+
+	for reason, songs := range byReason {
+		sort.Slice(songs, func(i, j int) bool {
+			return songs[i].Added.After(songs[j].Added)
+		})
+
+		groups = append(groups, Group{
+			Title:       reason,
+			Description: songLenDescription(len(songs)),
+			Songs:       songs,
+		})
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Title < groups[j].Title
+	})
+
+	return
+}
+
+// detectSilence runs ffmpeg's silencedetect filter on the given file and returns all silent parts
+func (m *Manager) detectSilence(inputPath string, noise string, minDuration float64, duration float64) (silences []interval, err error) {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(m.cfg.Alternatives.FFmpeg, "-hide_banner", "-nostats", "-i", inputPath,
+		"-af", fmt.Sprintf("silencedetect=noise=%s:d=%s", noise, strconv.FormatFloat(minDuration, 'f', -1, 64)),
+		"-vn", "-f", "null", "-")
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("running silencedetect: %s\nStderr: %s", err.Error(), stderr.String())
+	}
+
+	return parseSilenceDetect(&stderr, duration), nil
+}
+
+// parseSilenceDetect parses the log output of ffmpeg's silencedetect filter.
+// If the audio ends while it is silent, the last interval ends at `duration`
+func parseSilenceDetect(r io.Reader, duration float64) (silences []interval) {
+	var (
+		current   interval
+		inSilence bool
+	)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if v, ok := silenceValue(line, "silence_start:"); ok {
+			current = interval{Start: math.Max(v, 0)}
+			inSilence = true
+			continue
+		}
+
+		if v, ok := silenceValue(line, "silence_end:"); ok && inSilence {
+			current.End = v
+			silences = append(silences, current)
+			inSilence = false
+		}
+	}
+
+	if inSilence && duration > current.Start {
+		current.End = duration
+		silences = append(silences, current)
+	}
+
+	return
+}
+
+// silenceValue extracts the number after `key` from a silencedetect log line
+func silenceValue(line, key string) (v float64, ok bool) {
+	i := strings.Index(line, key)
+	if i == -1 {
+		return
+	}
+
+	fields := strings.Fields(line[i+len(key):])
+	if len(fields) == 0 {
+		return
+	}
+
+	v, err := strconv.ParseFloat(fields[0], 64)
+
+	return v, err == nil
+}
+
+// suggestTrim proposes trim points for a song of the given duration.
+// `silences` are used for cutting leading/trailing silence, `pauses` can be nil.
+// If they are given, a spoken intro or outro (e.g. in music videos) is detected from them.
+// Returns nil if nothing should be trimmed
+func suggestTrim(duration float64, silences, pauses []interval) (s *music.TrimSuggestion) {
+	const edge = 0.05
+
+	start, end := -1.0, -1.0
+	var reasons []string
+
+	if len(silences) > 0 {
+		first, last := silences[0], silences[len(silences)-1]
+
+		if first.Start <= edge && first.End < duration {
+			start = first.End
+			reasons = append(reasons, "Leading silence")
+		}
+
+		if last.End >= duration-edge && last.Start > math.Max(start, 0) {
+			end = last.Start
+			reasons = append(reasons, "Trailing silence")
+		}
+	}
+
+	if len(pauses) > 0 {
+		sounds := soundParts(duration, pauses)
+
+		// The first long stretch without pauses is where the music starts
+		for i, part := range sounds {
+			if part.length() < musicMinStretch {
+				continue
+			}
+
+			if part.Start >= introMinLength && part.Start <= introMaxLength && part.Start > start && i >= introMinParts {
+				start = part.Start
+				reasons = append(reasons, "Spoken intro")
+			}
+			break
+		}
+
+		// Same for the end, but backwards
+		for i := len(sounds) - 1; i >= 0; i-- {
+			part := sounds[i]
+			if part.length() < musicMinStretch {
+				continue
+			}
+
+			outro := duration - part.End
+			if outro >= introMinLength && outro <= introMaxLength && (end < 0 || part.End < end) && len(sounds)-1-i >= introMinParts {
+				end = part.End
+				reasons = append(reasons, "Spoken outro")
+			}
+			break
+		}
+	}
+
+	if start < 0 && end < 0 {
+		return nil
+	}
+
+	// Make sure we don't suggest cutting away everything
+	if start >= 0 && end >= 0 && end-start < 1 {
+		return nil
+	}
+
+	return &music.TrimSuggestion{
+		Start:  round(start),
+		End:    round(end),
+		Reason: strings.Join(reasons, ", "),
+	}
+}
+
+// soundParts returns the parts of the audio that are not in `pauses`
+func soundParts(duration float64, pauses []interval) (parts []interval) {
+	var last float64
+
+	for _, p := range pauses {
+		if p.Start > last {
+			parts = append(parts, interval{last, p.Start})
+		}
+		last = p.End
+	}
+
+	if duration > last {
+		parts = append(parts, interval{last, duration})
+	}
+
+	return
+}
+
+// differentTrim returns whether applying `s` would change `a` in a noticeable way
+func differentTrim(a music.AudioSettings, s music.TrimSuggestion) bool {
+	if s.Start >= 0 && math.Abs(math.Max(a.Start, 0)-s.Start) > trimTolerance {
+		return true
+	}
+
+	return s.End >= 0 && (a.End < 0 || math.Abs(a.End-s.End) > trimTolerance)
+}
+
+// round rounds a time to milliseconds, but keeps the -1 of unset values
+func round(t float64) float64 {
+	if t < 0 {
+		return -1
+	}
+	return math.Round(t*1000) / 1000
+}
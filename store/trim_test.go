@@ -0,0 +1,157 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func Test_parseSilenceDetect(t *testing.T) {
+	tests := []struct {
+		output   string
+		duration float64
+		want     []interval
+	}{
+		{"", 10, nil},
+		{
+			`[silencedetect @ 0x55d5c2d0a8c0] silence_start: 0
+[silencedetect @ 0x55d5c2d0a8c0] silence_end: 2.5 | silence_duration: 2.5
+size=N/A time=00:03:00.00 bitrate=N/A speed= 512x
+[silencedetect @ 0x55d5c2d0a8c0] silence_start: 170.25
+[silencedetect @ 0x55d5c2d0a8c0] silence_end: 171 | silence_duration: 0.75`,
+			180,
+			[]interval{{0, 2.5}, {170.25, 171}},
+		},
+		{
+			// Audio ends while silent
+			`[silencedetect @ 0x1] silence_start: -0.01
+[silencedetect @ 0x1] silence_end: 1.2 | silence_duration: 1.21
+[silencedetect @ 0x1] silence_start: 175.5`,
+			180,
+			[]interval{{0, 1.2}, {175.5, 180}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(t.Name(), func(t *testing.T) {
+			if got := parseSilenceDetect(strings.NewReader(tt.output), tt.duration); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSilenceDetect() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_suggestTrim(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration float64
+		silences []interval
+		pauses   []interval
+
+		wantNil    bool
+		start, end float64
+	}{
+		{
+			name:     "nothing to trim",
+			duration: 200,
+			wantNil:  true,
+		},
+		{
+			name:     "leading and trailing silence",
+			duration: 200,
+			silences: []interval{{0, 3}, {100, 101}, {195, 200}},
+			start:    3,
+			end:      195,
+		},
+		{
+			name:     "spoken intro",
+			duration: 240,
+			// talking with short pauses for the first 15 seconds, then music
+			pauses: []interval{{2, 2.5}, {6, 6.4}, {9, 9.3}, {14.5, 15}},
+			start:  15,
+			end:    -1,
+		},
+		{
+			name:     "spoken outro after trailing silence check",
+			duration: 240,
+			silences: []interval{{0, 1}},
+			pauses:   []interval{{0, 1}, {200, 200.5}, {210, 210.5}, {220, 221}},
+			start:    1,
+			end:      200,
+		},
+		{
+			name:     "short break in music is not an intro",
+			duration: 240,
+			pauses:   []interval{{10, 10.3}},
+			wantNil:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := suggestTrim(tt.duration, tt.silences, tt.pauses)
+			if tt.wantNil {
+				if got != nil {
+					t.Errorf("suggestTrim() = %+v, want nil", *got)
+				}
+				return
+			}
+
+			if got == nil {
+				t.Fatalf("suggestTrim() = nil, want start %f and end %f", tt.start, tt.end)
+			}
+
+			if got.Start != tt.start || got.End != tt.end {
+				t.Errorf("suggestTrim() = %f-%f, want %f-%f", got.Start, got.End, tt.start, tt.end)
+			}
+		})
+	}
+}
+
+func TestManager_ApplyTrimSuggestions(t *testing.T) {
+	// Applying saves to the data directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	m := &Manager{
+		SongsLock: new(sync.RWMutex),
+		Songs: map[string]music.Entry{
+			// The suggested start is after the current end
+			"a": {ID: "a", AudioSettings: music.AudioSettings{Start: -1, End: 100},
+				TrimSuggestion: &music.TrimSuggestion{Start: 150, End: -1}},
+			"b": {ID: "b", AudioSettings: music.AudioSettings{Start: -1, End: -1}},
+			// Would result in a song without any audio
+			"c": {ID: "c", AudioSettings: music.AudioSettings{Start: 20, End: -1},
+				TrimSuggestion: &music.TrimSuggestion{Start: -1, End: 20}},
+		},
+	}
+
+	_, err = m.ApplyTrimSuggestions("a", "missing")
+	if err == nil {
+		t.Errorf("expected error for a song that doesn't exist")
+	}
+	_, err = m.ApplyTrimSuggestions("a", "c")
+	if !errors.Is(err, ErrAudioSameStartEnd) {
+		t.Errorf("expected ErrAudioSameStartEnd, got %v", err)
+	}
+	if m.Songs["a"].TrimSuggestion == nil || m.Songs["a"].AudioSettings.End != 100 {
+		t.Fatalf("song was changed even though applying failed: %+v", m.Songs["a"])
+	}
+
+	n, err := m.ApplyTrimSuggestions("a", "b", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a := m.Songs["a"]; n != 1 || a.AudioSettings.Start != 100 || a.AudioSettings.End != 150 || a.TrimSuggestion != nil {
+		t.Errorf("expected start and end to be swapped, got %d changes and %+v", n, a)
+	}
+}
@@ -0,0 +1,291 @@
+package subsonic
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func (s *Server) handlePing(w http.ResponseWriter, r *request) error {
+	writeResponse(w, r.Request, &response{})
+	return nil
+}
+
+// handleGetLicense tells clients that this server is fine to use, which is true
+func (s *Server) handleGetLicense(w http.ResponseWriter, r *request) error {
+	writeResponse(w, r.Request, &response{
+		License: &license{Valid: true},
+	})
+	return nil
+}
+
+// handleGetMusicFolders returns the only music folder we have
+func (s *Server) handleGetMusicFolders(w http.ResponseWriter, r *request) error {
+	writeResponse(w, r.Request, &response{
+		MusicFolders: &musicFolders{
+			MusicFolders: []musicFolder{{ID: 1, Name: "Music"}},
+		},
+	})
+	return nil
+}
+
+// handleGetIndexes returns all artists for browsing by folders
+func (s *Server) handleGetIndexes(w http.ResponseWriter, r *request) error {
+	writeResponse(w, r.Request, &response{
+		Indexes: &indexes{
+			LastModified:    r.lib.lastModified().UnixNano() / 1e6,
+			IgnoredArticles: ignoredArticles,
+			Indexes:         r.lib.indexes(false),
+		},
+	})
+	return nil
+}
+
+// handleGetMusicDirectory returns the albums of an artist or the songs of an album when browsing by folders
+func (s *Server) handleGetMusicDirectory(w http.ResponseWriter, r *request) error {
+	id, err := r.requireParam("id")
+	if err != nil {
+		return err
+	}
+
+	if ar, ok := r.lib.artist(id); ok {
+		dir := &directory{
+			ID:   ar.ID,
+			Name: ar.Name,
+		}
+
+		for _, al := range ar.Albums {
+			dir.Children = append(dir.Children, r.lib.albumDir(al))
+		}
+
+		writeResponse(w, r.Request, &response{Directory: dir})
+		return nil
+	}
+
+	if al, ok := r.lib.album(id); ok {
+		dir := &directory{
+			ID:     al.ID,
+			Parent: al.Artist.ID,
+			Name:   al.AlbumTitle(),
+		}
+
+		for _, e := range al.Songs {
+			dir.Children = append(dir.Children, r.lib.child(e))
+		}
+
+		writeResponse(w, r.Request, &response{Directory: dir})
+		return nil
+	}
+
+	return apiError{Code: errNotFound, Message: "Directory not found"}
+}
+
+// handleGetArtists returns all artists, grouped by their first letter
+func (s *Server) handleGetArtists(w http.ResponseWriter, r *request) error {
+	writeResponse(w, r.Request, &response{
+		Artists: &artists{
+			IgnoredArticles: ignoredArticles,
+			Indexes:         r.lib.indexes(false),
+		},
+	})
+	return nil
+}
+
+// handleGetArtist returns an artist and their albums
+func (s *Server) handleGetArtist(w http.ResponseWriter, r *request) error {
+	id, err := r.requireParam("id")
+	if err != nil {
+		return err
+	}
+
+	ar, ok := r.lib.artist(id)
+	if !ok {
+		return apiError{Code: errNotFound, Message: "Artist not found"}
+	}
+
+	a := r.lib.artistID3(ar, true)
+
+	writeResponse(w, r.Request, &response{Artist: &a})
+	return nil
+}
+
+// handleGetAlbum returns an album and its songs
+func (s *Server) handleGetAlbum(w http.ResponseWriter, r *request) error {
+	id, err := r.requireParam("id")
+	if err != nil {
+		return err
+	}
+
+	al, ok := r.lib.album(id)
+	if !ok {
+		return apiError{Code: errNotFound, Message: "Album not found"}
+	}
+
+	a := r.lib.albumID3(al, true)
+
+	writeResponse(w, r.Request, &response{Album: &a})
+	return nil
+}
+
+func (s *Server) handleGetSong(w http.ResponseWriter, r *request) error {
+	id, err := r.requireParam("id")
+	if err != nil {
+		return err
+	}
+
+	e, ok := r.lib.song(id)
+	if !ok {
+		return errSongNotFound
+	}
+
+	c := r.lib.child(e)
+
+	writeResponse(w, r.Request, &response{Song: &c})
+	return nil
+}
+
+// handleSearch3 searches artists, albums and songs. An empty query returns everything, some clients use that to sync the library
+func (s *Server) handleSearch3(w http.ResponseWriter, r *request) error {
+	query := strings.TrimSpace(strings.Trim(r.FormValue("query"), `"`))
+	upperQuery := strings.ToUpper(query)
+
+	res := &searchResult3{}
+
+	var artistResults []*artist
+	for _, a := range r.lib.artists {
+		if strings.Contains(strings.ToUpper(a.Name), upperQuery) {
+			artistResults = append(artistResults, a)
+		}
+	}
+	from, to := page(len(artistResults), r.intParam("artistOffset", 0), r.intParam("artistCount", 20))
+	for _, a := range artistResults[from:to] {
+		res.Artists = append(res.Artists, r.lib.artistID3(a, false))
+	}
+
+	var albumResults []*album
+	for _, a := range r.lib.artists {
+		for _, al := range a.Albums {
+			if strings.Contains(strings.ToUpper(al.Title), upperQuery) {
+				albumResults = append(albumResults, al)
+			}
+		}
+	}
+	from, to = page(len(albumResults), r.intParam("albumOffset", 0), r.intParam("albumCount", 20))
+	for _, al := range albumResults[from:to] {
+		res.Albums = append(res.Albums, r.lib.albumID3(al, false))
+	}
+
+	var songResults []music.Entry
+	if query == "" {
+		for _, e := range r.lib.songs {
+			songResults = append(songResults, e)
+		}
+		sort.Slice(songResults, func(i, j int) bool {
+			return strings.ToUpper(songResults[i].SongName()) < strings.ToUpper(songResults[j].SongName())
+		})
+	} else {
+		for _, e := range s.m.Search(query) {
+			// Only return songs this user can see
+			if _, ok := r.lib.song(e.ID); ok {
+				songResults = append(songResults, e)
+			}
+		}
+	}
+	from, to = page(len(songResults), r.intParam("songOffset", 0), r.intParam("songCount", 20))
+	for _, e := range songResults[from:to] {
+		res.Songs = append(res.Songs, r.lib.child(e))
+	}
+
+	writeResponse(w, r.Request, &response{SearchResult3: res})
+	return nil
+}
+
+// page returns the bounds of a page of a list with `length` items
+func page(length, offset, count int) (from, to int) {
+	if offset < 0 {
+		offset = 0
+	}
+	if count < 0 {
+		count = 0
+	}
+
+	from = offset
+	if from > length {
+		from = length
+	}
+
+	to = from + count
+	if to > length {
+		to = length
+	}
+
+	return
+}
+
+// handleGetPlaylists returns all playlists
+func (s *Server) handleGetPlaylists(w http.ResponseWriter, r *request) error {
+	res := &playlists{}
+	for _, p := range s.m.AllPlaylists() {
+		res.Playlists = append(res.Playlists, r.lib.playlist(p, false))
+	}
+
+	writeResponse(w, r.Request, &response{Playlists: res})
+	return nil
+}
+
+// handleGetPlaylist returns a playlist and its songs
+func (s *Server) handleGetPlaylist(w http.ResponseWriter, r *request) error {
+	id, err := r.requireParam("id")
+	if err != nil {
+		return err
+	}
+
+	p, ok := s.m.GetPlaylist(id)
+	if !ok {
+		return apiError{Code: errNotFound, Message: "Playlist not found"}
+	}
+
+	res := r.lib.playlist(p, true)
+
+	writeResponse(w, r.Request, &response{Playlist: &res})
+	return nil
+}
+
+// handleScrobble is called by clients when a song is played. Submitted plays are added to the play history,
+// "now playing" notifications are ignored. Clients may send several songs, each with an optional time
+func (s *Server) handleScrobble(w http.ResponseWriter, r *request) error {
+	_, err := r.requireParam("id")
+	if err != nil {
+		return err
+	}
+
+	if r.FormValue("submission") == "false" {
+		writeResponse(w, r.Request, &response{})
+		return nil
+	}
+
+	ids, times := r.Form["id"], r.Form["time"]
+	for i, id := range ids {
+		if _, ok := r.lib.song(id); !ok {
+			return errSongNotFound
+		}
+
+		t := time.Now()
+		if i < len(times) {
+			if ms, err := strconv.ParseInt(times[i], 10, 64); err == nil {
+				t = time.UnixMilli(ms)
+			}
+		}
+
+		err = s.m.RecordPlay(id, t)
+		if err != nil {
+			return err
+		}
+	}
+
+	writeResponse(w, r.Request, &response{})
+	return nil
+}
@@ -0,0 +1,414 @@
+package subsonic
+
+import (
+	"encoding/base64"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/store/music"
+)
+
+// ignoredArticles are skipped when sorting artists into indexes
+const ignoredArticles = "The El La Los Las Le Les"
+
+// library contains all songs a user is allowed to see, grouped into artists and albums.
+// The API has IDs for artists and albums, we generate them from their names
+type library struct {
+	user    config.FTPUser
+	profile config.Profile
+	layout  store.Layout
+
+	artists []*artist
+
+	albums map[string]*album
+	songs  map[string]music.Entry
+}
+
+type artist struct {
+	ID   string
+	Name string
+
+	Albums []*album
+}
+
+type album struct {
+	store.Album
+
+	ID     string
+	Artist *artist
+
+	// tracks are the positions of the songs in the album
+	tracks map[string]int
+}
+
+// maxLibraryAge is how long a library is used if nothing changes. Filters with smart playlists that have date rules
+// can select other songs without anything in the library changing
+const maxLibraryAge = 10 * time.Minute
+
+// cachedLibrary is a library together with the manager generation it was built from
+type cachedLibrary struct {
+	lib *library
+
+	generation uint64
+	built      time.Time
+}
+
+// library returns the library of `user`. It is only built again if the manager was changed since,
+// as clients usually send many requests in a row. Libraries are never modified after building them
+func (s *Server) library(cfg config.Config, user config.FTPUser) *library {
+	s.librariesMu.Lock()
+	defer s.librariesMu.Unlock()
+
+	c, ok := s.libraries[user.Name]
+	if ok && c.generation == s.m.Generation() && time.Since(c.built) < maxLibraryAge {
+		return c.lib
+	}
+
+	// Changes while building are picked up by the next request
+	c = &cachedLibrary{generation: s.m.Generation(), built: time.Now()}
+	c.lib = newLibrary(s.m, cfg, user)
+	s.libraries[user.Name] = c
+
+	return c.lib
+}
+
+func newLibrary(m *store.Manager, cfg config.Config, user config.FTPUser) *library {
+	lib := &library{
+		user:   user,
+		albums: make(map[string]*album),
+		songs:  make(map[string]music.Entry),
+	}
+
+	lib.profile, _ = cfg.Profile(user.Profile)
+
+	var err error
+	lib.layout, err = store.ParseLayout(user.Layout)
+	if err != nil {
+		lib.layout, _ = store.ParseLayout("")
+	}
+
+	artistsByID := make(map[string]*artist)
+	filter := m.NewFilter(user.Filter)
+
+	for _, a := range m.GroupByAlbum() {
+		var songs []music.Entry
+		for _, e := range a.Songs {
+			if store.MatchesFilter(e, filter) {
+				songs = append(songs, e)
+			}
+		}
+		if len(songs) == 0 {
+			continue
+		}
+
+		name := songs[0].Artist()
+
+		ar, ok := artistsByID[artistID(name)]
+		if !ok {
+			ar = &artist{
+				ID:   artistID(name),
+				Name: name,
+			}
+			artistsByID[ar.ID] = ar
+			lib.artists = append(lib.artists, ar)
+		}
+
+		al := &album{
+			Album: store.Album{
+				Title:  songs[0].AlbumName(),
+				Artist: name,
+				Songs:  songs,
+			},
+			ID:     albumID(name, songs[0].AlbumName()),
+			Artist: ar,
+			tracks: make(map[string]int),
+		}
+
+		// Songs without track number are numbered by their position in the album, just like FTP does
+		for i, e := range songs {
+			al.tracks[e.ID] = i + 1
+			if e.MusicData.Track > 0 {
+				al.tracks[e.ID] = e.MusicData.Track
+			}
+
+			lib.songs[e.ID] = e
+		}
+
+		sort.SliceStable(al.Songs, func(i, j int) bool {
+			a, b := al.Songs[i], al.Songs[j]
+			if a.MusicData.Disc != b.MusicData.Disc {
+				return a.MusicData.Disc < b.MusicData.Disc
+			}
+			return al.tracks[a.ID] < al.tracks[b.ID]
+		})
+
+		ar.Albums = append(ar.Albums, al)
+		lib.albums[al.ID] = al
+	}
+
+	sort.SliceStable(lib.artists, func(i, j int) bool {
+		return sortName(lib.artists[i].Name) < sortName(lib.artists[j].Name)
+	})
+
+	return lib
+}
+
+// artistID returns the ID for the artist with the given name
+func artistID(name string) string {
+	return "ar-" + base64.RawURLEncoding.EncodeToString([]byte(strings.ToUpper(store.CleanName(name))))
+}
+
+// albumID returns the ID for the given album. Albums with the same name by different artists have different IDs
+func albumID(artist, title string) string {
+	return "al-" + base64.RawURLEncoding.EncodeToString([]byte(strings.ToUpper(store.CleanName(artist)+"/"+store.CleanName(title))))
+}
+
+// sortName returns the name used for sorting, ignored articles are removed
+func sortName(name string) string {
+	name = strings.ToUpper(name)
+	for _, article := range strings.Fields(ignoredArticles) {
+		if strings.HasPrefix(name, strings.ToUpper(article)+" ") {
+			return strings.TrimSpace(name[len(article):])
+		}
+	}
+	return name
+}
+
+// indexName returns the name of the index an artist is sorted into, e.g. "A" or "#"
+func indexName(name string) string {
+	for _, r := range sortName(name) {
+		if r >= 'A' && r <= 'Z' {
+			return string(r)
+		}
+		break
+	}
+	return "#"
+}
+
+func (l *library) artist(id string) (*artist, bool) {
+	for _, a := range l.artists {
+		if a.ID == id {
+			return a, true
+		}
+	}
+	return nil, false
+}
+
+func (l *library) album(id string) (al *album, ok bool) {
+	al, ok = l.albums[id]
+	return
+}
+
+func (l *library) song(id string) (e music.Entry, ok bool) {
+	e, ok = l.songs[id]
+	return
+}
+
+// lastModified returns the last time any song was edited
+func (l *library) lastModified() (t time.Time) {
+	for _, e := range l.songs {
+		if e.LastEdit.After(t) {
+			t = e.LastEdit
+		}
+	}
+	return
+}
+
+// indexes groups all artists by the first letter of their name
+func (l *library) indexes(withAlbums bool) (list []index) {
+	for _, a := range l.artists {
+		name := indexName(a.Name)
+		if len(list) == 0 || list[len(list)-1].Name != name {
+			list = append(list, index{Name: name})
+		}
+
+		list[len(list)-1].Artists = append(list[len(list)-1].Artists, l.artistID3(a, withAlbums))
+	}
+
+	// "#" should be the first index, but artists starting with e.g. a number might not be sorted before letters
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Name == "#" && list[j].Name != "#"
+	})
+
+	return mergeIndexes(list)
+}
+
+// mergeIndexes merges adjacent indexes with the same name
+func mergeIndexes(list []index) (merged []index) {
+	for _, i := range list {
+		if len(merged) > 0 && merged[len(merged)-1].Name == i.Name {
+			merged[len(merged)-1].Artists = append(merged[len(merged)-1].Artists, i.Artists...)
+			continue
+		}
+		merged = append(merged, i)
+	}
+	return
+}
+
+func (l *library) artistID3(a *artist, withAlbums bool) artistID3 {
+	res := artistID3{
+		ID:         a.ID,
+		Name:       a.Name,
+		AlbumCount: len(a.Albums),
+	}
+
+	for _, al := range a.Albums {
+		if res.CoverArt == "" {
+			res.CoverArt = albumCover(al)
+		}
+
+		if withAlbums {
+			res.Albums = append(res.Albums, l.albumID3(al, false))
+		}
+	}
+
+	return res
+}
+
+func (l *library) albumID3(al *album, withSongs bool) albumID3 {
+	res := albumID3{
+		ID:        al.ID,
+		Name:      al.AlbumTitle(),
+		Artist:    al.Artist.Name,
+		ArtistID:  al.Artist.ID,
+		CoverArt:  albumCover(al),
+		SongCount: len(al.Songs),
+	}
+
+	var created time.Time
+	for _, e := range al.Songs {
+		res.Duration += int(e.PlayDuration())
+
+		if created.IsZero() || e.Added.Before(created) {
+			created = e.Added
+		}
+		if res.Year == 0 && e.MusicData.Year != nil {
+			res.Year = *e.MusicData.Year
+		}
+		if res.Genre == "" {
+			res.Genre = e.MusicData.Genre
+		}
+
+		if withSongs {
+			res.Songs = append(res.Songs, l.child(e))
+		}
+	}
+	res.Created = created.UTC().Format(time.RFC3339)
+
+	return res
+}
+
+// playlist returns the API representation of a playlist. Songs the user can't see are left out
+func (l *library) playlist(p store.Playlist, withSongs bool) playlist {
+	res := playlist{
+		ID:      p.ID,
+		Name:    p.Name,
+		Public:  true,
+		Created: p.Created.UTC().Format(time.RFC3339),
+		Changed: p.LastEdit.UTC().Format(time.RFC3339),
+	}
+
+	for _, id := range p.Songs {
+		e, ok := l.song(id)
+		if !ok {
+			continue
+		}
+
+		if res.CoverArt == "" && e.PictureData.Filename != "" {
+			res.CoverArt = e.ID
+		}
+
+		res.SongCount++
+		res.Duration += int(e.PlayDuration())
+
+		if withSongs {
+			res.Entries = append(res.Entries, l.child(e))
+		}
+	}
+
+	return res
+}
+
+// albumDir returns the album as directory entry for browsing by folders
+func (l *library) albumDir(al *album) child {
+	a := l.albumID3(al, false)
+
+	return child{
+		ID:       a.ID,
+		Parent:   a.ArtistID,
+		IsDir:    true,
+		Title:    a.Name,
+		Album:    a.Name,
+		Artist:   a.Artist,
+		Year:     a.Year,
+		Genre:    a.Genre,
+		CoverArt: a.CoverArt,
+		Created:  a.Created,
+		ArtistID: a.ArtistID,
+	}
+}
+
+// child returns the API representation of a song
+func (l *library) child(e music.Entry) child {
+	al := l.albums[albumID(e.Artist(), e.AlbumName())]
+
+	ext := filepath.Ext(e.FileData.Filename)
+	transcodedExt := l.profile.Extension(ext)
+
+	c := child{
+		ID:     e.ID,
+		Title:  e.MusicData.Title,
+		Album:  e.AlbumName(),
+		Artist: e.Artist(),
+		Genre:  e.MusicData.Genre,
+
+		Size:        e.FileData.Size,
+		ContentType: music.ContentType(ext),
+		Suffix:      strings.TrimPrefix(ext, "."),
+		Duration:    int(e.PlayDuration()),
+
+		UserRating: e.MusicData.Rating,
+		DiscNumber: e.MusicData.Disc,
+		Created:    e.Added.UTC().Format(time.RFC3339),
+		ArtistID:   artistID(e.Artist()),
+		Type:       "music",
+	}
+
+	if e.PictureData.Filename != "" {
+		c.CoverArt = e.ID
+	}
+	if e.MusicData.Year != nil {
+		c.Year = *e.MusicData.Year
+	}
+
+	// Streams are transcoded using the users' profile
+	if transcodedExt != ext {
+		c.TranscodedContentType = music.ContentType(transcodedExt)
+		c.TranscodedSuffix = strings.TrimPrefix(transcodedExt, ".")
+	}
+
+	var track int
+	if al != nil {
+		track = al.tracks[e.ID]
+		c.Parent = al.ID
+		c.AlbumID = al.ID
+	}
+	c.Track = track
+
+	c.Path = strings.Join(l.layout.Path(e, ext, track), "/")
+
+	return c
+}
+
+// albumCover returns the ID of the first song with a cover in the album
+func albumCover(al *album) string {
+	for _, e := range al.Songs {
+		if e.PictureData.Filename != "" {
+			return e.ID
+		}
+	}
+	return ""
+}
@@ -0,0 +1,132 @@
+package subsonic
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/music"
+)
+
+// handleStream streams a song. It is transcoded using the users' profile, unless the client requests
+// the original file using the format "raw" or another profile by its name
+func (s *Server) handleStream(w http.ResponseWriter, r *request) (err error) {
+	id, err := r.requireParam("id")
+	if err != nil {
+		return err
+	}
+
+	e, ok := r.lib.song(id)
+	if !ok {
+		return errSongNotFound
+	}
+
+	p := e.AudioPath()
+
+	format := r.FormValue("format")
+	if format != "raw" {
+		profile := r.user.Profile
+		if _, ok := r.cfg.Profiles[format]; ok {
+			profile = format
+		}
+
+		p, err = e.ProfilePath(r.cfg, profile)
+		if err != nil {
+			return
+		}
+	}
+
+	return serveFile(w, r, e, p, false)
+}
+
+// handleDownload downloads the original audio file of a song
+func (s *Server) handleDownload(w http.ResponseWriter, r *request) (err error) {
+	id, err := r.requireParam("id")
+	if err != nil {
+		return err
+	}
+
+	e, ok := r.lib.song(id)
+	if !ok {
+		return errSongNotFound
+	}
+
+	return serveFile(w, r, e, e.AudioPath(), true)
+}
+
+// serveFile serves an audio file of `e`, range requests are supported
+func serveFile(w http.ResponseWriter, r *request, e music.Entry, p string, attachment bool) (err error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	ext := filepath.Ext(p)
+
+	if contentType := music.ContentType(ext); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	if attachment {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", store.CleanName(e.Filename(strings.TrimPrefix(ext, ".")))))
+	}
+
+	http.ServeContent(w, r.Request, fi.Name(), fi.ModTime(), f)
+
+	return nil
+}
+
+// handleGetCoverArt returns the cover of a song. Small sizes are served from the cover preview cache
+func (s *Server) handleGetCoverArt(w http.ResponseWriter, r *request) (err error) {
+	id, err := r.requireParam("id")
+	if err != nil {
+		return err
+	}
+
+	// Clients usually ask for covers with IDs we returned, but some also use the album or artist ID
+	if al, ok := r.lib.album(id); ok {
+		id = albumCover(al)
+	} else if ar, ok := r.lib.artist(id); ok {
+		id = r.lib.artistID3(ar, false).CoverArt
+	}
+
+	e, ok := r.lib.song(id)
+	if !ok || e.PictureData.Filename == "" {
+		return apiError{Code: errNotFound, Message: "Cover not found"}
+	}
+
+	// Previews are 120px wide
+	if size := r.intParam("size", 0); size > 0 && size <= 120 {
+		coverBytes, format, err := e.CoverPreview()
+		if err != nil {
+			return err
+		}
+
+		w.Header().Set("Content-Type", format)
+		http.ServeContent(w, r.Request, "cover-small.jpg", e.LastEdit, bytes.NewReader(coverBytes))
+		return nil
+	}
+
+	f, err := os.Open(e.CoverPath())
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	if mtype := mime.TypeByExtension(filepath.Ext(e.CoverPath())); mtype != "" {
+		w.Header().Set("Content-Type", mtype)
+	}
+
+	http.ServeContent(w, r.Request, filepath.Base(e.CoverPath()), e.LastEdit, f)
+
+	return nil
+}
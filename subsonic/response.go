@@ -0,0 +1,218 @@
+package subsonic
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"log"
+	"net/http"
+)
+
+const (
+	// apiVersion is the version of the Subsonic API we implement
+	apiVersion = "1.16.1"
+
+	errGeneric           = 0
+	errMissingParameter  = 10
+	errWrongCredentials  = 40
+	errTokenNotSupported = 41
+	errNotFound          = 70
+)
+
+// apiError is an error that is sent to the client in a failed response
+type apiError struct {
+	Code    int    `xml:"code,attr" json:"code"`
+	Message string `xml:"message,attr" json:"message"`
+}
+
+func (a apiError) Error() string {
+	return a.Message
+}
+
+var errSongNotFound = apiError{Code: errNotFound, Message: "Song not found"}
+
+// response is the root element of all responses. Only one of the optional fields is set
+type response struct {
+	XMLName xml.Name `xml:"http://subsonic.org/restapi subsonic-response" json:"-"`
+
+	Status  string `xml:"status,attr" json:"status"`
+	Version string `xml:"version,attr" json:"version"`
+
+	// These attributes are OpenSubsonic extensions
+	Type         string `xml:"type,attr" json:"type"`
+	OpenSubsonic bool   `xml:"openSubsonic,attr" json:"openSubsonic"`
+
+	Error *apiError `xml:"error,omitempty" json:"error,omitempty"`
+
+	License       *license       `xml:"license,omitempty" json:"license,omitempty"`
+	MusicFolders  *musicFolders  `xml:"musicFolders,omitempty" json:"musicFolders,omitempty"`
+	Indexes       *indexes       `xml:"indexes,omitempty" json:"indexes,omitempty"`
+	Directory     *directory     `xml:"directory,omitempty" json:"directory,omitempty"`
+	Artists       *artists       `xml:"artists,omitempty" json:"artists,omitempty"`
+	Artist        *artistID3     `xml:"artist,omitempty" json:"artist,omitempty"`
+	Album         *albumID3      `xml:"album,omitempty" json:"album,omitempty"`
+	Song          *child         `xml:"song,omitempty" json:"song,omitempty"`
+	SearchResult3 *searchResult3 `xml:"searchResult3,omitempty" json:"searchResult3,omitempty"`
+	Playlists     *playlists     `xml:"playlists,omitempty" json:"playlists,omitempty"`
+	Playlist      *playlist      `xml:"playlist,omitempty" json:"playlist,omitempty"`
+}
+
+type license struct {
+	Valid bool `xml:"valid,attr" json:"valid"`
+}
+
+type musicFolders struct {
+	MusicFolders []musicFolder `xml:"musicFolder" json:"musicFolder"`
+}
+
+type musicFolder struct {
+	ID   int    `xml:"id,attr" json:"id"`
+	Name string `xml:"name,attr" json:"name"`
+}
+
+type indexes struct {
+	LastModified    int64   `xml:"lastModified,attr" json:"lastModified"`
+	IgnoredArticles string  `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Indexes         []index `xml:"index" json:"index,omitempty"`
+}
+
+type index struct {
+	Name    string      `xml:"name,attr" json:"name"`
+	Artists []artistID3 `xml:"artist" json:"artist"`
+}
+
+type directory struct {
+	ID       string  `xml:"id,attr" json:"id"`
+	Parent   string  `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	Name     string  `xml:"name,attr" json:"name"`
+	Children []child `xml:"child" json:"child,omitempty"`
+}
+
+type artists struct {
+	IgnoredArticles string  `xml:"ignoredArticles,attr" json:"ignoredArticles"`
+	Indexes         []index `xml:"index" json:"index,omitempty"`
+}
+
+type artistID3 struct {
+	ID         string `xml:"id,attr" json:"id"`
+	Name       string `xml:"name,attr" json:"name"`
+	CoverArt   string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	AlbumCount int    `xml:"albumCount,attr" json:"albumCount"`
+
+	Albums []albumID3 `xml:"album" json:"album,omitempty"`
+}
+
+type albumID3 struct {
+	ID        string `xml:"id,attr" json:"id"`
+	Name      string `xml:"name,attr" json:"name"`
+	Artist    string `xml:"artist,attr" json:"artist"`
+	ArtistID  string `xml:"artistId,attr" json:"artistId"`
+	CoverArt  string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+	SongCount int    `xml:"songCount,attr" json:"songCount"`
+	Duration  int    `xml:"duration,attr" json:"duration"`
+	Created   string `xml:"created,attr" json:"created"`
+	Year      int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre     string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+
+	Songs []child `xml:"song" json:"song,omitempty"`
+}
+
+// child is a song or, when browsing by directory, an album
+type child struct {
+	ID     string `xml:"id,attr" json:"id"`
+	Parent string `xml:"parent,attr,omitempty" json:"parent,omitempty"`
+	IsDir  bool   `xml:"isDir,attr" json:"isDir"`
+	Title  string `xml:"title,attr" json:"title"`
+
+	Album    string `xml:"album,attr,omitempty" json:"album,omitempty"`
+	Artist   string `xml:"artist,attr,omitempty" json:"artist,omitempty"`
+	Track    int    `xml:"track,attr,omitempty" json:"track,omitempty"`
+	Year     int    `xml:"year,attr,omitempty" json:"year,omitempty"`
+	Genre    string `xml:"genre,attr,omitempty" json:"genre,omitempty"`
+	CoverArt string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+
+	Size                  int64  `xml:"size,attr,omitempty" json:"size,omitempty"`
+	ContentType           string `xml:"contentType,attr,omitempty" json:"contentType,omitempty"`
+	Suffix                string `xml:"suffix,attr,omitempty" json:"suffix,omitempty"`
+	TranscodedContentType string `xml:"transcodedContentType,attr,omitempty" json:"transcodedContentType,omitempty"`
+	TranscodedSuffix      string `xml:"transcodedSuffix,attr,omitempty" json:"transcodedSuffix,omitempty"`
+	Duration              int    `xml:"duration,attr,omitempty" json:"duration,omitempty"`
+	Path                  string `xml:"path,attr,omitempty" json:"path,omitempty"`
+
+	UserRating int    `xml:"userRating,attr,omitempty" json:"userRating,omitempty"`
+	DiscNumber int    `xml:"discNumber,attr,omitempty" json:"discNumber,omitempty"`
+	Created    string `xml:"created,attr,omitempty" json:"created,omitempty"`
+	AlbumID    string `xml:"albumId,attr,omitempty" json:"albumId,omitempty"`
+	ArtistID   string `xml:"artistId,attr,omitempty" json:"artistId,omitempty"`
+	Type       string `xml:"type,attr,omitempty" json:"type,omitempty"`
+}
+
+type searchResult3 struct {
+	Artists []artistID3 `xml:"artist" json:"artist,omitempty"`
+	Albums  []albumID3  `xml:"album" json:"album,omitempty"`
+	Songs   []child     `xml:"song" json:"song,omitempty"`
+}
+
+type playlists struct {
+	Playlists []playlist `xml:"playlist" json:"playlist,omitempty"`
+}
+
+type playlist struct {
+	ID        string `xml:"id,attr" json:"id"`
+	Name      string `xml:"name,attr" json:"name"`
+	Comment   string `xml:"comment,attr,omitempty" json:"comment,omitempty"`
+	Owner     string `xml:"owner,attr,omitempty" json:"owner,omitempty"`
+	Public    bool   `xml:"public,attr" json:"public"`
+	SongCount int    `xml:"songCount,attr" json:"songCount"`
+	Duration  int    `xml:"duration,attr" json:"duration"`
+	Created   string `xml:"created,attr" json:"created"`
+	Changed   string `xml:"changed,attr" json:"changed"`
+	CoverArt  string `xml:"coverArt,attr,omitempty" json:"coverArt,omitempty"`
+
+	// Entries are only set for getPlaylist
+	Entries []child `xml:"entry" json:"entry,omitempty"`
+}
+
+// writeResponse writes `resp` in the format requested by the client, which is either XML (default), JSON or JSONP
+func writeResponse(w http.ResponseWriter, r *http.Request, resp *response) {
+	if resp.Status == "" {
+		resp.Status = "ok"
+	}
+	resp.Version = apiVersion
+	resp.Type = "sensibleHub"
+	resp.OpenSubsonic = true
+
+	var err error
+
+	switch r.FormValue("f") {
+	case "json", "jsonp":
+		callback := r.FormValue("callback")
+
+		if callback != "" && r.FormValue("f") == "jsonp" {
+			w.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+			_, err = w.Write([]byte(callback + "("))
+		} else {
+			w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		}
+
+		if err == nil {
+			err = json.NewEncoder(w).Encode(map[string]*response{
+				"subsonic-response": resp,
+			})
+		}
+
+		if err == nil && callback != "" && r.FormValue("f") == "jsonp" {
+			_, err = w.Write([]byte(");"))
+		}
+	default:
+		w.Header().Set("Content-Type", "text/xml; charset=utf-8")
+
+		_, err = w.Write([]byte(xml.Header))
+		if err == nil {
+			err = xml.NewEncoder(w).Encode(resp)
+		}
+	}
+
+	if err != nil {
+		log.Println("[Subsonic] Error while writing response:", err.Error())
+	}
+}
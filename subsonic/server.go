@@ -0,0 +1,171 @@
+// Package subsonic implements the Subsonic REST API on top of the manager.
+// That way, the many existing Subsonic clients for phones and desktops can stream the library
+package subsonic
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/vfs"
+)
+
+// Server handles requests to the Subsonic API. Users log in with their FTP credentials
+type Server struct {
+	m *store.Manager
+
+	endpoints map[string]endpoint
+
+	// libraries contains the last library of every user by their name, see library
+	libraries   map[string]*cachedLibrary
+	librariesMu sync.Mutex
+}
+
+// request is an authenticated API request
+type request struct {
+	*http.Request
+
+	user config.FTPUser
+	cfg  config.Config
+
+	// lib contains all songs the user is allowed to see
+	lib *library
+}
+
+type endpoint func(w http.ResponseWriter, r *request) error
+
+// New returns a new Subsonic API server. It should be mounted at "/rest/"
+func New(m *store.Manager) *Server {
+	s := &Server{
+		m:         m,
+		libraries: make(map[string]*cachedLibrary),
+	}
+
+	s.endpoints = map[string]endpoint{
+		"ping":              s.handlePing,
+		"getLicense":        s.handleGetLicense,
+		"getMusicFolders":   s.handleGetMusicFolders,
+		"getIndexes":        s.handleGetIndexes,
+		"getMusicDirectory": s.handleGetMusicDirectory,
+		"getArtists":        s.handleGetArtists,
+		"getArtist":         s.handleGetArtist,
+		"getAlbum":          s.handleGetAlbum,
+		"getSong":           s.handleGetSong,
+		"search3":           s.handleSearch3,
+		"stream":            s.handleStream,
+		"download":          s.handleDownload,
+		"getCoverArt":       s.handleGetCoverArt,
+		"getPlaylists":      s.handleGetPlaylists,
+		"getPlaylist":       s.handleGetPlaylist,
+		"scrobble":          s.handleScrobble,
+	}
+
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Clients might call "/rest/ping" or "/rest/ping.view"
+	method := strings.TrimSuffix(path.Base(r.URL.Path), ".view")
+
+	var err error
+
+	ep, ok := s.endpoints[method]
+	if !ok {
+		err = apiError{Code: errGeneric, Message: "Unknown API method " + strconv.Quote(method)}
+	} else {
+		var req *request
+		req, err = s.authenticate(r)
+		if err == nil {
+			err = ep(w, req)
+		}
+	}
+
+	if err != nil {
+		ae, ok := err.(apiError)
+		if !ok {
+			log.Printf("[Subsonic] Error while handling %s: %s\n", method, err.Error())
+			ae = apiError{Code: errGeneric, Message: err.Error()}
+		}
+
+		writeResponse(w, r, &response{Status: "failed", Error: &ae})
+	}
+}
+
+// authenticate checks the credentials of a request. Clients can either send the password
+// (plain or hex-encoded with an "enc:" prefix) or a token and salt, see http://www.subsonic.org/pages/api.jsp
+func (s *Server) authenticate(r *http.Request) (req *request, err error) {
+	name := r.FormValue("u")
+	if name == "" {
+		return nil, apiError{Code: errMissingParameter, Message: "Required parameter \"u\" is missing"}
+	}
+
+	cfg := s.m.GetConfig()
+
+	var (
+		user config.FTPUser
+		ok   bool
+	)
+
+	if token, salt := r.FormValue("t"), r.FormValue("s"); token != "" && salt != "" {
+		for _, u := range cfg.FTP.Users {
+			if len(u.Name) != len(name) || subtle.ConstantTimeCompare([]byte(u.Name), []byte(name)) != 1 {
+				continue
+			}
+
+			var supported bool
+			ok, supported = config.CheckToken(u.Passwd, token, salt)
+			if !supported {
+				return nil, apiError{Code: errTokenNotSupported, Message: "Token authentication is not supported for users with hashed passwords, please send the password"}
+			}
+
+			user = u
+			break
+		}
+	} else {
+		pass := r.FormValue("p")
+		if strings.HasPrefix(pass, "enc:") {
+			decoded, derr := hex.DecodeString(strings.TrimPrefix(pass, "enc:"))
+			if derr != nil {
+				return nil, apiError{Code: errWrongCredentials, Message: "Wrong username or password"}
+			}
+			pass = string(decoded)
+		}
+
+		user, ok = vfs.FindUser(cfg, name, pass)
+	}
+
+	if !ok {
+		return nil, apiError{Code: errWrongCredentials, Message: "Wrong username or password"}
+	}
+
+	return &request{
+		Request: r,
+		user:    user,
+		cfg:     cfg,
+		lib:     s.library(cfg, user),
+	}, nil
+}
+
+// intParam returns the integer value of the parameter `name` or `def` if it isn't set or invalid
+func (r *request) intParam(name string, def int) int {
+	v, err := strconv.Atoi(r.FormValue(name))
+	if err != nil {
+		return def
+	}
+	return v
+}
+
+// requireParam returns the value of the parameter `name` or an error if it isn't set
+func (r *request) requireParam(name string) (string, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return "", apiError{Code: errMissingParameter, Message: "Required parameter " + strconv.Quote(name) + " is missing"}
+	}
+	return v, nil
+}
@@ -0,0 +1,258 @@
+package subsonic
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func testServer(t *testing.T) *Server {
+	var cfg config.Config
+	cfg.FTP.Users = []config.FTPUser{
+		{Name: "user", Passwd: "sesame"},
+		{Name: "kid", Passwd: "kid", Filter: config.SongFilter{Artists: []string{"ABBA"}}},
+	}
+
+	m, err := store.NewManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	year := 1975
+	for _, e := range []music.Entry{
+		{ID: "a", MusicData: music.MusicData{Title: "Bohemian Rhapsody", Artist: "Queen", Album: "A Night at the Opera", Year: &year, Track: 11, Duration: 354}},
+		{ID: "b", MusicData: music.MusicData{Title: "Love of My Life", Artist: "Queen", Album: "A Night at the Opera", Track: 9, Duration: 219}},
+		{ID: "c", MusicData: music.MusicData{Title: "SOS", Artist: "ABBA", Album: "ABBA", Duration: 200}},
+	} {
+		e.Added = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		e.AudioSettings = music.AudioSettings{Start: -1, End: -1}
+		m.Songs[e.ID] = e
+	}
+
+	return New(m)
+}
+
+func get(t *testing.T, s *Server, method string, params url.Values) (resp response) {
+	params.Set("f", "json")
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest("GET", "/rest/"+method+".view?"+params.Encode(), nil))
+
+	var root struct {
+		Response response `json:"subsonic-response"`
+	}
+
+	err := json.NewDecoder(rec.Body).Decode(&root)
+	if err != nil {
+		t.Fatalf("decoding response of %s: %s", method, err.Error())
+	}
+
+	return root.Response
+}
+
+func TestAuthentication(t *testing.T) {
+	s := testServer(t)
+
+	tests := []struct {
+		name   string
+		params url.Values
+		code   int
+	}{
+		// Example from the Subsonic API documentation
+		{"token", url.Values{"u": {"user"}, "t": {"26719a1196d2a940705a59634eb18eab"}, "s": {"c19b2d"}}, -1},
+		{"wrong token", url.Values{"u": {"user"}, "t": {"26719a1196d2a940705a59634eb18eab"}, "s": {"other"}}, errWrongCredentials},
+		{"password", url.Values{"u": {"user"}, "p": {"sesame"}}, -1},
+		{"encoded password", url.Values{"u": {"user"}, "p": {"enc:736573616d65"}}, -1},
+		{"wrong password", url.Values{"u": {"user"}, "p": {"wrong"}}, errWrongCredentials},
+		{"unknown user", url.Values{"u": {"other"}, "p": {"sesame"}}, errWrongCredentials},
+		{"no user", url.Values{}, errMissingParameter},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := get(t, s, "ping", tt.params)
+
+			if tt.code < 0 {
+				if resp.Status != "ok" {
+					t.Errorf("ping failed: %v", resp.Error)
+				}
+				return
+			}
+
+			if resp.Status != "failed" || resp.Error == nil || resp.Error.Code != tt.code {
+				t.Errorf("ping returned %s with error %v, want error code %d", resp.Status, resp.Error, tt.code)
+			}
+		})
+	}
+}
+
+func TestBrowsing(t *testing.T) {
+	s := testServer(t)
+
+	login := func(user, pass string) url.Values {
+		return url.Values{"u": {user}, "p": {pass}}
+	}
+
+	resp := get(t, s, "getArtists", login("user", "sesame"))
+	if resp.Artists == nil || len(resp.Artists.Indexes) != 2 {
+		t.Fatalf("getArtists returned %+v, want two indexes", resp.Artists)
+	}
+	queen := resp.Artists.Indexes[1].Artists[0]
+	if queen.Name != "Queen" || queen.AlbumCount != 1 {
+		t.Fatalf("getArtists returned %+v as second artist, want Queen", queen)
+	}
+
+	params := login("user", "sesame")
+	params.Set("id", queen.ID)
+	resp = get(t, s, "getArtist", params)
+	if resp.Artist == nil || len(resp.Artist.Albums) != 1 {
+		t.Fatalf("getArtist returned %+v, want one album", resp.Artist)
+	}
+
+	params.Set("id", resp.Artist.Albums[0].ID)
+	resp = get(t, s, "getAlbum", params)
+	if resp.Album == nil || len(resp.Album.Songs) != 2 {
+		t.Fatalf("getAlbum returned %+v, want two songs", resp.Album)
+	}
+	// Songs are sorted by their track number
+	if first := resp.Album.Songs[0]; first.ID != "b" || first.Track != 9 || first.AlbumID != resp.Album.ID {
+		t.Errorf("getAlbum returned %+v as first song, want song b", first)
+	}
+	if resp.Album.Duration != 354+219 || resp.Album.Year != 1975 {
+		t.Errorf("getAlbum returned duration %d and year %d", resp.Album.Duration, resp.Album.Year)
+	}
+
+	// The filter of a user also applies to the API
+	params = login("kid", "kid")
+	params.Set("id", "a")
+	if resp = get(t, s, "getSong", params); resp.Error == nil || resp.Error.Code != errNotFound {
+		t.Errorf("getSong returned %+v for a song the user can't see", resp.Song)
+	}
+
+	params = login("kid", "kid")
+	params.Set("query", `""`)
+	resp = get(t, s, "search3", params)
+	if resp.SearchResult3 == nil || len(resp.SearchResult3.Songs) != 1 || len(resp.SearchResult3.Artists) != 1 {
+		t.Errorf("search3 returned %+v, want only ABBA", resp.SearchResult3)
+	}
+}
+
+func TestPage(t *testing.T) {
+	tests := []struct {
+		length, offset, count int
+		from, to              int
+	}{
+		{10, 0, 20, 0, 10},
+		{10, 5, 2, 5, 7},
+		{10, 15, 2, 10, 10},
+		{10, -1, -1, 0, 0},
+	}
+	for _, tt := range tests {
+		from, to := page(tt.length, tt.offset, tt.count)
+		if from != tt.from || to != tt.to {
+			t.Errorf("page(%d, %d, %d) = %d, %d, want %d, %d", tt.length, tt.offset, tt.count, from, to, tt.from, tt.to)
+		}
+	}
+}
+
+func TestPlaylists(t *testing.T) {
+	s := testServer(t)
+	s.m.Playlists = map[string]store.Playlist{
+		"p": {ID: "p", Name: "Mixed", Songs: []string{"c", "a"}},
+	}
+
+	params := url.Values{"u": {"user"}, "p": {"sesame"}}
+	resp := get(t, s, "getPlaylists", params)
+	if resp.Playlists == nil || len(resp.Playlists.Playlists) != 1 || resp.Playlists.Playlists[0].SongCount != 2 {
+		t.Fatalf("getPlaylists returned %+v, want one playlist with two songs", resp.Playlists)
+	}
+
+	params.Set("id", "p")
+	resp = get(t, s, "getPlaylist", params)
+	if resp.Playlist == nil || len(resp.Playlist.Entries) != 2 || resp.Playlist.Entries[0].ID != "c" {
+		t.Fatalf("getPlaylist returned %+v, want songs c and a", resp.Playlist)
+	}
+
+	// Songs the user can't see are left out
+	params = url.Values{"u": {"kid"}, "p": {"kid"}, "id": {"p"}}
+	resp = get(t, s, "getPlaylist", params)
+	if resp.Playlist == nil || len(resp.Playlist.Entries) != 1 || resp.Playlist.Duration != 200 {
+		t.Errorf("getPlaylist returned %+v for a user with a filter, want only song c", resp.Playlist)
+	}
+}
+
+func TestScrobble(t *testing.T) {
+	// Recording plays saves the manager to the data directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	s := testServer(t)
+
+	played := time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC)
+	params := url.Values{"u": {"user"}, "p": {"sesame"}, "id": {"a", "c"}, "time": {strconv.FormatInt(played.UnixMilli(), 10)}}
+	if resp := get(t, s, "scrobble", params); resp.Status != "ok" {
+		t.Fatalf("scrobble failed: %+v", resp.Error)
+	}
+
+	if len(s.m.PlayHistory) != 2 || !s.m.PlayHistory[0].Time.Equal(played) || s.m.PlayHistory[1].ID != "c" {
+		t.Errorf("play history is %+v, want plays of a and c", s.m.PlayHistory)
+	}
+
+	// "Now playing" notifications and songs the user can't see are not recorded
+	params = url.Values{"u": {"user"}, "p": {"sesame"}, "id": {"b"}, "submission": {"false"}}
+	get(t, s, "scrobble", params)
+	params = url.Values{"u": {"kid"}, "p": {"kid"}, "id": {"a"}}
+	if resp := get(t, s, "scrobble", params); resp.Status == "ok" {
+		t.Errorf("scrobbling a song the user can't see should fail")
+	}
+
+	if len(s.m.PlayHistory) != 2 {
+		t.Errorf("play history has %d plays, want 2", len(s.m.PlayHistory))
+	}
+}
+
+func TestServer_library(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	s := testServer(t)
+	cfg := s.m.GetConfig()
+
+	lib := s.library(cfg, cfg.FTP.Users[0])
+	if s.library(cfg, cfg.FTP.Users[0]) != lib {
+		t.Errorf("library was built again even though nothing changed")
+	}
+	if kid := s.library(cfg, cfg.FTP.Users[1]); kid == lib || len(kid.songs) != 1 {
+		t.Errorf("users should have their own library")
+	}
+
+	err = s.m.RetagEntry("c", map[string]string{"title": "Waterloo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lib = s.library(cfg, cfg.FTP.Users[1])
+	if e, ok := lib.song("c"); !ok || e.MusicData.Title != "Waterloo" {
+		t.Errorf("library wasn't updated after editing a song: %+v", e.MusicData)
+	}
+}
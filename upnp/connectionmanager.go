@@ -0,0 +1,51 @@
+package upnp
+
+import (
+	"net/http"
+	"strings"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func (s *Server) connectionManagerAction(r *http.Request, action string, args map[string]string) ([]soapArg, error) {
+	switch action {
+	case "GetProtocolInfo":
+		return []soapArg{{"Source", sourceProtocolInfo()}, {"Sink", ""}}, nil
+	case "GetCurrentConnectionIDs":
+		return []soapArg{{"ConnectionIDs", "0"}}, nil
+	case "GetCurrentConnectionInfo":
+		// We don't manage connections, so there is only the default one
+		if args["ConnectionID"] != "0" {
+			return nil, upnpError{Code: errInvalidArgs, Description: "Invalid connection reference"}
+		}
+
+		return []soapArg{
+			{"RcsID", "-1"},
+			{"AVTransportID", "-1"},
+			{"ProtocolInfo", ""},
+			{"PeerConnectionManager", ""},
+			{"PeerConnectionID", "-1"},
+			{"Direction", "Output"},
+			{"Status", "OK"},
+		}, nil
+	default:
+		return nil, upnpError{Code: errInvalidAction, Description: "Invalid Action"}
+	}
+}
+
+// sourceProtocolInfo lists the audio formats we usually serve
+func sourceProtocolInfo() string {
+	var seen = make(map[string]bool)
+
+	var infos []string
+	for _, ext := range []string{".mp3", ".m4a", ".opus", ".flac", ".wav"} {
+		ct := music.ContentType(ext)
+		if ct == "" || seen[ct] {
+			continue
+		}
+		seen[ct] = true
+
+		infos = append(infos, "http-get:*:"+ct+":*")
+	}
+
+	return strings.Join(infos, ",")
+}
@@ -0,0 +1,461 @@
+package upnp
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/music"
+)
+
+const (
+	classMusicTrack = "object.item.audioItem.musicTrack"
+	classContainer  = "object.container.storageFolder"
+	classArtist     = "object.container.person.musicArtist"
+	classAlbum      = "object.container.album.musicAlbum"
+	classGenre      = "object.container.genre.musicGenre"
+	classPlaylist   = "object.container.playlistContainer"
+
+	// dlnaFlags tells renderers that they can seek using byte ranges
+	dlnaFlags = "DLNA.ORG_OP=01;DLNA.ORG_CI=0;DLNA.ORG_FLAGS=01700000000000000000000000000000"
+)
+
+// group is a container below one of the top-level containers, e.g. an artist or album
+type group struct {
+	key    string
+	title  string
+	artist string
+	class  string
+
+	songs []music.Entry
+}
+
+// category is one of the top-level containers
+type category struct {
+	id    string
+	title string
+
+	groups func(m *store.Manager) []group
+}
+
+var categories = []category{
+	{
+		id:    "artists",
+		title: "Artists",
+		groups: func(m *store.Manager) (res []group) {
+			for _, g := range m.GroupByArtist() {
+				res = append(res, group{
+					key:   strings.ToUpper(store.CleanName(g.Title)),
+					title: g.Title,
+					class: classArtist,
+					songs: g.Songs,
+				})
+			}
+			return
+		},
+	},
+	{
+		id:    "albums",
+		title: "Albums",
+		groups: func(m *store.Manager) (res []group) {
+			for _, a := range m.GroupByAlbum() {
+				res = append(res, group{
+					key:    strings.ToUpper(store.CleanName(a.Artist) + "/" + store.CleanName(a.Title)),
+					title:  a.Title,
+					artist: a.Artist,
+					class:  classAlbum,
+					songs:  a.Songs,
+				})
+			}
+			return
+		},
+	},
+	{
+		id:    "genres",
+		title: "Genres",
+		groups: func(m *store.Manager) (res []group) {
+			for _, g := range m.GroupByGenre() {
+				res = append(res, group{
+					key:   strings.ToUpper(g.Title),
+					title: g.Title,
+					class: classGenre,
+					songs: g.Songs,
+				})
+			}
+			return
+		},
+	},
+	{
+		id:    "years",
+		title: "Years",
+		groups: func(m *store.Manager) (res []group) {
+			for _, g := range m.GroupByYear() {
+				title := g.Title
+				if title == "#" {
+					title = "Unknown"
+				}
+
+				res = append(res, group{
+					key:   g.Title,
+					title: title,
+					class: classContainer,
+					songs: g.Songs,
+				})
+			}
+			return
+		},
+	},
+	{
+		id:    "playlists",
+		title: "Playlists",
+		groups: func(m *store.Manager) (res []group) {
+			for _, p := range m.AllPlaylists() {
+				res = append(res, group{
+					key:   p.ID,
+					title: p.Name,
+					class: classPlaylist,
+					songs: m.PlaylistEntries(p),
+				})
+			}
+			return
+		},
+	},
+}
+
+// groupID returns the object ID of `g`. Keys are encoded because they might contain slashes
+func groupID(c category, g group) string {
+	return c.id + "/" + base64.RawURLEncoding.EncodeToString([]byte(g.key))
+}
+
+// object is a container or item in the content directory
+type object struct {
+	id, parentID string
+	title        string
+	class        string
+
+	// For containers
+	artist     string
+	childCount int
+	cover      *music.Entry
+
+	// For items
+	entry *music.Entry
+}
+
+func (o object) isContainer() bool {
+	return o.entry == nil
+}
+
+func rootObject() object {
+	return object{id: "0", parentID: "-1", title: "sensibleHub", class: classContainer, childCount: len(categories)}
+}
+
+func categoryObject(m *store.Manager, c category) object {
+	return object{id: c.id, parentID: "0", title: c.title, class: classContainer, childCount: len(c.groups(m))}
+}
+
+func groupObject(c category, g group) object {
+	o := object{
+		id:         groupID(c, g),
+		parentID:   c.id,
+		title:      g.title,
+		class:      g.class,
+		artist:     g.artist,
+		childCount: len(g.songs),
+	}
+
+	for i := range g.songs {
+		if g.songs[i].PictureData.Filename != "" {
+			o.cover = &g.songs[i]
+			break
+		}
+	}
+
+	return o
+}
+
+func itemObject(parentID string, e music.Entry) object {
+	return object{
+		id:       parentID + "/" + e.ID,
+		parentID: parentID,
+		title:    e.MusicData.Title,
+		class:    classMusicTrack,
+		entry:    &e,
+	}
+}
+
+// resolve returns the object with the given ID and its children
+func (s *Server) resolve(id string) (obj object, children []object, ok bool) {
+	if id == "0" {
+		obj = rootObject()
+		for _, c := range categories {
+			children = append(children, categoryObject(s.m, c))
+		}
+		return obj, children, true
+	}
+
+	parts := strings.Split(id, "/")
+
+	for _, c := range categories {
+		if c.id != parts[0] {
+			continue
+		}
+
+		groups := c.groups(s.m)
+
+		if len(parts) == 1 {
+			obj = categoryObject(s.m, c)
+			for _, g := range groups {
+				children = append(children, groupObject(c, g))
+			}
+			return obj, children, true
+		}
+
+		for _, g := range groups {
+			gid := groupID(c, g)
+			if gid != parts[0]+"/"+parts[1] {
+				continue
+			}
+
+			obj = groupObject(c, g)
+
+			if len(parts) == 2 {
+				for _, e := range g.songs {
+					children = append(children, itemObject(gid, e))
+				}
+				return obj, children, true
+			}
+
+			if len(parts) == 3 {
+				for _, e := range g.songs {
+					if e.ID == parts[2] {
+						return itemObject(gid, e), nil, true
+					}
+				}
+			}
+
+			return
+		}
+	}
+
+	return
+}
+
+func (s *Server) contentDirectoryAction(r *http.Request, action string, args map[string]string) ([]soapArg, error) {
+	switch action {
+	case "Browse":
+		return s.browse(r, args)
+	case "GetSearchCapabilities":
+		return []soapArg{{"SearchCaps", ""}}, nil
+	case "GetSortCapabilities":
+		return []soapArg{{"SortCaps", ""}}, nil
+	case "GetSystemUpdateID":
+		return []soapArg{{"Id", strconv.FormatUint(uint64(s.systemUpdateID()), 10)}}, nil
+	default:
+		return nil, upnpError{Code: errInvalidAction, Description: "Invalid Action"}
+	}
+}
+
+// systemUpdateID changes whenever a song is edited. Control points use it to invalidate their caches
+func (s *Server) systemUpdateID() uint32 {
+	var newest int64
+	for _, e := range s.m.AllEntries() {
+		if t := e.LastEdit.Unix(); t > newest {
+			newest = t
+		}
+	}
+
+	return uint32(newest)
+}
+
+func (s *Server) browse(r *http.Request, args map[string]string) (out []soapArg, err error) {
+	start, err := strconv.Atoi(args["StartingIndex"])
+	if err != nil || start < 0 {
+		return nil, upnpError{Code: errInvalidArgs, Description: "Invalid StartingIndex"}
+	}
+	count, err := strconv.Atoi(args["RequestedCount"])
+	if err != nil || count < 0 {
+		return nil, upnpError{Code: errInvalidArgs, Description: "Invalid RequestedCount"}
+	}
+
+	obj, children, ok := s.resolve(args["ObjectID"])
+	if !ok {
+		return nil, upnpError{Code: errNoSuchObject, Description: "No such object"}
+	}
+
+	var list []object
+	switch args["BrowseFlag"] {
+	case "BrowseMetadata":
+		list = []object{obj}
+	case "BrowseDirectChildren":
+		list = children
+	default:
+		return nil, upnpError{Code: errInvalidArgs, Description: "Invalid BrowseFlag"}
+	}
+
+	total := len(list)
+
+	if start > len(list) {
+		start = len(list)
+	}
+	list = list[start:]
+	if count > 0 && count < len(list) {
+		list = list[:count]
+	}
+
+	result, err := s.didl(r, list)
+	if err != nil {
+		return
+	}
+
+	return []soapArg{
+		{"Result", result},
+		{"NumberReturned", strconv.Itoa(len(list))},
+		{"TotalMatches", strconv.Itoa(total)},
+		{"UpdateID", strconv.FormatUint(uint64(s.systemUpdateID()), 10)},
+	}, nil
+}
+
+type didlLite struct {
+	XMLName xml.Name `xml:"DIDL-Lite"`
+
+	Xmlns      string          `xml:"xmlns,attr"`
+	XmlnsDC    string          `xml:"xmlns:dc,attr"`
+	XmlnsUPnP  string          `xml:"xmlns:upnp,attr"`
+	XmlnsDLNA  string          `xml:"xmlns:dlna,attr"`
+	Containers []didlContainer `xml:"container"`
+	Items      []didlItem      `xml:"item"`
+}
+
+type didlContainer struct {
+	ID         string `xml:"id,attr"`
+	ParentID   string `xml:"parentID,attr"`
+	Restricted int    `xml:"restricted,attr"`
+	ChildCount int    `xml:"childCount,attr"`
+
+	Title       string `xml:"dc:title"`
+	Class       string `xml:"upnp:class"`
+	Artist      string `xml:"upnp:artist,omitempty"`
+	AlbumArtURI string `xml:"upnp:albumArtURI,omitempty"`
+}
+
+type didlItem struct {
+	ID         string `xml:"id,attr"`
+	ParentID   string `xml:"parentID,attr"`
+	Restricted int    `xml:"restricted,attr"`
+
+	Title       string `xml:"dc:title"`
+	Creator     string `xml:"dc:creator,omitempty"`
+	Artist      string `xml:"upnp:artist,omitempty"`
+	Album       string `xml:"upnp:album,omitempty"`
+	Genre       string `xml:"upnp:genre,omitempty"`
+	Date        string `xml:"dc:date,omitempty"`
+	Track       int    `xml:"upnp:originalTrackNumber,omitempty"`
+	AlbumArtURI string `xml:"upnp:albumArtURI,omitempty"`
+	Class       string `xml:"upnp:class"`
+
+	Resources []didlRes `xml:"res"`
+}
+
+type didlRes struct {
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	Size         int64  `xml:"size,attr,omitempty"`
+	Duration     string `xml:"duration,attr,omitempty"`
+
+	URL string `xml:",chardata"`
+}
+
+// didl returns the DIDL-Lite document describing `list`
+func (s *Server) didl(r *http.Request, list []object) (string, error) {
+	base := baseURL(r)
+
+	doc := didlLite{
+		Xmlns:     "urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/",
+		XmlnsDC:   "http://purl.org/dc/elements/1.1/",
+		XmlnsUPnP: "urn:schemas-upnp-org:metadata-1-0/upnp/",
+		XmlnsDLNA: "urn:schemas-dlna-org:metadata-1-0/",
+	}
+
+	for _, o := range list {
+		if o.isContainer() {
+			c := didlContainer{
+				ID:         o.id,
+				ParentID:   o.parentID,
+				Restricted: 1,
+				ChildCount: o.childCount,
+				Title:      o.title,
+				Class:      o.class,
+				Artist:     o.artist,
+			}
+			if o.cover != nil {
+				c.AlbumArtURI = base + "/upnp/song/" + o.cover.ID + "/cover"
+			}
+
+			doc.Containers = append(doc.Containers, c)
+			continue
+		}
+
+		doc.Items = append(doc.Items, s.didlItem(base, o))
+	}
+
+	out, err := xml.Marshal(doc)
+	return string(out), err
+}
+
+func (s *Server) didlItem(base string, o object) didlItem {
+	e := o.entry
+
+	item := didlItem{
+		ID:         o.id,
+		ParentID:   o.parentID,
+		Restricted: 1,
+		Title:      o.title,
+		Creator:    e.MusicData.Artist,
+		Artist:     e.MusicData.Artist,
+		Album:      e.MusicData.Album,
+		Genre:      e.MusicData.Genre,
+		Track:      e.MusicData.Track,
+		Class:      classMusicTrack,
+	}
+
+	if e.MusicData.Year != nil {
+		item.Date = fmt.Sprintf("%04d-01-01", *e.MusicData.Year)
+	}
+	if e.PictureData.Filename != "" {
+		item.AlbumArtURI = base + "/upnp/song/" + e.ID + "/cover"
+	}
+
+	// The transcoded file is listed first, as most renderers play the first resource they support
+	profile, _ := s.cfg.Profile(s.cfg.UPnP.Profile)
+	ext := profile.Extension(filepath.Ext(e.FileData.Filename))
+	if ct := music.ContentType("." + ext); ct != "" {
+		item.Resources = append(item.Resources, didlRes{
+			ProtocolInfo: "http-get:*:" + ct + ":" + dlnaFlags,
+			Duration:     formatDuration(e.PlayDuration()),
+			URL:          base + "/upnp/song/" + e.ID + "/file?profile=" + url.QueryEscape(s.cfg.UPnP.Profile),
+		})
+	}
+
+	if ct := music.ContentType(filepath.Ext(e.FileData.Filename)); ct != "" {
+		item.Resources = append(item.Resources, didlRes{
+			ProtocolInfo: "http-get:*:" + ct + ":" + dlnaFlags,
+			Size:         e.FileData.Size,
+			Duration:     formatDuration(e.MusicData.Duration),
+			URL:          base + "/upnp/song/" + e.ID + "/audio",
+		})
+	}
+
+	return item
+}
+
+// formatDuration formats `seconds` as H:MM:SS.mmm
+func formatDuration(seconds float64) string {
+	ms := int64(seconds * 1000)
+
+	return fmt.Sprintf("%d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
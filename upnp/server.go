@@ -0,0 +1,131 @@
+// Package upnp implements a UPnP/DLNA media server. Devices find it using SSDP and browse the library
+// using the ContentDirectory service. Audio files and covers are served by the web server below /upnp/song/
+package upnp
+
+import (
+	"crypto/md5"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/config"
+)
+
+const (
+	deviceType = "urn:schemas-upnp-org:device:MediaServer:1"
+
+	contentDirectoryType  = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	connectionManagerType = "urn:schemas-upnp-org:service:ConnectionManager:1"
+
+	serverHeader = "Linux/1.0 UPnP/1.0 sensibleHub/1.0"
+
+	// descriptionPath is the path of the device description, it is sent to devices that discover this server
+	descriptionPath = "/upnp/device.xml"
+)
+
+// Server serves the device and service descriptions as well as the control endpoints. It should be mounted at "/upnp/"
+type Server struct {
+	m   *store.Manager
+	cfg config.Config
+
+	// uuid identifies this server, it stays the same across restarts
+	uuid string
+}
+
+// New returns a new UPnP server
+func New(m *store.Manager) *Server {
+	cfg := m.GetConfig()
+
+	return &Server{
+		m:    m,
+		cfg:  cfg,
+		uuid: deviceUUID(cfg.UPnP.Name),
+	}
+}
+
+// deviceUUID generates a UUID from the host and server name, that way devices recognize the server after a restart
+func deviceUUID(name string) string {
+	host, _ := os.Hostname()
+
+	sum := md5.Sum([]byte("sensibleHub/" + host + "/" + name))
+
+	// Mark it as version 3 (name-based with MD5) UUID
+	sum[6] = (sum[6] & 0x0f) | 0x30
+	sum[8] = (sum[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var err error
+
+	switch r.URL.Path {
+	case descriptionPath:
+		err = s.writeXML(w, deviceDescription(s.cfg.UPnP.Name, s.uuid))
+	case "/upnp/ContentDirectory.xml":
+		err = s.writeXML(w, contentDirectorySCPD)
+	case "/upnp/ConnectionManager.xml":
+		err = s.writeXML(w, connectionManagerSCPD)
+	case "/upnp/control/ContentDirectory":
+		err = s.handleControl(w, r, contentDirectoryType, s.contentDirectoryAction)
+	case "/upnp/control/ConnectionManager":
+		err = s.handleControl(w, r, connectionManagerType, s.connectionManagerAction)
+	case "/upnp/event/ContentDirectory", "/upnp/event/ConnectionManager":
+		s.handleSubscription(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+
+	if err != nil {
+		log.Printf("[UPnP] Error while handling %s %s: %s\n", r.Method, r.URL.Path, err.Error())
+	}
+}
+
+func (s *Server) writeXML(w http.ResponseWriter, content string) error {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("Server", serverHeader)
+
+	_, err := w.Write([]byte(content))
+	return err
+}
+
+// handleSubscription accepts event subscriptions. We never send events, but some control points
+// refuse to use a server that doesn't accept subscriptions
+func (s *Server) handleSubscription(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "SUBSCRIBE":
+		sid := r.Header.Get("SID")
+		if sid == "" {
+			sid = "uuid:" + deviceUUID(r.Header.Get("CALLBACK")+r.RemoteAddr)
+		}
+
+		w.Header().Set("SID", sid)
+		w.Header().Set("TIMEOUT", "Second-1800")
+		w.Header().Set("Server", serverHeader)
+	case "UNSUBSCRIBE":
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// baseURL returns the URL of the web server as seen by the client of `r`
+func baseURL(r *http.Request) string {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+
+	return "http://" + strings.TrimSuffix(host, "/")
+}
+
+// SetStreamingHeaders sets the DLNA headers that renderers request when fetching audio files
+func SetStreamingHeaders(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("getcontentFeatures.dlna.org") == "1" {
+		w.Header().Set("contentFeatures.dlna.org", dlnaFlags)
+	}
+	if r.Header.Get("transferMode.dlna.org") != "" {
+		w.Header().Set("transferMode.dlna.org", "Streaming")
+	}
+}
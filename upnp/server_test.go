@@ -0,0 +1,191 @@
+package upnp
+
+import (
+	"encoding/xml"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/store/music"
+)
+
+func testServer(t *testing.T) *Server {
+	var cfg config.Config
+	cfg.UPnP.Name = "Test"
+
+	m, err := store.NewManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	year := 1975
+	for _, e := range []music.Entry{
+		{ID: "a", FileData: music.FileData{Filename: "audio.m4a", Size: 1000}, PictureData: music.PictureData{Filename: "cover.jpg"},
+			MusicData: music.MusicData{Title: "Bohemian Rhapsody", Artist: "Queen", Album: "A Night at the Opera", Genre: "Rock", Year: &year, Track: 11, Duration: 354}},
+		{ID: "b", FileData: music.FileData{Filename: "audio.mp3"},
+			MusicData: music.MusicData{Title: "Love of My Life", Artist: "Queen", Album: "A Night at the Opera", Genre: "rock", Track: 9, Duration: 219}},
+		{ID: "c", FileData: music.FileData{Filename: "audio.mp3"},
+			MusicData: music.MusicData{Title: "SOS", Artist: "ABBA", Album: "ABBA", Duration: 200}},
+	} {
+		e.LastEdit = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		e.AudioSettings = music.AudioSettings{Start: -1, End: -1}
+		m.Songs[e.ID] = e
+	}
+
+	return New(m)
+}
+
+type browseResult struct {
+	Result         string
+	NumberReturned int
+	TotalMatches   int
+
+	didl struct {
+		Containers []struct {
+			ID         string `xml:"id,attr"`
+			ChildCount int    `xml:"childCount,attr"`
+			Title      string `xml:"title"`
+		} `xml:"container"`
+		Items []struct {
+			ID          string `xml:"id,attr"`
+			ParentID    string `xml:"parentID,attr"`
+			Title       string `xml:"title"`
+			Date        string `xml:"date"`
+			AlbumArtURI string `xml:"albumArtURI"`
+			Resources   []struct {
+				ProtocolInfo string `xml:"protocolInfo,attr"`
+				URL          string `xml:",chardata"`
+			} `xml:"res"`
+		} `xml:"item"`
+	}
+}
+
+func browse(t *testing.T, s *Server, id, flag string, start, count int) (res browseResult, code int) {
+	body := `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+	<s:Body>
+		<u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1">
+			<ObjectID>` + id + `</ObjectID>
+			<BrowseFlag>` + flag + `</BrowseFlag>
+			<Filter>*</Filter>
+			<StartingIndex>` + strconv.Itoa(start) + `</StartingIndex>
+			<RequestedCount>` + strconv.Itoa(count) + `</RequestedCount>
+			<SortCriteria></SortCriteria>
+		</u:Browse>
+	</s:Body>
+</s:Envelope>`
+
+	req := httptest.NewRequest(http.MethodPost, "/upnp/control/ContentDirectory", strings.NewReader(body))
+	req.Host = "192.168.0.2:128"
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		var fault struct {
+			Code int `xml:"Body>Fault>detail>UPnPError>errorCode"`
+		}
+		err := xml.Unmarshal(rec.Body.Bytes(), &fault)
+		if err != nil {
+			t.Fatalf("decoding fault: %s", err.Error())
+		}
+		return res, fault.Code
+	}
+
+	var env struct {
+		Response browseResult `xml:"Body>BrowseResponse"`
+	}
+	err := xml.Unmarshal(rec.Body.Bytes(), &env)
+	if err != nil {
+		t.Fatalf("decoding response: %s", err.Error())
+	}
+	res = env.Response
+
+	err = xml.Unmarshal([]byte(res.Result), &res.didl)
+	if err != nil {
+		t.Fatalf("decoding DIDL-Lite: %s\n%s", err.Error(), res.Result)
+	}
+
+	return res, 0
+}
+
+func TestBrowse(t *testing.T) {
+	s := testServer(t)
+
+	res, _ := browse(t, s, "0", "BrowseDirectChildren", 0, 0)
+	if res.TotalMatches != len(categories) || len(res.didl.Containers) != len(categories) {
+		t.Fatalf("expected %d top-level containers, got %d", len(categories), res.TotalMatches)
+	}
+
+	res, _ = browse(t, s, "genres", "BrowseDirectChildren", 0, 0)
+	if len(res.didl.Containers) != 1 || res.didl.Containers[0].ChildCount != 2 {
+		t.Fatalf("expected one genre with two songs, got %+v", res.didl.Containers)
+	}
+
+	res, _ = browse(t, s, "artists", "BrowseDirectChildren", 1, 1)
+	if res.TotalMatches != 2 || res.NumberReturned != 1 || res.didl.Containers[0].Title != "Queen" {
+		t.Fatalf("expected the second of two artists to be Queen, got %+v", res)
+	}
+
+	queen := res.didl.Containers[0].ID
+	res, _ = browse(t, s, queen, "BrowseDirectChildren", 0, 0)
+	if len(res.didl.Items) != 2 {
+		t.Fatalf("expected two songs by Queen, got %d", len(res.didl.Items))
+	}
+
+	item := res.didl.Items[0]
+	if item.ParentID != queen || item.ID != queen+"/a" {
+		t.Errorf("unexpected IDs of item: %q in %q", item.ID, item.ParentID)
+	}
+	if item.AlbumArtURI != "http://192.168.0.2:128/upnp/song/a/cover" {
+		t.Errorf("unexpected album art URI %q", item.AlbumArtURI)
+	}
+	if len(item.Resources) != 2 || item.Resources[0].URL != "http://192.168.0.2:128/upnp/song/a/file?profile=" ||
+		item.Resources[1].URL != "http://192.168.0.2:128/upnp/song/a/audio" || !strings.Contains(item.Resources[1].ProtocolInfo, ":audio/mp4:") {
+		t.Errorf("unexpected resources %+v", item.Resources)
+	}
+
+	res, _ = browse(t, s, item.ID, "BrowseMetadata", 0, 0)
+	if len(res.didl.Items) != 1 || res.didl.Items[0].Title != "Bohemian Rhapsody" || res.didl.Items[0].Date != "1975-01-01" {
+		t.Errorf("unexpected metadata %+v", res.didl.Items)
+	}
+
+	for _, id := range []string{"unknown", "artists/unknown", queen + "/c"} {
+		if _, code := browse(t, s, id, "BrowseMetadata", 0, 0); code != errNoSuchObject {
+			t.Errorf("expected error %d for object %q, got %d", errNoSuchObject, id, code)
+		}
+	}
+
+	if _, code := browse(t, s, "0", "Other", 0, 0); code != errInvalidArgs {
+		t.Errorf("expected error %d for an invalid browse flag, got %d", errInvalidArgs, code)
+	}
+}
+
+func TestDescription(t *testing.T) {
+	s := testServer(t)
+
+	rec := httptest.NewRecorder()
+	s.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, descriptionPath, nil))
+
+	var root struct {
+		Device struct {
+			FriendlyName string `xml:"friendlyName"`
+			UDN          string `xml:"UDN"`
+		} `xml:"device"`
+	}
+	err := xml.Unmarshal(rec.Body.Bytes(), &root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if root.Device.FriendlyName != "Test" || root.Device.UDN != "uuid:"+deviceUUID("Test") {
+		t.Errorf("unexpected device %+v", root.Device)
+	}
+
+	if deviceUUID("Test") == deviceUUID("Other") {
+		t.Errorf("different servers should have different UUIDs")
+	}
+}
@@ -0,0 +1,122 @@
+package upnp
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Error codes from the UPnP device architecture and ContentDirectory specifications
+const (
+	errInvalidAction = 401
+	errInvalidArgs   = 402
+	errActionFailed  = 501
+	errNoSuchObject  = 701
+)
+
+// upnpError is an error that is sent to the control point as SOAP fault
+type upnpError struct {
+	Code        int
+	Description string
+}
+
+func (u upnpError) Error() string {
+	return fmt.Sprintf("UPnP error %d: %s", u.Code, u.Description)
+}
+
+// soapArg is an output argument of an action. The order of arguments matters for some control points
+type soapArg struct {
+	name, value string
+}
+
+// actionFunc runs the action `action` with the given input arguments
+type actionFunc func(r *http.Request, action string, args map[string]string) ([]soapArg, error)
+
+type soapEnvelope struct {
+	Body struct {
+		Action struct {
+			XMLName xml.Name
+			Args    []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:",any"`
+	} `xml:"Body"`
+}
+
+// handleControl parses a SOAP request, runs the action and writes its result or fault
+func (s *Server) handleControl(w http.ResponseWriter, r *http.Request, serviceType string, run actionFunc) (err error) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	var env soapEnvelope
+	err = xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&env)
+	if err != nil {
+		return writeFault(w, upnpError{Code: errInvalidArgs, Description: "Invalid SOAP request"})
+	}
+
+	action := env.Body.Action.XMLName.Local
+
+	args := make(map[string]string)
+	for _, a := range env.Body.Action.Args {
+		args[a.XMLName.Local] = a.Value
+	}
+
+	out, err := run(r, action, args)
+	if err != nil {
+		ue, ok := err.(upnpError)
+		if !ok {
+			// Tell the control point that something went wrong, the actual error is logged
+			_ = writeFault(w, upnpError{Code: errActionFailed, Description: "Action Failed"})
+			return err
+		}
+
+		return writeFault(w, ue)
+	}
+
+	var body strings.Builder
+	body.WriteString(`<u:` + action + `Response xmlns:u="` + serviceType + `">`)
+	for _, arg := range out {
+		body.WriteString("<" + arg.name + ">")
+		_ = xml.EscapeText(&body, []byte(arg.value))
+		body.WriteString("</" + arg.name + ">")
+	}
+	body.WriteString(`</u:` + action + `Response>`)
+
+	return writeEnvelope(w, http.StatusOK, body.String())
+}
+
+func writeFault(w http.ResponseWriter, ue upnpError) error {
+	var desc strings.Builder
+	_ = xml.EscapeText(&desc, []byte(ue.Description))
+
+	return writeEnvelope(w, http.StatusInternalServerError, `<s:Fault>
+			<faultcode>s:Client</faultcode>
+			<faultstring>UPnPError</faultstring>
+			<detail>
+				<UPnPError xmlns="urn:schemas-upnp-org:control-1-0">
+					<errorCode>`+strconv.Itoa(ue.Code)+`</errorCode>
+					<errorDescription>`+desc.String()+`</errorDescription>
+				</UPnPError>
+			</detail>
+		</s:Fault>`)
+}
+
+func writeEnvelope(w http.ResponseWriter, status int, body string) (err error) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("Ext", "")
+	w.Header().Set("Server", serverHeader)
+	w.WriteHeader(status)
+
+	_, err = io.WriteString(w, xml.Header+`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+	<s:Body>
+		`+body+`
+	</s:Body>
+</s:Envelope>`)
+	return
+}
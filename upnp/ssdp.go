@@ -0,0 +1,300 @@
+package upnp
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"xarantolus/sensibleHub/store/config"
+
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	ssdpAddr = "239.255.255.250:1900"
+
+	// maxAge is how long devices may remember us, in seconds. We announce ourselves at least twice in that time
+	maxAge         = 1800
+	notifyInterval = maxAge / 2 * time.Second
+
+	// maxResponseDelay is the longest we wait before answering a search, even if devices allow more
+	maxResponseDelay = 5 * time.Second
+)
+
+// ssdpServer answers searches of devices looking for media servers
+type ssdpServer struct {
+	conn net.PacketConn
+
+	uuid string
+
+	// port is the port of the web server that serves the device description
+	port int
+}
+
+// RunServer answers SSDP discovery requests and announces the server on the network until an error occurs.
+// The device description and content directory are served by the web server
+func RunServer(cfg config.Config) (err error) {
+	ifaces, err := interfaces(cfg.UPnP.Interface)
+	if err != nil {
+		return
+	}
+
+	dst, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return
+	}
+
+	conn, err := net.ListenPacket("udp4", ":1900")
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	pc := ipv4.NewPacketConn(conn)
+
+	group := &net.UDPAddr{IP: net.IPv4(239, 255, 255, 250)}
+
+	var joined []net.Interface
+	for _, ifi := range ifaces {
+		err = pc.JoinGroup(&ifi, group)
+		if err != nil {
+			log.Printf("[UPnP] Cannot join multicast group on interface %s: %s\n", ifi.Name, err.Error())
+			continue
+		}
+
+		joined = append(joined, ifi)
+	}
+	if len(joined) == 0 {
+		return fmt.Errorf("cannot join the SSDP multicast group on any interface")
+	}
+
+	// This allows devices on the same host to find us
+	err = pc.SetMulticastLoopback(true)
+	if err != nil {
+		return
+	}
+
+	s := &ssdpServer{
+		conn: conn,
+		uuid: deviceUUID(cfg.UPnP.Name),
+		port: cfg.Port,
+	}
+
+	go s.announce(pc, joined, dst)
+
+	log.Printf("[UPnP] Media server %q is discoverable on %d interface(s)\n", cfg.UPnP.Name, len(joined))
+
+	return s.serve()
+}
+
+// interfaces returns the interface with the given name or all interfaces that support multicast
+func interfaces(name string) (res []net.Interface, err error) {
+	if name != "" {
+		ifi, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return []net.Interface{*ifi}, nil
+	}
+
+	all, err := net.Interfaces()
+	if err != nil {
+		return
+	}
+
+	for _, ifi := range all {
+		if ifi.Flags&net.FlagUp != 0 && ifi.Flags&net.FlagMulticast != 0 {
+			res = append(res, ifi)
+		}
+	}
+
+	return
+}
+
+// serve answers M-SEARCH requests until reading from the connection fails
+func (s *ssdpServer) serve() error {
+	buf := make([]byte, 4096)
+
+	for {
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+
+		s.handle(buf[:n], from)
+	}
+}
+
+func (s *ssdpServer) handle(msg []byte, from net.Addr) {
+	req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(msg)))
+	if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` {
+		return
+	}
+
+	targets := s.searchTargets(req.Header.Get("ST"))
+	if len(targets) == 0 {
+		return
+	}
+
+	ip, err := localIP(from)
+	if err != nil {
+		log.Printf("[UPnP] Cannot answer search from %s: %s\n", from.String(), err.Error())
+		return
+	}
+
+	// Waiting a random time makes sure not all devices on the network answer at the same time
+	time.AfterFunc(responseDelay(req.Header.Get("MX")), func() {
+		s.respond(targets, ip, from)
+	})
+}
+
+// responseDelay returns a random duration between 0 and the `mx` header value in seconds, which is the
+// time the UPnP specification wants servers to wait before answering a search
+func responseDelay(mx string) time.Duration {
+	seconds, err := strconv.Atoi(strings.TrimSpace(mx))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+
+	max := time.Duration(seconds) * time.Second
+	if max > maxResponseDelay {
+		max = maxResponseDelay
+	}
+
+	return time.Duration(rand.Int63n(int64(max) + 1))
+}
+
+// respond answers a search from `from` for all given targets, `ip` is the local address the device can reach
+func (s *ssdpServer) respond(targets []string, ip net.IP, from net.Addr) {
+	for _, target := range targets {
+		_, err := s.conn.WriteTo(s.message("HTTP/1.1 200 OK", map[string]string{
+			"CACHE-CONTROL": "max-age=" + strconv.Itoa(maxAge),
+			"DATE":          time.Now().UTC().Format(http.TimeFormat),
+			"EXT":           "",
+			"LOCATION":      s.location(ip),
+			"SERVER":        serverHeader,
+			"ST":            target,
+			"USN":           s.usn(target),
+		}), from)
+		if err != nil {
+			log.Printf("[UPnP] Cannot answer search from %s: %s\n", from.String(), err.Error())
+			return
+		}
+	}
+}
+
+// announce sends alive notifications to `dst` on all interfaces every notifyInterval
+func (s *ssdpServer) announce(pc *ipv4.PacketConn, ifaces []net.Interface, dst net.Addr) {
+	for {
+		for _, ifi := range ifaces {
+			ip, ok := interfaceIP(ifi)
+			if !ok {
+				continue
+			}
+
+			err := pc.SetMulticastInterface(&ifi)
+			if err != nil {
+				log.Printf("[UPnP] Cannot announce server on interface %s: %s\n", ifi.Name, err.Error())
+				continue
+			}
+
+			for _, target := range s.searchTargets("ssdp:all") {
+				_, err = pc.WriteTo(s.message("NOTIFY * HTTP/1.1", map[string]string{
+					"HOST":          ssdpAddr,
+					"CACHE-CONTROL": "max-age=" + strconv.Itoa(maxAge),
+					"LOCATION":      s.location(ip),
+					"NT":            target,
+					"NTS":           "ssdp:alive",
+					"SERVER":        serverHeader,
+					"USN":           s.usn(target),
+				}), nil, dst)
+				if err != nil {
+					log.Printf("[UPnP] Cannot announce server on interface %s: %s\n", ifi.Name, err.Error())
+					break
+				}
+			}
+		}
+
+		time.Sleep(notifyInterval)
+	}
+}
+
+// searchTargets returns the targets we should answer for a search with the search target `st`
+func (s *ssdpServer) searchTargets(st string) []string {
+	all := []string{"upnp:rootdevice", "uuid:" + s.uuid, deviceType, contentDirectoryType, connectionManagerType}
+
+	if st == "ssdp:all" {
+		return all
+	}
+
+	for _, t := range all {
+		if t == st {
+			return []string{t}
+		}
+	}
+
+	return nil
+}
+
+func (s *ssdpServer) usn(target string) string {
+	if target == "uuid:"+s.uuid {
+		return target
+	}
+
+	return "uuid:" + s.uuid + "::" + target
+}
+
+func (s *ssdpServer) location(ip net.IP) string {
+	return "http://" + net.JoinHostPort(ip.String(), strconv.Itoa(s.port)) + descriptionPath
+}
+
+// message formats an SSDP message. Header names are upper-case as some devices don't understand anything else
+func (s *ssdpServer) message(firstLine string, headers map[string]string) []byte {
+	var buf bytes.Buffer
+
+	buf.WriteString(firstLine + "\r\n")
+	for _, name := range []string{"HOST", "CACHE-CONTROL", "DATE", "EXT", "LOCATION", "NT", "NTS", "SERVER", "ST", "USN"} {
+		value, ok := headers[name]
+		if ok {
+			buf.WriteString(name + ": " + value + "\r\n")
+		}
+	}
+	buf.WriteString("\r\n")
+
+	return buf.Bytes()
+}
+
+// localIP returns the local address that is used for sending packets to `remote`
+func localIP(remote net.Addr) (net.IP, error) {
+	// Dialing UDP doesn't send anything, but chooses the local address for us
+	c, err := net.Dial("udp4", remote.String())
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	return c.LocalAddr().(*net.UDPAddr).IP, nil
+}
+
+// interfaceIP returns the first IPv4 address of `ifi`
+func interfaceIP(ifi net.Interface) (net.IP, bool) {
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, false
+	}
+
+	for _, a := range addrs {
+		if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP, true
+		}
+	}
+
+	return nil, false
+}
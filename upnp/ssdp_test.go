@@ -0,0 +1,86 @@
+package upnp
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestSSDPSearch(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	s := &ssdpServer{conn: conn, uuid: deviceUUID("Test"), port: 128}
+	go func() { _ = s.serve() }()
+
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// MX 0 means the server answers without waiting
+	search := func(st string) (responses []*http.Response) {
+		_, err := client.WriteTo([]byte("M-SEARCH * HTTP/1.1\r\n"+
+			"HOST: 239.255.255.250:1900\r\n"+
+			"MAN: \"ssdp:discover\"\r\n"+
+			"MX: 0\r\n"+
+			"ST: "+st+"\r\n\r\n"), conn.LocalAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := make([]byte, 2048)
+		for {
+			_ = client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+
+			n, _, err := client.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+
+			resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+			if err != nil {
+				t.Fatalf("invalid response: %s", err.Error())
+			}
+			responses = append(responses, resp)
+		}
+	}
+
+	responses := search(deviceType)
+	if len(responses) != 1 {
+		t.Fatalf("expected one response for %s, got %d", deviceType, len(responses))
+	}
+
+	resp := responses[0]
+	if loc := resp.Header.Get("LOCATION"); loc != "http://127.0.0.1:128"+descriptionPath {
+		t.Errorf("unexpected location %q", loc)
+	}
+	if usn := resp.Header.Get("USN"); usn != "uuid:"+s.uuid+"::"+deviceType {
+		t.Errorf("unexpected USN %q", usn)
+	}
+
+	if n := len(search("ssdp:all")); n != 5 {
+		t.Errorf("expected 5 responses for ssdp:all, got %d", n)
+	}
+
+	if n := len(search("urn:schemas-upnp-org:device:MediaRenderer:1")); n != 0 {
+		t.Errorf("expected no responses for other devices, got %d", n)
+	}
+}
+
+func Test_responseDelay(t *testing.T) {
+	for mx, max := range map[string]time.Duration{"": 0, "0": 0, "x": 0, "2": 2 * time.Second, "120": maxResponseDelay} {
+		for i := 0; i < 20; i++ {
+			if d := responseDelay(mx); d < 0 || d > max {
+				t.Fatalf("responseDelay(%q) = %s, want between 0 and %s", mx, d, max)
+			}
+		}
+	}
+}
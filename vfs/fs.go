@@ -0,0 +1,522 @@
+// Package vfs implements the virtual file system of songs that is shared by FTP and WebDAV
+package vfs
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/store/music"
+)
+
+var (
+	ErrReadOnly = fmt.Errorf("write/delete not allowed")
+	ErrNotFound = fmt.Errorf("file/directory not found")
+
+	ErrRenameDepth = fmt.Errorf("files and directories can only be renamed, not moved to another level")
+	ErrPlaylist    = fmt.Errorf("playlists can only be changed on the website")
+	ErrRenameTags  = fmt.Errorf("the new name doesn't contain any tags that could be changed")
+	ErrExists      = fmt.Errorf("a file with this name already exists")
+)
+
+const (
+	// PlaylistDir is the top-level directory that contains a directory for every synchronized playlist
+	PlaylistDir = "Playlists"
+	// SmartPlaylistDir is the same for smart playlists
+	SmartPlaylistDir = "Smart Playlists"
+)
+
+// FS is the virtual file system of one user. It contains all songs they are allowed to see,
+// arranged by their layout. Write operations are mapped to library operations depending on their permissions
+type FS struct {
+	mu sync.Mutex
+
+	// root is the root directory of the virtual file system. It is replaced instead of changed,
+	// so callers can keep reading a tree after mu was released
+	root *Dir
+
+	manager *store.Manager
+	cfg     config.Config
+
+	// user is the logged in user, their profile, filter and layout decide which files are shown where
+	user   config.FTPUser
+	layout store.Layout
+
+	// madeDirs are the paths of all directories created by the user. They are kept when the tree is rebuilt
+	madeDirs [][]string
+
+	// generation is the generation of the library the tree was built from, built is when that happened
+	generation uint64
+	built      time.Time
+}
+
+// maxTreeAge is how long a tree is used if the library doesn't change. Smart playlists with date rules
+// can change without anything in the library changing
+const maxTreeAge = 10 * time.Minute
+
+// FindUser returns the user with the given credentials
+func FindUser(cfg config.Config, name, pass string) (u config.FTPUser, ok bool) {
+	for _, user := range cfg.FTP.Users {
+		if constantTimeEquals(name, user.Name) && config.CheckPassword(user.Passwd, pass) {
+			return user, true
+		}
+	}
+	return
+}
+
+func constantTimeEquals(a, b string) bool {
+	return len(a) == len(b) && subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// CheckLayouts returns an error if the layout of any user is invalid
+func CheckLayouts(cfg config.Config) (err error) {
+	for _, u := range cfg.FTP.Users {
+		_, err = store.ParseLayout(u.Layout)
+		if err != nil {
+			return fmt.Errorf("invalid layout for user %s: %s", u.Name, err.Error())
+		}
+	}
+	return nil
+}
+
+// New creates the file system for the given user
+func New(manager *store.Manager, user config.FTPUser) *FS {
+	fs := &FS{
+		manager: manager,
+		cfg:     manager.GetConfig(),
+		user:    user,
+	}
+
+	var err error
+	fs.layout, err = store.ParseLayout(user.Layout)
+	if err != nil {
+		// Layouts are checked on startup, so this shouldn't happen
+		log.Printf("[VFS] Invalid layout for user %s, using default: %s\n", user.Name, err.Error())
+		fs.layout, _ = store.ParseLayout("")
+	}
+
+	fs.build()
+
+	return fs
+}
+
+// User returns the user this file system was created for
+func (fs *FS) User() config.FTPUser {
+	return fs.user
+}
+
+// Rebuild updates the file system to contain the latest changes from the library
+func (fs *FS) Rebuild() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	fs.build()
+}
+
+// Refresh rebuilds the file system if the library was changed since it was last built
+func (fs *FS) Refresh() {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if fs.generation != fs.manager.Generation() || time.Since(fs.built) > maxTreeAge {
+		fs.build()
+	}
+}
+
+// build creates the virtual file system containing all songs the user is allowed to see
+func (fs *FS) build() {
+	// Changes while building are picked up by the next refresh
+	fs.generation, fs.built = fs.manager.Generation(), time.Now()
+
+	profile, _ := fs.cfg.Profile(fs.user.Profile)
+	filter := fs.manager.NewFilter(fs.user.Filter)
+
+	// Songs without track number are numbered by their position in the album
+	positions := make(map[string]int)
+	for _, a := range fs.manager.GroupByAlbum() {
+		for i, e := range a.Songs {
+			positions[e.ID] = i + 1
+		}
+	}
+
+	root := newDir("")
+
+	for _, made := range fs.madeDirs {
+		root.mkdir(made)
+	}
+
+	for _, e := range fs.manager.AllEntries() {
+		// Entries that should not be synced will not appear in the listing, no matter the user
+		if !e.SyncSettings.Should || !store.MatchesFilter(e, filter) {
+			continue
+		}
+
+		root.add(fs.layout.Path(e, profile.Extension(filepath.Ext(e.FileData.Filename)), positions[e.ID]), fs.newFile(e))
+	}
+
+	// Playlists have their own sync setting, so they can contain songs that are not synced otherwise
+	for _, p := range fs.manager.AllPlaylists() {
+		if p.SyncSettings.Should {
+			fs.addPlaylist(root, profile, filter, PlaylistDir, p.Name, p.ID, fs.manager.PlaylistEntries(p))
+		}
+	}
+	for _, p := range fs.manager.AllSmartPlaylists() {
+		if p.SyncSettings.Should {
+			fs.addPlaylist(root, profile, filter, SmartPlaylistDir, p.Name, p.ID, fs.manager.SmartEntries(p))
+		}
+	}
+
+	fs.root = root
+}
+
+// addPlaylist adds a directory for a playlist below `parent`. It contains the songs the user can see, numbered in order
+func (fs *FS) addPlaylist(root *Dir, profile config.Profile, filter store.Filter, parent, name, id string, entries []music.Entry) {
+	name = strings.TrimSpace(store.CleanName(name))
+	if name == "" {
+		name = id
+	}
+
+	var n int
+	for _, e := range entries {
+		if !store.MatchesFilter(e, filter) {
+			continue
+		}
+		n++
+
+		// The number keeps the order of the playlist when files are sorted by name
+		file := fmt.Sprintf("%02d %s.%s", n, store.CleanName(e.SongName()), profile.Extension(filepath.Ext(e.FileData.Filename)))
+		root.add([]string{parent, name, file}, fs.newFile(e))
+	}
+}
+
+// newFile returns the file for a song, its name is set when it is added to a directory
+func (fs *FS) newFile(e music.Entry) *File {
+	return &File{Entry: e, cfg: &fs.cfg, profile: fs.user.Profile}
+}
+
+// inPlaylist returns whether the path is in one of the playlist directories. Files there can't be changed
+func inPlaylist(p string) bool {
+	components := Split(p)
+	return len(components) > 0 && (strings.EqualFold(components[0], PlaylistDir) || strings.EqualFold(components[0], SmartPlaylistDir))
+}
+
+// Root returns the root directory
+func (fs *FS) Root() *Dir {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.root
+}
+
+// Find returns the directory or file at the given path. Only one of them is set if `ok` is true
+func (fs *FS) Find(p string) (dir *Dir, file *File, ok bool) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.root.find(p)
+}
+
+// Open opens the audio file for `file`, transcoded with the users' profile. Generating it might take some time.
+// The download is recorded for the users' device when the file is closed after it was read to the end
+func (fs *FS) Open(file *File) (f *SongFile, err error) {
+	p, err := file.ProfilePath(fs.cfg, fs.user.Profile)
+	if err != nil {
+		return
+	}
+
+	osf, err := os.Open(p)
+	if err != nil {
+		return
+	}
+
+	fi, err := osf.Stat()
+	if err != nil {
+		osf.Close()
+		return
+	}
+
+	return &SongFile{f: osf, size: fi.Size(), fs: fs, file: file}, nil
+}
+
+// SongFile is an opened audio file. It doesn't embed the *os.File, that way all reads go through Read
+type SongFile struct {
+	f *os.File
+
+	fs   *FS
+	file *File
+
+	// size is the size of the file, pos the current offset
+	size, pos int64
+	// complete is set when the end of the file was reached while reading
+	complete bool
+}
+
+func (s *SongFile) Read(p []byte) (n int, err error) {
+	n, err = s.f.Read(p)
+	s.pos += int64(n)
+
+	if s.pos >= s.size || err == io.EOF {
+		s.complete = true
+	}
+
+	return
+}
+
+func (s *SongFile) Seek(offset int64, whence int) (n int64, err error) {
+	n, err = s.f.Seek(offset, whence)
+	if err == nil {
+		s.pos = n
+	}
+	return
+}
+
+func (s *SongFile) Stat() (os.FileInfo, error) {
+	return s.f.Stat()
+}
+
+// Close closes the file. If it was read to the end, the download is recorded for the users' device.
+// Aborted downloads and requests that only read the beginning of the file are not recorded
+func (s *SongFile) Close() error {
+	if s.complete {
+		err := s.fs.manager.RecordSync(s.fs.user.Name, s.fs.user.Profile, s.file.Entry)
+		if err != nil {
+			log.Printf("[VFS] Error while recording download of %s by %s: %s\n", s.file.ID, s.fs.user.Name, err.Error())
+		}
+	}
+
+	return s.f.Close()
+}
+
+// Put imports the file read from `f`. That way, you can use FTP or WebDAV to import your music library.
+// Tags like artist and album are taken from the directory the file is put in, depending on the users' layout
+func (fs *FS) Put(p string, f io.Reader) (n int64, err error) {
+	if fs.user.DisableUpload {
+		return 0, ErrReadOnly
+	}
+	if inPlaylist(p) {
+		return 0, ErrPlaylist
+	}
+
+	components := Split(p)
+	if len(components) == 0 {
+		return 0, ErrNotFound
+	}
+
+	e, n, err := fs.manager.ImportReader(p, f)
+	if err != nil {
+		log.Println("[Import] Error while importing uploaded file:", err.Error())
+		return
+	}
+
+	err = fs.manager.RetagEntry(e.ID, fs.uploadValues(components))
+	if err != nil {
+		log.Println("[Import] Error while setting tags of uploaded file:", err.Error())
+		return
+	}
+
+	fs.Rebuild()
+
+	return
+}
+
+// uploadValues returns the tags of a file uploaded to the path with the given components.
+// The file name is only used if the file is at the place where the layout puts files, otherwise we only look at directories
+func (fs *FS) uploadValues(components []string) map[string]string {
+	if len(components) != fs.layout.Depth() {
+		dirs := components[:len(components)-1]
+		if len(dirs) >= fs.layout.Depth() {
+			dirs = dirs[:fs.layout.Depth()-1]
+		}
+		components = dirs
+	}
+
+	return fs.layout.Values(components)
+}
+
+// DeleteFile deletes the song from the library or disables syncing it, depending on the users' permissions
+func (fs *FS) DeleteFile(p string) (err error) {
+	if fs.user.Delete == "" {
+		return ErrReadOnly
+	}
+	if inPlaylist(p) {
+		return ErrPlaylist
+	}
+
+	_, file, ok := fs.Find(p)
+	if !ok || file == nil {
+		return ErrNotFound
+	}
+
+	err = fs.deleteEntry(file.ID)
+	if err != nil {
+		return
+	}
+
+	fs.Rebuild()
+
+	return nil
+}
+
+// DeleteDir deletes all songs in the given directory like DeleteFile does.
+// Empty directories can be deleted by users that can create them
+func (fs *FS) DeleteDir(p string) (err error) {
+	dir, _, ok := fs.Find(p)
+	if !ok || dir == nil || len(Split(p)) == 0 {
+		return ErrNotFound
+	}
+	if inPlaylist(p) {
+		return ErrPlaylist
+	}
+
+	var ids []string
+	_ = dir.walk(func(_ []string, file *File) error {
+		ids = append(ids, file.ID)
+		return nil
+	})
+
+	if len(ids) > 0 && fs.user.Delete == "" || len(ids) == 0 && !fs.canMakeDir() {
+		return ErrReadOnly
+	}
+
+	for _, id := range ids {
+		err = fs.deleteEntry(id)
+		if err != nil {
+			return
+		}
+	}
+
+	fs.mu.Lock()
+	fs.moveMadeDirs(Split(p), nil)
+	fs.mu.Unlock()
+
+	fs.Rebuild()
+
+	return nil
+}
+
+func (fs *FS) deleteEntry(id string) error {
+	if fs.user.Delete == "trash" {
+		return fs.manager.TrashEntry(id)
+	}
+	return fs.manager.SetSync(id, false)
+}
+
+// Rename changes the tags of a song or all songs in a directory according to the new name
+func (fs *FS) Rename(src string, dest string) (err error) {
+	if !fs.user.Rename {
+		return ErrReadOnly
+	}
+	if inPlaylist(src) || inPlaylist(dest) {
+		return ErrPlaylist
+	}
+
+	from, to := Split(src), Split(dest)
+
+	dir, file, ok := fs.Find(src)
+	if !ok || len(from) == 0 {
+		return ErrNotFound
+	}
+
+	if len(from) != len(to) {
+		return ErrRenameDepth
+	}
+
+	if file != nil {
+		// Two songs with the same path would hide one of them. Changing the case of the name is fine
+		if _, existing, ok := fs.Find(dest); ok && existing != nil && existing != file {
+			return ErrExists
+		}
+
+		values := fs.layout.ChangedValues(from, to)
+		if len(values) == 0 {
+			return ErrRenameTags
+		}
+
+		err = fs.manager.RetagEntry(file.ID, values)
+	} else {
+		err = dir.walk(func(p []string, file *File) error {
+			fileFrom := append(append([]string{}, from...), p...)
+			fileTo := append(append([]string{}, to...), p...)
+
+			values := fs.layout.ChangedValues(fileFrom, fileTo)
+			if len(values) == 0 {
+				return ErrRenameTags
+			}
+
+			return fs.manager.RetagEntry(file.ID, values)
+		})
+
+		fs.mu.Lock()
+		fs.moveMadeDirs(from, to)
+		fs.mu.Unlock()
+	}
+	if err != nil {
+		return
+	}
+
+	fs.Rebuild()
+
+	return nil
+}
+
+// MakeDir creates an empty directory. Users can then upload files to it or rename it
+func (fs *FS) MakeDir(p string) (err error) {
+	if !fs.canMakeDir() {
+		return ErrReadOnly
+	}
+	if inPlaylist(p) {
+		return ErrPlaylist
+	}
+
+	components := Split(p)
+	if len(components) == 0 {
+		return nil
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	// The current tree might be read by other requests, so the new directory is added to a copy
+	fs.madeDirs = append(fs.madeDirs, components)
+	fs.root = fs.root.withDir(components)
+
+	return nil
+}
+
+func (fs *FS) canMakeDir() bool {
+	return fs.user.Rename || !fs.user.DisableUpload
+}
+
+// moveMadeDirs moves all created directories at `from` to `to`. If `to` is nil, they are removed
+func (fs *FS) moveMadeDirs(from, to []string) {
+	var moved [][]string
+
+outer:
+	for _, d := range fs.madeDirs {
+		if len(d) >= len(from) {
+			for i := range from {
+				if !strings.EqualFold(d[i], from[i]) {
+					moved = append(moved, d)
+					continue outer
+				}
+			}
+
+			if to == nil {
+				continue
+			}
+
+			d = append(append([]string{}, to...), d[len(from):]...)
+		}
+
+		moved = append(moved, d)
+	}
+
+	fs.madeDirs = moved
+}
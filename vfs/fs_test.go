@@ -0,0 +1,313 @@
+package vfs
+
+import (
+	"os"
+	"reflect"
+	"testing"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/store/music"
+)
+
+// testFS returns the file system of `user` for a library with a few songs and a synchronized playlist
+func testFS(t *testing.T, user config.FTPUser) *FS {
+	t.Helper()
+
+	// The manager saves to the data directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	var cfg config.Config
+	cfg.FTP.Users = []config.FTPUser{user}
+
+	m, err := store.NewManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	song := func(id, title, artist, album string) music.Entry {
+		return music.Entry{
+			ID:            id,
+			SyncSettings:  music.SyncSettings{Should: true},
+			FileData:      music.FileData{Filename: "original.mp3"},
+			AudioSettings: music.AudioSettings{Start: -1, End: -1},
+			MusicData:     music.MusicData{Title: title, Artist: artist, Album: album},
+		}
+	}
+
+	m.SongsLock.Lock()
+	m.Songs["a"] = song("a", "Bohemian Rhapsody", "Queen", "A Night at the Opera")
+	m.Songs["b"] = song("b", "Love of My Life", "Queen", "A Night at the Opera")
+	m.Songs["c"] = song("c", "Under Pressure", "Queen", "Hot Space")
+	m.Songs["d"] = song("d", "Waterloo", "ABBA", "Waterloo")
+	m.Playlists = map[string]store.Playlist{
+		"p": {ID: "p", Name: "Road", Songs: []string{"a", "d"}, SyncSettings: music.SyncSettings{Should: true}},
+	}
+	m.SongsLock.Unlock()
+
+	return New(m, user)
+}
+
+// musicData returns the music data of the song with the given ID
+func musicData(t *testing.T, fs *FS, id string) music.MusicData {
+	t.Helper()
+
+	e, ok := fs.manager.GetEntry(id)
+	if !ok {
+		t.Fatalf("song %s doesn't exist", id)
+	}
+	return e.MusicData
+}
+
+func TestFS_Rename(t *testing.T) {
+	tests := []struct {
+		name      string
+		src, dest string
+		wantErr   error
+
+		// id is the song that is checked afterwards
+		id   string
+		want music.MusicData
+	}{
+		{
+			name: "file",
+			src:  "/Queen/A Night at the Opera/Bohemian Rhapsody.mp3", dest: "/Queen/A Night at the Opera/Bohemian Rhapsody (Live).mp3",
+			id: "a", want: music.MusicData{Title: "Bohemian Rhapsody (Live)", Artist: "Queen", Album: "A Night at the Opera"},
+		},
+		{
+			name: "album directory",
+			src:  "/Queen/Hot Space", dest: "/Queen/Hot Space (Remastered)",
+			id: "c", want: music.MusicData{Title: "Under Pressure", Artist: "Queen", Album: "Hot Space (Remastered)"},
+		},
+		{
+			name: "artist directory",
+			src:  "/ABBA", dest: "/ABBA (Band)",
+			id: "d", want: music.MusicData{Title: "Waterloo", Artist: "ABBA (Band)", Album: "Waterloo"},
+		},
+		{
+			name: "file to directory level",
+			src:  "/Queen/Hot Space/Under Pressure.mp3", dest: "/Queen/Under Pressure.mp3",
+			wantErr: ErrRenameDepth,
+			id:      "c", want: music.MusicData{Title: "Under Pressure", Artist: "Queen", Album: "Hot Space"},
+		},
+		{
+			name: "album to artist level",
+			src:  "/Queen/Hot Space", dest: "/Hot Space",
+			wantErr: ErrRenameDepth,
+			id:      "c", want: music.MusicData{Title: "Under Pressure", Artist: "Queen", Album: "Hot Space"},
+		},
+		{
+			name: "onto existing file",
+			src:  "/Queen/A Night at the Opera/Love of My Life.mp3", dest: "/Queen/A Night at the Opera/Bohemian Rhapsody.mp3",
+			wantErr: ErrExists,
+			id:      "b", want: music.MusicData{Title: "Love of My Life", Artist: "Queen", Album: "A Night at the Opera"},
+		},
+		{
+			name: "only changing case",
+			src:  "/Queen/Hot Space/Under Pressure.mp3", dest: "/Queen/Hot Space/UNDER PRESSURE.mp3",
+			id: "c", want: music.MusicData{Title: "UNDER PRESSURE", Artist: "Queen", Album: "Hot Space"},
+		},
+		{
+			// Directories with the same name are merged, like albums with the same name on the website
+			name: "onto existing album directory",
+			src:  "/Queen/Hot Space", dest: "/Queen/A Night at the Opera",
+			id: "c", want: music.MusicData{Title: "Under Pressure", Artist: "Queen", Album: "A Night at the Opera"},
+		},
+		{
+			name: "playlist",
+			src:  "/Playlists/Road", dest: "/Playlists/Trip",
+			wantErr: ErrPlaylist,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := testFS(t, config.FTPUser{Name: "phone", Rename: true, Layout: "{artist}/{album}/{title}.{ext}"})
+
+			err := fs.Rename(tt.src, tt.dest)
+			if err != tt.wantErr {
+				t.Fatalf("Rename() error = %v, want %v", err, tt.wantErr)
+			}
+
+			if tt.id != "" {
+				if got := musicData(t, fs, tt.id); !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("Rename() changed song %s to %+v, want %+v", tt.id, got, tt.want)
+				}
+			}
+
+			if tt.wantErr == nil {
+				if _, _, ok := fs.Find(tt.dest); !ok {
+					t.Errorf("%s doesn't exist after renaming", tt.dest)
+				}
+			}
+		})
+	}
+}
+
+func TestFS_DeleteDir(t *testing.T) {
+	tests := []struct {
+		name    string
+		user    config.FTPUser
+		dir     string
+		wantErr error
+
+		// unsynced are the songs that shouldn't be synced afterwards
+		unsynced []string
+	}{
+		{
+			name:     "artist directory",
+			user:     config.FTPUser{Name: "phone", Delete: "unsync"},
+			dir:      "/Queen",
+			unsynced: []string{"a", "b", "c"},
+		},
+		{
+			name:     "album directory",
+			user:     config.FTPUser{Name: "phone", Delete: "unsync"},
+			dir:      "/Queen/Hot Space",
+			unsynced: []string{"c"},
+		},
+		{
+			name:    "playlist directory",
+			user:    config.FTPUser{Name: "phone", Delete: "unsync"},
+			dir:     "/Playlists/Road",
+			wantErr: ErrPlaylist,
+		},
+		{
+			name:    "all playlists",
+			user:    config.FTPUser{Name: "phone", Delete: "unsync"},
+			dir:     "/Playlists",
+			wantErr: ErrPlaylist,
+		},
+		{
+			name:    "without permission",
+			user:    config.FTPUser{Name: "phone"},
+			dir:     "/Queen",
+			wantErr: ErrReadOnly,
+		},
+		{
+			name:    "missing directory",
+			user:    config.FTPUser{Name: "phone", Delete: "unsync"},
+			dir:     "/Queen/Innuendo",
+			wantErr: ErrNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs := testFS(t, tt.user)
+
+			err := fs.DeleteDir(tt.dir)
+			if err != tt.wantErr {
+				t.Fatalf("DeleteDir() error = %v, want %v", err, tt.wantErr)
+			}
+
+			unsynced := map[string]bool{}
+			for _, id := range tt.unsynced {
+				unsynced[id] = true
+			}
+			for _, id := range []string{"a", "b", "c", "d"} {
+				e, _ := fs.manager.GetEntry(id)
+				if e.SyncSettings.Should == unsynced[id] {
+					t.Errorf("song %s should be synced: %v, got %v", id, !unsynced[id], e.SyncSettings.Should)
+				}
+			}
+
+			_, _, exists := fs.Find(tt.dir)
+			if tt.wantErr == nil && exists {
+				t.Errorf("%s still exists after deleting it", tt.dir)
+			}
+			if tt.wantErr != nil && tt.wantErr != ErrNotFound && !exists {
+				t.Errorf("%s was deleted even though deleting failed", tt.dir)
+			}
+		})
+	}
+}
+
+func TestFS_DeleteDir_made(t *testing.T) {
+	fs := testFS(t, config.FTPUser{Name: "phone"})
+
+	err := fs.MakeDir("/Queen/Innuendo")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Empty directories can be deleted by users that can create them, even if they cannot delete songs
+	err = fs.DeleteDir("/Queen/Innuendo")
+	if err != nil {
+		t.Fatalf("DeleteDir() error = %v", err)
+	}
+
+	if _, _, ok := fs.Find("/Queen/Innuendo"); ok {
+		t.Errorf("directory still exists after deleting it")
+	}
+	if _, _, ok := fs.Find("/Queen/Hot Space"); !ok {
+		t.Errorf("other directories were deleted too")
+	}
+}
+
+func TestFS_uploadValues(t *testing.T) {
+	fs := testFS(t, config.FTPUser{Name: "phone"})
+
+	// Uploading to a new directory creates it first
+	err := fs.MakeDir("/Freddie Mercury/Mr. Bad Guy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dir, _, ok := fs.Find("/Freddie Mercury/Mr. Bad Guy"); !ok || dir == nil {
+		t.Fatalf("nested directory wasn't created")
+	}
+
+	tests := []struct {
+		path string
+		want map[string]string
+	}{
+		{
+			path: "/Freddie Mercury/Mr. Bad Guy/Freddie Mercury - Living on My Own.mp3",
+			want: map[string]string{"artist": "Freddie Mercury", "album": "Mr. Bad Guy"},
+		},
+		{
+			path: "/Freddie Mercury/Mr. Bad Guy/living-on-my-own.mp3",
+			want: map[string]string{"artist": "Freddie Mercury", "album": "Mr. Bad Guy"},
+		},
+		{
+			// Only the artist is known from the directory
+			path: "/Freddie Mercury/living-on-my-own.mp3",
+			want: map[string]string{"artist": "Freddie Mercury"},
+		},
+		{
+			// Deeper directories than the layout has are ignored
+			path: "/Freddie Mercury/Mr. Bad Guy/CD 1/living-on-my-own.mp3",
+			want: map[string]string{"artist": "Freddie Mercury", "album": "Mr. Bad Guy"},
+		},
+		{
+			path: "/living-on-my-own.mp3",
+			want: map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		if got := fs.uploadValues(Split(tt.path)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("uploadValues(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestFS_Put_playlist(t *testing.T) {
+	fs := testFS(t, config.FTPUser{Name: "phone"})
+
+	_, err := fs.Put("/Playlists/Road/song.mp3", nil)
+	if err != ErrPlaylist {
+		t.Errorf("Put() error = %v, want %v", err, ErrPlaylist)
+	}
+
+	fs = testFS(t, config.FTPUser{Name: "phone", DisableUpload: true})
+
+	_, err = fs.Put("/Queen/song.mp3", nil)
+	if err != ErrReadOnly {
+		t.Errorf("Put() error = %v, want %v", err, ErrReadOnly)
+	}
+}
@@ -0,0 +1,244 @@
+package vfs
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"time"
+	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/store/music"
+)
+
+// File is a song file in the virtual file system. It implements os.FileInfo
+type File struct {
+	music.Entry
+
+	// name is the file name generated from the users' layout
+	name string
+
+	// cfg and profile are used to find the file generated for the user
+	cfg     *config.Config
+	profile string
+}
+
+func (f *File) Group() string {
+	return "music"
+}
+
+func (f *File) Owner() string {
+	return "human"
+}
+
+func (f *File) Name() string {
+	// base name
+	return f.name
+}
+
+// Size returns the size of the file generated with the users' profile. Files are only generated when they are
+// downloaded, until then the size of the original file is the best guess
+func (f *File) Size() int64 {
+	if size, ok := f.GeneratedSize(*f.cfg, f.profile); ok {
+		return size
+	}
+	return f.FileData.Size
+}
+
+func (f *File) Mode() os.FileMode {
+	return os.ModePerm
+}
+
+func (f *File) ModTime() time.Time {
+	return f.LastEdit
+}
+
+func (f *File) IsDir() bool {
+	return false
+}
+
+func (f *File) Sys() interface{} {
+	return nil
+}
+
+// Dir is a directory in the virtual file system. It implements os.FileInfo
+type Dir struct {
+	name string
+
+	// dirs and files are keyed by their upper-case name,
+	// that way names that only differ in case end up in the same directory
+	dirs  map[string]*Dir
+	files map[string]*File
+}
+
+func newDir(name string) *Dir {
+	return &Dir{
+		name:  name,
+		dirs:  make(map[string]*Dir),
+		files: make(map[string]*File),
+	}
+}
+
+func (d *Dir) Group() string {
+	return "music"
+}
+
+func (d *Dir) Owner() string {
+	return "human"
+}
+
+func (d *Dir) Name() string {
+	return d.name
+}
+
+func (d *Dir) Size() int64 {
+	return 4096
+}
+
+func (d *Dir) Mode() os.FileMode {
+	// From https://github.com/goftp/qiniu-driver/blob/master/fileinfo.go#L24-L29
+	return os.ModeDir | os.ModePerm
+}
+
+func (d *Dir) ModTime() time.Time {
+	return time.Time{}
+}
+
+// this is the most important bit
+func (d *Dir) IsDir() bool {
+	return true
+}
+
+func (d *Dir) Sys() interface{} {
+	return nil
+}
+
+// add adds a song file to the tree at the given path, creating all directories on the way.
+// If a file with the same name already exists, the song is not added
+func (d *Dir) add(components []string, f *File) {
+	dir := d.mkdir(components[:len(components)-1])
+
+	name := components[len(components)-1]
+	if _, ok := dir.files[strings.ToUpper(name)]; ok {
+		// Don't allow duplicate paths
+		return
+	}
+
+	f.name = name
+	dir.files[strings.ToUpper(name)] = f
+}
+
+// mkdir creates all directories of the given path and returns the last one
+func (d *Dir) mkdir(components []string) *Dir {
+	dir := d
+	for _, c := range components {
+		sub, ok := dir.dirs[strings.ToUpper(c)]
+		if !ok {
+			sub = newDir(c)
+			dir.dirs[strings.ToUpper(c)] = sub
+		}
+		dir = sub
+	}
+	return dir
+}
+
+// withDir returns a copy of this directory that also contains all directories of the given path.
+// Only the directories on the path are copied, the rest is shared. Trees are never changed after they
+// were built, that way they can be read without holding a lock
+func (d *Dir) withDir(components []string) *Dir {
+	cp := &Dir{
+		name:  d.name,
+		dirs:  make(map[string]*Dir, len(d.dirs)+1),
+		files: d.files,
+	}
+	for k, sub := range d.dirs {
+		cp.dirs[k] = sub
+	}
+
+	if len(components) == 0 {
+		return cp
+	}
+
+	sub, ok := d.dirs[strings.ToUpper(components[0])]
+	if !ok {
+		sub = newDir(components[0])
+	}
+	cp.dirs[strings.ToUpper(components[0])] = sub.withDir(components[1:])
+
+	return cp
+}
+
+// find returns the directory or file at the given path
+func (d *Dir) find(p string) (dir *Dir, file *File, ok bool) {
+	dir = d
+
+	split := Split(p)
+	for i, c := range split {
+		if sub, ok := dir.dirs[strings.ToUpper(c)]; ok {
+			dir = sub
+			continue
+		}
+
+		// Files can only be at the end of a path
+		if f, ok := dir.files[strings.ToUpper(c)]; ok && i == len(split)-1 {
+			return nil, f, true
+		}
+
+		return nil, nil, false
+	}
+
+	return dir, nil, true
+}
+
+// List returns all directories and files in alphabetical order
+func (d *Dir) List() (dirs []*Dir, files []*File) {
+	for _, sub := range d.dirs {
+		dirs = append(dirs, sub)
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].name < dirs[j].name
+	})
+
+	for _, f := range d.files {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].name < files[j].name
+	})
+
+	return
+}
+
+// walk calls `f` for all files in this directory and its subdirectories.
+// The path of a file is given relative to this directory
+func (d *Dir) walk(f func(p []string, file *File) error) error {
+	return d.walkPrefix(nil, f)
+}
+
+func (d *Dir) walkPrefix(prefix []string, f func(p []string, file *File) error) (err error) {
+	dirs, files := d.List()
+
+	for _, file := range files {
+		err = f(append(append([]string{}, prefix...), file.name), file)
+		if err != nil {
+			return
+		}
+	}
+
+	for _, sub := range dirs {
+		err = sub.walkPrefix(append(append([]string{}, prefix...), sub.name), f)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Split returns the non-empty components of a slash-separated path. Backslashes are treated like slashes
+func Split(p string) (components []string) {
+	for _, c := range strings.Split(strings.ReplaceAll(p, "\\", "/"), "/") {
+		if c != "" && c != "." {
+			components = append(components, c)
+		}
+	}
+	return
+}
@@ -0,0 +1,80 @@
+package web
+
+import (
+	"net/http"
+	"xarantolus/sensibleHub/store"
+)
+
+type accountPage struct {
+	Title string
+
+	Name string
+	Role string
+
+	Tokens []store.APIToken
+	// NewToken is only set directly after creating a token, it cannot be shown again later
+	NewToken string
+}
+
+// HandleAccount shows the logged in user and their API tokens
+func (s *server) HandleAccount(w http.ResponseWriter, r *http.Request) (err error) {
+	return s.renderAccount(w, r, "")
+}
+
+func (s *server) renderAccount(w http.ResponseWriter, r *http.Request, newToken string) error {
+	acc := requestAccount(r)
+
+	return s.renderTemplate(w, r, "account.html", accountPage{
+		Title:    "Account",
+		Name:     acc.Name,
+		Role:     acc.Role.String(),
+		Tokens:   s.m.UserAPITokens(acc.Name),
+		NewToken: newToken,
+	})
+}
+
+// HandleEditAccount creates or revokes API tokens of the logged in user
+func (s *server) HandleEditAccount(w http.ResponseWriter, r *http.Request) (err error) {
+	acc := requestAccount(r)
+	if acc.Name == "" {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "API tokens are only needed if users have to log in",
+		}
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "create":
+		token, _, err := s.m.CreateAPIToken(acc.Name, r.FormValue("token-name"))
+		if err != nil {
+			return httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    err.Error(),
+			}
+		}
+
+		// The token is shown once, so it must not end up in the history of the browser by redirecting
+		return s.renderAccount(w, r, token)
+	case "revoke":
+		err = s.m.RevokeAPIToken(acc.Name, r.FormValue("id"))
+		if err != nil {
+			return httpError{
+				StatusCode: http.StatusNotFound,
+				Message:    err.Error(),
+			}
+		}
+	default:
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid action",
+		}
+	}
+
+	http.Redirect(w, r, "/account", http.StatusSeeOther)
+	return nil
+}
@@ -3,9 +3,13 @@ package web
 import (
 	"encoding/json"
 	"fmt"
+	"math"
+	"mime/multipart"
 	"net/http"
 	"strconv"
+	"strings"
 	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/music"
 
 	"github.com/gorilla/mux"
 )
@@ -20,12 +24,20 @@ func (s *server) HandleAPISongSearch(w http.ResponseWriter, r *http.Request) (er
 	type apiSearchResult struct {
 		Query string `json:"query"`
 
+		// Interpreted describes how the query was understood
+		Interpreted string `json:"interpreted"`
+		// Error is set if the query could not be parsed, it was then searched as plain words
+		Error string `json:"error,omitempty"`
+
 		Results []shortResult `json:"results"`
 	}
 
 	query := r.URL.Query().Get("q")
 	if query == "" {
-		return fmt.Errorf("empty query")
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Need a search query in the q parameter",
+		}
 	}
 
 	limit := 5
@@ -33,14 +45,17 @@ func (s *server) HandleAPISongSearch(w http.ResponseWriter, r *http.Request) (er
 		limit = i
 	}
 
-	res := s.m.Search(query)
+	res, q, qerr := s.m.SearchQuery(query)
 	if len(res) > limit {
 		res = res[:limit]
 	}
 
 	// TODO:  Suggest random song (title = 🔀 Random Song, id=random)
 
-	var searchResult = apiSearchResult{Query: query, Results: make([]shortResult, len(res))}
+	var searchResult = apiSearchResult{Query: query, Interpreted: q.String(), Results: make([]shortResult, len(res))}
+	if qerr != nil {
+		searchResult.Error = qerr.Error()
+	}
 	for i, r := range res {
 		searchResult.Results[i] = shortResult{
 			Title: r.SongName(),
@@ -52,7 +67,8 @@ func (s *server) HandleAPISongSearch(w http.ResponseWriter, r *http.Request) (er
 	return json.NewEncoder(w).Encode(searchResult)
 }
 
-// HandleAPIListing shows an API listing given via an mux URL variable
+// HandleAPIListing shows an API listing given via an mux URL variable.
+// Smart playlists are available as "smart-" followed by their ID
 func (s *server) HandleAPIListing(w http.ResponseWriter, r *http.Request) (err error) {
 	var possibleListings = map[string]func() []store.Group{
 		"title":          s.m.GroupByTitle,
@@ -61,6 +77,14 @@ func (s *server) HandleAPIListing(w http.ResponseWriter, r *http.Request) (err e
 		"incomplete":     s.m.Incomplete,
 		"unsynced":       s.m.Unsynced,
 		"recentlyedited": s.m.RecentlyEdited,
+		"trims":          s.m.TrimSuggestions,
+	}
+
+	for _, p := range s.m.AllSmartPlaylists() {
+		p := p
+		possibleListings["smart-"+p.ID] = func() []store.Group {
+			return s.m.SmartGroup(p)
+		}
 	}
 
 	vars := mux.Vars(r)
@@ -72,18 +96,26 @@ func (s *server) HandleAPIListing(w http.ResponseWriter, r *http.Request) (err e
 
 	listFunc, ok := possibleListings[listingType]
 	if !ok {
-		return fmt.Errorf("invalid listing type %q", listingType)
+		return httpError{
+			StatusCode: http.StatusNotFound,
+			Message:    fmt.Sprintf("Invalid listing type %q", listingType),
+		}
+	}
+
+	groups := listFunc()
+	if groups == nil {
+		groups = []store.Group{}
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	return json.NewEncoder(w).Encode(listFunc())
+	return json.NewEncoder(w).Encode(groups)
 }
 
-// HandleAPISong lets you request a song by its ID from the API
-func (s *server) HandleAPISong(w http.ResponseWriter, r *http.Request) (err error) {
+// apiSongFromURL returns the song identified by the `songID` URL variable
+func (s *server) apiSongFromURL(r *http.Request) (e music.Entry, err error) {
 	v := mux.Vars(r)
 	if v == nil || v["songID"] == "" {
-		return httpError{
+		return e, httpError{
 			StatusCode: http.StatusPreconditionFailed,
 			Message:    "Need a song ID",
 		}
@@ -91,17 +123,485 @@ func (s *server) HandleAPISong(w http.ResponseWriter, r *http.Request) (err erro
 
 	e, ok := s.m.GetEntry(v["songID"])
 	if !ok {
-		return httpError{
+		return e, httpError{
 			StatusCode: http.StatusNotFound,
 			Message:    "Song not found",
 		}
 	}
 
-	similar := s.m.GetRelatedSongs(e)
+	return e, nil
+}
+
+// sizeParam returns the value of the query parameter `name`, limited to `max`
+func sizeParam(r *http.Request, name string, def, max int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < 0 {
+		return 0, httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    fmt.Sprintf("Parameter %s must be a number that is not negative", name),
+		}
+	}
+	if i > max {
+		i = max
+	}
+
+	return i, nil
+}
+
+// HandleAPISong lets you request a song by its ID from the API.
+// The `similar` parameter sets the number of related songs, it defaults to 5
+func (s *server) HandleAPISong(w http.ResponseWriter, r *http.Request) (err error) {
+	e, err := s.apiSongFromURL(r)
+	if err != nil {
+		return
+	}
+
+	count, err := sizeParam(r, "similar", 5, 100)
+	if err != nil {
+		return
+	}
+
+	similar := s.m.GetRelatedSongs(e, count)
+	if similar == nil {
+		similar = []music.Entry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"song":           e,
+		"similar":        similar,
+		"source_history": s.sourceHistory(e.ID),
+	})
+}
+
+// sourceHistory returns the previous audio sources of a song, the list is empty instead of nil
+func (s *server) sourceHistory(id string) []store.ReplacedSource {
+	h := s.m.GetSourceHistory(id)
+	if h == nil {
+		h = []store.ReplacedSource{}
+	}
+	return h
+}
+
+// HandleAPIReplaceAudio replaces the audio of a song, keeping its ID and metadata. The request is either a multipart form
+// with the new file in the `file` field or JSON like {"url": "..."}. Links are downloaded in the background
+func (s *server) HandleAPIReplaceAudio(w http.ResponseWriter, r *http.Request) (err error) {
+	e, err := s.apiSongFromURL(r)
+	if err != nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		err = r.ParseMultipartForm(250 << 20) // Limit: 250MB
+		if err != nil {
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		audioFile, fh, ferr := r.FormFile("file")
+		if ferr != nil {
+			return httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "Need an audio file in the file field",
+			}
+		}
+		defer audioFile.Close()
+
+		err = s.m.ReplaceAudioFile(e.ID, fh.Filename, audioFile)
+		if err != nil {
+			return httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    err.Error(),
+			}
+		}
+
+		e, _ = s.m.GetEntry(e.ID)
+		return json.NewEncoder(w).Encode(map[string]interface{}{
+			"song":           e,
+			"source_history": s.sourceHistory(e.ID),
+		})
+	}
+
+	var req struct {
+		URL string `json:"url"`
+	}
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req)
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid JSON body: " + err.Error(),
+		}
+	}
+
+	err = s.m.EnqueueReplacement(e.ID, req.URL)
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"queued": true,
+	})
+}
+
+// HandleAPIRadio returns a queue of related songs that starts with the given song.
+// The `size` parameter sets the length of the queue, it defaults to 25
+func (s *server) HandleAPIRadio(w http.ResponseWriter, r *http.Request) (err error) {
+	e, err := s.apiSongFromURL(r)
+	if err != nil {
+		return
+	}
+
+	size, err := sizeParam(r, "size", 25, 250)
+	if err != nil {
+		return
+	}
+
+	queue := s.m.Radio(e, size)
+	if queue == nil {
+		queue = []music.Entry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"seed":  e.ID,
+		"queue": queue,
+	})
+}
+
+// songPatch is the body of PATCH requests for songs. It has the same structure as music.Entry,
+// only the fields that are set are changed
+type songPatch struct {
+	MusicData struct {
+		Title  *string `json:"title"`
+		Artist *string `json:"artist"`
+		Album  *string `json:"album"`
+		Genre  *string `json:"genre"`
+
+		// Setting these numbers to 0 clears them
+		Year   *int `json:"year"`
+		Track  *int `json:"track"`
+		Disc   *int `json:"disc"`
+		Rating *int `json:"rating"`
+	} `json:"music_data"`
+
+	AudioSettings struct {
+		Start   *float64 `json:"start"`
+		End     *float64 `json:"end"`
+		FadeIn  *float64 `json:"fade_in"`
+		FadeOut *float64 `json:"fade_out"`
+		Gain    *float64 `json:"gain"`
+		Mono    *bool    `json:"mono"`
+		Speed   *float64 `json:"speed"`
+		Pitch   *float64 `json:"pitch"`
+	} `json:"audio_settings"`
+
+	SyncSettings struct {
+		Should *bool `json:"should"`
+	} `json:"sync_settings"`
+}
+
+// editData returns the data for store.EditEntry that applies the patch to `e`. Unlike the edit form,
+// which ignores invalid values, it returns an error for them
+func (p songPatch) editData(e music.Entry) (data store.EditEntryData, err error) {
+	data = store.EditDataFromEntry(e)
+
+	setS := func(target *string, value *string) {
+		if value != nil {
+			*target = *value
+		}
+	}
+	setI := func(target *string, value *int, name string, min, max int) {
+		if value == nil || err != nil {
+			return
+		}
+		if *value == 0 {
+			*target = ""
+			return
+		}
+		if *value < min || *value > max {
+			err = fmt.Errorf("%s must be between %d and %d", name, min, max)
+			return
+		}
+		*target = strconv.Itoa(*value)
+	}
+	setF := func(target *string, value *float64, name string, min, max float64) {
+		if value == nil || err != nil {
+			return
+		}
+		if *value < min || *value > max {
+			err = fmt.Errorf("%s must be between %g and %g", name, min, max)
+			return
+		}
+		*target = strconv.FormatFloat(*value, 'f', -1, 64)
+	}
+	setB := func(target *string, value *bool) {
+		if value == nil {
+			return
+		}
+		*target = ""
+		if *value {
+			*target = "on"
+		}
+	}
+
+	md, as := p.MusicData, p.AudioSettings
+
+	if md.Title != nil && strings.TrimSpace(*md.Title) == "" {
+		return data, fmt.Errorf("title must not be empty")
+	}
+	setS(&data.Title, md.Title)
+	setS(&data.Artist, md.Artist)
+	setS(&data.Album, md.Album)
+	setS(&data.Genre, md.Genre)
+
+	setI(&data.Year, md.Year, "year", math.MinInt32, math.MaxInt32)
+	setI(&data.Track, md.Track, "track", 1, 9999)
+	setI(&data.Disc, md.Disc, "disc", 1, 999)
+	setI(&data.Rating, md.Rating, "rating", 1, 5)
+
+	setF(&data.Start, as.Start, "start", 0, e.MusicData.Duration)
+	setF(&data.End, as.End, "end", 0, e.MusicData.Duration)
+	setF(&data.FadeIn, as.FadeIn, "fade_in", 0, e.MusicData.Duration)
+	setF(&data.FadeOut, as.FadeOut, "fade_out", 0, e.MusicData.Duration)
+	setF(&data.Gain, as.Gain, "gain", -30, 30)
+	setF(&data.Speed, as.Speed, "speed", 0.5, 2)
+	setF(&data.Pitch, as.Pitch, "pitch", -12, 12)
+	setB(&data.Mono, as.Mono)
+
+	setB(&data.Sync, p.SyncSettings.Should)
+
+	return
+}
+
+// writeAPISong writes the song with the given ID as JSON
+func (s *server) writeAPISong(w http.ResponseWriter, id string, status int) error {
+	e, _ := s.m.GetEntry(id)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"song": e,
+	})
+}
+
+// HandleAPIEditSong changes metadata, audio and sync settings of a song
+func (s *server) HandleAPIEditSong(w http.ResponseWriter, r *http.Request) (err error) {
+	e, err := s.apiSongFromURL(r)
+	if err != nil {
+		return
+	}
+
+	var patch songPatch
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&patch)
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid JSON body: " + err.Error(),
+		}
+	}
+
+	data, err := patch.editData(e)
+	if err == nil {
+		err = s.m.EditEntry(e.ID, data)
+	}
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	return s.writeAPISong(w, e.ID, http.StatusOK)
+}
+
+// HandleAPIDeleteSong deletes a song and all its files
+func (s *server) HandleAPIDeleteSong(w http.ResponseWriter, r *http.Request) (err error) {
+	err = requireRole(r, roleAdmin)
+	if err != nil {
+		return
+	}
+
+	e, err := s.apiSongFromURL(r)
+	if err != nil {
+		return
+	}
+
+	err = s.m.DeleteEntry(e.ID)
+	if err != nil {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// formImage returns the image uploaded in the `file` field of a multipart form
+func formImage(r *http.Request) (f multipart.File, filename string, err error) {
+	err = r.ParseMultipartForm(50 << 20) // Limit: 50MB
+	if err != nil {
+		return nil, "", httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Need a multipart form: " + err.Error(),
+		}
+	}
+
+	f, fh, err := r.FormFile("file")
+	if err != nil {
+		return nil, "", httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Need an image in the file field",
+		}
+	}
+
+	return f, fh.Filename, nil
+}
+
+// HandleAPISongCover sets (PUT) or removes (DELETE) the cover image of a song.
+// New images are uploaded as multipart form with the image in the `file` field
+func (s *server) HandleAPISongCover(w http.ResponseWriter, r *http.Request) (err error) {
+	e, err := s.apiSongFromURL(r)
+	if err != nil {
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		err = s.m.DeleteCoverImage(e.ID)
+		if err != nil {
+			return
+		}
+
+		return s.writeAPISong(w, e.ID, http.StatusOK)
+	}
+
+	cover, filename, err := formImage(r)
+	if err != nil {
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
 
+	data := store.EditDataFromEntry(e)
+	data.CoverImage, data.CoverFilename = cover, filename
+
+	err = s.m.EditEntry(e.ID, data)
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	return s.writeAPISong(w, e.ID, http.StatusOK)
+}
+
+// apiAlbumFromURL returns the album identified by the `artist` and `album` URL variables
+func (s *server) apiAlbumFromURL(r *http.Request) (a store.Album, err error) {
+	v := mux.Vars(r)
+	if v == nil || v["artist"] == "" || v["album"] == "" {
+		return a, httpError{
+			StatusCode: http.StatusPreconditionFailed,
+			Message:    "Need an artist and album",
+		}
+	}
+
+	a, ok := s.m.GetAlbum(v["artist"], v["album"])
+	if !ok {
+		return a, httpError{
+			StatusCode: http.StatusNotFound,
+			Message:    "Album not found",
+		}
+	}
+
+	return a, nil
+}
+
+// writeAPIAlbum writes an album and its songs as JSON
+func writeAPIAlbum(w http.ResponseWriter, a store.Album) error {
 	w.Header().Set("Content-Type", "application/json")
 	return json.NewEncoder(w).Encode(map[string]interface{}{
-		"song":    e,
-		"similar": similar,
+		"artist": a.Artist,
+		"title":  a.Title,
+		"songs":  a.Songs,
 	})
 }
+
+// HandleAPIAlbum returns an album and its songs
+func (s *server) HandleAPIAlbum(w http.ResponseWriter, r *http.Request) (err error) {
+	a, err := s.apiAlbumFromURL(r)
+	if err != nil {
+		return
+	}
+
+	return writeAPIAlbum(w, a)
+}
+
+// HandleAPIRenameAlbum sets artist and name of all songs in an album, the body is like {"artist": "...", "title": "..."}
+func (s *server) HandleAPIRenameAlbum(w http.ResponseWriter, r *http.Request) (err error) {
+	_, err = s.apiAlbumFromURL(r)
+	if err != nil {
+		return
+	}
+
+	var req struct {
+		Artist string `json:"artist"`
+		Title  string `json:"title"`
+	}
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req)
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid JSON body: " + err.Error(),
+		}
+	}
+
+	v := mux.Vars(r)
+	_, err = s.m.RenameAlbum(v["artist"], v["album"], req.Artist, req.Title)
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	a, _ := s.m.GetAlbum(req.Artist, req.Title)
+	return writeAPIAlbum(w, a)
+}
+
+// HandleAPIAlbumCover sets the cover of all songs in an album. The image is uploaded as multipart form in the `file` field
+func (s *server) HandleAPIAlbumCover(w http.ResponseWriter, r *http.Request) (err error) {
+	_, err = s.apiAlbumFromURL(r)
+	if err != nil {
+		return
+	}
+
+	cover, filename, err := formImage(r)
+	if err != nil {
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	v := mux.Vars(r)
+	err = s.m.EditAlbumCover(v["artist"], v["album"], filename, cover)
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	a, _ := s.m.GetAlbum(v["artist"], v["album"])
+	return writeAPIAlbum(w, a)
+}
@@ -0,0 +1,360 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"xarantolus/sensibleHub/store/config"
+)
+
+// role is the permission level of a website user, higher roles can do everything lower ones can
+type role int
+
+const (
+	roleListener role = iota + 1
+	roleEditor
+	roleAdmin
+)
+
+func parseRole(s string) role {
+	switch s {
+	case config.RoleAdmin:
+		return roleAdmin
+	case config.RoleEditor:
+		return roleEditor
+	case config.RoleListener:
+		return roleListener
+	}
+	return 0
+}
+
+func (r role) String() string {
+	switch r {
+	case roleAdmin:
+		return config.RoleAdmin
+	case roleEditor:
+		return config.RoleEditor
+	case roleListener:
+		return config.RoleListener
+	}
+	return "none"
+}
+
+// account is the user a request was made by. If authentication is disabled, its name is empty
+type account struct {
+	Name string
+	Role role
+}
+
+type accountKey struct{}
+
+// requestAccount returns the account of a request that passed authWrap
+func requestAccount(r *http.Request) account {
+	acc, _ := r.Context().Value(accountKey{}).(account)
+	return acc
+}
+
+const (
+	sessionCookie   = "shub_session"
+	sessionDuration = 30 * 24 * time.Hour
+)
+
+type session struct {
+	user    string
+	expires time.Time
+}
+
+// sessionStore keeps the sessions of logged in users. They are only kept in memory, so everyone
+// has to log in again after a restart
+type sessionStore struct {
+	lock     sync.Mutex
+	sessions map[string]session
+}
+
+func newSessionStore() *sessionStore {
+	return &sessionStore{
+		sessions: make(map[string]session),
+	}
+}
+
+// create starts a new session for `user` and returns its token
+func (st *sessionStore) create(user string) (token string, err error) {
+	b := make([]byte, 32)
+	_, err = rand.Read(b)
+	if err != nil {
+		return
+	}
+	token = hex.EncodeToString(b)
+
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	now := time.Now()
+	for t, s := range st.sessions {
+		if now.After(s.expires) {
+			delete(st.sessions, t)
+		}
+	}
+
+	st.sessions[token] = session{
+		user:    user,
+		expires: now.Add(sessionDuration),
+	}
+
+	return token, nil
+}
+
+// get returns the user name of the session with the given token
+func (st *sessionStore) get(token string) (user string, ok bool) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	s, ok := st.sessions[token]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(s.expires) {
+		delete(st.sessions, token)
+		return "", false
+	}
+
+	return s.user, true
+}
+
+func (st *sessionStore) remove(token string) {
+	st.lock.Lock()
+	defer st.lock.Unlock()
+
+	delete(st.sessions, token)
+}
+
+// isSafeMethod returns whether requests with this method only read data
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// sameOrigin returns whether the request was not sent by another website. Browsers tell us where a request
+// comes from using the Sec-Fetch-Site header, older ones only send the Origin header. Requests without both
+// don't come from a browser, so they cannot be forged by other websites
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "same-origin", "none":
+		return true
+	case "":
+	default:
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+// authenticate returns the account that made the request. A request is authenticated by the header
+// of a trusted reverse proxy, an API token, a session cookie or HTTP basic authentication, in that order
+func (s *server) authenticate(r *http.Request) (acc account, ok bool) {
+	cfg := s.m.GetConfig()
+
+	if !cfg.WebAuthEnabled() {
+		return account{Role: roleAdmin}, true
+	}
+
+	if cfg.Web.ProxyHeader != "" {
+		if name := strings.TrimSpace(r.Header.Get(cfg.Web.ProxyHeader)); name != "" {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err == nil && cfg.IsTrustedProxy(net.ParseIP(host)) {
+				if u, ok := cfg.FindWebUser(name); ok {
+					return account{Name: u.Name, Role: parseRole(u.Role)}, true
+				}
+				if cfg.Web.ProxyRole != "" {
+					return account{Name: name, Role: parseRole(cfg.Web.ProxyRole)}, true
+				}
+				return account{}, false
+			}
+		}
+	}
+
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		if t, ok := s.m.CheckAPIToken(strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))); ok {
+			if u, ok := cfg.FindWebUser(t.User); ok {
+				return account{Name: u.Name, Role: parseRole(u.Role)}, true
+			}
+		}
+		return account{}, false
+	}
+
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		if name, ok := s.sessions.get(c.Value); ok {
+			// Users might have been removed from the config since they logged in
+			if u, ok := cfg.FindWebUser(name); ok {
+				return account{Name: u.Name, Role: parseRole(u.Role)}, true
+			}
+		}
+	}
+
+	if name, pass, ok := r.BasicAuth(); ok {
+		if u, ok := cfg.CheckWebUser(name, pass); ok {
+			return account{Name: u.Name, Role: parseRole(u.Role)}, true
+		}
+	}
+
+	return account{}, false
+}
+
+// authWrap makes sure only logged in users can use the website. Listeners can only use GET requests and manage
+// their API tokens, all other requests need at least the editor role. Requests that change something are rejected
+// if they come from another website
+func (s *server) authWrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p := r.URL.Path
+
+		// These handle their own authentication or are needed before logging in
+		if p == "/dav" || strings.HasPrefix(p, "/dav/") || strings.HasPrefix(p, "/rest/") || strings.HasPrefix(p, "/upnp/") ||
+			strings.HasPrefix(p, "/assets/") || p == "/favicon.ico" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !isSafeMethod(r.Method) && !sameOrigin(r) {
+			writeError(w, r, "Cross-origin requests are not allowed", http.StatusForbidden)
+			return
+		}
+
+		if p == "/login" || p == "/logout" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		acc, ok := s.authenticate(r)
+		if !ok {
+			if isSafeMethod(r.Method) && !strings.HasPrefix(p, "/api/") {
+				http.Redirect(w, r, "/login?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+
+			w.Header().Set("WWW-Authenticate", `Basic realm="sensibleHub", charset="UTF-8"`)
+			writeError(w, r, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		required := roleListener
+		if !isSafeMethod(r.Method) && p != "/account" {
+			required = roleEditor
+		}
+		if acc.Role < required {
+			writeError(w, r, "Forbidden: "+acc.Name+" is a "+acc.Role.String()+" and cannot change anything", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), accountKey{}, acc)))
+	})
+}
+
+// requireRole returns an error if the user of the request doesn't have at least the `required` role
+func requireRole(r *http.Request, required role) error {
+	if acc := requestAccount(r); acc.Role < required {
+		return httpError{
+			StatusCode: http.StatusForbidden,
+			Message:    "Only users with the " + required.String() + " role can do this",
+		}
+	}
+	return nil
+}
+
+type loginPage struct {
+	Title string
+
+	Next  string
+	Error string
+}
+
+// safeRedirect returns `next` if it is a path on this website, otherwise the index page
+func safeRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/"
+	}
+	return next
+}
+
+// HandleLoginPage shows the login form
+func (s *server) HandleLoginPage(w http.ResponseWriter, r *http.Request) (err error) {
+	if _, ok := s.authenticate(r); ok {
+		http.Redirect(w, r, safeRedirect(r.URL.Query().Get("next")), http.StatusSeeOther)
+		return nil
+	}
+
+	return s.renderTemplate(w, r, "login.html", loginPage{
+		Title: "Log in",
+		Next:  safeRedirect(r.URL.Query().Get("next")),
+	})
+}
+
+// HandleLogin checks the submitted credentials and starts a session
+func (s *server) HandleLogin(w http.ResponseWriter, r *http.Request) (err error) {
+	err = r.ParseForm()
+	if err != nil {
+		return
+	}
+
+	next := safeRedirect(r.FormValue("next"))
+
+	u, ok := s.m.GetConfig().CheckWebUser(r.FormValue("name"), r.FormValue("password"))
+	if !ok {
+		log.Printf("[Web] Failed login for %q from %s\n", r.FormValue("name"), r.RemoteAddr)
+
+		w.WriteHeader(http.StatusUnauthorized)
+		return s.renderTemplate(w, r, "login.html", loginPage{
+			Title: "Log in",
+			Next:  next,
+			Error: "Wrong user name or password",
+		})
+	}
+
+	token, err := s.sessions.create(u.Name)
+	if err != nil {
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  time.Now().Add(sessionDuration),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, next, http.StatusSeeOther)
+	return nil
+}
+
+// HandleLogout ends the current session
+func (s *server) HandleLogout(w http.ResponseWriter, r *http.Request) (err error) {
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		s.sessions.remove(c.Value)
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, "/login", http.StatusSeeOther)
+	return nil
+}
@@ -0,0 +1,273 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/music"
+)
+
+type bulkPage struct {
+	Title string
+
+	Songs []music.Entry
+	Form  bulkForm
+
+	// Next is the page the user came from, they are sent back there after applying the changes
+	Next string
+
+	CanDelete bool
+
+	// Preview contains the changes that would be made. Deleting is set if they delete the songs
+	Preview   []store.BulkChange
+	Previewed bool
+	Deleting  bool
+
+	Error string
+}
+
+// bulkForm contains the values of the bulk edit form, they are kept between previewing and applying changes
+type bulkForm struct {
+	Artist string
+	Album  string
+	Genre  string
+	Year   string
+	Rating string
+	Sync   string
+
+	Find    string
+	Replace string
+	Regex   bool
+	Fields  map[string]bool
+}
+
+// data returns the changes described by the form. Empty fields stay the same
+func (f bulkForm) data() (data store.BulkEditData, err error) {
+	setS := func(value string) *string {
+		if strings.TrimSpace(value) == "" {
+			return nil
+		}
+		return &value
+	}
+	setI := func(value, name string) *int {
+		if value == "" || err != nil {
+			return nil
+		}
+		i, ierr := strconv.Atoi(value)
+		if ierr != nil {
+			err = httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    name + " must be a number",
+			}
+			return nil
+		}
+		return &i
+	}
+
+	data.Artist = setS(f.Artist)
+	data.Album = setS(f.Album)
+	data.Genre = setS(f.Genre)
+	data.Year = setI(f.Year, "Year")
+	data.Rating = setI(f.Rating, "Rating")
+
+	switch f.Sync {
+	case "on", "off":
+		should := f.Sync == "on"
+		data.Sync = &should
+	}
+
+	if f.Find != "" {
+		data.Replace = &store.BulkReplace{
+			Find:  f.Find,
+			With:  f.Replace,
+			Regex: f.Regex,
+		}
+		for _, field := range []string{"title", "artist", "album"} {
+			if f.Fields[field] {
+				data.Replace.Fields = append(data.Replace.Fields, field)
+			}
+		}
+	}
+
+	return
+}
+
+func parseBulkForm(r *http.Request) (f bulkForm) {
+	f = bulkForm{
+		Artist: r.FormValue("artist"),
+		Album:  r.FormValue("album"),
+		Genre:  r.FormValue("genre"),
+		Year:   strings.TrimSpace(r.FormValue("year")),
+		Rating: r.FormValue("rating"),
+		Sync:   r.FormValue("sync"),
+
+		Find:    r.FormValue("find"),
+		Replace: r.FormValue("replace"),
+		Regex:   r.FormValue("regex") == "on",
+		Fields:  map[string]bool{},
+	}
+	for _, field := range r.Form["field"] {
+		f.Fields[field] = true
+	}
+	return
+}
+
+// bulkSongs returns the songs with the given IDs, songs that don't exist are left out
+func (s *server) bulkSongs(ids []string) (songs []music.Entry, err error) {
+	for _, id := range ids {
+		if e, ok := s.m.GetEntry(id); ok {
+			songs = append(songs, e)
+		}
+	}
+
+	if len(songs) == 0 {
+		return nil, httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "No songs selected",
+		}
+	}
+
+	return
+}
+
+// HandleBulkEdit shows the form for editing all songs given in `id` parameters at once
+func (s *server) HandleBulkEdit(w http.ResponseWriter, r *http.Request) (err error) {
+	songs, err := s.bulkSongs(r.URL.Query()["id"])
+	if err != nil {
+		return
+	}
+
+	next := r.URL.Query().Get("next")
+	if next == "" {
+		next = "/songs"
+	}
+
+	return s.renderTemplate(w, r, "bulk.html", bulkPage{
+		Title:     "Edit songs",
+		Songs:     songs,
+		Form:      bulkForm{Fields: map[string]bool{"title": true, "artist": true, "album": true}},
+		Next:      safeRedirect(next),
+		CanDelete: requestAccount(r).Role >= roleAdmin,
+	})
+}
+
+// HandleApplyBulkEdit previews or applies the changes from the bulk edit form. The `action` value
+// is "preview" or "apply" for editing and "preview-delete" or "delete" for deleting the songs
+func (s *server) HandleApplyBulkEdit(w http.ResponseWriter, r *http.Request) (err error) {
+	err = r.ParseForm()
+	if err != nil {
+		return
+	}
+
+	songs, err := s.bulkSongs(r.PostForm["id"])
+	if err != nil {
+		return
+	}
+
+	// Songs that were deleted in the meantime are left out
+	var ids []string
+	for _, e := range songs {
+		ids = append(ids, e.ID)
+	}
+
+	action := r.FormValue("action")
+	page := bulkPage{
+		Title:     "Edit songs",
+		Songs:     songs,
+		Form:      parseBulkForm(r),
+		Next:      safeRedirect(r.FormValue("next")),
+		CanDelete: requestAccount(r).Role >= roleAdmin,
+		Deleting:  action == "preview-delete" || action == "delete",
+	}
+
+	var data store.BulkEditData
+	if page.Deleting {
+		err = requireRole(r, roleAdmin)
+		if err != nil {
+			return
+		}
+		data.Delete = true
+	} else {
+		data, err = page.Form.data()
+	}
+
+	if err == nil {
+		switch action {
+		case "preview", "preview-delete":
+			page.Preview, err = s.m.PreviewEditEntries(ids, data)
+			page.Previewed = err == nil
+		case "apply", "delete":
+			_, err = s.m.EditEntries(ids, data)
+			if err == nil {
+				http.Redirect(w, r, page.Next, http.StatusSeeOther)
+				return nil
+			}
+		default:
+			return httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "Invalid action",
+			}
+		}
+	}
+
+	if err != nil {
+		page.Error = err.Error()
+		if h, ok := err.(httpError); ok {
+			page.Error = h.Message
+		}
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	return s.renderTemplate(w, r, "bulk.html", page)
+}
+
+// HandleAPIBulkEdit changes or deletes several songs at once. The body is like
+// {"songs": ["id", ...], "edit": {...}, "preview": true}, previews return the changes without applying them
+func (s *server) HandleAPIBulkEdit(w http.ResponseWriter, r *http.Request) (err error) {
+	var req struct {
+		Songs   []string           `json:"songs"`
+		Edit    store.BulkEditData `json:"edit"`
+		Preview bool               `json:"preview"`
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&req)
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid JSON body: " + err.Error(),
+		}
+	}
+
+	if req.Edit.Delete {
+		err = requireRole(r, roleAdmin)
+		if err != nil {
+			return
+		}
+	}
+
+	var changes []store.BulkChange
+	if req.Preview {
+		changes, err = s.m.PreviewEditEntries(req.Songs, req.Edit)
+	} else {
+		changes, err = s.m.EditEntries(req.Songs, req.Edit)
+	}
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+	if changes == nil {
+		changes = []store.BulkChange{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"changes": changes,
+		"applied": !req.Preview,
+	})
+}
@@ -0,0 +1,397 @@
+package web
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/store/music"
+	"xarantolus/sensibleHub/vfs"
+
+	"golang.org/x/net/webdav"
+)
+
+// davHandler serves the virtual music file system over WebDAV. Users log in with their FTP credentials
+// and see the same files they would see via FTP
+type davHandler struct {
+	m *store.Manager
+
+	locks webdav.LockSystem
+
+	// filesystems contains the file system of each user. We keep them around because
+	// they contain the directories created by the user
+	filesystemsLock sync.Mutex
+	filesystems     map[string]*vfs.FS
+
+	// logins caches successful logins, since checking password hashes is slow and clients send a lot of requests.
+	// They are keyed by a hash of the salt, user name and password. The config can't change while running,
+	// so logins stay valid
+	loginsLock sync.Mutex
+	logins     map[[sha256.Size]byte]config.FTPUser
+	loginSalt  []byte
+}
+
+func newDavHandler(m *store.Manager) *davHandler {
+	salt := make([]byte, 32)
+	_, _ = rand.Read(salt)
+
+	return &davHandler{
+		m:           m,
+		locks:       webdav.NewMemLS(),
+		filesystems: make(map[string]*vfs.FS),
+		logins:      make(map[[sha256.Size]byte]config.FTPUser),
+		loginSalt:   salt,
+	}
+}
+
+// login returns the user with the given credentials
+func (d *davHandler) login(name, pass string) (user config.FTPUser, ok bool) {
+	h := sha256.New()
+	h.Write(d.loginSalt)
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(pass))
+
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+
+	d.loginsLock.Lock()
+	user, ok = d.logins[key]
+	d.loginsLock.Unlock()
+	if ok {
+		return
+	}
+
+	user, ok = vfs.FindUser(d.m.GetConfig(), name, pass)
+	if ok {
+		d.loginsLock.Lock()
+		d.logins[key] = user
+		d.loginsLock.Unlock()
+	}
+
+	return
+}
+
+func (d *davHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, pass, ok := r.BasicAuth()
+	if ok {
+		var user, found = d.login(name, pass)
+		if found {
+			fs := d.userFS(user.Name)
+			if fs == nil {
+				fs = vfs.New(d.m, user)
+
+				d.filesystemsLock.Lock()
+				d.filesystems[user.Name] = fs
+				d.filesystemsLock.Unlock()
+			} else {
+				// Make sure we show the latest changes
+				fs.Refresh()
+			}
+
+			// ServeContent only knows content types from the mime package, so we set it for formats it might not know
+			if contentType := music.ContentType(path.Ext(r.URL.Path)); contentType != "" && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+				w.Header().Set("Content-Type", contentType)
+			}
+
+			(&webdav.Handler{
+				Prefix:     "/dav",
+				FileSystem: &davFS{fs},
+				LockSystem: d.locks,
+				Logger: func(r *http.Request, err error) {
+					if err != nil && !os.IsNotExist(err) {
+						log.Printf("[WebDAV] Error while handling %s %s for %s: %s\n", r.Method, r.URL.Path, user.Name, err.Error())
+					}
+				},
+			}).ServeHTTP(w, r)
+			return
+		}
+	}
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="sensibleHub", charset="UTF-8"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
+
+func (d *davHandler) userFS(name string) *vfs.FS {
+	d.filesystemsLock.Lock()
+	defer d.filesystemsLock.Unlock()
+
+	return d.filesystems[name]
+}
+
+// davFS implements webdav.FileSystem on top of the virtual file system
+type davFS struct {
+	fs *vfs.FS
+}
+
+func (d *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return davError(d.fs.MakeDir(name))
+}
+
+func (d *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	dir, file, ok := d.fs.Find(name)
+
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC) != 0 {
+		// Uploads always import a new song, existing songs and directories can't be overwritten
+		if ok {
+			return nil, os.ErrPermission
+		}
+		return d.create(name)
+	}
+
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	if file != nil {
+		return &davFile{fs: d.fs, info: davFileInfo{File: file, profile: d.fs.User().Profile}}, nil
+	}
+
+	return &davDir{dir: dir, profile: d.fs.User().Profile}, nil
+}
+
+// create returns a file that imports its content to the library when it is closed
+func (d *davFS) create(name string) (webdav.File, error) {
+	if d.fs.User().DisableUpload {
+		return nil, os.ErrPermission
+	}
+
+	if len(vfs.Split(name)) == 0 {
+		return nil, os.ErrPermission
+	}
+
+	tmp, err := os.CreateTemp("", "sensibleHub-dav-*"+path.Ext(name))
+	if err != nil {
+		return nil, err
+	}
+
+	return &davUpload{File: tmp, fs: d.fs, name: name}, nil
+}
+
+func (d *davFS) RemoveAll(ctx context.Context, name string) error {
+	dir, _, ok := d.fs.Find(name)
+	if !ok {
+		return os.ErrNotExist
+	}
+
+	if dir != nil {
+		return davError(d.fs.DeleteDir(name))
+	}
+
+	return davError(d.fs.DeleteFile(name))
+}
+
+func (d *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	return davError(d.fs.Rename(oldName, newName))
+}
+
+func (d *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	dir, file, ok := d.fs.Find(name)
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	if file != nil {
+		return davFileInfo{File: file, profile: d.fs.User().Profile}, nil
+	}
+
+	return dir, nil
+}
+
+// davError converts errors of the virtual file system to errors the webdav package understands
+func davError(err error) error {
+	switch err {
+	case vfs.ErrNotFound:
+		return os.ErrNotExist
+	case vfs.ErrReadOnly, vfs.ErrPlaylist:
+		return os.ErrPermission
+	case vfs.ErrExists:
+		return os.ErrExist
+	}
+	return err
+}
+
+// davFileInfo adds ETag and content type information to a song file
+type davFileInfo struct {
+	*vfs.File
+
+	// profile is the name of the transcoding profile, generated files are different for each one
+	profile string
+}
+
+// ETag changes whenever the song or the profile is changed
+func (f davFileInfo) ETag(ctx context.Context) (string, error) {
+	if f.profile == "" {
+		return fmt.Sprintf(`"%s-%x"`, f.ID, f.LastEdit.UnixNano()), nil
+	}
+	return fmt.Sprintf(`"%s-%x-%s"`, f.ID, f.LastEdit.UnixNano(), f.profile), nil
+}
+
+func (f davFileInfo) ContentType(ctx context.Context) (string, error) {
+	contentType := music.ContentType(path.Ext(f.Name()))
+	if contentType == "" {
+		return "application/octet-stream", nil
+	}
+
+	return contentType, nil
+}
+
+// davFile is a song file. The audio file is only generated when it is read,
+// that way listing directories doesn't generate all files in them
+type davFile struct {
+	fs   *vfs.FS
+	info davFileInfo
+
+	f *vfs.SongFile
+}
+
+func (d *davFile) open() (err error) {
+	if d.f != nil {
+		return nil
+	}
+
+	d.f, err = d.fs.Open(d.info.File)
+	return
+}
+
+func (d *davFile) Read(p []byte) (n int, err error) {
+	err = d.open()
+	if err != nil {
+		return
+	}
+
+	return d.f.Read(p)
+}
+
+func (d *davFile) Seek(offset int64, whence int) (n int64, err error) {
+	err = d.open()
+	if err != nil {
+		return
+	}
+
+	return d.f.Seek(offset, whence)
+}
+
+func (d *davFile) Write(p []byte) (n int, err error) {
+	return 0, os.ErrPermission
+}
+
+func (d *davFile) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (d *davFile) Stat() (os.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *davFile) Close() error {
+	if d.f == nil {
+		return nil
+	}
+	return d.f.Close()
+}
+
+// davDir is an opened directory
+type davDir struct {
+	dir     *vfs.Dir
+	profile string
+
+	// read is the number of entries already returned by Readdir
+	read int
+}
+
+func (d *davDir) Read(p []byte) (n int, err error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *davDir) Seek(offset int64, whence int) (int64, error) {
+	return 0, os.ErrInvalid
+}
+
+func (d *davDir) Write(p []byte) (n int, err error) {
+	return 0, os.ErrPermission
+}
+
+func (d *davDir) Readdir(count int) (infos []os.FileInfo, err error) {
+	dirs, files := d.dir.List()
+
+	for _, sub := range dirs {
+		infos = append(infos, sub)
+	}
+	for _, file := range files {
+		infos = append(infos, davFileInfo{File: file, profile: d.profile})
+	}
+
+	if d.read >= len(infos) {
+		if count > 0 {
+			return nil, io.EOF
+		}
+		return nil, nil
+	}
+	infos = infos[d.read:]
+
+	if count > 0 && count < len(infos) {
+		infos = infos[:count]
+	}
+	d.read += len(infos)
+
+	return infos, nil
+}
+
+func (d *davDir) Stat() (os.FileInfo, error) {
+	return d.dir, nil
+}
+
+func (d *davDir) Close() error {
+	return nil
+}
+
+// davUpload is a file that is uploaded to a temporary file first, it is imported when it is closed
+type davUpload struct {
+	*os.File
+
+	fs   *vfs.FS
+	name string
+}
+
+func (d *davUpload) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, os.ErrInvalid
+}
+
+func (d *davUpload) Close() (err error) {
+	defer os.Remove(d.File.Name())
+
+	fi, err := d.File.Stat()
+	if err != nil {
+		d.File.Close()
+		return
+	}
+
+	// Clients lock new files by creating them empty before uploading, there is nothing to import yet
+	if fi.Size() == 0 {
+		return d.File.Close()
+	}
+
+	_, err = d.File.Seek(0, io.SeekStart)
+	if err != nil {
+		d.File.Close()
+		return
+	}
+
+	_, err = d.fs.Put(d.name, d.File)
+
+	cerr := d.File.Close()
+	if err == nil {
+		err = cerr
+	}
+
+	return davError(err)
+}
@@ -0,0 +1,111 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"xarantolus/sensibleHub/store/config"
+)
+
+func TestDav_recordSync(t *testing.T) {
+	var cfg config.Config
+	cfg.FTP.Users = []config.FTPUser{{Name: "phone", Passwd: "secret"}}
+
+	s := testServerConfig(t, cfg)
+
+	// The mp3 file was already generated
+	e, _ := s.m.GetEntry("a")
+	err := os.MkdirAll(e.DirPath(), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, _ := e.GeneratedPath(s.m.GetConfig(), "")
+	err = os.WriteFile(p, []byte("0123456789"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(method, header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/dav/Queen/A%20Night%20at%20the%20Opera/Queen%20-%20Bohemian%20Rhapsody.mp3", nil)
+		req.SetBasicAuth("phone", "secret")
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	synced := func() bool {
+		s.m.SongsLock.RLock()
+		defer s.m.SongsLock.RUnlock()
+
+		d := s.m.Devices["phone"]
+		if d == nil {
+			return false
+		}
+		_, ok := d.Songs["a"]
+		return ok
+	}
+
+	rec := request("PROPFIND", "Depth", "0")
+	if rec.Code != http.StatusMultiStatus || !strings.Contains(rec.Body.String(), "<D:getcontentlength>10</D:getcontentlength>") {
+		t.Errorf("expected the size of the generated file, got status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = request(http.MethodGet, "Range", "bytes=0-3")
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "0123" {
+		t.Fatalf("range request returned status %d: %q", rec.Code, rec.Body.String())
+	}
+	if synced() {
+		t.Errorf("reading the beginning of the file was recorded as sync")
+	}
+
+	rec = request(http.MethodGet, "", "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "0123456789" {
+		t.Fatalf("download returned status %d: %q", rec.Code, rec.Body.String())
+	}
+	if !synced() {
+		t.Errorf("complete download wasn't recorded")
+	}
+}
+
+func TestDav_uploads(t *testing.T) {
+	var cfg config.Config
+	cfg.FTP.Users = []config.FTPUser{{Name: "phone", Passwd: "secret"}}
+
+	s := testServerConfig(t, cfg)
+
+	request := func(method, p, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, p, strings.NewReader(body))
+		req.SetBasicAuth("phone", "secret")
+
+		rec := httptest.NewRecorder()
+		s.router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Existing songs can't be overwritten
+	existing := "/dav/Queen/A%20Night%20at%20the%20Opera/Queen%20-%20Bohemian%20Rhapsody.mp3"
+	rec := request(http.MethodPut, existing, "audio")
+	if rec.Code < 400 {
+		t.Errorf("overwriting a song returned status %d", rec.Code)
+	}
+
+	// Clients lock new files before uploading them, which creates them empty
+	rec = request("LOCK", "/dav/Queen/new.mp3", `<?xml version="1.0" encoding="utf-8"?>
+<D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>`)
+	if rec.Code != http.StatusCreated {
+		t.Errorf("locking a new file returned status %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if _, err := os.Stat("import"); !os.IsNotExist(err) {
+		t.Errorf("expected nothing to be imported, got %v", err)
+	}
+	if n := len(s.m.AllEntries()); n != 2 {
+		t.Errorf("expected 2 songs, got %d", n)
+	}
+}
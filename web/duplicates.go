@@ -0,0 +1,97 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"xarantolus/sensibleHub/store/music"
+)
+
+type duplicatesPage struct {
+	Title string
+
+	Groups []duplicateGroup
+
+	// Enabled is false if fpcalc is not configured, Missing is the number of songs without a fingerprint
+	Enabled bool
+	Missing int
+}
+
+type duplicateGroup struct {
+	Similarity string
+	Songs      []duplicateSong
+}
+
+// duplicateSong contains the values that are compared when choosing which duplicate to keep
+type duplicateSong struct {
+	music.Entry
+
+	Best bool
+
+	Format string
+	Size   string
+}
+
+// HandleDuplicates renders a listing of songs that probably contain the same audio
+func (s *server) HandleDuplicates(w http.ResponseWriter, r *http.Request) (err error) {
+	enabled, missing := s.m.FingerprintStatus()
+
+	var groups []duplicateGroup
+	for _, g := range s.m.FindDuplicates() {
+		dg := duplicateGroup{
+			Similarity: fmt.Sprintf("%.0f%%", g.Similarity*100),
+		}
+
+		for i, e := range g.Songs {
+			dg.Songs = append(dg.Songs, duplicateSong{
+				Entry:  e,
+				Best:   i == 0,
+				Format: strings.ToUpper(strings.TrimPrefix(filepath.Ext(e.FileData.Filename), ".")),
+				Size:   fmt.Sprintf("%.1f MB", float64(e.FileData.Size)/(1<<20)),
+			})
+		}
+
+		groups = append(groups, dg)
+	}
+
+	return s.renderTemplate(w, r, "duplicates.html", duplicatesPage{
+		Title:   "Duplicates",
+		Groups:  groups,
+		Enabled: enabled,
+		Missing: missing,
+	})
+}
+
+// HandleMergeDuplicates merges the songs with the IDs in the "merge" form values into the song given by "keep"
+func (s *server) HandleMergeDuplicates(w http.ResponseWriter, r *http.Request) (err error) {
+	// Merging deletes the other songs
+	err = requireRole(r, roleAdmin)
+	if err != nil {
+		return
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		return
+	}
+
+	keep := r.FormValue("keep")
+	if keep == "" {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Need a song to keep",
+		}
+	}
+
+	err = s.m.MergeDuplicates(keep, r.Form["merge"]...)
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	http.Redirect(w, r, "/duplicates", http.StatusSeeOther)
+	return nil
+}
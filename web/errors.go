@@ -1,9 +1,11 @@
 package web
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"strings"
 )
 
 // httpError is an error type that also contains an appropriate HTTP status code
@@ -31,7 +33,7 @@ func (s *server) errWrap(f func(http.ResponseWriter, *http.Request) error) http.
 				log.Printf("[Web] %s %s: %s\n", r.Method, r.URL.Path, err.Error())
 			}
 
-			http.Error(w, h.Message, h.StatusCode)
+			writeError(w, r, h.Message, h.StatusCode)
 			return
 		}
 
@@ -41,6 +43,31 @@ func (s *server) errWrap(f func(http.ResponseWriter, *http.Request) error) http.
 
 		// there is the possibility that we leak internal details here, but it doesn't really matter in this case
 		// as no http requests (with secret tokens etc.) are performed on the back-end
-		http.Error(w, "Internal Server Error: "+err.Error(), http.StatusInternalServerError)
+		writeError(w, r, "Internal Server Error: "+err.Error(), http.StatusInternalServerError)
 	}
 }
+
+// apiError is the body of error responses of the API
+type apiError struct {
+	Error struct {
+		Status  int    `json:"status"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// writeError writes an error message with the given status code. API requests get an apiError as JSON, all others plain text
+func writeError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		http.Error(w, message, status)
+		return
+	}
+
+	var e apiError
+	e.Error.Status = status
+	e.Error.Message = message
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(e)
+}
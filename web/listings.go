@@ -15,6 +15,15 @@ type listingPage struct {
 
 	// Groups are the groups that should be displayed
 	Groups []store.Group
+
+	// BulkAction is an optional button that applies an action to all songs in this listing
+	BulkAction *bulkAction
+}
+
+// bulkAction is a form button that sends a POST request to URL
+type bulkAction struct {
+	URL   string
+	Label string
 }
 
 // HandleTitleListing renders the song listing, sorted by titles
@@ -49,6 +58,14 @@ func (s *server) HandleIncompleteListing(w http.ResponseWriter, r *http.Request)
 	})
 }
 
+// HandleQualityListing renders a listing of all songs grouped by audio quality, the worst first
+func (s *server) HandleQualityListing(w http.ResponseWriter, r *http.Request) (err error) {
+	return s.renderTemplate(w, r, "listing.html", listingPage{
+		Title:  "Audio quality",
+		Groups: s.m.QualityGroups(),
+	})
+}
+
 // HandleUnsyncedListing renders a listing with all items that are not synced
 func (s *server) HandleUnsyncedListing(w http.ResponseWriter, r *http.Request) (err error) {
 	return s.renderTemplate(w, r, "listing.html", listingPage{
@@ -65,6 +82,14 @@ func (s *server) HandleRecentlyEditedListing(w http.ResponseWriter, r *http.Requ
 	})
 }
 
+// HandleDeviceListing renders a listing that shows which songs are outdated or missing on each FTP/WebDAV device
+func (s *server) HandleDeviceListing(w http.ResponseWriter, r *http.Request) (err error) {
+	return s.renderTemplate(w, r, "listing.html", listingPage{
+		Title:  "Devices",
+		Groups: s.m.DeviceGroups(),
+	})
+}
+
 // HandleSortedByAddDateListing returns a listing of all songs, sorted by add date
 func (s *server) HandleSortedByAddDateListing(w http.ResponseWriter, r *http.Request) (err error) {
 	return s.renderTemplate(w, r, "listing.html", listingPage{
@@ -73,11 +98,52 @@ func (s *server) HandleSortedByAddDateListing(w http.ResponseWriter, r *http.Req
 	})
 }
 
+// HandleTrimListing renders a listing of all songs that have suggested trim points
+func (s *server) HandleTrimListing(w http.ResponseWriter, r *http.Request) (err error) {
+	groups := s.m.TrimSuggestions()
+
+	var action *bulkAction
+	if len(groups) > 0 {
+		action = &bulkAction{
+			URL:   "/trims",
+			Label: "Apply all suggestions",
+		}
+	}
+
+	return s.renderTemplate(w, r, "listing.html", listingPage{
+		Title:      "Trim suggestions",
+		Groups:     groups,
+		BulkAction: action,
+	})
+}
+
+// HandleApplyTrims applies all trim suggestions
+func (s *server) HandleApplyTrims(w http.ResponseWriter, r *http.Request) (err error) {
+	var ids []string
+	for _, g := range s.m.TrimSuggestions() {
+		for _, e := range g.Songs {
+			ids = append(ids, e.ID)
+		}
+	}
+
+	_, err = s.m.ApplyTrimSuggestions(ids...)
+	if err != nil {
+		return
+	}
+
+	http.Redirect(w, r, "/trims", http.StatusSeeOther)
+	return
+}
+
 type searchListing struct {
 	Title string
 	Songs []music.Entry
 
 	Query string
+
+	// Interpreted describes how the query was understood, Error is set if it could not be parsed
+	Interpreted string
+	Error       string
 }
 
 // HandleSearchListing renders a search listing
@@ -90,25 +156,34 @@ func (s *server) HandleSearchListing(w http.ResponseWriter, r *http.Request) (er
 		}
 	}
 
-	res := s.m.Search(query)
+	res, q, qerr := s.m.SearchQuery(query)
 
 	// If we find exactly one song, we can just redirect
-	if len(res) == 1 {
+	if len(res) == 1 && qerr == nil {
 		http.Redirect(w, r, "/song/"+res[0].ID, http.StatusTemporaryRedirect)
 		return
 	}
 
-	return s.renderTemplate(w, r, "search.html", searchListing{
-		Title: "Search results",
-		Songs: res,
-		Query: query,
-	})
+	sl := searchListing{
+		Title:       "Search results",
+		Songs:       res,
+		Query:       query,
+		Interpreted: q.String(),
+	}
+	if qerr != nil {
+		sl.Error = qerr.Error()
+	}
+
+	return s.renderTemplate(w, r, "search.html", sl)
 }
 
 type albumPage struct {
 	Title string
 
 	A store.Album
+
+	// Playlists are shown as targets the songs of the album can be added to
+	Playlists []store.Playlist
 }
 
 // HandleShowAlbum renders the album page for the artist and album that's given in the url
@@ -131,8 +206,9 @@ func (s *server) HandleShowAlbum(w http.ResponseWriter, r *http.Request) (err er
 
 	al.Title = al.Artist + " - " + al.Title
 	return s.renderTemplate(w, r, "album.html", albumPage{
-		Title: al.Title,
-		A:     al,
+		Title:     al.Title,
+		A:         al,
+		Playlists: s.m.AllPlaylists(),
 	})
 }
 
@@ -12,6 +12,8 @@ import (
 	"strconv"
 	"strings"
 	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/music"
+	"xarantolus/sensibleHub/upnp"
 
 	"github.com/gorilla/mux"
 )
@@ -142,6 +144,7 @@ func (s *server) HandleAudio(w http.ResponseWriter, r *http.Request) (err error)
 
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", store.CleanName(e.Filename(filepath.Ext(e.FileData.Filename)))))
 
+	upnp.SetStreamingHeaders(w, r)
 	http.ServeFile(w, r, cp)
 
 	return nil
@@ -174,7 +177,93 @@ func (s *server) HandleMP3(w http.ResponseWriter, r *http.Request) (err error) {
 	w.Header().Set("Content-Type", "audio/mpeg")
 	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", store.CleanName(e.Filename("mp3"))))
 
+	upnp.SetStreamingHeaders(w, r)
 	http.ServeFile(w, r, outName)
 
 	return nil
 }
+
+// HandleFile returns the requested songs' audio transcoded with the profile given in the `profile` URL parameter.
+// Without that parameter, it serves the same mp3 file as HandleMP3
+func (s *server) HandleFile(w http.ResponseWriter, r *http.Request) (err error) {
+	v := mux.Vars(r)
+	if v == nil || v["songID"] == "" {
+		return httpError{
+			StatusCode: http.StatusPreconditionFailed,
+			Message:    "Need a song ID",
+		}
+	}
+
+	e, ok := s.m.GetEntry(v["songID"])
+	if !ok {
+		return httpError{
+			StatusCode: http.StatusNotFound,
+			Message:    "Song not found",
+		}
+	}
+
+	cfg := s.m.GetConfig()
+
+	profile := r.URL.Query().Get("profile")
+	if _, ok := cfg.Profile(profile); !ok {
+		return httpError{
+			StatusCode: http.StatusNotFound,
+			Message:    "Profile not found",
+		}
+	}
+
+	outName, err := e.ProfilePath(cfg, profile)
+	if err != nil {
+		return
+	}
+
+	ext := filepath.Ext(outName)
+
+	if contentType := music.ContentType(ext); contentType != "" {
+		w.Header().Set("Content-Type", contentType)
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", store.CleanName(e.Filename(strings.TrimPrefix(ext, ".")))))
+
+	upnp.SetStreamingHeaders(w, r)
+	http.ServeFile(w, r, outName)
+
+	return nil
+}
+
+// HandleDataFile serves the audio or cover file of a song from the data directory. Other files in there,
+// like the library index, generated transcodes or the trash, aren't served
+func (s *server) HandleDataFile(w http.ResponseWriter, r *http.Request) (err error) {
+	v := mux.Vars(r)
+	if v == nil || v["songID"] == "" || v["file"] == "" {
+		return httpError{
+			StatusCode: http.StatusPreconditionFailed,
+			Message:    "Need a song ID and file name",
+		}
+	}
+
+	e, ok := s.m.GetEntry(v["songID"])
+	if !ok {
+		return httpError{
+			StatusCode: http.StatusNotFound,
+			Message:    "Song not found",
+		}
+	}
+
+	var p string
+	switch v["file"] {
+	case e.FileData.Filename:
+		p = e.AudioPath()
+	case e.PictureData.Filename:
+		p = e.CoverPath()
+	}
+	if p == "" {
+		return httpError{
+			StatusCode: http.StatusNotFound,
+			Message:    "File not found",
+		}
+	}
+
+	http.ServeFile(w, r, p)
+
+	return
+}
@@ -0,0 +1,58 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"xarantolus/sensibleHub/store/config"
+)
+
+func TestHandleDataFile(t *testing.T) {
+	var cfg config.Config
+	cfg.Web.Users = []config.WebUser{{Name: "listener", Passwd: "secret", Role: config.RoleListener}}
+
+	s := testServerConfig(t, cfg)
+	h := s.authWrap(s.router)
+
+	e, _ := s.m.GetEntry("a")
+	err := os.MkdirAll(e.DirPath(), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{e.AudioPath(), filepath.Join(e.DirPath(), "latest.mp3"), filepath.Join("data", "manager.json"), filepath.Join("data", "ftp-key.pem")} {
+		err = os.WriteFile(p, []byte("content"), 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/data/songs/a/a.opus", http.StatusOK},
+		{"/data/songs/a/latest.mp3", http.StatusNotFound},
+		{"/data/songs/missing/a.opus", http.StatusNotFound},
+		{"/data/manager.json", http.StatusNotFound},
+		{"/data/ftp-key.pem", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.SetBasicAuth("listener", "secret")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Errorf("GET %s returned status %d, want %d", tt.path, rec.Code, tt.status)
+		}
+	}
+
+	// Song files need a login like every other page
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/data/songs/a/a.opus", nil))
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("expected a redirect to the login page, got status %d", rec.Code)
+	}
+}
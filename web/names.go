@@ -0,0 +1,214 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/music"
+
+	"github.com/gorilla/mux"
+)
+
+type namesPage struct {
+	Title string
+
+	Artists []nameGroup
+	Albums  []nameGroup
+
+	Aliases []store.NameAlias
+
+	Error string
+}
+
+type nameGroup struct {
+	store.NameGroup
+
+	Similarity string
+}
+
+func newNameGroups(groups []store.NameGroup) (res []nameGroup) {
+	for _, g := range groups {
+		res = append(res, nameGroup{
+			NameGroup:  g,
+			Similarity: fmt.Sprintf("%.0f%%", g.Similarity*100),
+		})
+	}
+	return
+}
+
+// HandleNames lists artists and albums with similar names and the remembered aliases
+func (s *server) HandleNames(w http.ResponseWriter, r *http.Request) (err error) {
+	return s.renderNames(w, r, "")
+}
+
+func (s *server) renderNames(w http.ResponseWriter, r *http.Request, errMessage string) (err error) {
+	artists, albums := s.m.SimilarNames()
+
+	if errMessage != "" {
+		w.WriteHeader(http.StatusBadRequest)
+	}
+
+	return s.renderTemplate(w, r, "names.html", namesPage{
+		Title:   "Artists & albums",
+		Artists: newNameGroups(artists),
+		Albums:  newNameGroups(albums),
+		Aliases: s.m.Aliases(),
+		Error:   errMessage,
+	})
+}
+
+// HandleMergeNames renames or merges artists or albums. The form contains the `artist` and `album` of every name in the group,
+// the indices of the ones that should be merged in `merge` and the index of the name to keep in `keep`.
+// If `new-artist` or `new-album` are set, they are used instead of the kept name
+func (s *server) HandleMergeNames(w http.ResponseWriter, r *http.Request) (err error) {
+	err = r.ParseForm()
+	if err != nil {
+		return
+	}
+
+	artists, albums := r.PostForm["artist"], r.PostForm["album"]
+	if len(albums) != len(artists) {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Every name needs an artist and an album",
+		}
+	}
+
+	name := func(index string) (a store.AlbumName, ok bool) {
+		i, err := strconv.Atoi(index)
+		if err != nil || i < 0 || i >= len(artists) {
+			return a, false
+		}
+		return store.AlbumName{Artist: artists[i], Album: albums[i]}, true
+	}
+
+	var names []store.AlbumName
+	for _, index := range r.PostForm["merge"] {
+		if n, ok := name(index); ok {
+			names = append(names, n)
+		}
+	}
+
+	keep, ok := name(r.FormValue("keep"))
+	if !ok && len(names) > 0 {
+		keep = names[0]
+	}
+	if a := r.FormValue("new-artist"); a != "" {
+		keep.Artist = a
+	}
+	if a := r.FormValue("new-album"); a != "" {
+		keep.Album = a
+	}
+
+	if r.FormValue("kind") == "album" {
+		_, err = s.m.MergeAlbums(names, keep.Artist, keep.Album)
+	} else {
+		var artistNames []string
+		for _, n := range names {
+			artistNames = append(artistNames, n.Artist)
+		}
+		_, err = s.m.MergeArtists(artistNames, keep.Artist)
+	}
+	if err != nil {
+		return s.renderNames(w, r, err.Error())
+	}
+
+	http.Redirect(w, r, "/names", http.StatusSeeOther)
+	return nil
+}
+
+// HandleRemoveAlias forgets the alias given by the `key` form value
+func (s *server) HandleRemoveAlias(w http.ResponseWriter, r *http.Request) (err error) {
+	err = s.m.RemoveAlias(r.FormValue("key"))
+	if err != nil {
+		return s.renderNames(w, r, err.Error())
+	}
+
+	http.Redirect(w, r, "/names", http.StatusSeeOther)
+	return nil
+}
+
+// HandleAPINames returns artists and albums with similar names and the remembered aliases
+func (s *server) HandleAPINames(w http.ResponseWriter, r *http.Request) (err error) {
+	artists, albums := s.m.SimilarNames()
+	if artists == nil {
+		artists = []store.NameGroup{}
+	}
+	if albums == nil {
+		albums = []store.NameGroup{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"artists": artists,
+		"albums":  albums,
+		"aliases": s.m.Aliases(),
+	})
+}
+
+// HandleAPIMergeNames renames artists, e.g. {"artists": ["Qeen", "QUEEN"], "artist": "Queen"}, or albums
+// like {"albums": [{"artist": "Queen", "album": "ANATO"}], "artist": "Queen", "album": "A Night at the Opera"}
+func (s *server) HandleAPIMergeNames(w http.ResponseWriter, r *http.Request) (err error) {
+	var req struct {
+		Artists []string          `json:"artists"`
+		Albums  []store.AlbumName `json:"albums"`
+
+		Artist string `json:"artist"`
+		Album  string `json:"album"`
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&req)
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid JSON body: " + err.Error(),
+		}
+	}
+
+	if (len(req.Artists) == 0) == (len(req.Albums) == 0) {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Need either artists or albums to rename",
+		}
+	}
+
+	var edited []music.Entry
+	if len(req.Albums) > 0 {
+		edited, err = s.m.MergeAlbums(req.Albums, req.Artist, req.Album)
+	} else {
+		edited, err = s.m.MergeArtists(req.Artists, req.Artist)
+	}
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+	if edited == nil {
+		edited = []music.Entry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"songs":   edited,
+		"aliases": s.m.Aliases(),
+	})
+}
+
+// HandleAPIRemoveAlias forgets an alias, songs that were already renamed keep their name
+func (s *server) HandleAPIRemoveAlias(w http.ResponseWriter, r *http.Request) (err error) {
+	err = s.m.RemoveAlias(mux.Vars(r)["key"])
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusNotFound,
+			Message:    err.Error(),
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
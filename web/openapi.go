@@ -0,0 +1,596 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/music"
+)
+
+// schema is a JSON schema object as used in OpenAPI 3.0 documents
+type schema map[string]interface{}
+
+func ref(name string) schema {
+	return schema{"$ref": "#/components/schemas/" + name}
+}
+
+func arrayOf(items schema) schema {
+	return schema{"type": "array", "items": items}
+}
+
+func typed(t string) schema {
+	return schema{"type": t}
+}
+
+// object returns the schema of a JSON object with the given properties, all of which are required
+func object(props map[string]schema) schema {
+	var required []string
+	for name := range props {
+		required = append(required, name)
+	}
+	sort.Strings(required)
+
+	return schema{"type": "object", "properties": props, "required": required}
+}
+
+// nullable marks `s` as nullable. References cannot have other keywords, so they are wrapped
+func nullable(s schema) schema {
+	if _, ok := s["$ref"]; ok {
+		return schema{"allOf": []schema{s}, "nullable": true}
+	}
+
+	n := schema{"nullable": true}
+	for k, v := range s {
+		n[k] = v
+	}
+	return n
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaGenerator creates schemas for Go types the way encoding/json encodes them
+type schemaGenerator struct {
+	// components maps types to their name in the components section. They are referenced instead of repeated
+	components map[reflect.Type]string
+
+	// optional makes all properties optional and pointers not nullable. It is used for request bodies
+	// where only the fields that are set are changed
+	optional bool
+}
+
+// schemaFor returns the schema of `t` or a reference to it if it is a component
+func (g schemaGenerator) schemaFor(t reflect.Type) schema {
+	if name, ok := g.components[t]; ok {
+		return ref(name)
+	}
+	return g.inline(t)
+}
+
+// inline returns the schema of `t` without referencing it
+func (g schemaGenerator) inline(t reflect.Type) schema {
+	if t == timeType {
+		return schema{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.schemaFor(t.Elem())
+	case reflect.Struct:
+		props := map[string]schema{}
+		var required []string
+		g.addFields(t, props, &required)
+
+		s := schema{"type": "object", "properties": props}
+		if len(required) > 0 {
+			sort.Strings(required)
+			s["required"] = required
+		}
+		return s
+	case reflect.Slice, reflect.Array:
+		return arrayOf(g.schemaFor(t.Elem()))
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case reflect.String:
+		return typed("string")
+	case reflect.Bool:
+		return typed("boolean")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return typed("integer")
+	case reflect.Float32, reflect.Float64:
+		return typed("number")
+	}
+
+	// Interfaces can be anything
+	return schema{}
+}
+
+// addFields adds the JSON properties of struct type `t` to `props`
+func (g schemaGenerator) addFields(t reflect.Type, props map[string]schema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		omitEmpty := strings.Contains(","+opts+",", ",omitempty,")
+
+		// Embedded structs without a name are flattened by encoding/json
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			g.addFields(f.Type, props, required)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+
+		s := g.schemaFor(f.Type)
+		switch f.Type.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map:
+			// These are encoded as null if they are nil
+			if !omitEmpty && !g.optional {
+				s = nullable(s)
+			}
+		}
+		props[name] = s
+
+		if !omitEmpty && !g.optional {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// apiOperation describes one method of an API route
+type apiOperation struct {
+	Method string
+	Path   string
+
+	ID      string
+	Summary string
+
+	// Query lists query parameters, path parameters are taken from the path
+	Query []apiParam
+
+	// Body is the schema of a JSON request body
+	Body schema
+	// Upload is the schema of the `file` field if the request can be a multipart form
+	Upload schema
+
+	// Responses maps status codes to the schema of their JSON body, nil means no body.
+	// All other status codes return an error
+	Responses map[int]schema
+}
+
+type apiParam struct {
+	Name        string
+	Description string
+	Schema      schema
+}
+
+// apiOperations returns all operations of the API. It must be kept in sync with the routes below /api/
+func apiOperations() []apiOperation {
+	var (
+		song       = object(map[string]schema{"song": ref("Entry")})
+		album      = object(map[string]schema{"artist": typed("string"), "title": typed("string"), "songs": arrayOf(ref("Entry"))})
+		playlist   = object(map[string]schema{"playlist": ref("Playlist"), "songs": arrayOf(ref("Entry"))})
+		queued     = object(map[string]schema{"queued": typed("boolean")})
+		rules      = object(map[string]schema{"rules": arrayOf(ref("RewriteRule"))})
+		file       = schema{"type": "string", "format": "binary"}
+		playlistRq = schema{
+			"type": "object",
+			"properties": map[string]schema{
+				"name":  typed("string"),
+				"sync":  typed("boolean"),
+				"songs": arrayOf(typed("string")),
+			},
+		}
+	)
+
+	search := object(map[string]schema{
+		"query":       typed("string"),
+		"interpreted": typed("string"),
+		"results":     arrayOf(object(map[string]schema{"title": typed("string"), "id": typed("string")})),
+	})
+	search["properties"].(map[string]schema)["error"] = typed("string")
+
+	return []apiOperation{
+		{
+			Method: http.MethodGet, Path: "/api/v1/openapi.json",
+			ID: "getOpenAPI", Summary: "This document",
+			Responses: map[int]schema{http.StatusOK: typed("object")},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/listing/{listing}",
+			ID: "getListing", Summary: "Songs grouped like on the website. Listings are title, artist, year, incomplete, unsynced, recentlyedited, trims and smart- followed by the ID of a smart playlist",
+			Responses: map[int]schema{http.StatusOK: arrayOf(ref("Group"))},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/search",
+			ID: "searchSongs", Summary: "Search songs, the query supports the same syntax as the search on the website",
+			Query: []apiParam{
+				{"q", "Search query", typed("string")},
+				{"limit", "Maximum number of results, defaults to 5", typed("integer")},
+			},
+			Responses: map[int]schema{http.StatusOK: search},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/song/{songID}",
+			ID: "getSong", Summary: "A song, related songs and the audio sources it had before its audio was replaced",
+			Query: []apiParam{{"similar", "Number of related songs, defaults to 5", typed("integer")}},
+			Responses: map[int]schema{http.StatusOK: object(map[string]schema{
+				"song":           ref("Entry"),
+				"similar":        arrayOf(ref("Entry")),
+				"source_history": arrayOf(ref("ReplacedSource")),
+			})},
+		},
+		{
+			Method: http.MethodPatch, Path: "/api/v1/song/{songID}",
+			ID: "editSong", Summary: "Change metadata, audio and sync settings of a song. Only fields that are set are changed, setting year, track, disc or rating to 0 clears them",
+			Body:      ref("SongPatch"),
+			Responses: map[int]schema{http.StatusOK: song},
+		},
+		{
+			Method: http.MethodDelete, Path: "/api/v1/song/{songID}",
+			ID: "deleteSong", Summary: "Delete a song and its files, only admins can do this",
+			Responses: map[int]schema{http.StatusNoContent: nil},
+		},
+		{
+			Method: http.MethodPut, Path: "/api/v1/song/{songID}/cover",
+			ID: "setSongCover", Summary: "Set the cover image of a song",
+			Upload:    file,
+			Responses: map[int]schema{http.StatusOK: song},
+		},
+		{
+			Method: http.MethodDelete, Path: "/api/v1/song/{songID}/cover",
+			ID: "deleteSongCover", Summary: "Remove the cover image of a song",
+			Responses: map[int]schema{http.StatusOK: song},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/song/{songID}/radio",
+			ID: "getRadio", Summary: "A queue of related songs that starts with this song",
+			Query: []apiParam{{"size", "Length of the queue, defaults to 25", typed("integer")}},
+			Responses: map[int]schema{http.StatusOK: object(map[string]schema{
+				"seed":  typed("string"),
+				"queue": arrayOf(ref("Entry")),
+			})},
+		},
+		{
+			Method: http.MethodPost, Path: "/api/v1/song/{songID}/replace",
+			ID: "replaceAudio", Summary: "Replace the audio of a song with an uploaded file or download it from a link in the background. Metadata, cover and playlists stay the same",
+			Body:   object(map[string]schema{"url": typed("string")}),
+			Upload: file,
+			Responses: map[int]schema{
+				http.StatusOK: object(map[string]schema{
+					"song":           ref("Entry"),
+					"source_history": arrayOf(ref("ReplacedSource")),
+				}),
+				http.StatusAccepted: queued,
+			},
+		},
+		{
+			Method: http.MethodPost, Path: "/api/v1/songs/bulk",
+			ID: "bulkEditSongs", Summary: "Change or delete several songs at once. Either all songs are changed or none of them. Previews return the changes without applying them, deleting is only allowed for admins",
+			Body: schema{
+				"type": "object",
+				"properties": map[string]schema{
+					"songs":   arrayOf(typed("string")),
+					"edit":    ref("BulkEdit"),
+					"preview": typed("boolean"),
+				},
+				"required": []string{"songs", "edit"},
+			},
+			Responses: map[int]schema{http.StatusOK: object(map[string]schema{
+				"changes": arrayOf(ref("BulkChange")),
+				"applied": typed("boolean"),
+			})},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/album/{artist}/{album}",
+			ID: "getAlbum", Summary: "An album and its songs",
+			Responses: map[int]schema{http.StatusOK: album},
+		},
+		{
+			Method: http.MethodPatch, Path: "/api/v1/album/{artist}/{album}",
+			ID: "renameAlbum", Summary: "Set artist and album name of all songs in an album",
+			Body:      object(map[string]schema{"artist": typed("string"), "title": typed("string")}),
+			Responses: map[int]schema{http.StatusOK: album},
+		},
+		{
+			Method: http.MethodPut, Path: "/api/v1/album/{artist}/{album}/cover",
+			ID: "setAlbumCover", Summary: "Set the cover image of all songs in an album",
+			Upload:    file,
+			Responses: map[int]schema{http.StatusOK: album},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/names",
+			ID: "getSimilarNames", Summary: "Artists and albums with similar names that can be merged and the remembered old spellings",
+			Responses: map[int]schema{http.StatusOK: object(map[string]schema{
+				"artists": arrayOf(ref("NameGroup")),
+				"albums":  arrayOf(ref("NameGroup")),
+				"aliases": arrayOf(ref("NameAlias")),
+			})},
+		},
+		{
+			Method: http.MethodPost, Path: "/api/v1/names/merge",
+			ID: "mergeNames", Summary: "Rename artists or albums in all songs. The old spellings are remembered, songs added later with them get the new name",
+			Body: schema{
+				"type": "object",
+				"properties": map[string]schema{
+					"artists": arrayOf(typed("string")),
+					"albums":  arrayOf(ref("AlbumName")),
+					"artist":  typed("string"),
+					"album":   typed("string"),
+				},
+				"required": []string{"artist"},
+			},
+			Responses: map[int]schema{http.StatusOK: object(map[string]schema{
+				"songs":   arrayOf(ref("Entry")),
+				"aliases": arrayOf(ref("NameAlias")),
+			})},
+		},
+		{
+			Method: http.MethodDelete, Path: "/api/v1/names/aliases/{key}",
+			ID: "deleteNameAlias", Summary: "Forget an old spelling, songs that were already renamed keep their name",
+			Responses: map[int]schema{http.StatusNoContent: nil},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/rules",
+			ID: "getRewriteRules", Summary: "The rules that change the metadata of new downloads, in the order they are applied",
+			Responses: map[int]schema{http.StatusOK: rules},
+		},
+		{
+			Method: http.MethodPut, Path: "/api/v1/rules",
+			ID: "setRewriteRules", Summary: "Replace all rewrite rules. Actions are replace, set, clear or set- and clear- followed by the field that is changed",
+			Body:      rules,
+			Responses: map[int]schema{http.StatusOK: rules},
+		},
+		{
+			Method: http.MethodPost, Path: "/api/v1/rules/apply",
+			ID: "applyRewriteRules", Summary: "Apply rewrite rules to existing songs. If no rules are given, the saved ones are used. Previews return the changes without applying them",
+			Body: schema{
+				"type": "object",
+				"properties": map[string]schema{
+					"rules":   arrayOf(ref("RewriteRule")),
+					"preview": typed("boolean"),
+				},
+			},
+			Responses: map[int]schema{http.StatusOK: object(map[string]schema{
+				"changes": arrayOf(ref("BulkChange")),
+				"applied": typed("boolean"),
+			})},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/downloads",
+			ID: "getDownloads", Summary: "The running download, the number of queued downloads and downloads that are probably duplicates",
+			Responses: map[int]schema{http.StatusOK: object(map[string]schema{
+				"running":    typed("boolean"),
+				"url":        typed("string"),
+				"queued":     typed("integer"),
+				"last_error": typed("string"),
+				"pending":    arrayOf(ref("PendingDownload")),
+			})},
+		},
+		{
+			Method: http.MethodPost, Path: "/api/v1/downloads",
+			ID: "enqueueDownload", Summary: "Add a link or search term to the download queue",
+			Body: schema{
+				"type":       "object",
+				"properties": map[string]schema{"url": typed("string"), "allow_duplicates": typed("boolean")},
+				"required":   []string{"url"},
+			},
+			Responses: map[int]schema{http.StatusAccepted: queued},
+		},
+		{
+			Method: http.MethodDelete, Path: "/api/v1/downloads/current",
+			ID: "abortDownload", Summary: "Abort the running download",
+			Responses: map[int]schema{http.StatusNoContent: nil},
+		},
+		{
+			Method: http.MethodPost, Path: "/api/v1/downloads/pending/{pendingID}",
+			ID: "addPendingDownload", Summary: "Add a download that is probably a duplicate without downloading it again",
+			Responses: map[int]schema{http.StatusAccepted: queued},
+		},
+		{
+			Method: http.MethodDelete, Path: "/api/v1/downloads/pending/{pendingID}",
+			ID: "discardPendingDownload", Summary: "Delete a download that is probably a duplicate",
+			Responses: map[int]schema{http.StatusNoContent: nil},
+		},
+		{
+			Method: http.MethodPost, Path: "/api/v1/import",
+			ID: "importSongs", Summary: "Import audio files. Metadata is read from their tags or file names",
+			Upload: arrayOf(file),
+			Responses: map[int]schema{
+				http.StatusCreated: ref("ImportResult"),
+				// Nothing could be imported
+				http.StatusUnprocessableEntity: ref("ImportResult"),
+			},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/playlists",
+			ID: "getPlaylists", Summary: "All playlists",
+			Responses: map[int]schema{http.StatusOK: arrayOf(ref("Playlist"))},
+		},
+		{
+			Method: http.MethodPost, Path: "/api/v1/playlists",
+			ID: "createPlaylist", Summary: "Create a playlist",
+			Body:      playlistRq,
+			Responses: map[int]schema{http.StatusCreated: playlist},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/playlist/{playlistID}",
+			ID: "getPlaylist", Summary: "A playlist and its songs",
+			Responses: map[int]schema{http.StatusOK: playlist},
+		},
+		{
+			Method: http.MethodPost, Path: "/api/v1/playlist/{playlistID}",
+			ID: "editPlaylist", Summary: "Change name or sync setting of a playlist",
+			Body:      playlistRq,
+			Responses: map[int]schema{http.StatusOK: playlist},
+		},
+		{
+			Method: http.MethodDelete, Path: "/api/v1/playlist/{playlistID}",
+			ID: "deletePlaylist", Summary: "Delete a playlist, its songs are kept",
+			Responses: map[int]schema{http.StatusNoContent: nil},
+		},
+		{
+			Method: http.MethodPost, Path: "/api/v1/playlist/{playlistID}/songs",
+			ID: "addPlaylistSongs", Summary: "Add songs to the end of a playlist",
+			Body:      playlistRq,
+			Responses: map[int]schema{http.StatusOK: playlist},
+		},
+		{
+			Method: http.MethodPut, Path: "/api/v1/playlist/{playlistID}/songs",
+			ID: "reorderPlaylistSongs", Summary: "Set the order of all songs in a playlist",
+			Body:      playlistRq,
+			Responses: map[int]schema{http.StatusOK: playlist},
+		},
+		{
+			Method: http.MethodDelete, Path: "/api/v1/playlist/{playlistID}/songs/{songID}",
+			ID: "removePlaylistSong", Summary: "Remove a song from a playlist",
+			Responses: map[int]schema{http.StatusOK: playlist},
+		},
+		{
+			Method: http.MethodGet, Path: "/api/v1/events/ws",
+			ID: "events", Summary: `Websocket that receives events like {"type": "song-edit", "data": {...}} when something changes`,
+			Responses: map[int]schema{http.StatusSwitchingProtocols: nil},
+		},
+	}
+}
+
+// pathParams returns the names of the variables in a route path like /song/{songID}
+func pathParams(path string) (names []string) {
+	for _, part := range strings.Split(path, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			name, _, _ := strings.Cut(part[1:len(part)-1], ":")
+			names = append(names, name)
+		}
+	}
+	return
+}
+
+func jsonContent(s schema) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": s},
+	}
+}
+
+// openAPIDocument returns the OpenAPI 3.0 description of the API
+func openAPIDocument() map[string]interface{} {
+	g := schemaGenerator{components: map[reflect.Type]string{
+		reflect.TypeOf(music.Entry{}):          "Entry",
+		reflect.TypeOf(store.Group{}):          "Group",
+		reflect.TypeOf(store.Playlist{}):       "Playlist",
+		reflect.TypeOf(store.ReplacedSource{}): "ReplacedSource",
+		reflect.TypeOf(store.BulkChange{}):     "BulkChange",
+		reflect.TypeOf(store.NameGroup{}):      "NameGroup",
+		reflect.TypeOf(store.NameAlias{}):      "NameAlias",
+		reflect.TypeOf(store.AlbumName{}):      "AlbumName",
+		reflect.TypeOf(store.RewriteRule{}):    "RewriteRule",
+		reflect.TypeOf(store.DuplicateError{}): "PendingDownload",
+	}}
+
+	schemas := map[string]schema{
+		"Error":     g.inline(reflect.TypeOf(apiError{})),
+		"SongPatch": schemaGenerator{optional: true}.inline(reflect.TypeOf(songPatch{})),
+		"BulkEdit":  schemaGenerator{optional: true}.inline(reflect.TypeOf(store.BulkEditData{})),
+		"ImportResult": object(map[string]schema{
+			"songs":  arrayOf(ref("Entry")),
+			"errors": arrayOf(object(map[string]schema{"file": typed("string"), "message": typed("string")})),
+		}),
+	}
+	for t, name := range g.components {
+		schemas[name] = g.inline(t)
+	}
+
+	paths := map[string]map[string]interface{}{}
+	for _, op := range apiOperations() {
+		var params []map[string]interface{}
+		for _, name := range pathParams(op.Path) {
+			params = append(params, map[string]interface{}{
+				"name": name, "in": "path", "required": true, "schema": typed("string"),
+			})
+		}
+		for _, q := range op.Query {
+			params = append(params, map[string]interface{}{
+				"name": q.Name, "in": "query", "description": q.Description, "schema": q.Schema,
+			})
+		}
+
+		responses := map[string]interface{}{
+			"default": map[string]interface{}{"$ref": "#/components/responses/Error"},
+		}
+		for status, s := range op.Responses {
+			resp := map[string]interface{}{"description": http.StatusText(status)}
+			if s != nil {
+				resp["content"] = jsonContent(s)
+			}
+			responses[strconv.Itoa(status)] = resp
+		}
+
+		operation := map[string]interface{}{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+			"responses":   responses,
+		}
+		if params != nil {
+			operation["parameters"] = params
+		}
+
+		content := map[string]interface{}{}
+		if op.Body != nil {
+			content = jsonContent(op.Body)
+		}
+		if op.Upload != nil {
+			content["multipart/form-data"] = map[string]interface{}{
+				"schema": object(map[string]schema{"file": op.Upload}),
+			}
+		}
+		if len(content) > 0 {
+			operation["requestBody"] = map[string]interface{}{"required": true, "content": content}
+		}
+
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]interface{}{}
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = operation
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "sensibleHub API",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"responses": map[string]interface{}{
+				"Error": map[string]interface{}{
+					"description": "Error",
+					"content":     jsonContent(ref("Error")),
+				},
+			},
+			"securitySchemes": map[string]interface{}{
+				"token": map[string]interface{}{"type": "http", "scheme": "bearer"},
+				"basic": map[string]interface{}{"type": "http", "scheme": "basic"},
+			},
+		},
+		"security": []map[string][]string{{"token": {}}, {"basic": {}}},
+	}
+}
+
+// HandleAPIOpenAPI returns the OpenAPI document that describes the API
+func (s *server) HandleAPIOpenAPI(w http.ResponseWriter, r *http.Request) (err error) {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(openAPIDocument())
+}
@@ -0,0 +1,387 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/store/music"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+// testServer returns a server with all routes and a manager with a few songs. Authentication is disabled
+func testServer(t *testing.T) *server {
+	t.Helper()
+
+	return testServerConfig(t, config.Config{})
+}
+
+// testServerConfig returns a server like testServer that uses `cfg`
+func testServerConfig(t *testing.T, cfg config.Config) *server {
+	t.Helper()
+
+	// The manager saves to the data directory
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	m, err := store.NewManager(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	year := 1975
+	m.SongsLock.Lock()
+	m.Songs["a"] = music.Entry{
+		ID:            "a",
+		SyncSettings:  music.SyncSettings{Should: true},
+		FileData:      music.FileData{Filename: "a.opus", Size: 4 << 20},
+		AudioSettings: music.AudioSettings{Start: -1, End: -1},
+		MusicData:     music.MusicData{Title: "Bohemian Rhapsody", Artist: "Queen", Album: "A Night at the Opera", Year: &year, Duration: 354},
+	}
+	m.Songs["b"] = music.Entry{
+		ID:            "b",
+		FileData:      music.FileData{Filename: "b.m4a", Size: 3 << 20},
+		AudioSettings: music.AudioSettings{Start: -1, End: -1},
+		AudioInfo:     &music.AudioInfo{Codec: "aac", Bitrate: 128},
+		MusicData:     music.MusicData{Title: "Love of My Life", Artist: "Queen", Album: "A Night at the Opera", Duration: 219},
+	}
+	m.SongsLock.Unlock()
+
+	s := &server{
+		m:                m,
+		router:           mux.NewRouter(),
+		sessions:         newSessionStore(),
+		connectedSockets: make(map[*websocket.Conn]chan struct{}),
+	}
+	s.router.StrictSlash(true)
+	s.routes(cfg)
+
+	return s
+}
+
+// testDocument returns the OpenAPI document like clients see it
+func testDocument(t *testing.T) (doc map[string]interface{}) {
+	t.Helper()
+
+	b, err := json.Marshal(openAPIDocument())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = json.Unmarshal(b, &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestOpenAPI_allRoutesDocumented(t *testing.T) {
+	s := testServer(t)
+	doc := testDocument(t)
+
+	paths := doc["paths"].(map[string]interface{})
+
+	var routes = map[string]bool{}
+	err := s.router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(tpl, "/api/") {
+			return nil
+		}
+
+		methods, err := route.GetMethods()
+		if err != nil {
+			// The websocket route accepts all methods, but is only opened with GET
+			methods = []string{http.MethodGet}
+		}
+
+		for _, method := range methods {
+			op := strings.ToLower(method) + " " + tpl
+			routes[op] = true
+
+			item, _ := paths[tpl].(map[string]interface{})
+			if _, ok := item[strings.ToLower(method)]; !ok {
+				t.Errorf("route %s is not documented", op)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for path, item := range paths {
+		for method := range item.(map[string]interface{}) {
+			if !routes[method+" "+path] {
+				t.Errorf("documented operation %s %s has no route", method, path)
+			}
+		}
+	}
+
+	for _, name := range []string{"Entry", "Group"} {
+		if _, ok := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})[name]; !ok {
+			t.Errorf("schema %s is missing", name)
+		}
+	}
+}
+
+func TestOpenAPI_responses(t *testing.T) {
+	s := testServer(t)
+	doc := testDocument(t)
+	h := s.authWrap(s.router)
+
+	p, err := s.m.CreatePlaylist("Favorites")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.m.AddToPlaylist(p.ID, "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only audio files can be imported
+	upload := "--b\r\nContent-Disposition: form-data; name=\"file\"; filename=\"notes.txt\"\r\n\r\nnot audio\r\n--b--\r\n"
+
+	tests := []struct {
+		method, path, body string
+
+		wantStatus int
+	}{
+		{"GET", "/api/v1/openapi.json", "", 200},
+		{"GET", "/api/v1/song/a", "", 200},
+		{"GET", "/api/v1/song/b?similar=1", "", 200},
+		{"GET", "/api/v1/song/missing", "", 404},
+		{"GET", "/api/v1/song/a?similar=-1", "", 400},
+		{"GET", "/api/v1/song/a/radio", "", 200},
+		{"PATCH", "/api/v1/song/a", `{"music_data": {"rating": 4, "year": 0}, "audio_settings": {"fade_in": 2}}`, 200},
+		{"PATCH", "/api/v1/song/a", `{"music_data": {"rating": 6}}`, 400},
+		{"PATCH", "/api/v1/song/a", `{"unknown": true}`, 400},
+		{"DELETE", "/api/v1/song/missing/cover", "", 404},
+		{"POST", "/api/v1/song/a/replace", `{"url": ""}`, 400},
+		{"POST", "/api/v1/songs/bulk", `{"songs": ["a", "b"], "edit": {"genre": "Rock", "replace": {"find": "(?i)^love", "with": "Loving", "regex": true}}, "preview": true}`, 200},
+		{"POST", "/api/v1/songs/bulk", `{"songs": ["a", "b"], "edit": {"year": 1975, "sync": false}}`, 200},
+		{"POST", "/api/v1/songs/bulk", `{"songs": ["a", "missing"], "edit": {"year": 1975}}`, 400},
+		{"GET", "/api/v1/names", "", 200},
+		{"POST", "/api/v1/names/merge", `{"artists": ["Queen"], "artist": "QUEEN"}`, 200},
+		{"POST", "/api/v1/names/merge", `{"albums": [{"artist": "QUEEN", "album": "A Night at the Opera"}], "artist": "Queen", "album": "A Night at the Opera"}`, 200},
+		{"POST", "/api/v1/names/merge", `{"artist": "Queen"}`, 400},
+		{"GET", "/api/v1/names", "", 200},
+		{"DELETE", "/api/v1/names/aliases/artist:queen", "", 204},
+		{"DELETE", "/api/v1/names/aliases/artist:queen", "", 404},
+		{"GET", "/api/v1/rules", "", 200},
+		{"POST", "/api/v1/rules/apply", `{"rules": [{"field": "title", "action": "replace", "pattern": "^love of", "value": "Loving"}], "preview": true}`, 200},
+		{"PUT", "/api/v1/rules", `{"rules": [{"field": "title", "action": "set-genre", "pattern": "rhapsody", "value": "Rock"}]}`, 200},
+		{"PUT", "/api/v1/rules", `{"rules": [{"field": "year", "action": "clear", "pattern": "1975"}]}`, 400},
+		{"POST", "/api/v1/rules/apply", `{}`, 200},
+		{"GET", "/api/v1/rules", "", 200},
+		{"GET", "/api/v1/listing/title", "", 200},
+		{"GET", "/api/v1/listing/artist", "", 200},
+		{"GET", "/api/v1/listing/year", "", 200},
+		{"GET", "/api/v1/listing/incomplete", "", 200},
+		{"GET", "/api/v1/listing/unsynced", "", 200},
+		{"GET", "/api/v1/listing/recentlyedited", "", 200},
+		{"GET", "/api/v1/listing/trims", "", 200},
+		{"GET", "/api/v1/listing/nothing", "", 404},
+		{"GET", "/api/v1/search?q=queen", "", 200},
+		{"GET", "/api/v1/search?q=year:", "", 200},
+		{"GET", "/api/v1/search", "", 400},
+		{"GET", "/api/v1/album/Queen/A%20Night%20at%20the%20Opera", "", 200},
+		{"GET", "/api/v1/album/Queen/Innuendo", "", 404},
+		{"PATCH", "/api/v1/album/Queen/A%20Night%20at%20the%20Opera", `{"artist": "Queen", "title": "A Night at the Opera (2011 Remaster)"}`, 200},
+		{"GET", "/api/v1/downloads", "", 200},
+		{"POST", "/api/v1/downloads", `{"url": " "}`, 400},
+		{"DELETE", "/api/v1/downloads/current", "", 409},
+		{"POST", "/api/v1/downloads/pending/none", "", 404},
+		{"DELETE", "/api/v1/downloads/pending/none", "", 404},
+		{"POST", "/api/v1/import", "", 400},
+		{"POST", "/api/v1/import", upload, 422},
+		{"GET", "/api/v1/playlists", "", 200},
+		{"POST", "/api/v1/playlists", `{"name": "Road trip", "songs": ["b"], "sync": true}`, 201},
+		{"GET", "/api/v1/playlist/" + p.ID, "", 200},
+		{"POST", "/api/v1/playlist/" + p.ID, `{"name": "Favorite songs"}`, 200},
+		{"PUT", "/api/v1/playlist/" + p.ID + "/songs", `{"songs": ["b", "a"]}`, 200},
+		{"DELETE", "/api/v1/playlist/" + p.ID + "/songs/a", "", 200},
+		{"DELETE", "/api/v1/playlist/" + p.ID, "", 204},
+		{"GET", "/api/v1/playlist/" + p.ID, "", 404},
+		{"DELETE", "/api/v1/song/b", "", 204},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if strings.HasPrefix(tt.body, "--b") {
+				req.Header.Set("Content-Type", "multipart/form-data; boundary=b")
+			} else if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+
+			var match mux.RouteMatch
+			if !s.router.Match(req, &match) {
+				t.Fatalf("no route matches")
+			}
+			tpl, _ := match.Route.GetPathTemplate()
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+
+			want, ok, err := responseSchema(doc, tpl, tt.method, rec.Code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok {
+				if rec.Body.Len() > 0 {
+					t.Errorf("got a body for a response without content: %s", rec.Body.String())
+				}
+				return
+			}
+
+			if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Errorf("got content type %q, want JSON", ct)
+			}
+
+			var body interface{}
+			err = json.Unmarshal(rec.Body.Bytes(), &body)
+			if err != nil {
+				t.Fatalf("invalid JSON: %s", err.Error())
+			}
+
+			for _, err := range validate(doc, want, body, "body") {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// responseSchema returns the JSON schema of the documented response, ok is false if it has no content
+func responseSchema(doc map[string]interface{}, path, method string, status int) (s map[string]interface{}, ok bool, err error) {
+	item, _ := doc["paths"].(map[string]interface{})[path].(map[string]interface{})
+	op, found := item[strings.ToLower(method)].(map[string]interface{})
+	if !found {
+		return nil, false, fmt.Errorf("%s %s is not documented", method, path)
+	}
+	responses := op["responses"].(map[string]interface{})
+
+	resp, found := responses[strconv.Itoa(status)].(map[string]interface{})
+	if !found {
+		resp = resolve(doc, responses["default"].(map[string]interface{}))
+	}
+
+	content, ok := resp["content"].(map[string]interface{})
+	if !ok {
+		return nil, false, nil
+	}
+
+	return content["application/json"].(map[string]interface{})["schema"].(map[string]interface{}), true, nil
+}
+
+// resolve follows `$ref` to the schema or response it points to
+func resolve(doc map[string]interface{}, s map[string]interface{}) map[string]interface{} {
+	r, ok := s["$ref"].(string)
+	if !ok {
+		return s
+	}
+
+	var cur interface{} = doc
+	for _, part := range strings.Split(strings.TrimPrefix(r, "#/"), "/") {
+		cur = cur.(map[string]interface{})[part]
+	}
+	return resolve(doc, cur.(map[string]interface{}))
+}
+
+// validate returns all differences between `v` and the schema `s`. It supports the keywords used in our
+// document. Properties that are not in the schema are reported too, so new fields cannot go undocumented
+func validate(doc map[string]interface{}, s map[string]interface{}, v interface{}, at string) (errs []error) {
+	s = resolve(doc, s)
+
+	if v == nil {
+		if s["nullable"] != true {
+			errs = append(errs, fmt.Errorf("%s: got null, but it isn't nullable", at))
+		}
+		return
+	}
+
+	if all, ok := s["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			errs = append(errs, validate(doc, sub.(map[string]interface{}), v, at)...)
+		}
+	}
+
+	switch s["type"] {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return append(errs, fmt.Errorf("%s: got %T, want object", at, v))
+		}
+
+		props, _ := s["properties"].(map[string]interface{})
+		required, _ := s["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := obj[name.(string)]; !ok {
+				errs = append(errs, fmt.Errorf("%s: required property %q is missing", at, name))
+			}
+		}
+
+		additional, _ := s["additionalProperties"].(map[string]interface{})
+
+		var names []string
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if ps, ok := props[name]; ok {
+				errs = append(errs, validate(doc, ps.(map[string]interface{}), obj[name], at+"."+name)...)
+			} else if additional != nil {
+				errs = append(errs, validate(doc, additional, obj[name], at+"."+name)...)
+			} else if props != nil {
+				errs = append(errs, fmt.Errorf("%s: property %q is not documented", at, name))
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return append(errs, fmt.Errorf("%s: got %T, want array", at, v))
+		}
+		for i, item := range arr {
+			errs = append(errs, validate(doc, s["items"].(map[string]interface{}), item, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	case "string":
+		if _, ok := v.(string); !ok {
+			errs = append(errs, fmt.Errorf("%s: got %T, want string", at, v))
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			errs = append(errs, fmt.Errorf("%s: got %T, want boolean", at, v))
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			errs = append(errs, fmt.Errorf("%s: got %T, want number", at, v))
+		}
+	case "integer":
+		if f, ok := v.(float64); !ok || f != math.Trunc(f) {
+			errs = append(errs, fmt.Errorf("%s: got %v, want integer", at, v))
+		}
+	}
+
+	return
+}
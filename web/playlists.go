@@ -0,0 +1,364 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/music"
+
+	"github.com/gorilla/mux"
+)
+
+type playlistsPage struct {
+	Title string
+
+	Playlists []store.Playlist
+}
+
+// HandlePlaylistListing shows all playlists and a form for creating a new one
+func (s *server) HandlePlaylistListing(w http.ResponseWriter, r *http.Request) (err error) {
+	return s.renderTemplate(w, r, "playlists.html", playlistsPage{
+		Title:     "Playlists",
+		Playlists: s.m.AllPlaylists(),
+	})
+}
+
+// HandleCreatePlaylist creates a playlist with the name from the form and redirects to it
+func (s *server) HandleCreatePlaylist(w http.ResponseWriter, r *http.Request) (err error) {
+	p, err := s.m.CreatePlaylist(r.FormValue("playlist-name"))
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	http.Redirect(w, r, "/playlist/"+p.ID, http.StatusSeeOther)
+	return nil
+}
+
+type playlistPage struct {
+	Title string
+
+	Playlist store.Playlist
+	Songs    []music.Entry
+
+	// Profiles are the names of all transcoding profiles, they can be used for exports
+	Profiles []string
+}
+
+// playlistFromURL returns the playlist identified by the `playlistID` URL variable
+func (s *server) playlistFromURL(r *http.Request) (p store.Playlist, err error) {
+	v := mux.Vars(r)
+	if v == nil || v["playlistID"] == "" {
+		return p, httpError{
+			StatusCode: http.StatusPreconditionFailed,
+			Message:    "Need a playlist ID",
+		}
+	}
+
+	p, ok := s.m.GetPlaylist(v["playlistID"])
+	if !ok {
+		return p, httpError{
+			StatusCode: http.StatusNotFound,
+			Message:    "Playlist not found",
+		}
+	}
+
+	return p, nil
+}
+
+// HandleShowPlaylist shows the songs of a playlist
+func (s *server) HandleShowPlaylist(w http.ResponseWriter, r *http.Request) (err error) {
+	p, err := s.playlistFromURL(r)
+	if err != nil {
+		return
+	}
+
+	var profiles []string
+	for name := range s.m.GetConfig().Profiles {
+		profiles = append(profiles, name)
+	}
+
+	return s.renderTemplate(w, r, "playlist.html", playlistPage{
+		Title:    p.Name,
+		Playlist: p,
+		Songs:    s.m.PlaylistEntries(p),
+		Profiles: profiles,
+	})
+}
+
+// HandleEditPlaylist handles the forms on the playlist page: renaming, changing the sync setting,
+// removing a song and deleting the playlist
+func (s *server) HandleEditPlaylist(w http.ResponseWriter, r *http.Request) (err error) {
+	p, err := s.playlistFromURL(r)
+	if err != nil {
+		return
+	}
+
+	isAjax := r.Header.Get("X-XHR") == "true"
+
+	redirect := r.URL.String()
+
+	switch {
+	case r.FormValue("delete") == "delete":
+		err = s.m.DeletePlaylist(p.ID)
+		redirect = "/playlists"
+	case r.FormValue("remove") != "":
+		err = s.m.RemoveFromPlaylist(p.ID, r.FormValue("remove"))
+	default:
+		err = s.m.RenamePlaylist(p.ID, r.FormValue("playlist-name"))
+		if err == nil {
+			err = s.m.SetPlaylistSync(p.ID, r.FormValue("should-sync") == "on")
+		}
+	}
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	if isAjax {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"message": "Updated"}`, http.StatusOK)
+		return nil
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+	return nil
+}
+
+// HandleExportPlaylist exports a playlist in the format given in the URL, which is either "m3u8" or "xspf".
+// Songs link to their original audio or, if the `profile` URL parameter is set, to the transcoded file
+func (s *server) HandleExportPlaylist(w http.ResponseWriter, r *http.Request) (err error) {
+	p, err := s.playlistFromURL(r)
+	if err != nil {
+		return
+	}
+
+	profile := r.URL.Query().Get("profile")
+	if profile != "" {
+		cfg := s.m.GetConfig()
+		if _, ok := cfg.Profile(profile); !ok {
+			return httpError{
+				StatusCode: http.StatusNotFound,
+				Message:    "Profile not found",
+			}
+		}
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	base := scheme + "://" + r.Host
+
+	location := func(e music.Entry) string {
+		if profile != "" {
+			return base + "/song/" + e.ID + "/file?profile=" + url.QueryEscape(profile)
+		}
+		return base + "/song/" + e.ID + "/audio"
+	}
+
+	entries := s.m.PlaylistEntries(p)
+
+	format := mux.Vars(r)["format"]
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", store.CleanName(p.Name)+"."+format))
+
+	switch format {
+	case "m3u8":
+		w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+		return store.WriteM3U8(w, p, entries, location)
+	case "xspf":
+		w.Header().Set("Content-Type", "application/xspf+xml")
+		return store.WriteXSPF(w, p, entries, location, func(e music.Entry) string {
+			if e.PictureData.Filename == "" {
+				return ""
+			}
+			return base + "/song/" + e.ID + "/cover"
+		})
+	default:
+		return httpError{
+			StatusCode: http.StatusNotFound,
+			Message:    "Unknown playlist format",
+		}
+	}
+}
+
+// HandleAPIPlaylists lists all playlists
+func (s *server) HandleAPIPlaylists(w http.ResponseWriter, r *http.Request) (err error) {
+	list := s.m.AllPlaylists()
+	if list == nil {
+		list = []store.Playlist{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(list)
+}
+
+// playlistRequest is the body of API requests that change playlists. Only the fields that are set are changed
+type playlistRequest struct {
+	Name *string `json:"name"`
+	Sync *bool   `json:"sync"`
+
+	Songs []string `json:"songs"`
+}
+
+func decodePlaylistRequest(r *http.Request) (req playlistRequest, err error) {
+	err = json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20)).Decode(&req)
+	if err != nil {
+		return req, httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid JSON body: " + err.Error(),
+		}
+	}
+	return
+}
+
+// HandleAPICreatePlaylist creates a playlist with the name and songs from the request body
+func (s *server) HandleAPICreatePlaylist(w http.ResponseWriter, r *http.Request) (err error) {
+	req, err := decodePlaylistRequest(r)
+	if err != nil {
+		return
+	}
+
+	var name string
+	if req.Name != nil {
+		name = *req.Name
+	}
+
+	p, err := s.m.CreatePlaylist(name)
+	if err == nil && len(req.Songs) > 0 {
+		err = s.m.AddToPlaylist(p.ID, req.Songs...)
+	}
+	if err == nil && req.Sync != nil {
+		err = s.m.SetPlaylistSync(p.ID, *req.Sync)
+	}
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	return s.writeAPIPlaylist(w, p.ID, http.StatusCreated)
+}
+
+// HandleAPIPlaylist returns a playlist and its songs
+func (s *server) HandleAPIPlaylist(w http.ResponseWriter, r *http.Request) (err error) {
+	p, err := s.playlistFromURL(r)
+	if err != nil {
+		return
+	}
+
+	return s.writeAPIPlaylist(w, p.ID, http.StatusOK)
+}
+
+// writeAPIPlaylist writes the playlist with the given ID and its songs as JSON
+func (s *server) writeAPIPlaylist(w http.ResponseWriter, id string, status int) error {
+	p, _ := s.m.GetPlaylist(id)
+
+	songs := s.m.PlaylistEntries(p)
+	if songs == nil {
+		songs = []music.Entry{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"playlist": p,
+		"songs":    songs,
+	})
+}
+
+// HandleAPIEditPlaylist changes the name or sync setting of a playlist
+func (s *server) HandleAPIEditPlaylist(w http.ResponseWriter, r *http.Request) (err error) {
+	p, err := s.playlistFromURL(r)
+	if err != nil {
+		return
+	}
+
+	req, err := decodePlaylistRequest(r)
+	if err != nil {
+		return
+	}
+
+	if req.Name != nil {
+		err = s.m.RenamePlaylist(p.ID, *req.Name)
+	}
+	if err == nil && req.Sync != nil {
+		err = s.m.SetPlaylistSync(p.ID, *req.Sync)
+	}
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	return s.writeAPIPlaylist(w, p.ID, http.StatusOK)
+}
+
+// HandleAPIDeletePlaylist deletes a playlist
+func (s *server) HandleAPIDeletePlaylist(w http.ResponseWriter, r *http.Request) (err error) {
+	p, err := s.playlistFromURL(r)
+	if err != nil {
+		return
+	}
+
+	err = s.m.DeletePlaylist(p.ID)
+	if err != nil {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// HandleAPIPlaylistSongs adds songs to a playlist (POST) or sets the order of all songs in it (PUT)
+func (s *server) HandleAPIPlaylistSongs(w http.ResponseWriter, r *http.Request) (err error) {
+	p, err := s.playlistFromURL(r)
+	if err != nil {
+		return
+	}
+
+	req, err := decodePlaylistRequest(r)
+	if err != nil {
+		return
+	}
+
+	if r.Method == http.MethodPut {
+		err = s.m.ReorderPlaylist(p.ID, req.Songs)
+	} else {
+		err = s.m.AddToPlaylist(p.ID, req.Songs...)
+	}
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	return s.writeAPIPlaylist(w, p.ID, http.StatusOK)
+}
+
+// HandleAPIRemovePlaylistSong removes the song given in the URL from a playlist
+func (s *server) HandleAPIRemovePlaylistSong(w http.ResponseWriter, r *http.Request) (err error) {
+	p, err := s.playlistFromURL(r)
+	if err != nil {
+		return
+	}
+
+	err = s.m.RemoveFromPlaylist(p.ID, mux.Vars(r)["songID"])
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusNotFound,
+			Message:    err.Error(),
+		}
+	}
+
+	return s.writeAPIPlaylist(w, p.ID, http.StatusOK)
+}
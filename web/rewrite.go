@@ -0,0 +1,156 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"xarantolus/sensibleHub/store"
+)
+
+type rulesPage struct {
+	Title string
+
+	// RulesText contains the rules from the form, or the saved rules if the form wasn't sent yet
+	RulesText string
+
+	Preview   []store.BulkChange
+	Previewed bool
+
+	// Applied is the number of existing songs that were changed
+	Applied int
+	Saved   bool
+
+	Error string
+}
+
+// HandleRewriteRules shows the rules that change the metadata of new downloads
+func (s *server) HandleRewriteRules(w http.ResponseWriter, r *http.Request) (err error) {
+	return s.renderTemplate(w, r, "rules.html", rulesPage{
+		Title:     "Rewrite rules",
+		RulesText: store.RewriteRulesText(s.m.RewriteRules()),
+	})
+}
+
+// HandleEditRewriteRules saves the rules from the form. The `action` value is "save", "preview" to show what the rules
+// would change in existing songs without saving them, or "apply" to save them and change existing songs
+func (s *server) HandleEditRewriteRules(w http.ResponseWriter, r *http.Request) (err error) {
+	page := rulesPage{
+		Title:     "Rewrite rules",
+		RulesText: r.FormValue("rules"),
+	}
+
+	rules, err := store.ParseRewriteRules(page.RulesText)
+	if err == nil {
+		switch r.FormValue("action") {
+		case "preview":
+			page.Preview, err = s.m.PreviewRewriteRules(rules)
+			page.Previewed = err == nil
+		case "save", "apply":
+			err = s.m.SetRewriteRules(rules)
+			page.Saved = err == nil
+			if err == nil && r.FormValue("action") == "apply" {
+				var changes []store.BulkChange
+				changes, err = s.m.ApplyRewriteRules(rules)
+				page.Applied = len(changes)
+			}
+		default:
+			return httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "Invalid action",
+			}
+		}
+	}
+
+	if err != nil {
+		page.Error = err.Error()
+		w.WriteHeader(http.StatusBadRequest)
+	} else if page.Saved {
+		page.RulesText = store.RewriteRulesText(rules)
+	}
+
+	return s.renderTemplate(w, r, "rules.html", page)
+}
+
+// HandleAPIRewriteRules returns the rules that change the metadata of new downloads
+func (s *server) HandleAPIRewriteRules(w http.ResponseWriter, r *http.Request) (err error) {
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"rules": s.m.RewriteRules(),
+	})
+}
+
+// HandleAPISetRewriteRules replaces all rewrite rules with the ones in the body, e.g.
+// {"rules": [{"field": "title", "action": "replace", "pattern": "\\s*\\(Official Video\\)"}]}
+func (s *server) HandleAPISetRewriteRules(w http.ResponseWriter, r *http.Request) (err error) {
+	var req struct {
+		Rules []store.RewriteRule `json:"rules"`
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&req)
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid JSON body: " + err.Error(),
+		}
+	}
+
+	if req.Rules == nil {
+		req.Rules = []store.RewriteRule{}
+	}
+
+	err = s.m.SetRewriteRules(req.Rules)
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	return s.HandleAPIRewriteRules(w, r)
+}
+
+// HandleAPIApplyRewriteRules applies rewrite rules to existing songs. The body is like {"rules": [...], "preview": true},
+// if no rules are given the saved ones are used. Previews return the changes without applying them
+func (s *server) HandleAPIApplyRewriteRules(w http.ResponseWriter, r *http.Request) (err error) {
+	var req struct {
+		Rules   []store.RewriteRule `json:"rules"`
+		Preview bool                `json:"preview"`
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(&req)
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid JSON body: " + err.Error(),
+		}
+	}
+
+	if req.Rules == nil {
+		req.Rules = s.m.RewriteRules()
+	}
+
+	var changes []store.BulkChange
+	if req.Preview {
+		changes, err = s.m.PreviewRewriteRules(req.Rules)
+	} else {
+		changes, err = s.m.ApplyRewriteRules(req.Rules)
+	}
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+	if changes == nil {
+		changes = []store.BulkChange{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"changes": changes,
+		"applied": !req.Preview,
+	})
+}
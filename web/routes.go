@@ -1,7 +1,10 @@
 package web
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"xarantolus/sensibleHub/store"
 	"xarantolus/sensibleHub/store/music"
 )
 
@@ -30,9 +33,20 @@ type newPage struct {
 	Running     bool
 	DownloadURL string
 
+	// Pending are downloads that are probably duplicates and wait for the user to add or discard them
+	Pending []pendingDownload
+
 	NewestSong *music.Entry
 }
 
+// pendingDownload is a download that sounds like the existing song `Song`
+type pendingDownload struct {
+	ID         string
+	URL        string
+	Song       music.Entry
+	Similarity string
+}
+
 // HandleAddSong displays the form for adding a song
 func (s *server) HandleAddSong(w http.ResponseWriter, r *http.Request) (err error) {
 	dl, okr := s.m.IsDownloading()
@@ -43,9 +57,27 @@ func (s *server) HandleAddSong(w http.ResponseWriter, r *http.Request) (err erro
 		nsp = &ns
 	}
 
+	// Duplicates are listed with the other pending downloads, where they can be added anyway
+	lastErr := s.m.LastError()
+	var dup *store.DuplicateError
+	if errors.As(lastErr, &dup) {
+		lastErr = nil
+	}
+
+	var pending []pendingDownload
+	for _, p := range s.m.PendingDownloads() {
+		pending = append(pending, pendingDownload{
+			ID:         p.PendingID,
+			URL:        p.URL,
+			Song:       p.Song,
+			Similarity: fmt.Sprintf("%.0f%%", p.Similarity*100),
+		})
+	}
+
 	return s.renderTemplate(w, r, "add.html", newPage{
 		Title:       "Add a new song",
-		LastError:   s.m.LastError(),
+		LastError:   lastErr,
+		Pending:     pending,
 		Running:     okr,
 		DownloadURL: dl,
 		NewestSong:  nsp,
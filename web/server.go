@@ -10,6 +10,8 @@ import (
 	"sync"
 	"xarantolus/sensibleHub/store"
 	"xarantolus/sensibleHub/store/config"
+	"xarantolus/sensibleHub/subsonic"
+	"xarantolus/sensibleHub/upnp"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
@@ -26,6 +28,8 @@ type server struct {
 
 	router *mux.Router
 
+	sessions *sessionStore
+
 	connectedSocketsLock sync.Mutex
 	connectedSockets     map[*websocket.Conn]chan struct{}
 }
@@ -44,6 +48,7 @@ func RunServer(manager *store.Manager, cfg config.Config, assetFS, templateFS fs
 		templateFS: templateFS,
 
 		router:           r,
+		sessions:         newSessionStore(),
 		connectedSockets: make(map[*websocket.Conn]chan struct{}),
 	}
 
@@ -60,65 +65,172 @@ func RunServer(manager *store.Manager, cfg config.Config, assetFS, templateFS fs
 
 	manager.SetEventFunc(server.AllSockets)
 
-	// set up the file server that serves our data directory
-	r.PathPrefix("/data/").Handler(http.StripPrefix("/data/", http.FileServer(http.Dir("data")))).Methods(http.MethodGet)
-
 	// serve static assets and a favicon
 	r.PathPrefix("/assets/").Handler(http.FileServer(http.FS(assetFS))).Methods(http.MethodGet)
 	r.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "assets/fav/favicon.ico", http.StatusMovedPermanently)
 	}).Methods(http.MethodGet)
 
+	server.routes(cfg)
+
+	if !cfg.WebAuthEnabled() {
+		log.Println("[Web] No web users are configured, everyone who can reach the server can change and delete songs")
+	}
+
+	log.Printf("[Web] Server listening on port %d\n", cfg.Port)
+	return http.ListenAndServe(":"+strconv.Itoa(cfg.Port), server.authWrap(r))
+}
+
+// routes registers all pages and API endpoints. Every route below /api/ must be described in openapi.go
+func (s *server) routes(cfg config.Config) {
+	// Login form, all other pages redirect here if the user isn't logged in
+	s.route("/login", s.HandleLoginPage).Methods(http.MethodGet)
+	s.route("/login", s.HandleLogin).Methods(http.MethodPost)
+	s.route("/logout", s.HandleLogout).Methods(http.MethodPost)
+
+	// The logged in user and their API tokens
+	s.route("/account", s.HandleAccount).Methods(http.MethodGet)
+	s.route("/account", s.HandleEditAccount).Methods(http.MethodPost)
+
 	// Index page
-	server.route("/", server.HandleIndex).Methods(http.MethodGet)
+	s.route("/", s.HandleIndex).Methods(http.MethodGet)
 
 	// Song submit form
-	server.route("/add", server.HandleAddSong).Methods(http.MethodGet)
-	server.route("/add", server.HandleDownloadSong).Methods(http.MethodPost)
+	s.route("/add", s.HandleAddSong).Methods(http.MethodGet)
+	s.route("/add", s.HandleDownloadSong).Methods(http.MethodPost)
 
-	server.route("/abort", server.HandleAbortDownload).Methods(http.MethodPost)
+	s.route("/add/pending/{pendingID}", s.HandleResolvePending).Methods(http.MethodPost)
+
+	s.route("/abort", s.HandleAbortDownload).Methods(http.MethodPost)
 
 	// Song listings
-	server.route("/songs", server.HandleTitleListing).Methods(http.MethodGet)
-	server.route("/artists", server.HandleArtistListing).Methods(http.MethodGet)
-	server.route("/years", server.HandleYearListing).Methods(http.MethodGet)
-	server.route("/incomplete", server.HandleIncompleteListing).Methods(http.MethodGet)
-	server.route("/unsynced", server.HandleUnsyncedListing).Methods(http.MethodGet)
-	server.route("/edits", server.HandleRecentlyEditedListing).Methods(http.MethodGet)
-	server.route("/added", server.HandleSortedByAddDateListing).Methods(http.MethodGet)
+	s.route("/songs", s.HandleTitleListing).Methods(http.MethodGet)
+	s.route("/artists", s.HandleArtistListing).Methods(http.MethodGet)
+	s.route("/years", s.HandleYearListing).Methods(http.MethodGet)
+	s.route("/incomplete", s.HandleIncompleteListing).Methods(http.MethodGet)
+	s.route("/quality", s.HandleQualityListing).Methods(http.MethodGet)
+	s.route("/unsynced", s.HandleUnsyncedListing).Methods(http.MethodGet)
+	s.route("/edits", s.HandleRecentlyEditedListing).Methods(http.MethodGet)
+	s.route("/added", s.HandleSortedByAddDateListing).Methods(http.MethodGet)
+	s.route("/trims", s.HandleTrimListing).Methods(http.MethodGet)
+	s.route("/trims", s.HandleApplyTrims).Methods(http.MethodPost)
+	s.route("/devices", s.HandleDeviceListing).Methods(http.MethodGet)
+	s.route("/duplicates", s.HandleDuplicates).Methods(http.MethodGet)
+	s.route("/duplicates/merge", s.HandleMergeDuplicates).Methods(http.MethodPost)
+
+	// Editing several songs at once
+	s.route("/bulk", s.HandleBulkEdit).Methods(http.MethodGet)
+	s.route("/bulk", s.HandleApplyBulkEdit).Methods(http.MethodPost)
+
+	// Merging artists and albums with similar names
+	s.route("/names", s.HandleNames).Methods(http.MethodGet)
+	s.route("/names", s.HandleMergeNames).Methods(http.MethodPost)
+	s.route("/names/aliases", s.HandleRemoveAlias).Methods(http.MethodPost)
+
+	// Rules that change the metadata of new downloads
+	s.route("/rules", s.HandleRewriteRules).Methods(http.MethodGet)
+	s.route("/rules", s.HandleEditRewriteRules).Methods(http.MethodPost)
 
 	// Search listing
-	server.route("/search", server.HandleSearchListing).Methods(http.MethodGet)
+	s.route("/search", s.HandleSearchListing).Methods(http.MethodGet)
 	// Search API for search suggestions
 
 	// Song html page and handler for editing
-	server.route("/song/{songID}", server.HandleShowSong).Methods(http.MethodGet)
-	server.route("/song/{songID}", server.HandleEditSong).Methods(http.MethodPost)
+	s.route("/song/{songID}", s.HandleShowSong).Methods(http.MethodGet)
+	s.route("/song/{songID}", s.HandleEditSong).Methods(http.MethodPost)
+	s.route("/song/{songID}/replace", s.HandleReplaceAudio).Methods(http.MethodPost)
 
 	// Song Data retrieval
-	server.route("/song/{songID}/cover", server.HandleCover).Methods(http.MethodGet)
-	server.route("/song/{songID}/audio", server.HandleAudio).Methods(http.MethodGet)
-	server.route("/song/{songID}/mp3", server.HandleMP3).Methods(http.MethodGet)
+	s.route("/song/{songID}/cover", s.HandleCover).Methods(http.MethodGet, http.MethodHead)
+	s.route("/song/{songID}/audio", s.HandleAudio).Methods(http.MethodGet, http.MethodHead)
+	s.route("/song/{songID}/mp3", s.HandleMP3).Methods(http.MethodGet, http.MethodHead)
+	s.route("/song/{songID}/file", s.HandleFile).Methods(http.MethodGet, http.MethodHead)
+
+	// Audio and cover files in the data directory, everything else in there stays private
+	s.route("/data/songs/{songID}/{file}", s.HandleDataFile).Methods(http.MethodGet, http.MethodHead)
 
 	// Redirects to a random song
-	server.route("/songs/random", server.HandleRandomSong).Methods(http.MethodGet)
+	s.route("/songs/random", s.HandleRandomSong).Methods(http.MethodGet)
 
 	// Album listing
-	server.route("/album/{artist}/{album}", server.HandleShowAlbum).Methods(http.MethodGet)
-	server.route("/album/{artist}/{album}", server.HandleEditAlbum).Methods(http.MethodPost)
+	s.route("/album/{artist}/{album}", s.HandleShowAlbum).Methods(http.MethodGet)
+	s.route("/album/{artist}/{album}", s.HandleEditAlbum).Methods(http.MethodPost)
+
+	// Playlists
+	s.route("/playlists", s.HandlePlaylistListing).Methods(http.MethodGet)
+	s.route("/playlists", s.HandleCreatePlaylist).Methods(http.MethodPost)
+	s.route("/playlist/{playlistID}", s.HandleShowPlaylist).Methods(http.MethodGet)
+	s.route("/playlist/{playlistID}", s.HandleEditPlaylist).Methods(http.MethodPost)
+	s.route("/playlist/{playlistID}/{format:m3u8|xspf}", s.HandleExportPlaylist).Methods(http.MethodGet)
+
+	// Smart playlists
+	s.route("/smart", s.HandleSmartListing).Methods(http.MethodGet)
+	s.route("/smart", s.HandleCreateSmart).Methods(http.MethodPost)
+	s.route("/smart/{smartID}", s.HandleShowSmart).Methods(http.MethodGet)
+	s.route("/smart/{smartID}", s.HandleEditSmart).Methods(http.MethodPost)
 
 	// Artist listing
-	server.route("/artist/{artist}", server.HandleShowArtist).Methods(http.MethodGet)
+	s.route("/artist/{artist}", s.HandleShowArtist).Methods(http.MethodGet)
 
 	// API
-	server.route("/api/v1/listing/{listing}", server.HandleAPIListing).Methods(http.MethodGet)
-	server.route("/api/v1/song/{songID}", server.HandleAPISong).Methods(http.MethodGet)
-
-	server.route("/api/v1/search", server.HandleAPISongSearch).Methods(http.MethodGet)
-	server.route("/api/v1/events/ws", server.HandleWebsocket)
-
-	log.Printf("[Web] Server listening on port %d\n", cfg.Port)
-	return http.ListenAndServe(":"+strconv.Itoa(cfg.Port), r)
+	s.route("/api/v1/listing/{listing}", s.HandleAPIListing).Methods(http.MethodGet)
+	s.route("/api/v1/song/{songID}", s.HandleAPISong).Methods(http.MethodGet)
+	s.route("/api/v1/song/{songID}", s.HandleAPIEditSong).Methods(http.MethodPatch)
+	s.route("/api/v1/song/{songID}", s.HandleAPIDeleteSong).Methods(http.MethodDelete)
+	s.route("/api/v1/song/{songID}/cover", s.HandleAPISongCover).Methods(http.MethodPut, http.MethodDelete)
+	s.route("/api/v1/song/{songID}/radio", s.HandleAPIRadio).Methods(http.MethodGet)
+	s.route("/api/v1/song/{songID}/replace", s.HandleAPIReplaceAudio).Methods(http.MethodPost)
+
+	s.route("/api/v1/songs/bulk", s.HandleAPIBulkEdit).Methods(http.MethodPost)
+
+	s.route("/api/v1/album/{artist}/{album}", s.HandleAPIAlbum).Methods(http.MethodGet)
+	s.route("/api/v1/album/{artist}/{album}", s.HandleAPIRenameAlbum).Methods(http.MethodPatch)
+	s.route("/api/v1/album/{artist}/{album}/cover", s.HandleAPIAlbumCover).Methods(http.MethodPut)
+
+	s.route("/api/v1/names", s.HandleAPINames).Methods(http.MethodGet)
+	s.route("/api/v1/names/merge", s.HandleAPIMergeNames).Methods(http.MethodPost)
+	s.route("/api/v1/names/aliases/{key}", s.HandleAPIRemoveAlias).Methods(http.MethodDelete)
+
+	s.route("/api/v1/rules", s.HandleAPIRewriteRules).Methods(http.MethodGet)
+	s.route("/api/v1/rules", s.HandleAPISetRewriteRules).Methods(http.MethodPut)
+	s.route("/api/v1/rules/apply", s.HandleAPIApplyRewriteRules).Methods(http.MethodPost)
+
+	s.route("/api/v1/downloads", s.HandleAPIDownloads).Methods(http.MethodGet)
+	s.route("/api/v1/downloads", s.HandleAPIEnqueue).Methods(http.MethodPost)
+	s.route("/api/v1/downloads/current", s.HandleAPIAbortDownload).Methods(http.MethodDelete)
+	s.route("/api/v1/downloads/pending/{pendingID}", s.HandleAPIAddPending).Methods(http.MethodPost)
+	s.route("/api/v1/downloads/pending/{pendingID}", s.HandleAPIDiscardPending).Methods(http.MethodDelete)
+	s.route("/api/v1/import", s.HandleAPIImport).Methods(http.MethodPost)
+
+	s.route("/api/v1/playlists", s.HandleAPIPlaylists).Methods(http.MethodGet)
+	s.route("/api/v1/playlists", s.HandleAPICreatePlaylist).Methods(http.MethodPost)
+	s.route("/api/v1/playlist/{playlistID}", s.HandleAPIPlaylist).Methods(http.MethodGet)
+	s.route("/api/v1/playlist/{playlistID}", s.HandleAPIEditPlaylist).Methods(http.MethodPost)
+	s.route("/api/v1/playlist/{playlistID}", s.HandleAPIDeletePlaylist).Methods(http.MethodDelete)
+	s.route("/api/v1/playlist/{playlistID}/songs", s.HandleAPIPlaylistSongs).Methods(http.MethodPost, http.MethodPut)
+	s.route("/api/v1/playlist/{playlistID}/songs/{songID}", s.HandleAPIRemovePlaylistSong).Methods(http.MethodDelete)
+
+	s.route("/api/v1/search", s.HandleAPISongSearch).Methods(http.MethodGet)
+	s.route("/api/v1/openapi.json", s.HandleAPIOpenAPI).Methods(http.MethodGet)
+	s.route("/api/v1/events/ws", s.HandleWebsocket)
+
+	// WebDAV access to the same files as via FTP. It handles its own methods and authentication
+	dav := newDavHandler(s.m)
+	s.router.PathPrefix("/dav/").Handler(dav)
+	s.router.Handle("/dav", dav)
+
+	// Subsonic API for streaming from existing music players
+	s.router.PathPrefix("/rest/").Handler(subsonic.New(s.m))
+
+	// Content directory for the UPnP media server, discovery is handled separately
+	if cfg.UPnP.Enabled {
+		// Renderers cannot log in, so the files listed in the content directory are also served below /upnp/
+		s.route("/upnp/song/{songID}/cover", s.HandleCover).Methods(http.MethodGet, http.MethodHead)
+		s.route("/upnp/song/{songID}/audio", s.HandleAudio).Methods(http.MethodGet, http.MethodHead)
+		s.route("/upnp/song/{songID}/file", s.HandleFile).Methods(http.MethodGet, http.MethodHead)
+
+		s.router.PathPrefix("/upnp/").Handler(upnp.New(s.m))
+	}
 }
 
 func (s *server) route(path string, f func(w http.ResponseWriter, r *http.Request) error) *mux.Route {
@@ -0,0 +1,163 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/music"
+
+	"github.com/gorilla/mux"
+)
+
+type smartPage struct {
+	Title string
+
+	// Playlist is the smart playlist shown on the page or the default values for creating a new one
+	Playlist store.SmartPlaylist
+	Songs    []music.Entry
+
+	// Playlists are all smart playlists, they are only set on the overview page
+	Playlists []store.SmartPlaylist
+
+	SortFields []string
+}
+
+// HandleSmartListing shows all smart playlists and a form for creating a new one
+func (s *server) HandleSmartListing(w http.ResponseWriter, r *http.Request) (err error) {
+	return s.renderTemplate(w, r, "smartlists.html", smartPage{
+		Title:      "Smart playlists",
+		Playlist:   store.SmartPlaylist{Sort: "title"},
+		Playlists:  s.m.AllSmartPlaylists(),
+		SortFields: store.SmartSortFields,
+	})
+}
+
+// HandleCreateSmart creates a smart playlist from the form and redirects to it
+func (s *server) HandleCreateSmart(w http.ResponseWriter, r *http.Request) (err error) {
+	p, err := smartFromForm(r)
+	if err == nil {
+		p, err = s.m.SaveSmartPlaylist(p)
+	}
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	http.Redirect(w, r, "/smart/"+p.ID, http.StatusSeeOther)
+	return nil
+}
+
+// smartFromForm reads the settings of a smart playlist from the submitted form
+func smartFromForm(r *http.Request) (p store.SmartPlaylist, err error) {
+	rules, err := store.ParseRules(r.FormValue("smart-rules"))
+	if err != nil {
+		return
+	}
+
+	var limit int
+	if l := strings.TrimSpace(r.FormValue("smart-limit")); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			return p, fmt.Errorf("The limit must be a number")
+		}
+	}
+
+	return store.SmartPlaylist{
+		Name:        r.FormValue("smart-name"),
+		Description: strings.TrimSpace(r.FormValue("smart-description")),
+		Any:         r.FormValue("smart-match") == "any",
+		Rules:       rules,
+		Sort:        r.FormValue("smart-sort"),
+		Descending:  r.FormValue("smart-descending") == "on",
+		Limit:       limit,
+		SyncSettings: music.SyncSettings{
+			Should: r.FormValue("should-sync") == "on",
+		},
+	}, nil
+}
+
+// smartFromURL returns the smart playlist identified by the `smartID` URL variable
+func (s *server) smartFromURL(r *http.Request) (p store.SmartPlaylist, err error) {
+	v := mux.Vars(r)
+	if v == nil || v["smartID"] == "" {
+		return p, httpError{
+			StatusCode: http.StatusPreconditionFailed,
+			Message:    "Need a smart playlist ID",
+		}
+	}
+
+	p, ok := s.m.GetSmartPlaylist(v["smartID"])
+	if !ok {
+		return p, httpError{
+			StatusCode: http.StatusNotFound,
+			Message:    "Smart playlist not found",
+		}
+	}
+
+	return p, nil
+}
+
+// HandleShowSmart shows the songs of a smart playlist and a form for changing its rules
+func (s *server) HandleShowSmart(w http.ResponseWriter, r *http.Request) (err error) {
+	p, err := s.smartFromURL(r)
+	if err != nil {
+		return
+	}
+
+	return s.renderTemplate(w, r, "smartlist.html", smartPage{
+		Title:      p.Name,
+		Playlist:   p,
+		Songs:      s.m.SmartEntries(p),
+		SortFields: store.SmartSortFields,
+	})
+}
+
+// HandleEditSmart changes or deletes a smart playlist. Built-in playlists cannot be changed
+func (s *server) HandleEditSmart(w http.ResponseWriter, r *http.Request) (err error) {
+	old, err := s.smartFromURL(r)
+	if err != nil {
+		return
+	}
+
+	if old.Builtin {
+		return httpError{
+			StatusCode: http.StatusForbidden,
+			Message:    "Built-in smart playlists cannot be changed",
+		}
+	}
+
+	isAjax := r.Header.Get("X-XHR") == "true"
+
+	redirect := r.URL.String()
+
+	if r.FormValue("delete") == "delete" {
+		err = s.m.DeleteSmartPlaylist(old.ID)
+		redirect = "/smart"
+	} else {
+		var p store.SmartPlaylist
+		p, err = smartFromForm(r)
+		if err == nil {
+			p.ID = old.ID
+			_, err = s.m.SaveSmartPlaylist(p)
+		}
+	}
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	if isAjax {
+		w.Header().Set("Content-Type", "application/json")
+		http.Error(w, `{"message": "Updated"}`, http.StatusOK)
+		return nil
+	}
+
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+	return nil
+}
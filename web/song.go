@@ -8,12 +8,20 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// similarSongs is the number of related songs shown on the song page
+const similarSongs = 5
+
 type songPage struct {
 	Title string
 
 	*music.Entry
 
 	SimilarSongs []music.Entry
+
+	// Playlists are shown as targets the song can be added to
+	Playlists []store.Playlist
+	// SourceHistory are the sources the song had before its audio was replaced
+	SourceHistory []store.ReplacedSource
 }
 
 // HandleShowSong shows information about a song
@@ -34,12 +42,14 @@ func (s *server) HandleShowSong(w http.ResponseWriter, r *http.Request) (err err
 		}
 	}
 
-	similar := s.m.GetRelatedSongs(e)
+	similar := s.m.GetRelatedSongs(e, similarSongs)
 
 	return s.renderTemplate(w, r, "song.html", songPage{
 		e.SongName(),
 		&e,
 		similar,
+		s.m.AllPlaylists(),
+		s.m.GetSourceHistory(e.ID),
 	})
 }
 
@@ -79,8 +89,41 @@ func (s *server) HandleEditSong(w http.ResponseWriter, r *http.Request) (err err
 		}
 		return nil
 	}
+	// Buttons for trim suggestions
+	if action := r.FormValue("trim-action"); action != "" {
+		switch action {
+		case "analyze":
+			err = s.m.AnalyzeTrim(songID)
+		case "apply":
+			_, err = s.m.ApplyTrimSuggestions(songID)
+		case "dismiss":
+			err = s.m.DismissTrimSuggestion(songID)
+		default:
+			return httpError{
+				StatusCode: http.StatusBadRequest,
+				Message:    "Invalid trim action",
+			}
+		}
+		if err != nil {
+			return err
+		}
+
+		if isAjax {
+			w.Header().Set("Content-Type", "application/json")
+			http.Error(w, `{"message": "Updated"}`, http.StatusOK)
+		} else {
+			http.Redirect(w, r, r.URL.String(), http.StatusSeeOther)
+		}
+		return nil
+	}
+
 	// If the delete button was clicked
 	if r.FormValue("delete") == "delete" {
+		err = requireRole(r, roleAdmin)
+		if err != nil {
+			return
+		}
+
 		err = s.m.DeleteEntry(songID)
 		if err != nil {
 			return err
@@ -114,10 +157,21 @@ func (s *server) HandleEditSong(w http.ResponseWriter, r *http.Request) (err err
 		Artist: r.FormValue("song-artist"),
 		Album:  r.FormValue("song-album"),
 		Year:   r.FormValue("song-year"),
+		Genre:  r.FormValue("song-genre"),
+		Track:  r.FormValue("song-track"),
+		Disc:   r.FormValue("song-disc"),
+		Rating: r.FormValue("song-rating"),
 
 		Start: r.FormValue("audio-start"),
 		End:   r.FormValue("audio-end"),
 
+		FadeIn:  r.FormValue("audio-fade-in"),
+		FadeOut: r.FormValue("audio-fade-out"),
+		Gain:    r.FormValue("audio-gain"),
+		Mono:    r.FormValue("audio-mono"),
+		Speed:   r.FormValue("audio-speed"),
+		Pitch:   r.FormValue("audio-pitch"),
+
 		Sync: r.FormValue("should-sync"),
 	}
 
@@ -142,6 +196,43 @@ func (s *server) HandleEditSong(w http.ResponseWriter, r *http.Request) (err err
 	return
 }
 
+// HandleReplaceAudio replaces the audio of a song with an uploaded file or, if no file was uploaded,
+// with the audio downloaded from the submitted link. Metadata and cover of the song are kept
+func (s *server) HandleReplaceAudio(w http.ResponseWriter, r *http.Request) (err error) {
+	e, err := s.apiSongFromURL(r)
+	if err != nil {
+		return
+	}
+
+	err = r.ParseMultipartForm(250 << 20) // Limit: 250MB
+	if err != nil {
+		return
+	}
+	if r.MultipartForm != nil {
+		defer r.MultipartForm.RemoveAll()
+	}
+
+	audioFile, fh, err := r.FormFile("replace-file")
+	switch err {
+	case nil:
+		err = s.m.ReplaceAudioFile(e.ID, fh.Filename, audioFile)
+		_ = audioFile.Close()
+	case http.ErrMissingFile:
+		err = s.m.EnqueueReplacement(e.ID, r.FormValue("replace-url"))
+	default:
+		return
+	}
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	http.Redirect(w, r, "/song/"+e.ID, http.StatusSeeOther)
+	return nil
+}
+
 // HandleRandomSong redirects to a randomly chosen song
 func (s *server) HandleRandomSong(w http.ResponseWriter, r *http.Request) (err error) {
 	song, ok := s.m.RandomSong()
@@ -4,12 +4,16 @@ import (
 	"encoding/json"
 	"net/http"
 	"strings"
+	"xarantolus/sensibleHub/store"
+	"xarantolus/sensibleHub/store/music"
 
 	"github.com/gorilla/mux"
 )
 
 type addAccept struct {
 	SearchTerm string `json:"searchTerm"`
+
+	AllowDuplicates bool `json:"allowDuplicates"`
 }
 
 // HandleDownloadSong handles a song download request. This kind of request is done
@@ -24,7 +28,7 @@ func (s *server) HandleDownloadSong(w http.ResponseWriter, r *http.Request) (err
 			return
 		}
 
-		err = s.m.Enqueue(acc.SearchTerm)
+		err = s.m.Enqueue(acc.SearchTerm, acc.AllowDuplicates)
 		if err == nil {
 			w.WriteHeader(http.StatusOK)
 			_, err = w.Write([]byte(`{}`))
@@ -43,7 +47,7 @@ func (s *server) HandleDownloadSong(w http.ResponseWriter, r *http.Request) (err
 		return
 	}
 
-	err = s.m.Enqueue(r.FormValue("searchTerm"))
+	err = s.m.Enqueue(r.FormValue("searchTerm"), r.FormValue("allow-duplicates") == "on")
 	if err != nil {
 		return
 	}
@@ -81,6 +85,44 @@ func (s *server) HandleAbortDownload(w http.ResponseWriter, r *http.Request) (er
 	return
 }
 
+// HandleResolvePending adds or discards a download that is probably a duplicate, depending on the "action" form value
+func (s *server) HandleResolvePending(w http.ResponseWriter, r *http.Request) (err error) {
+	v := mux.Vars(r)
+	if v == nil || v["pendingID"] == "" {
+		return httpError{
+			StatusCode: http.StatusPreconditionFailed,
+			Message:    "Need a pending download ID",
+		}
+	}
+
+	err = r.ParseForm()
+	if err != nil {
+		return
+	}
+
+	switch r.FormValue("action") {
+	case "add":
+		err = s.m.AddPending(v["pendingID"])
+	case "discard":
+		err = s.m.DiscardPending(v["pendingID"])
+	default:
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Action must be add or discard",
+		}
+	}
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	http.Redirect(w, r, "/add", http.StatusSeeOther)
+
+	return
+}
+
 // HandleEditAlbum edits an album, only accepts an image. This way, one image can quickly be set for all songs in one album
 func (s *server) HandleEditAlbum(w http.ResponseWriter, r *http.Request) (err error) {
 	v := mux.Vars(r)
@@ -120,3 +162,167 @@ func (s *server) HandleEditAlbum(w http.ResponseWriter, r *http.Request) (err er
 
 	return
 }
+
+// HandleAPIDownloads returns the state of the download queue
+func (s *server) HandleAPIDownloads(w http.ResponseWriter, r *http.Request) (err error) {
+	u, running := s.m.IsDownloading()
+
+	var lastError string
+	if err := s.m.LastError(); err != nil {
+		lastError = err.Error()
+	}
+
+	pending := s.m.PendingDownloads()
+	if pending == nil {
+		pending = []store.DuplicateError{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"running":    running,
+		"url":        u,
+		"queued":     s.m.QueueLength(),
+		"last_error": lastError,
+		"pending":    pending,
+	})
+}
+
+// HandleAPIEnqueue adds a link or search term to the download queue. The body is like {"url": "...", "allow_duplicates": false}
+func (s *server) HandleAPIEnqueue(w http.ResponseWriter, r *http.Request) (err error) {
+	var req struct {
+		URL             string `json:"url"`
+		AllowDuplicates bool   `json:"allow_duplicates"`
+	}
+	err = json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req)
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Invalid JSON body: " + err.Error(),
+		}
+	}
+
+	if strings.TrimSpace(req.URL) == "" {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Need a link or search term in the url field",
+		}
+	}
+
+	err = s.m.Enqueue(req.URL, req.AllowDuplicates)
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    err.Error(),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"queued": true,
+	})
+}
+
+// HandleAPIAbortDownload aborts the running download
+func (s *server) HandleAPIAbortDownload(w http.ResponseWriter, r *http.Request) (err error) {
+	err = s.m.AbortDownload()
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusConflict,
+			Message:    err.Error(),
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// HandleAPIAddPending adds a download that is probably a duplicate. It is queued like other downloads,
+// but the files that were already downloaded are used
+func (s *server) HandleAPIAddPending(w http.ResponseWriter, r *http.Request) (err error) {
+	err = s.m.AddPending(mux.Vars(r)["pendingID"])
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusNotFound,
+			Message:    err.Error(),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"queued": true,
+	})
+}
+
+// HandleAPIDiscardPending deletes the files of a download that is probably a duplicate
+func (s *server) HandleAPIDiscardPending(w http.ResponseWriter, r *http.Request) (err error) {
+	err = s.m.DiscardPending(mux.Vars(r)["pendingID"])
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusNotFound,
+			Message:    err.Error(),
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// HandleAPIImport imports the audio files uploaded as multipart form in `file` fields. Metadata is read
+// from their tags or file names like when importing from disk
+func (s *server) HandleAPIImport(w http.ResponseWriter, r *http.Request) (err error) {
+	type importError struct {
+		File    string `json:"file"`
+		Message string `json:"message"`
+	}
+
+	err = r.ParseMultipartForm(250 << 20) // Limit: 250MB
+	if err != nil {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Need a multipart form: " + err.Error(),
+		}
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	files := r.MultipartForm.File["file"]
+	if len(files) == 0 {
+		return httpError{
+			StatusCode: http.StatusBadRequest,
+			Message:    "Need at least one audio file in the file field",
+		}
+	}
+
+	var (
+		songs  = []music.Entry{}
+		failed = []importError{}
+	)
+	for _, fh := range files {
+		f, err := fh.Open()
+		if err != nil {
+			return err
+		}
+
+		e, _, err := s.m.ImportReader(fh.Filename, f)
+		_ = f.Close()
+		if err != nil {
+			failed = append(failed, importError{fh.Filename, err.Error()})
+			continue
+		}
+
+		songs = append(songs, *e)
+	}
+
+	status := http.StatusCreated
+	if len(songs) == 0 {
+		status = http.StatusUnprocessableEntity
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"songs":  songs,
+		"errors": failed,
+	})
+}
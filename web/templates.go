@@ -42,7 +42,10 @@ var (
 )
 
 func (s *server) parseTemplates(fs fs.FS) (err error) {
-	temp, err := template.New("base").Funcs(funcMap).ParseFS(fs, "templates/*.html")
+	temp, err := template.New("base").Funcs(funcMap).Funcs(template.FuncMap{
+		// auth returns whether users need to log in, the log out button is only shown if they do
+		"auth": s.m.GetConfig().WebAuthEnabled,
+	}).ParseFS(fs, "templates/*.html")
 	if err != nil {
 		return
 	}
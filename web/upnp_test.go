@@ -0,0 +1,72 @@
+package web
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"xarantolus/sensibleHub/store/config"
+)
+
+func TestUPnP_authEnabled(t *testing.T) {
+	var cfg config.Config
+	cfg.UPnP.Enabled = true
+	cfg.Web.Users = []config.WebUser{{Name: "admin", Passwd: "secret", Role: config.RoleAdmin}}
+
+	s := testServerConfig(t, cfg)
+	h := s.authWrap(s.router)
+
+	e, _ := s.m.GetEntry("a")
+	err := os.MkdirAll(e.DirPath(), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(e.DirPath(), e.FileData.Filename), []byte("audio"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := `<?xml version="1.0"?>
+<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">
+	<s:Body>
+		<u:Browse xmlns:u="urn:schemas-upnp-org:service:ContentDirectory:1">
+			<ObjectID>artists/` + base64.RawURLEncoding.EncodeToString([]byte("QUEEN")) + `</ObjectID>
+			<BrowseFlag>BrowseDirectChildren</BrowseFlag>
+			<Filter>*</Filter>
+			<StartingIndex>0</StartingIndex>
+			<RequestedCount>0</RequestedCount>
+			<SortCriteria></SortCriteria>
+		</u:Browse>
+	</s:Body>
+</s:Envelope>`
+
+	req := httptest.NewRequest(http.MethodPost, "/upnp/control/ContentDirectory", strings.NewReader(body))
+	req.Header.Set("SOAPAction", `"urn:schemas-upnp-org:service:ContentDirectory:1#Browse"`)
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Host = "192.168.0.2:128"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("browsing returned status %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), "http://192.168.0.2:128/upnp/song/a/audio") {
+		t.Fatalf("browse result doesn't link the audio file below /upnp/: %s", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/upnp/song/a/audio", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "audio" {
+		t.Errorf("fetching the audio file returned status %d: %q", rec.Code, rec.Body.String())
+	}
+
+	// The same file is still protected on the website
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/song/a/audio", nil))
+	if rec.Code != http.StatusSeeOther {
+		t.Errorf("expected a redirect to the login page, got status %d", rec.Code)
+	}
+}